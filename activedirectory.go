@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Active Directory returns at most MaxValRange (1500 by default) values of
+// an attribute per read: a large group's members arrive as
+// member;range=0-1499 and the rest must be read with further requests for
+// member;range=1500-* and so on. Source entries with such attributes are
+// completed before they are processed, so they carry the full attribute
+// under its plain name.
+//
+// With dc_locator.domain set, the servers of an LDAP connection are found
+// through the DNS SRV records Active Directory registers for its domain
+// controllers (_ldap._tcp.dc._msdcs.<domain>, or the site's records first)
+// and tried in priority and weight order. The url, when also set, is tried
+// last.
+
+// DCLocatorConfig configures finding domain controllers through DNS.
+type DCLocatorConfig struct {
+	Domain   string `yaml:"domain"`  // AD DNS domain, e.g. corp.example.org
+	Site     string `yaml:"site"`    // Prefer the domain controllers of this site
+	LDAPS    bool   `yaml:"ldaps"`   // Connect with ldaps on port 636
+	CacheSec int    `yaml:"cache_s"` // How long lookups are reused (default: 300)
+}
+
+var rangeOption = regexp.MustCompile(`(?i)^range=(\d+)-(\d+|\*)$`)
+
+var (
+	mRangedAttributes = describeMetric("ldapsync_ranged_attribute_reads_total", "counter",
+		"Additional reads of attribute value ranges from the source (Active Directory).")
+	mDCLookups = describeMetric("ldapsync_dc_locator_lookups_total", "counter",
+		"DNS SRV lookups of domain controllers, by result (success or failure).")
+)
+
+// splitRange splits an attribute name into its name without the range
+// option and the range's start and end ("*" for the last range). ok is
+// false for attributes without a range.
+func splitRange(name string) (plain string, start int, end string, ok bool) {
+	parts := strings.Split(name, ";")
+	kept := []string{parts[0]}
+	for _, opt := range parts[1:] {
+		if m := rangeOption.FindStringSubmatch(opt); m != nil {
+			start, _ = strconv.Atoi(m[1])
+			end, ok = m[2], true
+			continue
+		}
+		kept = append(kept, opt)
+	}
+	return strings.Join(kept, ";"), start, end, ok
+}
+
+// hasRangedAttributes reports whether an entry has attributes returned in
+// ranges that are not complete yet.
+func hasRangedAttributes(entry *ldap.Entry) bool {
+	for _, attr := range entry.Attributes {
+		if _, _, end, ok := splitRange(attr.Name); ok && end != "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// completeRangedAttributes reads the remaining ranges of an entry's ranged
+// attributes and replaces each with the complete attribute under its plain
+// name. Only entries for which hasRangedAttributes is true use l, which must
+// not be streaming a search then. On error the entry is left unchanged: a
+// range the server no longer returns fails the entry rather than truncate
+// the attribute.
+func completeRangedAttributes(l *ldap.Conn, entry *ldap.Entry) error {
+	completed := make(map[int]*ldap.EntryAttribute)
+	for i, attr := range entry.Attributes {
+		plain, _, end, ok := splitRange(attr.Name)
+		if !ok {
+			continue
+		}
+		values := append([][]byte{}, attr.ByteValues...)
+		for end != "*" {
+			last, _ := strconv.Atoi(end)
+			want := fmt.Sprintf("%s;range=%d-*", plain, last+1)
+			sr, err := l.Search(ldap.NewSearchRequest(entry.DN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+				0, 0, false, "(objectClass=*)", []string{want}, nil))
+			if err != nil {
+				return fmt.Errorf("reading %s of %s: %w", want, entry.DN, err)
+			}
+			incCounter(mRangedAttributes)
+			var next *ldap.EntryAttribute
+			if len(sr.Entries) > 0 {
+				for _, a := range sr.Entries[0].Attributes {
+					if p, start, _, ok := splitRange(a.Name); ok && strings.EqualFold(p, plain) && start == last+1 {
+						next = a
+						break
+					}
+				}
+			}
+			if next == nil {
+				return fmt.Errorf("reading %s of %s: range not returned", want, entry.DN)
+			}
+			values = append(values, next.ByteValues...)
+			_, _, end, _ = splitRange(next.Name)
+		}
+		complete := &ldap.EntryAttribute{Name: plain, ByteValues: values, Values: make([]string, len(values))}
+		for j, v := range values {
+			complete.Values[j] = string(v)
+		}
+		completed[i] = complete
+	}
+	for i, complete := range completed {
+		entry.Attributes[i] = complete
+	}
+	return nil
+}
+
+var dcCache = struct {
+	sync.Mutex
+	urls    map[string][]string
+	expires map[string]time.Time
+}{urls: make(map[string][]string), expires: make(map[string]time.Time)}
+
+func validateDCLocator() error {
+	for name, cfg := range map[string]LDAPConfig{"source": config.Source, "target": config.Target} {
+		if cfg.DCLocator.Site != "" && cfg.DCLocator.Domain == "" {
+			return fmt.Errorf("%s.dc_locator: site requires domain", name)
+		}
+		if cfg.DCLocator.CacheSec < 0 {
+			return fmt.Errorf("%s.dc_locator: cache_s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// ldapURLs returns the URLs to try for an LDAP connection: the located
+// domain controllers, then the configured url.
+func ldapURLs(cfg LDAPConfig) []string {
+	var urls []string
+	if cfg.DCLocator.Domain != "" {
+		located, err := locateDCs(cfg.DCLocator)
+		if err != nil {
+			logger.Warn("Failed to locate domain controllers", "Domain", cfg.DCLocator.Domain, "Err", err)
+		}
+		urls = append(urls, located...)
+	}
+	if cfg.URL != "" {
+		urls = append(urls, cfg.URL)
+	}
+	return urls
+}
+
+// locateDCs looks up the domain controllers of a domain, the site's first.
+func locateDCs(loc DCLocatorConfig) ([]string, error) {
+	key := strings.ToLower(loc.Domain + "|" + loc.Site + "|" + strconv.FormatBool(loc.LDAPS))
+	dcCache.Lock()
+	if urls, ok := dcCache.urls[key]; ok && time.Now().Before(dcCache.expires[key]) {
+		dcCache.Unlock()
+		return urls, nil
+	}
+	dcCache.Unlock()
+
+	names := []string{"dc._msdcs." + loc.Domain}
+	if loc.Site != "" {
+		names = append([]string{loc.Site + "._sites.dc._msdcs." + loc.Domain}, names...)
+	}
+	var urls []string
+	seen := make(map[string]struct{})
+	var lastErr error
+	for _, name := range names {
+		_, records, err := net.LookupSRV("ldap", "tcp", name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			scheme, port := "ldap", r.Port
+			if loc.LDAPS {
+				scheme, port = "ldaps", 636
+			}
+			u := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(port))))
+			if _, dup := seen[u]; !dup {
+				seen[u] = struct{}{}
+				urls = append(urls, u)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		incCounter(mDCLookups, "result", "failure")
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no SRV records for %s", loc.Domain)
+		}
+		return nil, lastErr
+	}
+	incCounter(mDCLookups, "result", "success")
+	ttl := time.Duration(loc.CacheSec) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	dcCache.Lock()
+	dcCache.urls[key] = urls
+	dcCache.expires[key] = time.Now().Add(ttl)
+	dcCache.Unlock()
+	return urls, nil
+}
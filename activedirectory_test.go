@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestSplitRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantPlain string
+		wantStart int
+		wantEnd   string
+		wantOK    bool
+	}{
+		{"member", "member", 0, "", false},
+		{"member;range=0-1499", "member", 0, "1499", true},
+		{"member;Range=1500-*", "member", 1500, "*", true},
+		{"description;lang-en;range=3-5", "description;lang-en", 3, "5", true},
+		{"member;range=x-1", "member;range=x-1", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, start, end, ok := splitRange(tt.name)
+			if plain != tt.wantPlain || start != tt.wantStart || end != tt.wantEnd || ok != tt.wantOK {
+				t.Errorf("splitRange() = %q, %d, %q, %v, want %q, %d, %q, %v",
+					plain, start, end, ok, tt.wantPlain, tt.wantStart, tt.wantEnd, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCompleteRangedAttributes(t *testing.T) {
+	const dn = "cn=staff,dc=org"
+	tests := []struct {
+		name    string
+		ranges  map[string]map[string][]string // requested range -> returned attributes
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "all ranges read",
+			ranges: map[string]map[string][]string{
+				"member;range=2-*": {"member;range=2-3": {"c", "d"}},
+				"member;range=4-*": {"member;range=4-*": {"e"}},
+			},
+			want: map[string][]string{"cn": {"staff"}, "member": {"a", "b", "c", "d", "e"}},
+		},
+		{
+			name: "range missing",
+			ranges: map[string]map[string][]string{
+				"member;range=2-*": {"member;range=2-3": {"c", "d"}},
+			},
+			wantErr: "reading member;range=4-* of cn=staff,dc=org: range not returned",
+		},
+		{
+			name: "range from another start",
+			ranges: map[string]map[string][]string{
+				"member;range=2-*": {"member;range=0-*": {"a", "b", "c"}},
+			},
+			wantErr: "range not returned",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := fakeLDAPServer(t, func(req *ldap.SearchRequest) []*ldap.Entry {
+				if attrs, ok := tt.ranges[req.Attributes[0]]; ok {
+					return []*ldap.Entry{ldap.NewEntry(dn, attrs)}
+				}
+				return []*ldap.Entry{ldap.NewEntry(dn, nil)}
+			})
+			original := map[string][]string{"cn": {"staff"}, "member;range=0-1": {"a", "b"}}
+			entry := ldap.NewEntry(dn, original)
+			err := completeRangedAttributes(l, entry)
+			got := make(map[string][]string)
+			for _, a := range entry.Attributes {
+				got[a.Name] = a.Values
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(got, original) {
+					t.Errorf("attributes after error = %q, want them unchanged", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attributes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIAuditRecord is one mutating management API call.
+type APIAuditRecord struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"` // API key name or token subject; anonymous without auth
+	RemoteIP  string    `json:"remoteIp"`
+	Method    string    `json:"method"`
+	Endpoint  string    `json:"endpoint"` // Route, e.g. /search/:id
+	URI       string    `json:"uri"`
+	// Params holds the query and form parameters, or the JSON body, with
+	// secret-looking values redacted and long values truncated.
+	Params   map[string]interface{} `json:"params,omitempty"`
+	Status   int                    `json:"status"`
+	Response string                 `json:"response,omitempty"` // Start of the response body
+}
+
+// APIAuditPage is a page of audit records, newest first. Next is passed as
+// before to fetch the following page; it is omitted on the last page.
+type APIAuditPage struct {
+	Records []APIAuditRecord `json:"records"`
+	Next    int64            `json:"next,omitempty"`
+}
+
+const (
+	auditBodyLimit     = 64 << 10 // Request bytes read for the summary
+	auditValueLimit    = 256      // Longest recorded parameter value
+	auditResponseLimit = 1024     // Response bytes recorded
+)
+
+var auditSecretParam = regexp.MustCompile(`(?i)password|secret|token|credential|private`)
+
+// auditResponseWriter keeps the start of the response body.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if room := auditResponseLimit - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// apiAuditMiddleware records every call that is not a GET or HEAD in the
+// api_audit table, including calls rejected by authentication.
+func apiAuditMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if db == nil || req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions {
+			return next(c)
+		}
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(req.Body, auditBodyLimit))
+			req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+		}
+		writer := &auditResponseWriter{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = writer
+
+		// Handle the error here so the recorded status is final; the
+		// outer handlers leave committed responses alone.
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		principal, _ := c.Get("principal").(string)
+		if principal == "" {
+			principal = "anonymous"
+		}
+		rec := APIAuditRecord{
+			Time:      time.Now(),
+			Principal: principal,
+			RemoteIP:  c.RealIP(),
+			Method:    req.Method,
+			Endpoint:  c.Path(),
+			URI:       req.RequestURI,
+			Params:    auditParams(req.URL.Query(), req.Header.Get(echo.HeaderContentType), body),
+			Status:    c.Response().Status,
+			Response:  strings.TrimSpace(writer.body.String()),
+		}
+		go recordAPIAudit(rec)
+		return err
+	}
+}
+
+// auditParams summarizes the request parameters.
+func auditParams(query url.Values, contentType string, body []byte) map[string]interface{} {
+	params := make(map[string]interface{})
+	add := func(values url.Values) {
+		for k, vs := range values {
+			redacted := make([]string, len(vs))
+			for i, v := range vs {
+				redacted[i] = auditValue(k, v)
+			}
+			if len(redacted) == 1 {
+				params[k] = redacted[0]
+			} else {
+				params[k] = redacted
+			}
+		}
+	}
+	add(query)
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMEApplicationForm):
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			add(form)
+		}
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON) && len(body) > 0:
+		var doc map[string]interface{}
+		if json.Unmarshal(body, &doc) == nil {
+			for k, v := range doc {
+				if s, ok := v.(string); ok {
+					params[k] = auditValue(k, s)
+				} else if auditSecretParam.MatchString(k) {
+					params[k] = "[redacted]"
+				} else {
+					params[k] = v
+				}
+			}
+		} else {
+			params["body"] = auditValue("body", string(body))
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+func auditValue(name, value string) string {
+	if auditSecretParam.MatchString(name) {
+		return "[redacted]"
+	}
+	if len(value) > auditValueLimit {
+		return value[:auditValueLimit] + "..."
+	}
+	return value
+}
+
+func recordAPIAudit(rec APIAuditRecord) {
+	params, err := json.Marshal(rec.Params)
+	if err != nil {
+		httpLogger.Error("Failed to encode API audit parameters", "URI", rec.URI, "Err", err)
+		return
+	}
+	const insertSQL = `
+	INSERT INTO api_audit (time, principal, remote_ip, method, endpoint, uri, params, status, response)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+	if _, err := db.Exec(insertSQL, rec.Time, rec.Principal, rec.RemoteIP, rec.Method, rec.Endpoint,
+		rec.URI, string(params), rec.Status, rec.Response); err != nil {
+		httpLogger.Error("Failed to record API audit entry", "Method", rec.Method, "URI", rec.URI, "Err", err)
+	}
+}
+
+// getAPIAuditHandler godoc
+// @Summary List management API calls
+// @Description Lists mutating API calls (everything but GET and HEAD), newest first, with the caller, parameters and outcome. Requires database persistence.
+// @Tags audit
+// @Produce json
+// @Param principal query string false "Only calls by this principal"
+// @Param endpoint query string false "Only calls to this route, e.g. /search/:id"
+// @Param since query string false "Only calls at or after this RFC 3339 time"
+// @Param until query string false "Only calls before this RFC 3339 time"
+// @Param before query int false "Only records with a smaller id (the next value of the previous page)"
+// @Param limit query int false "Maximum records to return (default 100, max 1000)"
+// @Success 200 {object} APIAuditPage
+// @Failure 400 {string} string "Invalid parameter"
+// @Failure 503 {string} string "Database persistence is disabled"
+// @Router /audit [get]
+func getAPIAuditHandler(c echo.Context) error {
+	if db == nil {
+		return c.String(http.StatusServiceUnavailable, "The API audit log requires database persistence")
+	}
+	query := `SELECT id, time, principal, remote_ip, method, endpoint, uri, params, status, response FROM api_audit WHERE TRUE`
+	var args []interface{}
+	for _, p := range []struct{ param, column string }{{"principal", "principal"}, {"endpoint", "endpoint"}} {
+		if v := c.QueryParam(p.param); v != "" {
+			args = append(args, v)
+			query += ` AND ` + p.column + ` = $` + strconv.Itoa(len(args))
+		}
+	}
+	for _, p := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := c.QueryParam(p.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid "+p.param+" parameter; expected RFC 3339 time")
+		}
+		args = append(args, t)
+		query += ` AND time ` + p.op + ` $` + strconv.Itoa(len(args))
+	}
+	if v := c.QueryParam("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid before parameter")
+		}
+		args = append(args, id)
+		query += ` AND id < $` + strconv.Itoa(len(args))
+	}
+	limit := 100
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.String(http.StatusBadRequest, "Invalid limit parameter")
+		}
+		limit = n
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	// Fetch one extra row to tell whether another page follows.
+	args = append(args, limit+1)
+	query += ` ORDER BY id DESC LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error("Failed to query API audit log", "Err", err)
+		return c.String(http.StatusInternalServerError, "Failed to query API audit log")
+	}
+	defer rows.Close()
+	page := APIAuditPage{Records: []APIAuditRecord{}}
+	for rows.Next() {
+		var rec APIAuditRecord
+		var params []byte
+		if err := rows.Scan(&rec.ID, &rec.Time, &rec.Principal, &rec.RemoteIP, &rec.Method, &rec.Endpoint,
+			&rec.URI, &params, &rec.Status, &rec.Response); err != nil {
+			logger.Error("Error scanning API audit row", "Err", err)
+			continue
+		}
+		if err := json.Unmarshal(params, &rec.Params); err != nil {
+			logger.Warn("Invalid API audit parameters", "Id", rec.ID, "Err", err)
+		}
+		page.Records = append(page.Records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("Error iterating API audit rows", "Err", err)
+		return c.String(http.StatusInternalServerError, "Failed to query API audit log")
+	}
+	if len(page.Records) > limit {
+		page.Records = page.Records[:limit]
+		page.Next = page.Records[limit-1].ID
+	}
+	return c.JSON(http.StatusOK, page)
+}
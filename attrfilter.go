@@ -0,0 +1,45 @@
+package main
+
+import "strings"
+
+// parseAttributeList splits a comma-separated attribute list, dropping blanks.
+func parseAttributeList(s string) []string {
+	var out []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// requestedAttributes returns the attributes to request from the source;
+// all user attributes unless the search narrows them. The correlation
+// attribute, often operational (entryUUID), is always requested, as are the
+// password attributes of searches that sync them.
+func requestedAttributes(id string, spec *SearchSpec) []string {
+	attrs := spec.Attributes
+	if len(attrs) == 0 {
+		attrs = []string{"*"}
+	}
+	if attr := correlationAttribute(id, spec); attr != "" {
+		attrs = append(append([]string{}, attrs...), attr)
+	}
+	if syncsPasswords(id) {
+		attrs = append(append([]string{}, attrs...), passwordAttributes()...)
+	}
+	return attrs
+}
+
+// isExcludedAttr reports whether attr appears in the exclude list. Names are
+// compared case-insensitively and without attribute options, so excluding
+// userCertificate also drops userCertificate;binary.
+func isExcludedAttr(attr string, exclude []string) bool {
+	base, _, _ := strings.Cut(attr, ";")
+	for _, ex := range exclude {
+		if strings.EqualFold(base, ex) || strings.EqualFold(attr, ex) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AuthConfig protects the management API. Callers authenticate with a static
+// API key or an OIDC bearer token; readers may call GET endpoints and admins
+// may call everything.
+type AuthConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+	OIDC    OIDCConfig     `yaml:"oidc"`
+	// PublicPaths are served without credentials
+	// (default: /healthz, /readyz, /metrics).
+	PublicPaths []string `yaml:"public_paths"`
+}
+
+// APIKeyConfig is a static API key, sent as "Authorization: Bearer <key>" or
+// in the X-API-Key header.
+type APIKeyConfig struct {
+	Name    string `yaml:"name"`     // Shown in the request log
+	KeyFile string `yaml:"key_file"` // File holding the key
+	Role    string `yaml:"role"`     // reader or admin
+}
+
+// OIDCConfig validates bearer tokens issued by an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"` // Required "aud" value
+	// JWKSURL defaults to the jwks_uri of the issuer's discovery document.
+	JWKSURL string `yaml:"jwks_url"`
+	// RoleClaim holds the caller's roles, as a string or list (default: roles).
+	RoleClaim string `yaml:"role_claim"`
+	// AdminValues grant the admin role; ReaderValues grant the reader role.
+	// With no reader values every valid token may read.
+	AdminValues  []string `yaml:"admin_values"`
+	ReaderValues []string `yaml:"reader_values"`
+}
+
+const (
+	roleReader = "reader"
+	roleAdmin  = "admin"
+)
+
+var mAuthFailures = describeMetric("ldapsync_api_auth_failures_total", "counter",
+	"Management API requests rejected by authentication or authorization, by reason.")
+
+type apiKey struct {
+	name string
+	hash [sha256.Size]byte
+	role string
+}
+
+var apiKeys []apiKey
+
+// initAuth loads the API keys and checks the OIDC settings.
+func initAuth() error {
+	cfg := config.Auth
+	if !cfg.Enabled {
+		return nil
+	}
+	for i, k := range cfg.APIKeys {
+		if k.Role != roleReader && k.Role != roleAdmin {
+			return fmt.Errorf("auth.api_keys[%d]: role must be %s or %s", i, roleReader, roleAdmin)
+		}
+		if k.KeyFile == "" {
+			return fmt.Errorf("auth.api_keys[%d]: key_file is required", i)
+		}
+		data, err := os.ReadFile(k.KeyFile)
+		if err != nil {
+			return fmt.Errorf("auth.api_keys[%d]: %w", i, err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return fmt.Errorf("auth.api_keys[%d]: %s is empty", i, k.KeyFile)
+		}
+		name := k.Name
+		if name == "" {
+			name = fmt.Sprintf("api-key-%d", i)
+		}
+		apiKeys = append(apiKeys, apiKey{name: name, hash: sha256.Sum256([]byte(key)), role: k.Role})
+	}
+	o := cfg.OIDC
+	if o.Issuer != "" && o.Audience == "" {
+		return fmt.Errorf("auth.oidc: audience is required")
+	}
+	if o.Issuer != "" && len(o.AdminValues) == 0 && len(o.ReaderValues) == 0 {
+		logger.Warn("auth.oidc has no admin_values; tokens only grant read access")
+	}
+	if len(apiKeys) == 0 && o.Issuer == "" {
+		return fmt.Errorf("auth: enabled without api_keys or oidc")
+	}
+	return nil
+}
+
+func publicPath(path string) bool {
+	paths := config.Auth.PublicPaths
+	if paths == nil {
+		paths = []string{"/healthz", "/readyz", "/metrics"}
+	}
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// authMiddleware rejects unauthenticated requests with 401 and requests
+// beyond the caller's role with 403. The caller's name is stored as
+// "principal" for the request log.
+func authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !config.Auth.Enabled || publicPath(c.Request().URL.Path) {
+			return next(c)
+		}
+		principal, role, err := authenticate(c.Request())
+		if err != nil {
+			incCounter(mAuthFailures, "reason", "unauthenticated")
+			httpLogger.Warn("Rejected API request", "Method", c.Request().Method, "URI", c.Request().RequestURI, "Err", err)
+			c.Response().Header().Set("WWW-Authenticate", "Bearer")
+			return c.String(http.StatusUnauthorized, "Unauthorized")
+		}
+		c.Set("principal", principal)
+		method := c.Request().Method
+		if role != roleAdmin && method != http.MethodGet && method != http.MethodHead {
+			incCounter(mAuthFailures, "reason", "forbidden")
+			httpLogger.Warn("Rejected API request", "Method", method, "URI", c.Request().RequestURI,
+				"Principal", principal, "Err", "admin role required")
+			return c.String(http.StatusForbidden, "Forbidden: admin role required")
+		}
+		return next(c)
+	}
+}
+
+// authenticate identifies the caller and returns their name and role.
+func authenticate(r *http.Request) (string, string, error) {
+	credential := r.Header.Get("X-API-Key")
+	if credential == "" {
+		auth := r.Header.Get("Authorization")
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			credential = strings.TrimSpace(auth[7:])
+		}
+	}
+	if credential == "" {
+		return "", "", fmt.Errorf("no credentials")
+	}
+	hash := sha256.Sum256([]byte(credential))
+	for _, k := range apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			return k.name, k.role, nil
+		}
+	}
+	if config.Auth.OIDC.Issuer == "" || strings.Count(credential, ".") != 2 {
+		return "", "", fmt.Errorf("unknown API key")
+	}
+	return verifyOIDCToken(credential)
+}
+
+// verifyOIDCToken checks the token's signature, issuer, audience and
+// lifetime and maps its role claim to a role.
+func verifyOIDCToken(token string) (string, string, error) {
+	o := config.Auth.OIDC
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return "", "", fmt.Errorf("token header: %w", err)
+	}
+	key, err := oidcKeys.key(header.Kid)
+	if err != nil {
+		return "", "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", fmt.Errorf("token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return "", "", fmt.Errorf("unsupported token algorithm %q", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return "", "", fmt.Errorf("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return "", "", fmt.Errorf("unsupported token algorithm %q", header.Alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return "", "", fmt.Errorf("invalid token signature")
+		}
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return "", "", fmt.Errorf("token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(o.Issuer, "/") {
+		return "", "", fmt.Errorf("token issuer %q not accepted", iss)
+	}
+	if !containsString(claimValues(claims["aud"]), o.Audience) {
+		return "", "", fmt.Errorf("token audience does not include %q", o.Audience)
+	}
+	const leeway = time.Minute
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return "", "", fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return "", "", fmt.Errorf("token not yet valid")
+	}
+
+	principal, _ := claims["sub"].(string)
+	if name, ok := claims["preferred_username"].(string); ok && name != "" {
+		principal = name
+	}
+	roleClaim := o.RoleClaim
+	if roleClaim == "" {
+		roleClaim = "roles"
+	}
+	values := claimValues(claims[roleClaim])
+	for _, v := range o.AdminValues {
+		if containsString(values, v) {
+			return principal, roleAdmin, nil
+		}
+	}
+	if len(o.ReaderValues) == 0 {
+		return principal, roleReader, nil
+	}
+	for _, v := range o.ReaderValues {
+		if containsString(values, v) {
+			return principal, roleReader, nil
+		}
+	}
+	return "", "", fmt.Errorf("token of %q grants no role", principal)
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// claimValues reads a claim holding a string (space-separated, as for
+// "scope") or a list of strings.
+func claimValues(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return strings.Fields(t)
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksCache holds the provider's signing keys. Unknown key ids trigger a
+// refetch, at most once a minute, so key rotation is picked up.
+type jwksCache struct {
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+var oidcKeys = &jwksCache{}
+
+func (j *jwksCache) key(kid string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if k, ok := j.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(j.fetched) > time.Minute {
+		keys, err := fetchJWKS()
+		if err != nil {
+			return nil, fmt.Errorf("fetching OIDC keys: %w", err)
+		}
+		j.keys = keys
+		j.fetched = time.Now()
+		if k, ok := j.lookup(kid); ok {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown token key id %q", kid)
+}
+
+// lookup finds a key by id; tokens without a kid match a provider's only key.
+func (j *jwksCache) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+	return k, ok
+}
+
+func fetchJWKS() (map[string]interface{}, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	jwksURL := config.Auth.OIDC.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(client, strings.TrimSuffix(config.Auth.OIDC.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(client, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no usable signing keys", jwksURL)
+	}
+	return keys, nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// defaultBinaryAttributes are attributes whose values are raw octets rather
+// than UTF-8 text. Attributes with a ;binary option are always binary.
+var defaultBinaryAttributes = []string{
+	"jpegPhoto",
+	"photo",
+	"thumbnailPhoto",
+	"audio",
+	"userCertificate",
+	"cACertificate",
+	"certificateRevocationList",
+	"authorityRevocationList",
+	"crossCertificatePair",
+	"userSMIMECertificate",
+	"userPKCS12",
+	"objectGUID",
+	"objectSid",
+}
+
+// isBinaryAttr reports whether values of attr are carried base64-encoded.
+// The comparison ignores case and attribute options other than ;binary.
+func isBinaryAttr(attr string) bool {
+	parts := strings.Split(attr, ";")
+	for _, opt := range parts[1:] {
+		if strings.EqualFold(opt, "binary") {
+			return true
+		}
+	}
+	names := config.BinaryAttributes
+	if len(names) == 0 {
+		names = defaultBinaryAttributes
+	}
+	for _, name := range names {
+		if strings.EqualFold(parts[0], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBinaryValues base64-encodes raw attribute values so they survive JSON
+// and string handling in hooks, transforms and the results store.
+func encodeBinaryValues(raw [][]byte) []string {
+	vals := make([]string, len(raw))
+	for i, b := range raw {
+		vals[i] = base64.StdEncoding.EncodeToString(b)
+	}
+	return vals
+}
+
+// decodeBinaryValues reverses encodeBinaryValues before a write to the target.
+func decodeBinaryValues(attr string, vals []string) ([]string, error) {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: value is not valid base64: %w", attr, err)
+		}
+		out[i] = string(b)
+	}
+	return out, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BindingsInfo lists the current bindings returned by GET /bindings.
+type BindingsInfo struct {
+	Bindings     map[string]BindingValue `json:"bindings"`
+	NullBindings []string                `json:"nullBindings"`
+	// Expires is when the bindings with a TTL expire.
+	Expires map[string]time.Time `json:"expires,omitempty"`
+}
+
+// BindingRequest is the body of PUT /bindings/:key. The value is a string,
+// number or list of them, and required; null marks the binding as explicitly
+// null. TTL overrides the lifetime set for the key's namespace, in seconds.
+type BindingRequest struct {
+	Value *BindingValue `json:"value"`
+	TTL   *int          `json:"ttl,omitempty"`
+}
+
+var bindingKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// bindingKeyParam returns the :key path parameter without a leading "$".
+func bindingKeyParam(c echo.Context) (string, bool) {
+	key := strings.TrimPrefix(c.Param("key"), "$")
+	return key, bindingKeyPattern.MatchString(key)
+}
+
+// getBindingsHandler godoc
+// @Summary List bindings
+// @Description Returns all current bindings, the keys bound to null and when bindings with a TTL expire.
+// @Tags bindings
+// @Produce json
+// @Success 200 {object} BindingsInfo
+// @Router /bindings [get]
+func getBindingsHandler(c echo.Context) error {
+	values, nulls := getBindingsSnapshot()
+	nullKeys := sortedKeys(nulls)
+	if nullKeys == nil {
+		nullKeys = []string{}
+	}
+	return c.JSON(http.StatusOK, BindingsInfo{Bindings: values, NullBindings: nullKeys, Expires: bindingExpiries()})
+}
+
+// putBindingHandler godoc
+// @Summary Set a binding
+// @Description Sets a binding as if a hook had returned it and reprocesses pending entries, so entries deferred on it are written.
+// @Tags bindings
+// @Accept json
+// @Produce plain
+// @Param key path string true "Binding key, without the leading $"
+// @Param binding body BindingRequest true "Value; null marks the binding as null. Optional ttl in seconds"
+// @Success 200 {string} string "Binding set"
+// @Failure 400 {string} string "Invalid key or body, or missing value"
+// @Router /bindings/{key} [put]
+func putBindingHandler(c echo.Context) error {
+	key, ok := bindingKeyParam(c)
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid binding key")
+	}
+	// The value is decoded in two steps, so a missing value is not taken
+	// for null.
+	var req struct {
+		Value json.RawMessage `json:"value"`
+		TTL   *int            `json:"ttl"`
+	}
+	if err := c.Bind(&req); err != nil || (req.TTL != nil && *req.TTL < 0) {
+		return c.String(http.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Value) == 0 {
+		return c.String(http.StatusBadRequest, "Missing value; use null to mark the binding as null")
+	}
+	var value *BindingValue
+	if err := json.Unmarshal(req.Value, &value); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid value")
+	}
+	var origin bindingOrigin
+	if req.TTL != nil {
+		origin.ttls = map[string]int{key: *req.TTL}
+	}
+	depLogger.Info("Binding set via API", "Key", key, "Null", value == nil)
+	updateBindings(map[string]*BindingValue{key: value}, origin)
+	return c.String(http.StatusOK, "Binding set")
+}
+
+// deleteBindingHandler godoc
+// @Summary Delete a binding
+// @Description Removes a binding (or null binding). Entries written with it are not changed; later entries referencing it wait until it is provided again.
+// @Tags bindings
+// @Produce plain
+// @Param key path string true "Binding key, without the leading $"
+// @Success 200 {string} string "Binding deleted"
+// @Failure 400 {string} string "Invalid key"
+// @Failure 404 {string} string "Binding not found"
+// @Router /bindings/{key} [delete]
+func deleteBindingHandler(c echo.Context) error {
+	key, ok := bindingKeyParam(c)
+	if !ok {
+		return c.String(http.StatusBadRequest, "Invalid binding key")
+	}
+	bindingsMu.Lock()
+	_, found := bindings[key]
+	_, foundNull := nullBindings[key]
+	delete(bindings, key)
+	delete(nullBindings, key)
+	delete(bindingMetas, key)
+	bindingsMu.Unlock()
+	if !found && !foundNull {
+		return c.String(http.StatusNotFound, "Binding not found")
+	}
+	incCounter(mBindingsRemoved, "namespace", bindingPrefix(key), "reason", "api")
+	if err := deletePersistedBinding(key); err != nil {
+		depLogger.Error("Failed to delete persisted binding", "Key", key, "Err", err)
+	}
+	depLogger.Info("Binding deleted via API", "Key", key)
+	return c.String(http.StatusOK, "Binding deleted")
+}
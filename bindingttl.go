@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bindings can be given a lifetime and an owner by namespace, the part of
+// the key before the first dot (pidUidMap for pidUidMap.1001), under
+// binding_namespaces. A binding expires ttl_s after it was last set; a hook
+// can also set the TTL of the bindings it returns with bindingTtls, which
+// takes precedence. Scoped bindings belong to whoever set them: with scope
+// search they are removed when the search is deleted or the entry whose hook
+// response set them leaves its results, and with scope hook when the hook is
+// no longer configured. Removing bindings reprocesses the pending entries, so
+// entries referencing them wait until they are provided again; entries
+// already written keep their values.
+
+// BindingNamespaceConfig configures the bindings of a namespace.
+type BindingNamespaceConfig struct {
+	TTLSec int `yaml:"ttl_s"` // Lifetime of the bindings (default: none)
+	// Scope ties the bindings to the search or hook that set them: search,
+	// hook or global (default).
+	Scope string `yaml:"scope"`
+}
+
+const (
+	bindingScopeGlobal = "global"
+	bindingScopeSearch = "search"
+	bindingScopeHook   = "hook"
+)
+
+// bindingOrigin describes who set a batch of bindings.
+type bindingOrigin struct {
+	search string
+	source string // DN of the source entry whose hook response set them
+	hook   string
+	ttls   map[string]int // TTL in seconds by key, overriding the namespace's
+}
+
+// bindingMeta is the expiry and owner of a binding; it is only kept for
+// bindings with a TTL or a scope.
+type bindingMeta struct {
+	expires time.Time
+	search  string
+	source  string // Normalized DN
+	hook    string
+}
+
+// bindingMetas holds the metadata of bindings, guarded by bindingsMu.
+var bindingMetas = make(map[string]bindingMeta)
+
+var (
+	mBindings = describeMetric("ldapsync_bindings", "gauge",
+		"Bindings held, null bindings included, by key prefix.")
+	mBindingUpdates = describeMetric("ldapsync_binding_updates_total", "counter",
+		"Bindings set by hooks, imports or the API, by key prefix.")
+	mBindingsRemoved = describeMetric("ldapsync_bindings_removed_total", "counter",
+		"Bindings removed, by key prefix and reason (expired, search, source, hook or api).")
+)
+
+func init() {
+	registerCollector(func() {
+		resetGauge(mBindings)
+		counts := make(map[string]int)
+		bindingsMu.RLock()
+		for k := range bindings {
+			counts[bindingPrefix(k)]++
+		}
+		for k := range nullBindings {
+			counts[bindingPrefix(k)]++
+		}
+		bindingsMu.RUnlock()
+		for prefix, n := range counts {
+			setGauge(mBindings, float64(n), "namespace", prefix)
+		}
+	})
+}
+
+// bindingNamespace returns the namespace of a binding key: everything before
+// the first dot, or the whole key.
+func bindingNamespace(key string) string {
+	if i := strings.Index(key, "."); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func validateBindingNamespaces() error {
+	for ns, cfg := range config.BindingNamespaces {
+		if cfg.TTLSec < 0 {
+			return fmt.Errorf("binding_namespaces: %s: ttl_s must not be negative", ns)
+		}
+		switch cfg.Scope {
+		case "", bindingScopeGlobal, bindingScopeSearch, bindingScopeHook:
+		default:
+			return fmt.Errorf("binding_namespaces: %s: unknown scope %q", ns, cfg.Scope)
+		}
+	}
+	return nil
+}
+
+// newBindingMeta returns the metadata of a binding set by origin, and
+// whether it has any.
+func newBindingMeta(key string, origin bindingOrigin, now time.Time) (bindingMeta, bool) {
+	ns := config.BindingNamespaces[bindingNamespace(key)]
+	ttl := ns.TTLSec
+	if t, ok := origin.ttls[key]; ok {
+		ttl = t
+	}
+	var m bindingMeta
+	if ttl > 0 {
+		m.expires = now.Add(time.Duration(ttl) * time.Second)
+	}
+	switch ns.Scope {
+	case bindingScopeSearch:
+		if origin.search != "" {
+			m.search, m.source = origin.search, normalizeDN(origin.source)
+		}
+	case bindingScopeHook:
+		m.hook = origin.hook
+	}
+	return m, m != bindingMeta{}
+}
+
+// removeBindings removes the bindings whose metadata matches, then
+// reprocesses the pending entries. It returns how many were removed.
+func removeBindings(reason string, match func(bindingMeta) bool) int {
+	var keys []string
+	bindingsMu.Lock()
+	for k, m := range bindingMetas {
+		if match(m) {
+			keys = append(keys, k)
+			delete(bindings, k)
+			delete(nullBindings, k)
+			delete(bindingMetas, k)
+		}
+	}
+	bindingsMu.Unlock()
+	if len(keys) == 0 {
+		return 0
+	}
+	for _, k := range keys {
+		incCounter(mBindingsRemoved, "namespace", bindingPrefix(k), "reason", reason)
+	}
+	if err := deletePersistedBinding(keys...); err != nil {
+		depLogger.Error("Failed to delete persisted bindings", "Reason", reason, "Err", err)
+	}
+	depLogger.Info("Bindings removed", "Reason", reason, "Count", len(keys))
+	dependencyTracker.reprocessPending()
+	return len(keys)
+}
+
+// expireBindings removes the bindings whose TTL has passed.
+func expireBindings() {
+	now := time.Now()
+	removeBindings("expired", func(m bindingMeta) bool {
+		return !m.expires.IsZero() && now.After(m.expires)
+	})
+}
+
+// forgetSearchBindings removes the search-scoped bindings set for a search.
+func forgetSearchBindings(searchID string) {
+	removeBindings("search", func(m bindingMeta) bool { return m.search == searchID })
+}
+
+// forgetSourceBindings removes the search-scoped bindings set for an entry
+// that left a search's results.
+func forgetSourceBindings(searchID, dn string) {
+	key := normalizeDN(dn)
+	removeBindings("source", func(m bindingMeta) bool {
+		return m.search == searchID && m.source == key
+	})
+}
+
+// pruneHookBindings removes the hook-scoped bindings of hooks and transforms
+// that are no longer configured.
+func pruneHookBindings() {
+	removeBindings("hook", func(m bindingMeta) bool {
+		if m.hook == "" {
+			return false
+		}
+		if name, ok := strings.CutPrefix(m.hook, "transform:"); ok {
+			_, configured := config.Transforms[name]
+			return !configured
+		}
+		return !slices.Contains(config.Hooks, m.hook)
+	})
+}
+
+// bindingExpiries returns when the bindings with a TTL expire.
+func bindingExpiries() map[string]time.Time {
+	bindingsMu.RLock()
+	defer bindingsMu.RUnlock()
+	out := make(map[string]time.Time)
+	for k, m := range bindingMetas {
+		if !m.expires.IsZero() {
+			out[k] = m.expires
+		}
+	}
+	return out
+}
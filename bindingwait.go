@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MissingBindingPrefix aggregates unresolved binding keys sharing a namespace,
+// e.g. every pidUidMap.<pid> key a hook has not yet provided.
+type MissingBindingPrefix struct {
+	Prefix         string    `json:"prefix"`
+	MissingKeys    int       `json:"missingKeys"`
+	WaitingEntries int       `json:"waitingEntries"`
+	OldestWaiter   time.Time `json:"oldestWaiter"`
+	OldestDN       string    `json:"oldestDN"`
+	SampleKeys     []string  `json:"sampleKeys"`
+}
+
+const missingBindingSamples = 5
+
+var (
+	mMissingBindingKeys = describeMetric("ldapsync_missing_binding_keys", "gauge",
+		"Distinct unresolved binding keys, by key prefix.")
+	mMissingBindingWaiters = describeMetric("ldapsync_missing_binding_waiting_entries", "gauge",
+		"Pending entries waiting on unresolved bindings, by key prefix.")
+	mMissingBindingOldest = describeMetric("ldapsync_missing_binding_oldest_wait_seconds", "gauge",
+		"Age of the oldest entry waiting on a binding, by key prefix.")
+)
+
+func init() {
+	registerCollector(collectMissingBindingMetrics)
+}
+
+// bindingPrefix returns the namespace of a binding key: everything before the
+// first dot, rendered as "ns.*". Keys without a dot are their own prefix.
+func bindingPrefix(key string) string {
+	if i := strings.Index(key, "."); i > 0 {
+		return key[:i] + ".*"
+	}
+	return key
+}
+
+// missingBindings summarises pending entries by the prefixes of the binding
+// keys they wait on, largest backlog first.
+func (d *dependencyState) missingBindings() []MissingBindingPrefix {
+	type agg struct {
+		MissingBindingPrefix
+		keys map[string]struct{}
+	}
+	byPrefix := make(map[string]*agg)
+
+	d.mu.Lock()
+	for parentKey, p := range d.pending {
+		if p == nil || len(p.missingBindings) == 0 {
+			continue
+		}
+		since := d.waitingSince[parentKey]
+		counted := make(map[string]struct{})
+		for _, key := range p.missingBindings {
+			prefix := bindingPrefix(key)
+			a := byPrefix[prefix]
+			if a == nil {
+				a = &agg{MissingBindingPrefix: MissingBindingPrefix{Prefix: prefix}, keys: make(map[string]struct{})}
+				byPrefix[prefix] = a
+			}
+			a.keys[key] = struct{}{}
+			if _, ok := counted[prefix]; ok {
+				continue
+			}
+			counted[prefix] = struct{}{}
+			a.WaitingEntries++
+			if a.OldestWaiter.IsZero() || since.Before(a.OldestWaiter) {
+				a.OldestWaiter = since
+				a.OldestDN = p.entry.DN
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	out := make([]MissingBindingPrefix, 0, len(byPrefix))
+	for _, a := range byPrefix {
+		a.MissingKeys = len(a.keys)
+		a.SampleKeys = sortedKeys(a.keys)
+		if len(a.SampleKeys) > missingBindingSamples {
+			a.SampleKeys = a.SampleKeys[:missingBindingSamples]
+		}
+		out = append(out, a.MissingBindingPrefix)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].WaitingEntries != out[j].WaitingEntries {
+			return out[i].WaitingEntries > out[j].WaitingEntries
+		}
+		return out[i].Prefix < out[j].Prefix
+	})
+	return out
+}
+
+func collectMissingBindingMetrics() {
+	resetGauge(mMissingBindingKeys)
+	resetGauge(mMissingBindingWaiters)
+	resetGauge(mMissingBindingOldest)
+	now := time.Now()
+	for _, p := range dependencyTracker.missingBindings() {
+		setGauge(mMissingBindingKeys, float64(p.MissingKeys), "prefix", p.Prefix)
+		setGauge(mMissingBindingWaiters, float64(p.WaitingEntries), "prefix", p.Prefix)
+		setGauge(mMissingBindingOldest, now.Sub(p.OldestWaiter).Seconds(), "prefix", p.Prefix)
+	}
+}
+
+// getMissingBindingsHandler godoc
+// @Summary List missing bindings
+// @Description Aggregates binding keys that pending entries are waiting on by key prefix, with counts and the oldest waiter.
+// @Tags bindings
+// @Produce json
+// @Success 200 {array} MissingBindingPrefix
+// @Router /bindings/missing [get]
+func getMissingBindingsHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, dependencyTracker.missingBindings())
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The cached search results and the per-DN write locks otherwise grow for
+// the life of the process. Results can be capped per search and by an
+// estimated memory budget across searches; when a cap is exceeded the
+// least recently seen results are evicted. An evicted entry counts as new
+// the next time its search returns it and is sent to the hooks again, so
+// the caps are a safety net, not a working size: set them above the size
+// of the searches. DN locks not used for dn_lock_ttl_s are dropped.
+
+// CacheLimitsConfig bounds the in-memory caches.
+type CacheLimitsConfig struct {
+	// MaxResultsPerSearch caps the cached results of each search (0:
+	// unlimited).
+	MaxResultsPerSearch int `yaml:"max_results_per_search"`
+	// MaxResultsMemoryMB caps the estimated size of all cached results (0:
+	// unlimited).
+	MaxResultsMemoryMB int `yaml:"max_results_memory_mb"`
+	// DNLockTTLSec drops per-DN write locks unused this long (default: 600).
+	DNLockTTLSec int `yaml:"dn_lock_ttl_s"`
+	// IntervalSec is how often the memory budget and locks are checked and
+	// the cache metrics updated (default: 30).
+	IntervalSec int `yaml:"interval_s"`
+}
+
+var (
+	mResultCacheEntries = describeMetric("ldapsync_result_cache_entries", "gauge",
+		"Cached search results, by search.")
+	mResultCacheBytes = describeMetric("ldapsync_result_cache_bytes", "gauge",
+		"Estimated memory used by the cached search results.")
+	mResultCacheEvictions = describeMetric("ldapsync_result_cache_evictions_total", "counter",
+		"Cached search results evicted, by reason (search_limit, memory).")
+	mDNLocks = describeMetric("ldapsync_dn_locks", "gauge",
+		"Per-DN write locks held in memory.")
+)
+
+func init() {
+	registerCollector(func() {
+		resetGauge(mResultCacheEntries)
+		searchResultsMu.RLock()
+		for id, results := range searchResults {
+			setGauge(mResultCacheEntries, float64(len(results)), "search", id)
+		}
+		searchResultsMu.RUnlock()
+		dnLocks.Lock()
+		n := len(dnLocks.byDN)
+		dnLocks.Unlock()
+		setGauge(mDNLocks, float64(n))
+	})
+}
+
+// evictionTarget is the fraction of a cap eviction shrinks to, so one pass
+// makes room for many inserts.
+const evictionTarget = 0.9
+
+// dnLock serializes writes to one target DN. refs counts the writers using
+// it; only unreferenced locks are dropped.
+type dnLock struct {
+	sync.Mutex
+	refs     int
+	lastUsed time.Time
+}
+
+var dnLocks = struct {
+	sync.Mutex
+	byDN map[string]*dnLock
+}{byDN: make(map[string]*dnLock)}
+
+// lockDN locks the DN for writing and returns the function that unlocks it.
+func lockDN(dn string) (unlock func()) {
+	key := normalizeDN(dn)
+	if key == "" {
+		key = dn
+	}
+	dnLocks.Lock()
+	l := dnLocks.byDN[key]
+	if l == nil {
+		l = &dnLock{}
+		dnLocks.byDN[key] = l
+	}
+	l.refs++
+	dnLocks.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		dnLocks.Lock()
+		l.refs--
+		l.lastUsed = time.Now()
+		dnLocks.Unlock()
+	}
+}
+
+// pruneDNLocks drops the locks unused for ttl.
+func pruneDNLocks(ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+	dnLocks.Lock()
+	for key, l := range dnLocks.byDN {
+		if l.refs == 0 && l.lastUsed.Before(cutoff) {
+			delete(dnLocks.byDN, key)
+		}
+	}
+	dnLocks.Unlock()
+}
+
+// resultSize estimates the memory a cached result uses.
+func resultSize(r LDAPResult) int {
+	size := 96 + len(r.DN) + len(r.hash) + len(r.correlation)
+	for attr, v := range r.Content {
+		size += 32 + len(attr)
+		switch v := v.(type) {
+		case string:
+			size += len(v)
+		case []string:
+			for _, s := range v {
+				size += 16 + len(s)
+			}
+		}
+	}
+	return size
+}
+
+// enforceSearchLimit evicts the least recently seen results of a search
+// over max_results_per_search. The caller holds searchResultsMu.
+func enforceSearchLimit(id string, results map[string]LDAPResult) {
+	limit := config.CacheLimits.MaxResultsPerSearch
+	if limit <= 0 || len(results) <= limit {
+		return
+	}
+	keys := make([]string, 0, len(results))
+	for k := range results {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return results[keys[i]].touched < results[keys[j]].touched })
+	evict := len(results) - int(float64(limit)*evictionTarget)
+	for _, k := range keys[:evict] {
+		delete(results, k)
+	}
+	addCounter(mResultCacheEvictions, float64(evict), "reason", "search_limit")
+	syncLogger.Warn("Evicted cached results over the per-search limit; they will be re-sent when seen again",
+		"SearchId", id, "Evicted", evict, "Limit", limit)
+}
+
+// enforceMemoryBudget updates the cache size metric and evicts the least
+// recently seen results across searches while their estimated size exceeds
+// max_results_memory_mb.
+func enforceMemoryBudget() {
+	budget := config.CacheLimits.MaxResultsMemoryMB << 20
+	type cached struct {
+		id, key string
+		touched int64
+		size    int
+	}
+	var all []cached
+	total := 0
+	searchResultsMu.Lock()
+	defer searchResultsMu.Unlock()
+	for id, results := range searchResults {
+		for k, r := range results {
+			size := resultSize(r)
+			total += size
+			if budget > 0 {
+				all = append(all, cached{id, k, r.touched, size})
+			}
+		}
+	}
+	if budget > 0 && total > budget {
+		sort.Slice(all, func(i, j int) bool { return all[i].touched < all[j].touched })
+		target := int(float64(budget) * evictionTarget)
+		evicted := 0
+		for _, c := range all {
+			if total <= target {
+				break
+			}
+			delete(searchResults[c.id], c.key)
+			total -= c.size
+			evicted++
+		}
+		addCounter(mResultCacheEvictions, float64(evicted), "reason", "memory")
+		syncLogger.Warn("Evicted cached results over the memory budget; they will be re-sent when seen again",
+			"Evicted", evicted, "BudgetMB", config.CacheLimits.MaxResultsMemoryMB)
+	}
+	setGauge(mResultCacheBytes, float64(total))
+}
+
+// runCacheSweeper enforces the memory budget and prunes DN locks.
+func runCacheSweeper() {
+	limits := config.CacheLimits
+	interval := time.Duration(limits.IntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ttl := time.Duration(limits.DNLockTTLSec) * time.Second
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		enforceMemoryBudget()
+		pruneDNLocks(ttl)
+		expireBindings()
+		pruneDependencyLookupMisses()
+	}
+}
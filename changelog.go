@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ChangelogConfig describes the source's retro changelog (cn=changelog, as
+// defined by draft-good-ldap-changelog), used by searches with
+// change_detection "changelog".
+type ChangelogConfig struct {
+	// Flavor selects server quirks: "389ds" (default), "edirectory" or "generic".
+	Flavor string `yaml:"flavor"`
+	// BaseDN of the changelog (default: cn=changelog).
+	BaseDN string `yaml:"base_dn"`
+	// PageSize for reading changelog entries (default: 500).
+	PageSize uint32 `yaml:"page_size"`
+}
+
+// Change detection modes of a search.
+const (
+	changeDetectionPoll      = "poll"
+	changeDetectionChangelog = "changelog"
+)
+
+func validChangeDetection(mode string) bool {
+	return mode == "" || mode == changeDetectionPoll || mode == changeDetectionChangelog
+}
+
+// changelogCursor is the last change number a search has applied. It is
+// only valid after a full baseline search.
+type changelogCursor struct {
+	last  int64
+	valid bool
+}
+
+// changeRecord is one changelog entry.
+type changeRecord struct {
+	number       int64
+	targetDN     string
+	changeType   string
+	newRDN       string
+	newSuperior  string
+	deleteOldRDN bool
+}
+
+func changelogBaseDN() string {
+	if config.Changelog.BaseDN != "" {
+		return config.Changelog.BaseDN
+	}
+	return "cn=changelog"
+}
+
+// lastChangeNumberAttrs lists the root DSE attributes holding the newest
+// change number. eDirectory publishes it as lastChangeNumber on the root DSE
+// only when the changelog is enabled on the LDAP server object.
+func lastChangeNumberAttrs() []string {
+	switch strings.ToLower(config.Changelog.Flavor) {
+	case "edirectory":
+		return []string{"lastChangeNumber"}
+	case "generic":
+		return []string{"lastchangenumber", "lastChangeNumber"}
+	default:
+		return []string{"lastchangenumber"}
+	}
+}
+
+// readLastChangeNumber returns the newest change number of the source. When
+// the root DSE does not publish it, the changelog itself is scanned.
+func readLastChangeNumber(l *ldap.Conn) (int64, error) {
+	attrs := lastChangeNumberAttrs()
+	sr, err := l.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", attrs, nil))
+	if err == nil && len(sr.Entries) > 0 {
+		for _, a := range attrs {
+			if v := sr.Entries[0].GetEqualFoldAttributeValue(a); v != "" {
+				return strconv.ParseInt(v, 10, 64)
+			}
+		}
+	}
+	changes, err := readChanges(l, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(changes) == 0 {
+		return 0, nil
+	}
+	return changes[len(changes)-1].number, nil
+}
+
+// readChanges returns changelog entries newer than after, in change order.
+func readChanges(l *ldap.Conn, after int64) ([]changeRecord, error) {
+	pageSize := config.Changelog.PageSize
+	if pageSize == 0 {
+		pageSize = 500
+	}
+	filter := fmt.Sprintf("(&(objectClass=changeLogEntry)(changeNumber>=%d))", after+1)
+	sr, err := l.SearchWithPaging(ldap.NewSearchRequest(changelogBaseDN(), ldap.ScopeSingleLevel, ldap.NeverDerefAliases,
+		0, 0, false, filter,
+		[]string{"changeNumber", "targetDN", "changeType", "newRDN", "newSuperior", "deleteOldRDN"}, nil), pageSize)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]changeRecord, 0, len(sr.Entries))
+	for _, e := range sr.Entries {
+		n, err := strconv.ParseInt(e.GetEqualFoldAttributeValue("changeNumber"), 10, 64)
+		if err != nil || n <= after {
+			continue
+		}
+		changes = append(changes, changeRecord{
+			number:       n,
+			targetDN:     e.GetEqualFoldAttributeValue("targetDN"),
+			changeType:   strings.ToLower(e.GetEqualFoldAttributeValue("changeType")),
+			newRDN:       e.GetEqualFoldAttributeValue("newRDN"),
+			newSuperior:  e.GetEqualFoldAttributeValue("newSuperior"),
+			deleteOldRDN: strings.EqualFold(e.GetEqualFoldAttributeValue("deleteOldRDN"), "true"),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].number < changes[j].number })
+	return changes, nil
+}
+
+// renamedDN returns the DN of an entry after a modrdn change.
+func (c changeRecord) renamedDN() string {
+	parent := c.newSuperior
+	if parent == "" {
+		if parsed, err := ldap.ParseDN(c.targetDN); err == nil && len(parsed.RDNs) > 0 {
+			parent = (&ldap.DN{RDNs: parsed.RDNs[1:]}).String()
+		}
+	}
+	if parent == "" {
+		return c.newRDN
+	}
+	return c.newRDN + "," + parent
+}
+
+// forgetResult removes an entry from a search's results.
+func forgetResult(id, dn string) {
+	key := normalizeDN(dn)
+	var removed LDAPResult
+	var found bool
+	searchResultsMu.Lock()
+	if results, ok := searchResults[id]; ok {
+		if _, ok := results[key]; !ok {
+			// Results of searches with a correlation attribute are keyed
+			// by it.
+			for k, r := range results {
+				if normalizeDN(r.DN) == key {
+					key = k
+					break
+				}
+			}
+		}
+		removed, found = results[key]
+		delete(results, key)
+	}
+	searchResultsMu.Unlock()
+	unpersistResults(id, key)
+	if found {
+		countersFor(id).deleted.Add(1)
+		notifyDeleted(id, removed)
+		forgetSourceBindings(id, removed.DN)
+		publishSourceEvent(id, changeTypeDelete, LDAPResult{DN: removed.DN, previous: removed.Content})
+	}
+}
+
+// forgetUnseenResults removes the results of a search whose DNs (normalized)
+// a complete run did not return, so entries that were deleted or no longer
+// match reach the hooks as deletes.
+func forgetUnseenResults(id string, seen map[string]struct{}) {
+	var gone []string
+	searchResultsMu.RLock()
+	for _, r := range searchResults[id] {
+		if _, ok := seen[normalizeDN(r.DN)]; !ok {
+			gone = append(gone, r.DN)
+		}
+	}
+	searchResultsMu.RUnlock()
+	for _, dn := range gone {
+		syncLogger.Debug("Entry left the search", "SearchId", id, "DN", dn)
+		forgetResult(id, dn)
+	}
+}
+
+// refetchEntry reads one source entry and processes it if it still matches
+// the search filter.
+func refetchEntry(l *ldap.Conn, id, dn string, spec *SearchSpec) error {
+	sr, err := l.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, spec.Filter, requestedAttributes(id, spec), nil))
+	if err != nil {
+		if ldapErr, ok := err.(*ldap.Error); ok && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+			forgetResult(id, dn)
+			return nil
+		}
+		return err
+	}
+	if len(sr.Entries) == 0 {
+		// The entry no longer matches the filter.
+		forgetResult(id, dn)
+		return nil
+	}
+	if err := completeRangedAttributes(l, sr.Entries[0]); err != nil {
+		return err
+	}
+	processLDAPEntry(id, sr.Entries[0], spec)
+	return nil
+}
+
+// syncFromChangelog applies the changes since the cursor to the search. An
+// error invalidates the cursor so the next cycle falls back to a full search.
+func syncFromChangelog(l *ldap.Conn, id string, spec *SearchSpec, cursor *changelogCursor) error {
+	changes, err := readChanges(l, cursor.last)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 && changes[0].number > cursor.last+1 && cursor.last > 0 {
+		return fmt.Errorf("changelog trimmed past change %d (oldest available %d)", cursor.last, changes[0].number)
+	}
+	base, err := ldap.ParseDN(spec.BaseDN)
+	if err != nil {
+		return fmt.Errorf("invalid base DN %q: %w", spec.BaseDN, err)
+	}
+	inScope := func(dn string) bool {
+		parsed, err := ldap.ParseDN(dn)
+		return err == nil && inSearchScope(base, parsed, spec.Scope)
+	}
+	for _, c := range changes {
+		switch c.changeType {
+		case "add", "modify":
+			if inScope(c.targetDN) {
+				err = refetchEntry(l, id, c.targetDN, spec)
+			}
+		case "delete":
+			if inScope(c.targetDN) {
+				forgetResult(id, c.targetDN)
+			}
+		case "modrdn", "moddn":
+			if inScope(c.targetDN) {
+				forgetResult(id, c.targetDN)
+			}
+			if newDN := c.renamedDN(); inScope(newDN) {
+				err = refetchEntry(l, id, newDN, spec)
+			}
+		default:
+			syncLogger.Debug("Ignoring changelog entry", "SearchId", id, "ChangeNumber", c.number, "ChangeType", c.changeType)
+		}
+		if err != nil {
+			return fmt.Errorf("applying change %d to %s: %w", c.number, c.targetDN, err)
+		}
+		cursor.last = c.number
+	}
+	if len(changes) > 0 {
+		syncLogger.Debug("Applied changelog entries", "SearchId", id, "Count", len(changes), "LastChangeNumber", cursor.last)
+	}
+	return nil
+}
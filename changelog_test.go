@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestRenamedDN(t *testing.T) {
+	tests := []struct {
+		name   string
+		change changeRecord
+		want   string
+	}{
+		{"new rdn", changeRecord{targetDN: "uid=a,ou=people,dc=org", newRDN: "uid=b"}, "uid=b,ou=people,dc=org"},
+		{"new superior", changeRecord{targetDN: "uid=a,ou=people,dc=org", newRDN: "uid=a", newSuperior: "ou=former,dc=org"}, "uid=a,ou=former,dc=org"},
+		{"escaped parent", changeRecord{targetDN: `cn=x,ou=Smith\, John,dc=org`, newRDN: "cn=y"}, `cn=y,ou=Smith\, John,dc=org`},
+		{"top-level entry", changeRecord{targetDN: "dc=org", newRDN: "dc=com"}, "dc=com"},
+		{"unparsable target", changeRecord{targetDN: "not a dn", newRDN: "cn=y"}, "cn=y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.change.renamedDN(); got != tt.want {
+				t.Errorf("renamedDN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadChanges(t *testing.T) {
+	entries := []*ldap.Entry{
+		ldap.NewEntry("changeNumber=12,cn=changelog", map[string][]string{
+			"changeNumber": {"12"}, "targetDN": {"uid=a,dc=org"}, "changeType": {"modrdn"},
+			"newRDN": {"uid=b"}, "newSuperior": {"ou=x,dc=org"}, "deleteOldRDN": {"TRUE"},
+		}),
+		ldap.NewEntry("changeNumber=11,cn=changelog", map[string][]string{
+			"changenumber": {"11"}, "targetdn": {"uid=c,dc=org"}, "changetype": {"Delete"},
+		}),
+		ldap.NewEntry("changeNumber=10,cn=changelog", map[string][]string{
+			"changeNumber": {"10"}, "targetDN": {"uid=d,dc=org"}, "changeType": {"add"},
+		}),
+		ldap.NewEntry("cn=bogus,cn=changelog", map[string][]string{"changeNumber": {"x"}}),
+	}
+	tests := []struct {
+		name       string
+		after      int64
+		wantFilter string
+		want       []changeRecord
+	}{
+		{
+			name: "from the start", after: 0, wantFilter: "(&(objectClass=changeLogEntry)(changeNumber>=1))",
+			want: []changeRecord{
+				{number: 10, targetDN: "uid=d,dc=org", changeType: "add"},
+				{number: 11, targetDN: "uid=c,dc=org", changeType: "delete"},
+				{number: 12, targetDN: "uid=a,dc=org", changeType: "modrdn", newRDN: "uid=b", newSuperior: "ou=x,dc=org", deleteOldRDN: true},
+			},
+		},
+		{
+			// Servers may return entries the filter should have excluded.
+			name: "after a change", after: 11, wantFilter: "(&(objectClass=changeLogEntry)(changeNumber>=12))",
+			want: []changeRecord{
+				{number: 12, targetDN: "uid=a,dc=org", changeType: "modrdn", newRDN: "uid=b", newSuperior: "ou=x,dc=org", deleteOldRDN: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, requests := fakeSearchServer(t, entries)
+			got, err := readChanges(l, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readChanges() = %+v, want %+v", got, tt.want)
+			}
+			req := <-requests
+			if req.BaseDN != "cn=changelog" || req.Filter != tt.wantFilter {
+				t.Errorf("search of %q for %q, want cn=changelog for %q", req.BaseDN, req.Filter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+// fakeSearchServer returns a connection to a server answering every search
+// with entries, and the searches it received.
+func fakeSearchServer(t *testing.T, entries []*ldap.Entry) (*ldap.Conn, <-chan *ldap.SearchRequest) {
+	t.Helper()
+	return fakeLDAPServer(t, func(*ldap.SearchRequest) []*ldap.Entry { return entries })
+}
+
+// fakeLDAPServer returns a connection to a server answering each search with
+// the entries answer returns for it, and the searches it received.
+func fakeLDAPServer(t *testing.T, answer func(*ldap.SearchRequest) []*ldap.Entry) (*ldap.Conn, <-chan *ldap.SearchRequest) {
+	t.Helper()
+	client, server := net.Pipe()
+	requests := make(chan *ldap.SearchRequest, 10)
+	go func() {
+		defer server.Close()
+		for {
+			packet, err := ber.ReadPacket(server)
+			if err != nil {
+				return
+			}
+			id := packet.Children[0].Value.(int64)
+			op := packet.Children[1]
+			if op.Tag != ldap.ApplicationSearchRequest {
+				continue
+			}
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			req := &ldap.SearchRequest{BaseDN: op.Children[0].Data.String(), Filter: filter}
+			for _, a := range op.Children[7].Children {
+				req.Attributes = append(req.Attributes, a.Data.String())
+			}
+			requests <- req
+			for _, e := range answer(req) {
+				res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for _, a := range e.Attributes {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Type"))
+					vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					for _, v := range a.Values {
+						vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+					}
+					attr.AppendChild(vals)
+					attrs.AppendChild(attr)
+				}
+				res.AppendChild(attrs)
+				if !writeResponse(server, id, res) {
+					return
+				}
+			}
+			done := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultDone, nil, "Search Result Done")
+			done.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, ldap.LDAPResultSuccess, "Result Code"))
+			done.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+			done.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+			if !writeResponse(server, id, done) {
+				return
+			}
+		}
+	}()
+	l := ldap.NewConn(client, false)
+	l.Start()
+	t.Cleanup(func() { l.Close() })
+	return l, requests
+}
+
+func writeResponse(conn net.Conn, id int64, op *ber.Packet) bool {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	envelope.AppendChild(op)
+	_, err := conn.Write(envelope.Bytes())
+	return err == nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ChecksumConfig stores a checksum of the managed content of each written
+// entry in a target attribute. Comparing it with the checksum of the content
+// a search would write now tells whether the entry is current without
+// fetching and diffing its attributes.
+type ChecksumConfig struct {
+	Attribute string `yaml:"attribute"` // Target attribute holding the checksum (empty disables it)
+	// SkipUnchanged skips the modify of an existing entry whose stored
+	// checksum matches the content being written.
+	SkipUnchanged bool `yaml:"skip_unchanged"`
+}
+
+// checksumPrefix tags the algorithm so the format can change later.
+const checksumPrefix = "sha256:"
+
+var mChecksumUnchanged = describeMetric("ldapsync_checksum_unchanged_total", "counter",
+	"Target modifies skipped because the stored checksum matched.")
+
+func checksumEnabled() bool {
+	return config.Checksum.Attribute != ""
+}
+
+// entryChecksum hashes the attributes of an entry as written to the target.
+// Attribute names are compared case-insensitively and value order does not
+// matter; the checksum attribute itself and the ownership marker, which
+// ldap-sync adds on its own, are left out.
+func entryChecksum(attributes map[string][]string) string {
+	names := make([]string, 0, len(attributes))
+	byName := make(map[string][]string, len(attributes))
+	for attr, vals := range attributes {
+		name := strings.ToLower(attr)
+		if strings.EqualFold(attr, config.Checksum.Attribute) {
+			continue
+		}
+		if m := config.Policy.OwnershipMarker; m != nil && strings.EqualFold(attr, m.Attribute) {
+			continue
+		}
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], vals...)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		vals := append([]string{}, byName[name]...)
+		sort.Strings(vals)
+		fmt.Fprintf(h, "%d:%s=%d", len(name), name, len(vals))
+		for _, v := range vals {
+			fmt.Fprintf(h, ",%d:%s", len(v), v)
+		}
+		h.Write([]byte{'\n'})
+	}
+	return checksumPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// checksumSearchAttributes returns the attributes to read from an existing
+// target entry so its stored checksum can be compared.
+func checksumSearchAttributes() []string {
+	if !checksumEnabled() {
+		return nil
+	}
+	return []string{config.Checksum.Attribute}
+}
+
+// storedChecksum returns the checksum recorded on a target entry, or "".
+func storedChecksum(entry *ldap.Entry) string {
+	if entry == nil || !checksumEnabled() {
+		return ""
+	}
+	if vals := getEntryAttributeValues(entry, config.Checksum.Attribute); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// stampChecksum records sum in the checksum attribute of attributes.
+func stampChecksum(attributes map[string][]string, sum string) {
+	for attr := range attributes {
+		if strings.EqualFold(attr, config.Checksum.Attribute) {
+			delete(attributes, attr)
+		}
+	}
+	attributes[config.Checksum.Attribute] = []string{sum}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// CompareOptions selects the managed entries compared between two targets.
+type CompareOptions struct {
+	Filter     string   `json:"filter"`     // default: (objectClass=*)
+	Attributes []string `json:"attributes"` // default: all user attributes
+	Ignore     []string `json:"ignore"`     // attributes left out of the hash
+}
+
+// CompareReport describes how two target directories diverge. Entries are
+// matched by their DN relative to each side's base DN, so environments with
+// different suffixes can be compared.
+type CompareReport struct {
+	BaseA     string        `json:"baseA"`
+	BaseB     string        `json:"baseB"`
+	EntriesA  int           `json:"entriesA"`
+	EntriesB  int           `json:"entriesB"`
+	Identical int           `json:"identical"`
+	OnlyInA   []string      `json:"onlyInA"`
+	OnlyInB   []string      `json:"onlyInB"`
+	Differing []EntryDiffer `json:"differing"`
+}
+
+// EntryDiffer is an entry present on both sides with different content.
+type EntryDiffer struct {
+	RDN        string   `json:"rdn"` // DN relative to the base DN
+	HashA      string   `json:"hashA"`
+	HashB      string   `json:"hashB"`
+	Attributes []string `json:"attributes"` // attributes whose values differ
+}
+
+// Diverged reports whether the two sides differ at all.
+func (r *CompareReport) Diverged() bool {
+	return len(r.OnlyInA) > 0 || len(r.OnlyInB) > 0 || len(r.Differing) > 0
+}
+
+// snapshotEntry is the content-addressed form of one entry: a hash over all
+// compared attributes plus per-attribute hashes for reporting differences.
+type snapshotEntry struct {
+	hash  string
+	attrs map[string]string
+}
+
+// dialLDAP connects and binds to an LDAP server.
+func dialLDAP(cfg LDAPConfig) (*ldap.Conn, error) {
+	urls := ldapURLs(cfg)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no LDAP server: url is empty and no domain controller was located")
+	}
+	opts, err := ldapDialOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var l *ldap.Conn
+	var server string
+	for _, server = range urls {
+		if l, err = ldap.DialURL(server, opts...); err == nil {
+			break
+		}
+		logger.Debug("Failed to connect to LDAP server", "URL", server, "Err", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = bindLDAP(l, cfg, server); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// relativeDN returns dn relative to base in normalized form.
+func relativeDN(dn string, base *ldap.DN) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || base == nil || len(parsed.RDNs) < len(base.RDNs) {
+		return normalizeDN(dn)
+	}
+	rel := &ldap.DN{RDNs: parsed.RDNs[:len(parsed.RDNs)-len(base.RDNs)]}
+	return strings.ToLower(rel.String())
+}
+
+// hashValues hashes an attribute's values independent of their order.
+func hashValues(vals [][]byte) string {
+	sorted := make([]string, len(vals))
+	for i, v := range vals {
+		sorted[i] = string(v)
+	}
+	sort.Strings(sorted)
+	h := sha256.New()
+	for _, v := range sorted {
+		fmt.Fprintf(h, "%d:%s", len(v), v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// snapshotTarget reads the managed entries of one directory and hashes them.
+func snapshotTarget(cfg LDAPConfig, opts CompareOptions) (map[string]snapshotEntry, error) {
+	l, err := dialLDAP(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.URL, err)
+	}
+	defer l.Close()
+	attrs := opts.Attributes
+	if len(attrs) == 0 {
+		attrs = []string{"*"}
+	}
+	sr, err := l.SearchWithPaging(ldap.NewSearchRequest(cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, opts.Filter, attrs, nil), 500)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.URL, err)
+	}
+	base, _ := ldap.ParseDN(cfg.BaseDN)
+	ignore := make(map[string]struct{}, len(opts.Ignore))
+	for _, a := range opts.Ignore {
+		ignore[strings.ToLower(a)] = struct{}{}
+	}
+	out := make(map[string]snapshotEntry, len(sr.Entries))
+	for _, e := range sr.Entries {
+		entry := snapshotEntry{attrs: make(map[string]string)}
+		for _, a := range e.Attributes {
+			name := strings.ToLower(a.Name)
+			if _, skip := ignore[name]; skip {
+				continue
+			}
+			entry.attrs[name] = hashValues(a.ByteValues)
+		}
+		names := make([]string, 0, len(entry.attrs))
+		for name := range entry.attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h := sha256.New()
+		for _, name := range names {
+			fmt.Fprintf(h, "%s=%s\n", name, entry.attrs[name])
+		}
+		entry.hash = hex.EncodeToString(h.Sum(nil))
+		out[relativeDN(e.DN, base)] = entry
+	}
+	return out, nil
+}
+
+// compareTargets snapshots both directories and reports their divergence.
+func compareTargets(a, b LDAPConfig, opts CompareOptions) (*CompareReport, error) {
+	if opts.Filter == "" {
+		opts.Filter = "(objectClass=*)"
+	}
+	snapA, err := snapshotTarget(a, opts)
+	if err != nil {
+		return nil, err
+	}
+	snapB, err := snapshotTarget(b, opts)
+	if err != nil {
+		return nil, err
+	}
+	report := &CompareReport{
+		BaseA: a.BaseDN, BaseB: b.BaseDN,
+		EntriesA: len(snapA), EntriesB: len(snapB),
+		OnlyInA: []string{}, OnlyInB: []string{}, Differing: []EntryDiffer{},
+	}
+	for rdn, ea := range snapA {
+		eb, ok := snapB[rdn]
+		switch {
+		case !ok:
+			report.OnlyInA = append(report.OnlyInA, rdn)
+		case ea.hash == eb.hash:
+			report.Identical++
+		default:
+			d := EntryDiffer{RDN: rdn, HashA: ea.hash, HashB: eb.hash}
+			for name, h := range ea.attrs {
+				if eb.attrs[name] != h {
+					d.Attributes = append(d.Attributes, name)
+				}
+			}
+			for name := range eb.attrs {
+				if _, ok := ea.attrs[name]; !ok {
+					d.Attributes = append(d.Attributes, name)
+				}
+			}
+			sort.Strings(d.Attributes)
+			report.Differing = append(report.Differing, d)
+		}
+	}
+	for rdn := range snapB {
+		if _, ok := snapA[rdn]; !ok {
+			report.OnlyInB = append(report.OnlyInB, rdn)
+		}
+	}
+	sort.Strings(report.OnlyInA)
+	sort.Strings(report.OnlyInB)
+	sort.Slice(report.Differing, func(i, j int) bool { return report.Differing[i].RDN < report.Differing[j].RDN })
+	return report, nil
+}
+
+func writeCompareText(w io.Writer, r *CompareReport) {
+	fmt.Fprintf(w, "A: %s (%d entries)\nB: %s (%d entries)\nidentical: %d\n", r.BaseA, r.EntriesA, r.BaseB, r.EntriesB, r.Identical)
+	for _, rdn := range r.OnlyInA {
+		fmt.Fprintf(w, "only in A: %s\n", rdn)
+	}
+	for _, rdn := range r.OnlyInB {
+		fmt.Fprintf(w, "only in B: %s\n", rdn)
+	}
+	for _, d := range r.Differing {
+		fmt.Fprintf(w, "differs:   %s [%s]\n", d.RDN, strings.Join(d.Attributes, ", "))
+	}
+}
+
+// runCompare implements `ldap-sync compare`. It compares the targets of two
+// config files and exits 1 if they diverge, 2 on error.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	configA := fs.String("config-a", "", "Config file whose target is side A")
+	configB := fs.String("config-b", "", "Config file whose target is side B")
+	filter := fs.String("filter", "(objectClass=*)", "LDAP filter selecting managed entries")
+	attributes := fs.String("attributes", "", "Comma-separated attributes to compare (default: all user attributes)")
+	ignore := fs.String("ignore", "", "Comma-separated attributes to leave out of the comparison")
+	output := fs.String("output", "text", "Report format: text or json")
+	fs.Parse(args)
+	if *configA == "" || *configB == "" {
+		fmt.Fprintln(os.Stderr, "compare: --config-a and --config-b are required")
+		return 2
+	}
+	a, err := readConfigFile(*configA)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compare: %v\n", err)
+		return 2
+	}
+	b, err := readConfigFile(*configB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compare: %v\n", err)
+		return 2
+	}
+	report, err := compareTargets(a.Target, b.Target, CompareOptions{
+		Filter:     *filter,
+		Attributes: parseAttributeList(*attributes),
+		Ignore:     parseAttributeList(*ignore),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compare: %v\n", err)
+		return 2
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		writeCompareText(os.Stdout, report)
+	}
+	if report.Diverged() {
+		return 1
+	}
+	return 0
+}
+
+// CompareRequest is the body of POST /compare.
+type CompareRequest struct {
+	Other struct {
+		URL          string `json:"url"`
+		BindDN       string `json:"bind_dn"`
+		BindPassword string `json:"bind_password"`
+		BaseDN       string `json:"base_dn"`
+	} `json:"other"`
+	CompareOptions
+}
+
+// compareHandler godoc
+// @Summary Compare targets
+// @Description Hashes the managed entries of this service's target (side A) and another directory (side B) and reports divergence.
+// @Tags compare
+// @Accept json
+// @Produce json
+// @Param request body CompareRequest true "Other directory and comparison options"
+// @Success 200 {object} CompareReport
+// @Failure 400 {string} string "Invalid request"
+// @Failure 502 {object} map[string]string "Directory could not be read"
+// @Router /compare [post]
+func compareHandler(c echo.Context) error {
+	var req CompareRequest
+	if err := c.Bind(&req); err != nil || req.Other.URL == "" {
+		return c.String(http.StatusBadRequest, "Invalid request; other.url is required")
+	}
+	other := LDAPConfig{URL: req.Other.URL, BindDN: req.Other.BindDN, BindPassword: req.Other.BindPassword, BaseDN: req.Other.BaseDN}
+	if other.BaseDN == "" {
+		other.BaseDN = config.Target.BaseDN
+	}
+	report, err := compareTargets(config.Target, other, req.CompareOptions)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A runOnce search is marked completed, with the time and its number of
+// results, when its run succeeds. Completed searches keep their results for
+// /results and are not run again after a restart; with
+// completed_searches.retention_m they are removed, like a DELETE /search,
+// once they have been completed that long.
+
+// CompletedSearchesConfig controls the removal of completed runOnce
+// searches.
+type CompletedSearchesConfig struct {
+	RetentionMin int `yaml:"retention_m"` // Remove completed searches after this long (0 keeps them)
+	IntervalMin  int `yaml:"interval_m"`  // Time between cleanup passes (default: 5)
+}
+
+const (
+	searchStateActive    = "active"
+	searchStateCompleted = "completed"
+)
+
+var mCompletedRemoved = describeMetric("ldapsync_completed_searches_removed_total", "counter",
+	"Completed runOnce searches removed after their retention.")
+
+func validateCompletedSearches() error {
+	c := config.CompletedSearches
+	if c.RetentionMin < 0 || c.IntervalMin < 0 {
+		return fmt.Errorf("completed_searches: retention_m and interval_m must not be negative")
+	}
+	return nil
+}
+
+// markSearchCompleted records the completion of the search run with the
+// given stop channel, unless the search has been replaced since.
+func markSearchCompleted(id string, stop chan struct{}) {
+	searchResultsMu.RLock()
+	count := len(searchResults[id])
+	searchResultsMu.RUnlock()
+	now := time.Now()
+	searchesMu.Lock()
+	spec, ok := searches[id]
+	current := ok && spec.Stop == stop
+	if current {
+		spec.CompletedAt = now
+		spec.ResultCount = count
+	}
+	searchesMu.Unlock()
+	if !current {
+		return
+	}
+	syncLogger.Info("Run-once search completed", "SearchId", id, "Results", count)
+	if db == nil {
+		return
+	}
+	// Saved in full, so a search without a row yet is not run again after
+	// a restart.
+	if err := saveSearchToDB(id, spec); err != nil {
+		syncLogger.Error("Failed to record search completion", "SearchId", id, "Err", err)
+	}
+}
+
+// searchState returns the state of a search for the API.
+func searchState(spec *SearchSpec) string {
+	if spec.CompletedAt.IsZero() {
+		return searchStateActive
+	}
+	return searchStateCompleted
+}
+
+// searchCompletion returns when a search completed and its result count,
+// or nils.
+func searchCompletion(spec *SearchSpec) (*time.Time, *int) {
+	if spec.CompletedAt.IsZero() {
+		return nil, nil
+	}
+	at, count := spec.CompletedAt, spec.ResultCount
+	return &at, &count
+}
+
+// completedAtParam is the completed_at value saved for a search.
+func completedAtParam(spec *SearchSpec) sql.NullTime {
+	return sql.NullTime{Time: spec.CompletedAt, Valid: !spec.CompletedAt.IsZero()}
+}
+
+func runCompletedSearchGC() {
+	if config.CompletedSearches.RetentionMin <= 0 {
+		return
+	}
+	interval := time.Duration(config.CompletedSearches.IntervalMin) * time.Minute
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		collectCompletedSearches()
+	}
+}
+
+// collectCompletedSearches removes the searches completed longer ago than
+// the retention.
+func collectCompletedSearches() {
+	retention := time.Duration(config.CompletedSearches.RetentionMin) * time.Minute
+	var expired []string
+	searchesMu.RLock()
+	for id, spec := range searches {
+		if !spec.CompletedAt.IsZero() && time.Since(spec.CompletedAt) > retention {
+			expired = append(expired, id)
+		}
+	}
+	searchesMu.RUnlock()
+	for _, id := range expired {
+		if removeSearch(id) {
+			incCounter(mCompletedRemoved)
+			syncLogger.Info("Removed completed search", "SearchId", id)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ConcurrencyConfig caps how many search runs (connect, search and process
+// the entries) execute at once. Searches over a limit queue until a run
+// finishes; their refresh interval starts after they ran.
+type ConcurrencyConfig struct {
+	// MaxSearches caps concurrent runs across all searches (0: unlimited).
+	MaxSearches int `yaml:"max_searches"`
+	// MaxDerivedPerSearch caps concurrent runs of the searches derived from
+	// one search by hook responses (0: unlimited), so one search fanning out
+	// into many derived searches cannot take every slot.
+	MaxDerivedPerSearch int `yaml:"max_derived_per_search"`
+}
+
+var (
+	mSearchRunsActive = describeMetric("ldapsync_search_runs_active", "gauge",
+		"Search runs currently executing.")
+	mSearchQueueDepth = describeMetric("ldapsync_search_queue_depth", "gauge",
+		"Search runs waiting for a concurrency slot, by limit (global or the parent search).")
+	mSearchQueueWait = describeMetric("ldapsync_search_queue_wait_seconds_total", "counter",
+		"Time search runs spent waiting for a concurrency slot.")
+)
+
+// searchScheduler hands out concurrency slots to search runs.
+type searchScheduler struct {
+	mu      sync.Mutex
+	global  chan struct{}
+	derived map[string]chan struct{} // By parent search
+	queued  map[string]int           // Waiting runs by limit; "" is the global one
+	active  int
+}
+
+var searchSlots = &searchScheduler{derived: make(map[string]chan struct{}), queued: make(map[string]int)}
+
+var searchSlotsOnce sync.Once
+
+// acquire waits for the slots a run of the search needs: first its parent's
+// (for derived searches), then the global one. It returns the function
+// releasing them, or nil if stop was closed while waiting.
+func (s *searchScheduler) acquire(id, parent string, stop <-chan struct{}) func() {
+	searchSlotsOnce.Do(func() {
+		if n := config.Concurrency.MaxSearches; n > 0 {
+			s.global = make(chan struct{}, n)
+		}
+	})
+	start := time.Now()
+	var held []chan struct{}
+	release := func() {
+		for _, slot := range held {
+			<-slot
+		}
+	}
+	if parent != "" {
+		if slot := s.derivedSlots(parent); slot != nil {
+			if !s.wait(id, parent, slot, stop) {
+				return nil
+			}
+			held = append(held, slot)
+		}
+	}
+	if s.global != nil {
+		if !s.wait(id, "", s.global, stop) {
+			release()
+			return nil
+		}
+		held = append(held, s.global)
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		addCounter(mSearchQueueWait, waited.Seconds())
+		syncLogger.Debug("Search run waited for a concurrency slot", "SearchId", id, "Wait", waited.Round(time.Millisecond))
+	}
+	s.mu.Lock()
+	s.active++
+	setGauge(mSearchRunsActive, float64(s.active))
+	s.mu.Unlock()
+	return func() {
+		release()
+		s.mu.Lock()
+		s.active--
+		setGauge(mSearchRunsActive, float64(s.active))
+		s.mu.Unlock()
+	}
+}
+
+// derivedSlots returns the semaphore of a parent search's derived searches,
+// or nil when they are unlimited.
+func (s *searchScheduler) derivedSlots(parent string) chan struct{} {
+	n := config.Concurrency.MaxDerivedPerSearch
+	if n <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot, ok := s.derived[parent]
+	if !ok {
+		slot = make(chan struct{}, n)
+		s.derived[parent] = slot
+	}
+	return slot
+}
+
+// wait takes a slot of the limit, counting the run as queued while it waits.
+func (s *searchScheduler) wait(id, limit string, slot chan struct{}, stop <-chan struct{}) bool {
+	select {
+	case slot <- struct{}{}:
+		return true
+	default:
+	}
+	s.setQueued(limit, 1)
+	defer s.setQueued(limit, -1)
+	syncLogger.Debug("Search run queued", "SearchId", id, "Limit", queueLabel(limit))
+	select {
+	case slot <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (s *searchScheduler) setQueued(limit string, delta int) {
+	s.mu.Lock()
+	s.queued[limit] += delta
+	n := s.queued[limit]
+	if n == 0 && limit != "" {
+		delete(s.queued, limit)
+	}
+	s.mu.Unlock()
+	setGauge(mSearchQueueDepth, float64(n), "limit", queueLabel(limit))
+}
+
+// queueDepth returns the number of runs waiting for any slot.
+func (s *searchScheduler) queueDepth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, n := range s.queued {
+		total += n
+	}
+	return total
+}
+
+func queueLabel(limit string) string {
+	if limit == "" {
+		return "global"
+	}
+	return limit
+}
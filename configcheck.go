@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// ConfigIssue is a problem found in the configuration. Errors stop the
+// service from starting or working; warnings are likely mistakes.
+type ConfigIssue struct {
+	Severity string `json:"severity"` // error or warning
+	Field    string `json:"field"`    // YAML path, e.g. source.base_dn
+	Message  string `json:"message"`
+}
+
+// ConfigValidation is the result of validating a configuration. Binds holds
+// "ok" or the error of each server when test binds were requested.
+type ConfigValidation struct {
+	Valid  bool              `json:"valid"`
+	Issues []ConfigIssue     `json:"issues"`
+	Binds  map[string]string `json:"binds,omitempty"`
+}
+
+type configValidator struct{ out *ConfigValidation }
+
+func (v configValidator) errorf(field, format string, args ...interface{}) {
+	v.out.Issues = append(v.out.Issues, ConfigIssue{Severity: "error", Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v configValidator) warnf(field, format string, args ...interface{}) {
+	v.out.Issues = append(v.out.Issues, ConfigIssue{Severity: "warning", Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkConfig validates c syntactically: required fields, LDAP and hook
+// URLs and DNs. It does not contact anything. Sections with their own
+// startup validation (configInitSteps) are left to it.
+func checkConfig(c *Config) *ConfigValidation {
+	out := &ConfigValidation{Issues: []ConfigIssue{}}
+	v := configValidator{out}
+	for name, l := range map[string]LDAPConfig{"source": c.Source, "target": c.Target} {
+		checkLDAPConfig(v, name, l)
+	}
+	if len(c.Hooks) == 0 && len(c.Transforms) == 0 && len(c.Mappings) == 0 {
+		v.warnf("hooks", "no hooks, transforms or mappings are configured; searches can only run with suppressHooks")
+	}
+	for i, h := range c.Hooks {
+		field := "hooks[" + strconv.Itoa(i) + "]"
+		u, err := url.Parse(h)
+		if err != nil {
+			v.errorf(field, "invalid URL: %v", err)
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			v.errorf(field, "%q is not an http(s) URL", h)
+		}
+	}
+	if c.Changelog.BaseDN != "" {
+		if _, err := ldap.ParseDN(c.Changelog.BaseDN); err != nil {
+			v.errorf("changelog.base_dn", "invalid DN: %v", err)
+		}
+	}
+	d := c.Database
+	if d.Enabled {
+		switch d.Driver {
+		case "", dbDriverPostgres:
+			for field, value := range map[string]string{"host": d.Host, "username": d.Username,
+				"database": d.Database, "password_file": d.PasswordFile} {
+				if value == "" {
+					v.errorf("database."+field, "required when the database is enabled")
+				}
+			}
+			if d.PasswordFile != "" {
+				if _, err := os.Stat(d.PasswordFile); err != nil {
+					v.errorf("database.password_file", "%v", err)
+				}
+			}
+		}
+	}
+	sort.SliceStable(out.Issues, func(i, j int) bool { return out.Issues[i].Field < out.Issues[j].Field })
+	return out
+}
+
+func checkLDAPConfig(v configValidator, name string, l LDAPConfig) {
+	if l.URL == "" {
+		if l.DCLocator.Domain == "" {
+			v.errorf(name+".url", "required unless dc_locator.domain is set")
+		}
+	} else if u, err := url.Parse(l.URL); err != nil {
+		v.errorf(name+".url", "invalid URL: %v", err)
+	} else if u.Scheme != "ldap" && u.Scheme != "ldaps" && u.Scheme != "ldapi" {
+		v.errorf(name+".url", "scheme must be ldap, ldaps or ldapi, not %q", u.Scheme)
+	}
+	if l.BaseDN == "" {
+		v.errorf(name+".base_dn", "required")
+	} else if _, err := ldap.ParseDN(l.BaseDN); err != nil {
+		v.errorf(name+".base_dn", "invalid DN: %v", err)
+	}
+	if l.BindDN != "" {
+		if _, err := ldap.ParseDN(l.BindDN); err != nil {
+			v.errorf(name+".bind_dn", "invalid DN: %v", err)
+		}
+	}
+	switch {
+	case l.BindPassword != "" && l.BindPasswordFile != "":
+		v.errorf(name+".bind_password_file", "bind_password and bind_password_file are mutually exclusive")
+	case l.BindPasswordFile != "":
+		if _, err := os.Stat(l.BindPasswordFile); err != nil {
+			v.errorf(name+".bind_password_file", "%v", err)
+		}
+	case l.BindDN != "" && l.BindPassword == "" && l.SASL.Mechanism == "":
+		v.warnf(name+".bind_password", "bind_dn is set without a password; the bind will be unauthenticated")
+	}
+	for field, path := range map[string]string{"keytab": l.SASL.Keytab, "ccache": l.SASL.CCache,
+		"krb5_conf": l.SASL.Krb5Conf, "cert_file": l.SASL.CertFile, "key_file": l.SASL.KeyFile, "ca_file": l.SASL.CAFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			v.errorf(name+".sasl."+field, "%v", err)
+		}
+	}
+	if l.SASL.Mechanism != "" && l.BindDN != "" {
+		v.warnf(name+".bind_dn", "ignored with sasl.mechanism %s", l.SASL.Mechanism)
+	}
+}
+
+// testBinds binds to the source and target of c.
+func testBinds(c *Config, out *ConfigValidation) {
+	out.Binds = make(map[string]string)
+	for name, l := range map[string]LDAPConfig{"source": c.Source, "target": c.Target} {
+		conn, err := dialLDAP(l)
+		if err != nil {
+			out.Binds[name] = err.Error()
+			out.Issues = append(out.Issues, ConfigIssue{Severity: "error", Field: name, Message: "test bind failed: " + err.Error()})
+			continue
+		}
+		conn.Close()
+		out.Binds[name] = "ok"
+	}
+}
+
+func (out *ConfigValidation) finish() {
+	out.Valid = true
+	for _, issue := range out.Issues {
+		if issue.Severity == "error" {
+			out.Valid = false
+		}
+	}
+}
+
+// runValidateConfig implements --validate-config: it loads the config file,
+// checks it, runs the startup validation of transforms, mappings, policies
+// and the other sections, optionally test-binds, and prints the problems.
+// It returns the exit code: 0 when valid, 1 otherwise.
+func runValidateConfig(path string, bind bool) int {
+	if err := loadConfig(path); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	out := checkConfig(&config)
+	for _, step := range configInitSteps {
+		if err := step.init(); err != nil {
+			out.Issues = append(out.Issues, ConfigIssue{Severity: "error", Field: step.section, Message: err.Error()})
+		}
+	}
+	if bind {
+		testBinds(&config, out)
+	}
+	out.finish()
+	for _, issue := range out.Issues {
+		fmt.Printf("%s: %s: %s\n", issue.Severity, issue.Field, issue.Message)
+	}
+	if !out.Valid {
+		fmt.Printf("%s is invalid\n", path)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", path)
+	return 0
+}
+
+// validateConfigHandler godoc
+// @Summary Validate the loaded configuration
+// @Description Checks the configuration the service is running with: required fields and the syntax of LDAP URLs, DNs and hook URLs, and the filters of the current searches. With bind=true it also binds to the source and target.
+// @Tags config
+// @Produce json
+// @Param bind query boolean false "Test-bind to the source and target"
+// @Success 200 {object} ConfigValidation
+// @Router /config/validate [get]
+func validateConfigHandler(c echo.Context) error {
+	out := checkConfig(&config)
+	v := configValidator{out}
+	searchesMu.RLock()
+	ids := make([]string, 0, len(searches))
+	for id := range searches {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		spec := searches[id]
+		if _, err := ldap.CompileFilter(spec.Filter); err != nil {
+			v.errorf("searches."+id+".filter", "invalid filter: %v", err)
+		}
+		if spec.BaseDN != "" {
+			if _, err := ldap.ParseDN(spec.BaseDN); err != nil {
+				v.errorf("searches."+id+".baseDN", "invalid DN: %v", err)
+			}
+		}
+	}
+	searchesMu.RUnlock()
+	if bind, _ := strconv.ParseBool(c.QueryParam("bind")); bind {
+		testBinds(&config, out)
+	}
+	out.finish()
+	return c.JSON(http.StatusOK, out)
+}
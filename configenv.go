@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The config file may reference environment variables as ${NAME} or
+// ${NAME:-default}; $${ is a literal ${. After parsing, LDAPSYNC_-prefixed
+// variables override single settings: the name is the YAML path in upper
+// case joined by underscores, e.g. LDAPSYNC_SOURCE_BIND_PASSWORD for
+// source.bind_password. Both let Kubernetes secrets be injected as
+// environment variables.
+
+const configEnvPrefix = "LDAPSYNC_"
+
+var configEnvRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandConfigEnv substitutes the environment references in config data.
+// A reference to an unset variable without a default is an error.
+func expandConfigEnv(data []byte) ([]byte, error) {
+	var missing []string
+	out := configEnvRef.ReplaceAllFunc(data, func(ref []byte) []byte {
+		if string(ref) == "$${" {
+			return []byte("${")
+		}
+		m := configEnvRef.FindSubmatch(ref)
+		if v, ok := os.LookupEnv(string(m[1])); ok {
+			return []byte(v)
+		}
+		if len(m[2]) > 0 {
+			return m[3]
+		}
+		missing = append(missing, string(m[1]))
+		return ref
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("config references unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// applyConfigEnvOverrides sets the settings named by LDAPSYNC_ variables
+// and returns the variables it applied. Lists take comma-separated values;
+// maps and lists of structs cannot be overridden.
+func applyConfigEnvOverrides(c *Config) ([]string, error) {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, configEnvPrefix) {
+			env[name] = value
+		}
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+	var applied []string
+	if err := overrideFields(reflect.ValueOf(c).Elem(), strings.TrimSuffix(configEnvPrefix, "_"), env, &applied); err != nil {
+		return nil, err
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
+
+func overrideFields(v reflect.Value, prefix string, env map[string]string, applied *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" || !field.IsExported() {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Struct:
+			if err := overrideFields(fv, name, env, applied); err != nil {
+				return err
+			}
+			continue
+		case fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct:
+			if !hasEnvPrefix(env, name+"_") {
+				continue
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			if err := overrideFields(fv.Elem(), name, env, applied); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+		if err := setConfigValue(fv, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*applied = append(*applied, name)
+	}
+	return nil
+}
+
+func hasEnvPrefix(env map[string]string, prefix string) bool {
+	for name := range env {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("lists of %s cannot be set from the environment", v.Type().Elem())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		return fmt.Errorf("%s settings cannot be set from the environment", v.Kind())
+	}
+	return nil
+}
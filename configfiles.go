@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The config path may name a file or a conf.d-style directory. The *.yaml
+// and *.yml files of a directory are fragments merged in lexical order, so
+// a base config and per-environment overrides can be kept (and mounted)
+// separately: 00-base.yaml, 50-secrets.yaml, 90-prod.yaml. Maps merge key
+// by key; scalars and lists in a later fragment replace earlier ones.
+
+const defaultConfigPath = "/etc/ldap-sync/config.yaml"
+
+// configPathDefault is the default of --config: CONFIG_PATH if set.
+func configPathDefault() string {
+	if p := os.Getenv("CONFIG_PATH"); p != "" {
+		return p
+	}
+	return defaultConfigPath
+}
+
+// configFiles returns the files making up the config at path, in merge
+// order.
+func configFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no *.yaml or *.yml files", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readConfigData reads the config at path, expanding environment references
+// in each file, and merges the fragments of a directory into one document.
+func readConfigData(path string) ([]byte, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	var merged map[interface{}]interface{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = expandConfigEnv(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		if len(files) == 1 {
+			return data, nil
+		}
+		var fragment map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &fragment); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		merged = mergeConfigMaps(merged, fragment)
+	}
+	return yaml.Marshal(merged)
+}
+
+// mergeConfigMaps merges src into dst and returns dst.
+func mergeConfigMaps(dst, src map[interface{}]interface{}) map[interface{}]interface{} {
+	if dst == nil {
+		dst = make(map[interface{}]interface{}, len(src))
+	}
+	for k, v := range src {
+		if sv, ok := v.(map[interface{}]interface{}); ok {
+			if dv, ok := dst[k].(map[interface{}]interface{}); ok {
+				dst[k] = mergeConfigMaps(dv, sv)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// contentFilter is an LDAP filter evaluated in memory against result
+// content. Attribute names and values compare case-insensitively, as for
+// most directory attributes; ordering compares numerically when both values
+// are numbers. Approximate matches are treated as equality; extensible
+// matches are not supported.
+type contentFilter struct {
+	packet *ber.Packet
+}
+
+func compileContentFilter(filter string) (*contentFilter, error) {
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFilterPacket(packet); err != nil {
+		return nil, err
+	}
+	return &contentFilter{packet: packet}, nil
+}
+
+func checkFilterPacket(p *ber.Packet) error {
+	switch p.Tag {
+	case ldap.FilterExtensibleMatch:
+		return fmt.Errorf("extensible matches are not supported")
+	case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+		for _, c := range p.Children {
+			if err := checkFilterPacket(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether content satisfies the filter.
+func (f *contentFilter) matches(content map[string]interface{}) bool {
+	return evalFilterPacket(f.packet, content)
+}
+
+func evalFilterPacket(p *ber.Packet, content map[string]interface{}) bool {
+	switch p.Tag {
+	case ldap.FilterAnd:
+		for _, c := range p.Children {
+			if !evalFilterPacket(c, content) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range p.Children {
+			if evalFilterPacket(c, content) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(p.Children) == 1 && !evalFilterPacket(p.Children[0], content)
+	case ldap.FilterPresent:
+		_, v, ok := lookupAttr(content, p.Data.String())
+		return ok && len(toStringSlice(v)) > 0
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual:
+		if len(p.Children) != 2 {
+			return false
+		}
+		_, v, ok := lookupAttr(content, p.Children[0].Data.String())
+		if !ok {
+			return false
+		}
+		want := p.Children[1].Data.String()
+		for _, have := range toStringSlice(v) {
+			c := compareFilterValues(have, want)
+			switch {
+			case (p.Tag == ldap.FilterEqualityMatch || p.Tag == ldap.FilterApproxMatch) && c == 0,
+				p.Tag == ldap.FilterGreaterOrEqual && c >= 0,
+				p.Tag == ldap.FilterLessOrEqual && c <= 0:
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		if len(p.Children) != 2 {
+			return false
+		}
+		_, v, ok := lookupAttr(content, p.Children[0].Data.String())
+		if !ok {
+			return false
+		}
+		for _, have := range toStringSlice(v) {
+			if matchSubstrings(strings.ToLower(have), p.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// compareFilterValues orders two values, numerically when both are numbers.
+func compareFilterValues(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func matchSubstrings(value string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		s := strings.ToLower(part.Data.String())
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ldap.FilterSubstringsAny:
+			i := strings.Index(value, s)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(s):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+			value = ""
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// A search with a correlation attribute identifies its source entries by
+// that attribute (entryUUID, objectGUID, employeeNumber) instead of their
+// DN, so a source entry that is renamed or moved is seen as an update of the
+// same entry rather than a delete and a create. The correlation key keys the
+// search's results and its rename tracking.
+
+// sourceRef identifies the source entry a transformed entry was produced from.
+type sourceRef struct {
+	DN          string
+	Correlation string
+	// passwords holds the password values hidden from hooks, by lower-cased
+	// attribute name.
+	passwords map[string]interface{}
+}
+
+// correlationKey returns "attr=value" for the entry's correlation attribute,
+// or "" when the search has none or the entry lacks it. Binary values are
+// hex-encoded; others compared case-insensitively.
+func correlationKey(id string, spec *SearchSpec, entry *ldap.Entry) string {
+	attr := correlationAttribute(id, spec)
+	if attr == "" {
+		return ""
+	}
+	for _, a := range entry.Attributes {
+		base, _, _ := strings.Cut(a.Name, ";")
+		if !strings.EqualFold(base, attr) || len(a.ByteValues) == 0 {
+			continue
+		}
+		value := strings.ToLower(a.Values[0])
+		if isBinaryAttr(a.Name) {
+			value = hex.EncodeToString(a.ByteValues[0])
+		}
+		return strings.ToLower(attr) + "=" + value
+	}
+	return ""
+}
+
+// correlationValue returns the value part of a correlation key.
+func correlationValue(key string) string {
+	_, value, _ := strings.Cut(key, "=")
+	return value
+}
+
+// findTargetByCorrelation looks up the target entry carrying the entry's
+// correlation value, for entries whose hooks write the correlation attribute
+// to the target. It returns "" unless exactly one entry matches.
+func findTargetByCorrelation(l *ldap.Conn, entry *TransformedEntry, attr string) (string, error) {
+	if attr == "" || entry.Correlation == "" || isBinaryAttr(attr) {
+		return "", nil
+	}
+	found := false
+	for name := range entry.Content {
+		if strings.EqualFold(name, attr) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", nil
+	}
+	filter := "(" + attr + "=" + ldap.EscapeFilter(correlationValue(entry.Correlation)) + ")"
+	sr, err := l.Search(ldap.NewSearchRequest(config.Target.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, filter, []string{"dn"}, nil))
+	if err != nil {
+		if isNoSuchObject(err) || ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(sr.Entries) != 1 {
+		return "", nil
+	}
+	return sr.Entries[0].DN, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DampeningConfig limits how often a flapping source entry is sent to the
+// hooks. Once an entry has been sent MaxUpdates times within the window,
+// further changes are held and only the latest is sent when the window
+// allows it again.
+type DampeningConfig struct {
+	MaxUpdates int `yaml:"max_updates"` // Sends per entry per window (0 disables dampening)
+	WindowSec  int `yaml:"window_s"`    // Length of the sliding window (default: 300)
+}
+
+var (
+	mDampenedSuppressed = describeMetric("ldapsync_dampened_updates_total", "counter",
+		"Entry updates held back by dampening instead of being sent to the hooks.")
+	mDampenedFlushed = describeMetric("ldapsync_dampened_flushes_total", "counter",
+		"Held entry updates sent once the dampening window allowed it.")
+	mDampenedHeld = describeMetric("ldapsync_dampened_entries", "gauge",
+		"Entries currently holding a dampened update.")
+)
+
+func init() {
+	registerCollector(func() {
+		damper.mu.Lock()
+		held := 0
+		for _, s := range damper.entries {
+			if s.latest != nil {
+				held++
+			}
+		}
+		damper.mu.Unlock()
+		setGauge(mDampenedHeld, float64(held))
+	})
+}
+
+// dampState tracks the recent sends of one entry of one search.
+type dampState struct {
+	sends  []time.Time
+	latest *LDAPResult // Held update, sent when the window allows
+	timer  *time.Timer
+}
+
+type dampener struct {
+	mu        sync.Mutex
+	entries   map[string]*dampState // search id + "\x00" + normalized DN
+	lastSweep time.Time
+}
+
+var damper = &dampener{entries: make(map[string]*dampState)}
+
+func dampeningWindow() time.Duration {
+	if config.Dampening.WindowSec > 0 {
+		return time.Duration(config.Dampening.WindowSec) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// prune drops sends that have left the window.
+func (s *dampState) prune(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(s.sends) && now.Sub(s.sends[i]) >= window {
+		i++
+	}
+	s.sends = s.sends[i:]
+}
+
+// admit reports whether a result may be sent now. Otherwise it is held,
+// replacing any update held earlier, and sent by a timer once the oldest
+// send leaves the window.
+func (d *dampener) admit(id string, spec *SearchSpec, result LDAPResult) bool {
+	max := config.Dampening.MaxUpdates
+	if max <= 0 {
+		return true
+	}
+	window := dampeningWindow()
+	now := time.Now()
+	key := id + "\x00" + normalizeDN(result.DN)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now, window)
+	s := d.entries[key]
+	if s == nil {
+		s = &dampState{}
+		d.entries[key] = s
+	}
+	s.prune(now, window)
+	if len(s.sends) < max && s.latest == nil {
+		s.sends = append(s.sends, now)
+		return true
+	}
+	if held := s.latest; held != nil {
+		// The hooks have not seen the held update; describe the change
+		// from what they saw last.
+		if held.changeType == changeTypeAdd {
+			result.changeType = changeTypeAdd
+		}
+		result.previous = held.previous
+	}
+	s.latest = &result
+	incCounter(mDampenedSuppressed)
+	syncLogger.Debug("Dampening entry update", "DN", result.DN, "SearchId", id)
+	if s.timer == nil {
+		wait := window - now.Sub(s.sends[0])
+		specCopy := *spec
+		s.timer = time.AfterFunc(wait, func() { d.flush(id, key, &specCopy) })
+	}
+	return false
+}
+
+// flush sends the update held for an entry, unless its search was deleted.
+func (d *dampener) flush(id, key string, spec *SearchSpec) {
+	d.mu.Lock()
+	s := d.entries[key]
+	if s == nil || s.latest == nil {
+		d.mu.Unlock()
+		return
+	}
+	result := *s.latest
+	s.latest = nil
+	s.timer = nil
+	s.sends = append(s.sends, time.Now())
+	d.mu.Unlock()
+
+	searchesMu.RLock()
+	_, exists := searches[id]
+	searchesMu.RUnlock()
+	if !exists {
+		return
+	}
+	incCounter(mDampenedFlushed)
+	syncLogger.Info("Sending dampened entry update", "DN", result.DN, "SearchId", id)
+	dispatchResult(id, spec, result)
+}
+
+// sweep forgets entries with no recent sends, at most once per window.
+// The caller holds d.mu.
+func (d *dampener) sweep(now time.Time, window time.Duration) {
+	if now.Sub(d.lastSweep) < window {
+		return
+	}
+	d.lastSweep = now
+	for key, s := range d.entries {
+		s.prune(now, window)
+		if len(s.sends) == 0 && s.latest == nil {
+			delete(d.entries, key)
+		}
+	}
+}
@@ -15,3 +15,210 @@ CREATE TABLE IF NOT EXISTS searches (
 -- Create indexes for common queries
 CREATE INDEX IF NOT EXISTS idx_searches_created_at ON searches(created_at);
 CREATE INDEX IF NOT EXISTS idx_searches_updated_at ON searches(updated_at);
+
+-- Name of the embedded transform used instead of the hooks (empty for hooks)
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS transform TEXT NOT NULL DEFAULT '';
+
+-- Name of the declarative attribute mapping applied to the search (empty for none)
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS mapping TEXT NOT NULL DEFAULT '';
+
+-- Comma-separated attributes requested from the source (empty for all user attributes)
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS attributes TEXT NOT NULL DEFAULT '';
+
+-- Comma-separated attributes stripped before results are stored and sent to hooks
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS exclude_attributes TEXT NOT NULL DEFAULT '';
+
+-- Audit trail of target operations blocked by the destructive-operation policy
+CREATE TABLE IF NOT EXISTS policy_violations (
+    id BIGSERIAL PRIMARY KEY,
+    time TIMESTAMP NOT NULL,
+    operation TEXT NOT NULL,
+    dn TEXT NOT NULL,
+    rule TEXT NOT NULL,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_policy_violations_time ON policy_violations(time);
+
+-- Whether the search previews target writes instead of performing them
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS dry_run BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Which search and hook/transform/mapping last wrote each target attribute
+CREATE TABLE IF NOT EXISTS attribute_provenance (
+    dn TEXT NOT NULL,
+    attribute TEXT NOT NULL,
+    search_id TEXT NOT NULL,
+    producer TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    written_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (dn, attribute)
+);
+
+-- Journal of every add/modify/delete performed against the target
+CREATE TABLE IF NOT EXISTS change_log (
+    id BIGSERIAL PRIMARY KEY,
+    time TIMESTAMP NOT NULL DEFAULT NOW(),
+    dn TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    search_id TEXT NOT NULL DEFAULT '',
+    producer TEXT NOT NULL DEFAULT '',
+    changes JSONB NOT NULL DEFAULT '{}',
+    result TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_log_time ON change_log(time);
+CREATE INDEX IF NOT EXISTS idx_change_log_dn ON change_log(lower(dn));
+
+-- How the search detects source changes: poll (empty) or changelog
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS change_detection TEXT NOT NULL DEFAULT '';
+
+-- Bindings returned by hooks; a NULL value is a null binding
+CREATE TABLE IF NOT EXISTS bindings (
+    key TEXT PRIMARY KEY,
+    value TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Entries parked by the dependency tracker until dependencies/bindings resolve
+CREATE TABLE IF NOT EXISTS pending_entries (
+    dn_key TEXT PRIMARY KEY,
+    entry JSONB NOT NULL,
+    raw_deps JSONB NOT NULL DEFAULT '[]',
+    waiting_since TIMESTAMP NOT NULL
+);
+
+-- Last-seen search results (database.persist_results), restored on startup
+CREATE TABLE IF NOT EXISTS search_results (
+    search_id TEXT NOT NULL,
+    dn_key TEXT NOT NULL,
+    dn TEXT NOT NULL,
+    content_hash TEXT NOT NULL,
+    content JSONB,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (search_id, dn_key)
+);
+
+-- Values last written to remove-absent merge attributes
+CREATE TABLE IF NOT EXISTS managed_values (
+    dn_key TEXT NOT NULL,
+    attribute TEXT NOT NULL,
+    vals JSONB NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (dn_key, attribute)
+);
+
+-- Rename target entries when the DN produced for a source entry changes
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS rename BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Target DN last written per source entry (searches with rename enabled)
+CREATE TABLE IF NOT EXISTS dn_mappings (
+    search_id TEXT NOT NULL,
+    source_dn_key TEXT NOT NULL,
+    target_dn TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (search_id, source_dn_key)
+);
+
+-- Source DN last seen for each mapped source entry
+ALTER TABLE dn_mappings ADD COLUMN IF NOT EXISTS source_dn TEXT NOT NULL DEFAULT '';
+
+-- Attribute identifying source entries instead of their DN
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS correlation_attribute TEXT NOT NULL DEFAULT '';
+
+-- Mutating management API calls, listed by GET /audit
+CREATE TABLE IF NOT EXISTS api_audit (
+    id BIGSERIAL PRIMARY KEY,
+    time TIMESTAMP NOT NULL DEFAULT NOW(),
+    principal TEXT NOT NULL,
+    remote_ip TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    status INTEGER NOT NULL,
+    response TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_audit_time ON api_audit(time);
+
+-- Cron expression the search runs on instead of its refresh interval, and
+-- the time of its last scheduled run
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS schedule TEXT NOT NULL DEFAULT '';
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS last_run_at TIMESTAMP;
+
+-- Summaries of search runs, returned by GET /search/:id/stats when
+-- search_stats.persist is set
+CREATE TABLE IF NOT EXISTS search_runs (
+    id BIGSERIAL PRIMARY KEY,
+    search_id TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    mode TEXT NOT NULL,
+    seen BIGINT NOT NULL DEFAULT 0,
+    new BIGINT NOT NULL DEFAULT 0,
+    updated BIGINT NOT NULL DEFAULT 0,
+    unchanged BIGINT NOT NULL DEFAULT 0,
+    deleted BIGINT NOT NULL DEFAULT 0,
+    hook_calls BIGINT NOT NULL DEFAULT 0,
+    hook_errors BIGINT NOT NULL DEFAULT 0,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_runs_search ON search_runs(search_id, started_at);
+
+-- List bindings: JSON array of the values, with value NULL
+ALTER TABLE bindings ADD COLUMN IF NOT EXISTS list_value JSONB;
+
+-- Hook responses reused for entries posted again unchanged (hook_cache)
+CREATE TABLE IF NOT EXISTS hook_response_cache (
+    hook TEXT NOT NULL,
+    cache_key TEXT NOT NULL,
+    responses JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (hook, cache_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_hook_response_cache_expires ON hook_response_cache(expires_at);
+
+-- Binding expiry and owner (binding_namespaces, bindingTtls)
+ALTER TABLE bindings ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE bindings ADD COLUMN IF NOT EXISTS search_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE bindings ADD COLUMN IF NOT EXISTS source_dn TEXT NOT NULL DEFAULT '';
+ALTER TABLE bindings ADD COLUMN IF NOT EXISTS hook TEXT NOT NULL DEFAULT '';
+
+-- Search scope, alias dereferencing and limits (empty and 0 for the
+-- defaults: whole subtree, never, no limits)
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS deref TEXT NOT NULL DEFAULT '';
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS size_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS time_limit INTEGER NOT NULL DEFAULT 0;
+
+-- runOnce and suppressHooks, which replace oneshot (still written as both
+-- together). NULL in rows saved before the split: both are read from oneshot.
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS run_once BOOLEAN;
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS suppress_hooks BOOLEAN;
+
+-- Completion of runOnce searches (completed_searches)
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS result_count INTEGER NOT NULL DEFAULT 0;
+
+-- remove-absent values by contributor (search and source entry); replaces
+-- managed_values, whose rows are dropped as their attributes are rewritten
+CREATE TABLE IF NOT EXISTS managed_contributions (
+    dn_key TEXT NOT NULL,
+    attribute TEXT NOT NULL,
+    contributor TEXT NOT NULL,
+    vals JSONB NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (dn_key, attribute, contributor)
+);
+
+-- Provenance and expiry of searches derived by hook responses (empty parent
+-- for searches created through the API)
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS parent TEXT NOT NULL DEFAULT '';
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS parent_dn TEXT NOT NULL DEFAULT '';
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS idle_expiry_s INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE searches ADD COLUMN IF NOT EXISTS last_change_at TIMESTAMP;
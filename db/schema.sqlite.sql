@@ -0,0 +1,173 @@
+-- ldap-sync database schema for SQLite (database.driver: sqlite)
+-- Created by the service on startup; keep in sync with schema.sql.
+-- Times are stored as UTC text, 'YYYY-MM-DD HH:MM:SS.ffffff'.
+
+CREATE TABLE IF NOT EXISTS searches (
+    id TEXT PRIMARY KEY,
+    filter TEXT NOT NULL,
+    refresh INTEGER NOT NULL,
+    base_dn TEXT NOT NULL,
+    oneshot BOOLEAN NOT NULL,
+    transform TEXT NOT NULL DEFAULT '',
+    mapping TEXT NOT NULL DEFAULT '',
+    attributes TEXT NOT NULL DEFAULT '',
+    exclude_attributes TEXT NOT NULL DEFAULT '',
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    change_detection TEXT NOT NULL DEFAULT '',
+    rename BOOLEAN NOT NULL DEFAULT FALSE,
+    correlation_attribute TEXT NOT NULL DEFAULT '',
+    schedule TEXT NOT NULL DEFAULT '',
+    last_run_at TIMESTAMP,
+    scope TEXT NOT NULL DEFAULT '',
+    deref TEXT NOT NULL DEFAULT '',
+    size_limit INTEGER NOT NULL DEFAULT 0,
+    time_limit INTEGER NOT NULL DEFAULT 0,
+    run_once BOOLEAN,
+    suppress_hooks BOOLEAN,
+    completed_at TIMESTAMP,
+    result_count INTEGER NOT NULL DEFAULT 0,
+    parent TEXT NOT NULL DEFAULT '',
+    parent_dn TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP,
+    idle_expiry_s INTEGER NOT NULL DEFAULT 0,
+    last_change_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_searches_created_at ON searches(created_at);
+CREATE INDEX IF NOT EXISTS idx_searches_updated_at ON searches(updated_at);
+
+CREATE TABLE IF NOT EXISTS policy_violations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time TIMESTAMP NOT NULL,
+    operation TEXT NOT NULL,
+    dn TEXT NOT NULL,
+    rule TEXT NOT NULL,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_policy_violations_time ON policy_violations(time);
+
+CREATE TABLE IF NOT EXISTS attribute_provenance (
+    dn TEXT NOT NULL,
+    attribute TEXT NOT NULL,
+    search_id TEXT NOT NULL,
+    producer TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    written_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (dn, attribute)
+);
+
+CREATE TABLE IF NOT EXISTS change_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    dn TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    search_id TEXT NOT NULL DEFAULT '',
+    producer TEXT NOT NULL DEFAULT '',
+    changes TEXT NOT NULL DEFAULT '{}',
+    result TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_log_time ON change_log(time);
+CREATE INDEX IF NOT EXISTS idx_change_log_dn ON change_log(lower(dn));
+
+CREATE TABLE IF NOT EXISTS bindings (
+    key TEXT PRIMARY KEY,
+    value TEXT,
+    list_value TEXT,
+    expires_at TIMESTAMP,
+    search_id TEXT NOT NULL DEFAULT '',
+    source_dn TEXT NOT NULL DEFAULT '',
+    hook TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS pending_entries (
+    dn_key TEXT PRIMARY KEY,
+    entry TEXT NOT NULL,
+    raw_deps TEXT NOT NULL DEFAULT '[]',
+    waiting_since TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS search_results (
+    search_id TEXT NOT NULL,
+    dn_key TEXT NOT NULL,
+    dn TEXT NOT NULL,
+    content_hash TEXT NOT NULL,
+    content TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    PRIMARY KEY (search_id, dn_key)
+);
+
+CREATE TABLE IF NOT EXISTS managed_values (
+    dn_key TEXT NOT NULL,
+    attribute TEXT NOT NULL,
+    vals TEXT NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    PRIMARY KEY (dn_key, attribute)
+);
+
+CREATE TABLE IF NOT EXISTS managed_contributions (
+    dn_key TEXT NOT NULL,
+    attribute TEXT NOT NULL,
+    contributor TEXT NOT NULL,
+    vals TEXT NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    PRIMARY KEY (dn_key, attribute, contributor)
+);
+
+CREATE TABLE IF NOT EXISTS dn_mappings (
+    search_id TEXT NOT NULL,
+    source_dn_key TEXT NOT NULL,
+    target_dn TEXT NOT NULL,
+    source_dn TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    PRIMARY KEY (search_id, source_dn_key)
+);
+
+CREATE TABLE IF NOT EXISTS api_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+    principal TEXT NOT NULL,
+    remote_ip TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    endpoint TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    params TEXT NOT NULL DEFAULT '{}',
+    status INTEGER NOT NULL,
+    response TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_audit_time ON api_audit(time);
+
+CREATE TABLE IF NOT EXISTS search_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    search_id TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    duration_ms INTEGER NOT NULL,
+    mode TEXT NOT NULL,
+    seen INTEGER NOT NULL DEFAULT 0,
+    new INTEGER NOT NULL DEFAULT 0,
+    updated INTEGER NOT NULL DEFAULT 0,
+    unchanged INTEGER NOT NULL DEFAULT 0,
+    deleted INTEGER NOT NULL DEFAULT 0,
+    hook_calls INTEGER NOT NULL DEFAULT 0,
+    hook_errors INTEGER NOT NULL DEFAULT 0,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_runs_search ON search_runs(search_id, started_at);
+
+CREATE TABLE IF NOT EXISTS hook_response_cache (
+    hook TEXT NOT NULL,
+    cache_key TEXT NOT NULL,
+    responses TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (hook, cache_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_hook_response_cache_expires ON hook_response_cache(expires_at);
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The connection pool reconnects on its own, but nothing noticed while the
+// database was unreachable: writes failed one by one until a restart. The
+// monitor pings the database every health interval; after a failure it
+// marks the database down (failing /readyz and the ldapsync_database_up
+// gauge) and pings with exponential backoff until it answers again.
+
+var (
+	mDatabaseUp = describeMetric("ldapsync_database_up", "gauge",
+		"1 while the database answers pings, 0 while it is unreachable.")
+	mDatabasePingFailures = describeMetric("ldapsync_database_ping_failures_total", "counter",
+		"Failed database health pings.")
+	mDatabaseOpenConns = describeMetric("ldapsync_database_open_connections", "gauge",
+		"Open database connections, by state (in_use, idle).")
+	mDatabaseWaits = describeMetric("ldapsync_database_wait_seconds_total", "counter",
+		"Time spent waiting for a free database connection.")
+)
+
+// dbHealth is the state of the database as seen by the monitor.
+var dbHealth = struct {
+	sync.Mutex
+	down  bool
+	err   string
+	since time.Time
+}{}
+
+// configureDBPool applies the pool settings of the database config.
+func configureDBPool(d *sql.DB, c DatabaseConfig) {
+	if c.MaxOpenConns > 0 {
+		d.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		d.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetimeSec > 0 {
+		d.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetimeSec) * time.Second)
+	}
+	if c.ConnMaxIdleTimeSec > 0 {
+		d.SetConnMaxIdleTime(time.Duration(c.ConnMaxIdleTimeSec) * time.Second)
+	}
+}
+
+// pingDBWithBackoff pings the database until it answers or the timeout
+// passes, doubling the delay between attempts up to 30 seconds.
+func pingDBWithBackoff(d *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := time.Second
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := d.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		logger.Warn("Database not reachable; retrying", "Err", err, "Delay", delay)
+		time.Sleep(delay)
+		delay = min(delay*2, 30*time.Second)
+	}
+}
+
+// databaseDownErr returns why the database is down, or nil while it is up.
+func databaseDownErr() error {
+	dbHealth.Lock()
+	defer dbHealth.Unlock()
+	if !dbHealth.down {
+		return nil
+	}
+	return fmt.Errorf("database unreachable since %s: %s", dbHealth.since.Format(time.RFC3339), dbHealth.err)
+}
+
+func runDBMonitor() {
+	interval := time.Duration(config.Database.HealthIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	setGauge(mDatabaseUp, 1)
+	var lastWait time.Duration
+	delay := time.Second
+	wait := interval
+	for {
+		time.Sleep(wait)
+		stats := db.Stats()
+		setGauge(mDatabaseOpenConns, float64(stats.InUse), "state", "in_use")
+		setGauge(mDatabaseOpenConns, float64(stats.Idle), "state", "idle")
+		addCounter(mDatabaseWaits, (stats.WaitDuration - lastWait).Seconds())
+		lastWait = stats.WaitDuration
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := db.PingContext(ctx)
+		cancel()
+		recordHealth(readyDatabase, err)
+
+		dbHealth.Lock()
+		wasDown, since := dbHealth.down, dbHealth.since
+		if err != nil {
+			if !wasDown {
+				dbHealth.down, dbHealth.since = true, time.Now()
+			}
+			dbHealth.err = err.Error()
+		} else {
+			dbHealth.down, dbHealth.err = false, ""
+		}
+		dbHealth.Unlock()
+
+		if err == nil {
+			if wasDown {
+				logger.Info("Database reachable again", "Downtime", time.Since(since).Round(time.Second))
+			}
+			setGauge(mDatabaseUp, 1)
+			delay, wait = time.Second, interval
+			continue
+		}
+		incCounter(mDatabasePingFailures)
+		setGauge(mDatabaseUp, 0)
+		if !wasDown {
+			logger.Error("Database unreachable; writes to it fail until it recovers", "Err", err)
+		} else {
+			logger.Warn("Database still unreachable", "Err", err, "Retry", delay)
+		}
+		wait = delay
+		delay = min(delay*2, interval)
+	}
+}
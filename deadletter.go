@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DeadLetterConfig controls how entries whose target writes failed are retried
+// and eventually archived.
+type DeadLetterConfig struct {
+	AutoRetry       *bool `yaml:"auto_retry"`       // Retry automatically (default: true)
+	InitialDelaySec int   `yaml:"initial_delay_s"`  // First retry delay (default: 60)
+	MaxDelaySec     int   `yaml:"max_delay_s"`      // Backoff cap (default: 3600)
+	MaxAgeHours     int   `yaml:"max_age_h"`        // Archive after this age (default: 168)
+	ArchiveSize     int   `yaml:"archive_size"`     // Archived letters kept (default: 1000)
+	IntervalSec     int   `yaml:"check_interval_s"` // Janitor interval (default: 10)
+}
+
+// DeadLetter is a set of resolved entries whose write to the target failed.
+// Entries from one write group stay together and are retried in order.
+type DeadLetter struct {
+	ID          uint64             `json:"id"`
+	Entries     []TransformedEntry `json:"entries"`
+	Error       string             `json:"error"`
+	Attempts    int                `json:"attempts"`
+	FirstFailed time.Time          `json:"firstFailed"`
+	LastAttempt time.Time          `json:"lastAttempt"`
+	NextRetry   time.Time          `json:"nextRetry"`
+	ArchivedAt  *time.Time         `json:"archivedAt,omitempty"`
+}
+
+type deadLetterQueue struct {
+	mu       sync.Mutex
+	seq      uint64
+	active   map[uint64]*DeadLetter
+	archived []*DeadLetter
+	retrying map[uint64]struct{}
+}
+
+var deadLetters = &deadLetterQueue{
+	active:   make(map[uint64]*DeadLetter),
+	retrying: make(map[uint64]struct{}),
+}
+
+var (
+	mDeadLetterIn = describeMetric("ldapsync_dead_letters_in_total", "counter",
+		"Entries moved to the dead-letter queue after a failed target write.")
+	mDeadLetterOut = describeMetric("ldapsync_dead_letters_out_total", "counter",
+		"Entries leaving the dead-letter queue, by outcome.")
+	mDeadLetterRetries = describeMetric("ldapsync_dead_letter_retries_total", "counter",
+		"Dead-letter retry attempts, by result.")
+	mDeadLetterActive = describeMetric("ldapsync_dead_letters", "gauge",
+		"Entries currently in the dead-letter queue.")
+)
+
+// deadLetterPolicy is the dead-letter configuration with defaults applied.
+type deadLetterPolicy struct {
+	autoRetry    bool
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	maxAge       time.Duration
+	archiveSize  int
+	interval     time.Duration
+}
+
+func deadLetterSettings() deadLetterPolicy {
+	dl := config.DeadLetter
+	p := deadLetterPolicy{
+		autoRetry:    dl.AutoRetry == nil || *dl.AutoRetry,
+		initialDelay: time.Duration(dl.InitialDelaySec) * time.Second,
+		maxDelay:     time.Duration(dl.MaxDelaySec) * time.Second,
+		maxAge:       time.Duration(dl.MaxAgeHours) * time.Hour,
+		archiveSize:  dl.ArchiveSize,
+		interval:     time.Duration(dl.IntervalSec) * time.Second,
+	}
+	if p.initialDelay <= 0 {
+		p.initialDelay = time.Minute
+	}
+	if p.maxDelay <= 0 {
+		p.maxDelay = time.Hour
+	}
+	if p.maxAge <= 0 {
+		p.maxAge = 7 * 24 * time.Hour
+	}
+	if p.archiveSize <= 0 {
+		p.archiveSize = 1000
+	}
+	if p.interval <= 0 {
+		p.interval = 10 * time.Second
+	}
+	return p
+}
+
+// backoff returns the delay before the next retry of a letter that has
+// failed the given number of retries.
+func (p deadLetterPolicy) backoff(attempts int) time.Duration {
+	delay := p.initialDelay
+	for i := 0; i < attempts && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay
+}
+
+func entryCount(letters map[uint64]*DeadLetter) int {
+	n := 0
+	for _, l := range letters {
+		n += len(l.Entries)
+	}
+	return n
+}
+
+var (
+	// errRetryInProgress is returned for a letter already being retried.
+	errRetryInProgress = errors.New("a retry of this dead letter is already in progress")
+	// errDeadLetterGone is returned when a letter was discarded or archived
+	// while its retry ran; its dependents are then not released.
+	errDeadLetterGone = errors.New("dead letter was discarded or archived during the retry")
+)
+
+// add records entries whose write failed with err.
+func (q *deadLetterQueue) add(entries []TransformedEntry, err error) {
+	now := time.Now()
+	q.mu.Lock()
+	q.seq++
+	letter := &DeadLetter{
+		ID:          q.seq,
+		Entries:     entries,
+		Error:       err.Error(),
+		FirstFailed: now,
+		LastAttempt: now,
+		NextRetry:   now.Add(deadLetterSettings().backoff(0)),
+	}
+	q.active[letter.ID] = letter
+	setGauge(mDeadLetterActive, float64(entryCount(q.active)))
+	q.mu.Unlock()
+	addCounter(mDeadLetterIn, float64(len(entries)))
+	dns := make([]string, 0, len(entries))
+	for _, e := range entries {
+		dns = append(dns, e.DN)
+	}
+	logger.Warn("Entries dead-lettered", "DeadLetterId", letter.ID, "DNs", dns, "Err", err)
+}
+
+// retry writes a letter's entries again. On failure the letter stays queued
+// with an exponentially longer delay; on success it leaves the queue and its
+// dependents are released. found is false for an unknown letter.
+func (q *deadLetterQueue) retry(id uint64) (bool, error) {
+	q.mu.Lock()
+	letter, ok := q.active[id]
+	if !ok {
+		q.mu.Unlock()
+		return false, nil
+	}
+	if _, busy := q.retrying[id]; busy {
+		q.mu.Unlock()
+		return true, errRetryInProgress
+	}
+	q.retrying[id] = struct{}{}
+	entries := append([]TransformedEntry{}, letter.Entries...)
+	q.mu.Unlock()
+
+	var writeErr error
+	for i := range entries {
+		if writeErr = storeEntry(&entries[i]); writeErr != nil {
+			break
+		}
+	}
+
+	q.mu.Lock()
+	delete(q.retrying, id)
+	letter.LastAttempt = time.Now()
+	if writeErr != nil {
+		letter.Attempts++
+		letter.Error = writeErr.Error()
+		letter.NextRetry = letter.LastAttempt.Add(deadLetterSettings().backoff(letter.Attempts))
+		attempts, nextRetry := letter.Attempts, letter.NextRetry
+		q.mu.Unlock()
+		incCounter(mDeadLetterRetries, "result", "failure")
+		logger.Warn("Dead-letter retry failed", "DeadLetterId", id, "Attempts", attempts, "NextRetry", nextRetry, "Err", writeErr)
+		return true, writeErr
+	}
+	if q.active[id] != letter {
+		q.mu.Unlock()
+		logger.Warn("Dead letter written after it was discarded or archived; dependents not released", "DeadLetterId", id)
+		return true, errDeadLetterGone
+	}
+	delete(q.active, id)
+	setGauge(mDeadLetterActive, float64(entryCount(q.active)))
+	q.mu.Unlock()
+	incCounter(mDeadLetterRetries, "result", "success")
+	addCounter(mDeadLetterOut, float64(len(entries)), "outcome", "written")
+	logger.Info("Dead-letter retry succeeded", "DeadLetterId", id, "Entries", len(entries))
+	for _, e := range entries {
+		dependencyTracker.markSyncedAndRelease(e.DN)
+	}
+	return true, nil
+}
+
+// discard removes a letter without writing it.
+func (q *deadLetterQueue) discard(id uint64) bool {
+	q.mu.Lock()
+	letter, ok := q.active[id]
+	if ok {
+		delete(q.active, id)
+		setGauge(mDeadLetterActive, float64(entryCount(q.active)))
+	}
+	q.mu.Unlock()
+	if ok {
+		addCounter(mDeadLetterOut, float64(len(letter.Entries)), "outcome", "discarded")
+	}
+	return ok
+}
+
+// supersede drops the entries for dn from the active letters after a later
+// write of the DN succeeded, so a retry cannot overwrite it with stale
+// content. Letters left without entries are dropped; letters being retried
+// are left alone.
+func (q *deadLetterQueue) supersede(dn string) {
+	key := normalizeDN(dn)
+	removed := 0
+	q.mu.Lock()
+	for id, letter := range q.active {
+		if _, busy := q.retrying[id]; busy {
+			continue
+		}
+		kept := make([]TransformedEntry, 0, len(letter.Entries))
+		for _, e := range letter.Entries {
+			if normalizeDN(e.DN) != key {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(letter.Entries) {
+			continue
+		}
+		removed += len(letter.Entries) - len(kept)
+		if len(kept) == 0 {
+			delete(q.active, id)
+			logger.Info("Dead letter superseded by a later write", "DeadLetterId", id, "DN", dn)
+			continue
+		}
+		letter.Entries = kept
+	}
+	if removed > 0 {
+		setGauge(mDeadLetterActive, float64(entryCount(q.active)))
+	}
+	q.mu.Unlock()
+	if removed > 0 {
+		addCounter(mDeadLetterOut, float64(removed), "outcome", "superseded")
+	}
+}
+
+// sweep archives letters older than the maximum age and returns the ids of
+// letters due for an automatic retry.
+func (q *deadLetterQueue) sweep(now time.Time) []uint64 {
+	policy := deadLetterSettings()
+	var due []uint64
+	archivedEntries := 0
+	q.mu.Lock()
+	for id, letter := range q.active {
+		if now.Sub(letter.FirstFailed) >= policy.maxAge {
+			archivedAt := now
+			letter.ArchivedAt = &archivedAt
+			q.archived = append(q.archived, letter)
+			delete(q.active, id)
+			archivedEntries += len(letter.Entries)
+			logger.Warn("Dead letter archived after max age", "DeadLetterId", id, "Attempts", letter.Attempts, "Err", letter.Error)
+			continue
+		}
+		if policy.autoRetry && !now.Before(letter.NextRetry) {
+			due = append(due, id)
+		}
+	}
+	if over := len(q.archived) - policy.archiveSize; over > 0 {
+		q.archived = append([]*DeadLetter{}, q.archived[over:]...)
+	}
+	setGauge(mDeadLetterActive, float64(entryCount(q.active)))
+	q.mu.Unlock()
+	if archivedEntries > 0 {
+		addCounter(mDeadLetterOut, float64(archivedEntries), "outcome", "archived")
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+	return due
+}
+
+// runDeadLetterLoop periodically archives aged letters and retries due ones.
+func runDeadLetterLoop() {
+	ticker := time.NewTicker(deadLetterSettings().interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, id := range deadLetters.sweep(now) {
+			deadLetters.retry(id)
+		}
+	}
+}
+
+func (q *deadLetterQueue) list(archived bool) []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []DeadLetter
+	if archived {
+		for _, l := range q.archived {
+			out = append(out, *l)
+		}
+		return out
+	}
+	for _, l := range q.active {
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// getDeadLettersHandler godoc
+// @Summary List dead letters
+// @Description Lists entries whose target writes failed. With archived=true, lists letters archived after exceeding the maximum age.
+// @Tags deadletters
+// @Produce json
+// @Param archived query boolean false "List archived letters instead of active ones"
+// @Success 200 {array} DeadLetter
+// @Router /deadletters [get]
+func getDeadLettersHandler(c echo.Context) error {
+	archived, _ := strconv.ParseBool(c.QueryParam("archived"))
+	letters := deadLetters.list(archived)
+	for i, l := range letters {
+		entries := make([]TransformedEntry, len(l.Entries))
+		for j, e := range l.Entries {
+			e.Content = redactPasswords(e.Content)
+			entries[j] = e
+		}
+		letters[i].Entries = entries
+	}
+	return c.JSON(http.StatusOK, letters)
+}
+
+// retryDeadLetterHandler godoc
+// @Summary Retry a dead letter
+// @Description Retries the write immediately. A failed retry pushes the next automatic retry further out.
+// @Tags deadletters
+// @Produce json
+// @Param id path int true "Dead letter id"
+// @Success 200 {object} map[string]string "Retry succeeded"
+// @Failure 404 {string} string "Dead letter not found"
+// @Failure 409 {object} map[string]string "A retry is already in progress, or the letter was discarded or archived meanwhile"
+// @Failure 502 {object} map[string]string "Retry failed"
+// @Router /deadletters/{id}/retry [post]
+func retryDeadLetterHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid dead letter id")
+	}
+	found, err := deadLetters.retry(id)
+	if !found {
+		return c.String(http.StatusNotFound, "Dead letter not found")
+	}
+	if errors.Is(err, errRetryInProgress) || errors.Is(err, errDeadLetterGone) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "written"})
+}
+
+// deleteDeadLetterHandler godoc
+// @Summary Discard a dead letter
+// @Description Removes a dead letter without writing its entries.
+// @Tags deadletters
+// @Produce json
+// @Param id path int true "Dead letter id"
+// @Success 200 {string} string "Dead letter discarded"
+// @Failure 404 {string} string "Dead letter not found"
+// @Router /deadletters/{id} [delete]
+func deleteDeadLetterHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid dead letter id")
+	}
+	if !deadLetters.discard(id) {
+		return c.String(http.StatusNotFound, "Dead letter not found")
+	}
+	return c.String(http.StatusOK, "Dead letter discarded")
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// funcTarget is a target whose writes are handled by a function.
+type funcTarget func(entry *TransformedEntry) error
+
+func (funcTarget) Name() string                          { return "func" }
+func (f funcTarget) Store(entry *TransformedEntry) error { return f(entry) }
+
+func newTestDeadLetterQueue(letters ...[]string) *deadLetterQueue {
+	q := &deadLetterQueue{active: make(map[uint64]*DeadLetter), retrying: make(map[uint64]struct{})}
+	for _, dns := range letters {
+		entries := make([]TransformedEntry, len(dns))
+		for i, dn := range dns {
+			entries[i] = TransformedEntry{DN: dn, Content: map[string]interface{}{"cn": "x"}}
+		}
+		q.add(entries, errors.New("unavailable"))
+	}
+	return q
+}
+
+// activeDNs returns the DNs of each active letter, by id.
+func (q *deadLetterQueue) activeDNs() map[uint64][]string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[uint64][]string)
+	for id, l := range q.active {
+		for _, e := range l.Entries {
+			out[id] = append(out[id], e.DN)
+		}
+	}
+	return out
+}
+
+func TestDeadLetterSupersede(t *testing.T) {
+	tests := []struct {
+		name     string
+		letters  [][]string
+		retrying []uint64
+		written  string
+		want     map[uint64][]string
+	}{
+		{
+			name:    "letter dropped",
+			letters: [][]string{{"uid=a,dc=org"}, {"uid=b,dc=org"}},
+			written: "UID=A, DC=org",
+			want:    map[uint64][]string{2: {"uid=b,dc=org"}},
+		},
+		{
+			name:    "entry removed from a group letter",
+			letters: [][]string{{"uid=a,dc=org", "cn=staff,dc=org"}},
+			written: "uid=a,dc=org",
+			want:    map[uint64][]string{1: {"cn=staff,dc=org"}},
+		},
+		{
+			name:    "every letter of the DN",
+			letters: [][]string{{"uid=a,dc=org"}, {"uid=a,dc=org"}},
+			written: "uid=a,dc=org",
+			want:    map[uint64][]string{},
+		},
+		{
+			name:     "letter being retried kept",
+			letters:  [][]string{{"uid=a,dc=org"}},
+			retrying: []uint64{1},
+			written:  "uid=a,dc=org",
+			want:     map[uint64][]string{1: {"uid=a,dc=org"}},
+		},
+		{
+			name:    "other DNs untouched",
+			letters: [][]string{{"uid=a,dc=org"}},
+			written: "uid=ab,dc=org",
+			want:    map[uint64][]string{1: {"uid=a,dc=org"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestDeadLetterQueue(tt.letters...)
+			for _, id := range tt.retrying {
+				q.retrying[id] = struct{}{}
+			}
+			q.supersede(tt.written)
+			if got := q.activeDNs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("active letters = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadLetterRetry(t *testing.T) {
+	defer func(d *dependencyState) { dependencyTracker = d }(dependencyTracker)
+	prev := primaryTarget
+	defer func() { primaryTarget = prev }()
+
+	tests := []struct {
+		name       string
+		id         uint64
+		busy       bool
+		store      func(q *deadLetterQueue) funcTarget
+		wantFound  bool
+		wantErr    error // nil, or matched with errors.Is; set wantFailed for other errors
+		wantFailed bool
+		wantActive bool
+		wantSynced bool
+	}{
+		{
+			name:       "written",
+			id:         1,
+			store:      func(*deadLetterQueue) funcTarget { return func(*TransformedEntry) error { return nil } },
+			wantFound:  true,
+			wantSynced: true,
+		},
+		{
+			name: "write fails again",
+			id:   1,
+			store: func(*deadLetterQueue) funcTarget {
+				return func(*TransformedEntry) error { return errors.New("still down") }
+			},
+			wantFound:  true,
+			wantFailed: true,
+			wantActive: true,
+		},
+		{
+			name:       "unknown letter",
+			id:         7,
+			store:      func(*deadLetterQueue) funcTarget { return func(*TransformedEntry) error { return nil } },
+			wantFound:  false,
+			wantActive: true,
+		},
+		{
+			name:       "retry already in progress",
+			id:         1,
+			busy:       true,
+			store:      func(*deadLetterQueue) funcTarget { return func(*TransformedEntry) error { return nil } },
+			wantFound:  true,
+			wantErr:    errRetryInProgress,
+			wantActive: true,
+		},
+		{
+			name: "discarded during the retry",
+			id:   1,
+			store: func(q *deadLetterQueue) funcTarget {
+				return func(*TransformedEntry) error { q.discard(1); return nil }
+			},
+			wantFound: true,
+			wantErr:   errDeadLetterGone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dependencyTracker = newDependencyState()
+			q := newTestDeadLetterQueue([]string{"uid=a,dc=org"})
+			if tt.busy {
+				q.retrying[1] = struct{}{}
+			}
+			primaryTarget = tt.store(q)
+			found, err := q.retry(tt.id)
+			if found != tt.wantFound {
+				t.Errorf("found = %v, want %v", found, tt.wantFound)
+			}
+			switch {
+			case tt.wantFailed:
+				if err == nil || errors.Is(err, errRetryInProgress) || errors.Is(err, errDeadLetterGone) {
+					t.Errorf("err = %v, want the write error", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("err = %v, want nil", err)
+			}
+			if _, active := q.active[1]; active != tt.wantActive {
+				t.Errorf("letter active = %v, want %v", active, tt.wantActive)
+			}
+			if tt.wantFailed && (q.active[1].Attempts != 1 || q.active[1].Error != "still down") {
+				t.Errorf("failed letter = %+v, want one attempt and the new error", q.active[1])
+			}
+			_, synced := dependencyTracker.synced[normalizeDN("uid=a,dc=org")]
+			if synced != tt.wantSynced {
+				t.Errorf("dependents released = %v, want %v", synced, tt.wantSynced)
+			}
+		})
+	}
+}
+
+func TestDeadLetterSweep(t *testing.T) {
+	q := newTestDeadLetterQueue([]string{"uid=a,dc=org"}, []string{"uid=b,dc=org"}, []string{"uid=c,dc=org"})
+	now := q.active[1].FirstFailed
+	policy := deadLetterSettings()
+	q.active[1].FirstFailed = now.Add(-policy.maxAge)         // aged out
+	q.active[2].NextRetry = now.Add(-1)                       // due
+	q.active[3].NextRetry = now.Add(policy.initialDelay * 10) // not yet due
+	due := q.sweep(now)
+	if !reflect.DeepEqual(due, []uint64{2}) {
+		t.Errorf("due = %v, want [2]", due)
+	}
+	var active []uint64
+	for id := range q.active {
+		active = append(active, id)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i] < active[j] })
+	if !reflect.DeepEqual(active, []uint64{2, 3}) {
+		t.Errorf("active = %v, want [2 3]", active)
+	}
+	if len(q.archived) != 1 || q.archived[0].ID != 1 || q.archived[0].ArchivedAt == nil {
+		t.Errorf("archived = %+v, want letter 1", q.archived)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PendingEntryInfo describes an entry held by the dependency tracker.
+type PendingEntryInfo struct {
+	DN                     string    `json:"dn"`
+	Key                    string    `json:"key"`
+	Search                 string    `json:"search,omitempty"`
+	WaitingSince           time.Time `json:"waitingSince"`
+	UnresolvedDependencies []string  `json:"unresolvedDependencies"`
+	MissingBindings        []string  `json:"missingBindings"`
+	RawDependencies        []string  `json:"rawDependencies"`
+	WriteGroup             uint64    `json:"writeGroup,omitempty"`
+	// Overdue is set once the entry has waited longer than
+	// pending_timeout.max_age_s.
+	Overdue bool `json:"overdue,omitempty"`
+}
+
+// DependencyStateInfo is the dependency tracker state returned by GET /dependencies.
+type DependencyStateInfo struct {
+	Pending     []PendingEntryInfo `json:"pending"`
+	SyncedCount int                `json:"syncedCount"`
+	// Blockers maps each unsynced dependency to the number of entries waiting on it.
+	Blockers map[string]int `json:"blockers"`
+	// OverdueCount is the number of overdue pending entries.
+	OverdueCount int `json:"overdueCount"`
+	// Cycles lists the dependency cycles among pending entries, each as
+	// the DNs along the cycle back to the first.
+	Cycles [][]string `json:"cycles"`
+}
+
+// DependencyEntryInfo is the state of a single DN returned by GET /dependencies/:dn.
+type DependencyEntryInfo struct {
+	Key     string            `json:"key"`
+	Synced  bool              `json:"synced"`
+	Pending *PendingEntryInfo `json:"pending,omitempty"`
+	// Dependents are pending entries waiting for this DN to be synced.
+	Dependents []string `json:"dependents"`
+}
+
+// pendingInfoLocked describes a pending entry; d.mu must be held.
+func (d *dependencyState) pendingInfoLocked(key string, p *pendingEntry) PendingEntryInfo {
+	info := PendingEntryInfo{
+		Key:                    key,
+		WaitingSince:           d.waitingSince[key],
+		UnresolvedDependencies: sortedKeys(p.deps),
+		MissingBindings:        p.missingBindings,
+		RawDependencies:        p.rawDeps,
+		Overdue:                d.pendingOverdueLocked(key, time.Now()),
+	}
+	if p.entry != nil {
+		info.DN = p.entry.DN
+		info.Search = p.entry.Search
+	}
+	if p.group != nil {
+		info.WriteGroup = p.group.origin
+	}
+	return info
+}
+
+func (d *dependencyState) snapshot(overdueOnly bool) DependencyStateInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := DependencyStateInfo{
+		Pending:     make([]PendingEntryInfo, 0, len(d.pending)),
+		SyncedCount: len(d.synced),
+		Blockers:    make(map[string]int, len(d.reverse)),
+	}
+	for key, p := range d.pending {
+		if p == nil {
+			continue
+		}
+		info := d.pendingInfoLocked(key, p)
+		if info.Overdue {
+			out.OverdueCount++
+		} else if overdueOnly {
+			continue
+		}
+		out.Pending = append(out.Pending, info)
+	}
+	for dep, parents := range d.reverse {
+		out.Blockers[dep] = len(parents)
+	}
+	out.Cycles = d.reportedCyclesLocked()
+	sort.Slice(out.Pending, func(i, j int) bool {
+		return out.Pending[i].WaitingSince.Before(out.Pending[j].WaitingSince)
+	})
+	return out
+}
+
+func (d *dependencyState) entryInfo(dn string) DependencyEntryInfo {
+	key := normalizeDN(dn)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, synced := d.synced[key]
+	info := DependencyEntryInfo{Key: key, Synced: synced, Dependents: sortedKeys(d.reverse[key])}
+	if p, ok := d.pending[key]; ok && p != nil {
+		pi := d.pendingInfoLocked(key, p)
+		info.Pending = &pi
+	}
+	if info.Dependents == nil {
+		info.Dependents = []string{}
+	}
+	return info
+}
+
+// removePendingLocked removes a pending entry and its reverse dependencies;
+// d.mu must be held.
+func (d *dependencyState) removePendingLocked(key string, p *pendingEntry) {
+	if p != nil {
+		for depKey := range p.deps {
+			if parents := d.reverse[depKey]; parents != nil {
+				delete(parents, key)
+				if len(parents) == 0 {
+					delete(d.reverse, depKey)
+				}
+			}
+		}
+	}
+	delete(d.pending, key)
+	delete(d.waitingSince, key)
+	delete(d.cycleExempt, key)
+}
+
+// dropPending discards a pending entry without writing it. A write group the
+// entry belonged to is committed without it once its other members are ready.
+func (d *dependencyState) dropPending(dn string) bool {
+	key := normalizeDN(dn)
+	d.mu.Lock()
+	p, ok := d.pending[key]
+	if ok {
+		d.removePendingLocked(key, p)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+	unpersistPending(key)
+	depLogger.Warn("Pending entry dropped", "DN", dn)
+	if p.group != nil && p.group.drop(key) {
+		d.commitGroup(p.group)
+	}
+	return true
+}
+
+func dnParam(c echo.Context) (string, error) {
+	return url.PathUnescape(c.Param("dn"))
+}
+
+// getDependenciesHandler godoc
+// @Summary Inspect dependency tracker
+// @Description Lists pending entries with their unresolved dependencies and missing bindings, oldest first, plus the number of entries waiting on each dependency. Entries waiting longer than pending_timeout.max_age_s are marked overdue.
+// @Tags dependencies
+// @Produce json
+// @Param overdue query bool false "Only list overdue entries"
+// @Success 200 {object} DependencyStateInfo
+// @Router /dependencies [get]
+func getDependenciesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, dependencyTracker.snapshot(c.QueryParam("overdue") == "true"))
+}
+
+// getDependencyHandler godoc
+// @Summary Inspect one DN in the dependency tracker
+// @Description Shows whether a DN is synced or pending, what it waits on, and which pending entries wait on it.
+// @Tags dependencies
+// @Produce json
+// @Param dn path string true "DN (URL-encoded)"
+// @Success 200 {object} DependencyEntryInfo
+// @Failure 400 {string} string "Invalid DN"
+// @Router /dependencies/{dn} [get]
+func getDependencyHandler(c echo.Context) error {
+	dn, err := dnParam(c)
+	if err != nil || normalizeDN(dn) == "" {
+		return c.String(http.StatusBadRequest, "Invalid DN")
+	}
+	return c.JSON(http.StatusOK, dependencyTracker.entryInfo(dn))
+}
+
+// deleteDependencyHandler godoc
+// @Summary Drop a pending entry
+// @Description Force-drops a wedged pending entry without writing it. Entries waiting on it stay pending.
+// @Tags dependencies
+// @Produce plain
+// @Param dn path string true "DN (URL-encoded)"
+// @Success 200 {string} string "Pending entry dropped"
+// @Failure 400 {string} string "Invalid DN"
+// @Failure 404 {string} string "No pending entry for DN"
+// @Router /dependencies/{dn} [delete]
+func deleteDependencyHandler(c echo.Context) error {
+	dn, err := dnParam(c)
+	if err != nil || normalizeDN(dn) == "" {
+		return c.String(http.StatusBadRequest, "Invalid DN")
+	}
+	if !dependencyTracker.dropPending(dn) {
+		return c.String(http.StatusNotFound, "No pending entry for DN")
+	}
+	return c.String(http.StatusOK, "Pending entry dropped")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestReleasePendingEntries(t *testing.T) {
+	type write struct {
+		dn   string
+		deps []string
+	}
+	tests := []struct {
+		name        string
+		writes      []write
+		wantWritten []string
+		wantPending []string
+	}{
+		{
+			name:        "released once its dependency is written",
+			writes:      []write{{"cn=staff,dc=org", []string{"uid=a,dc=org"}}, {"uid=a,dc=org", nil}},
+			wantWritten: []string{"uid=a,dc=org", "cn=staff,dc=org"},
+		},
+		{
+			name:        "waits for every dependency",
+			writes:      []write{{"cn=staff,dc=org", []string{"uid=a,dc=org", "uid=b,dc=org"}}, {"uid=a,dc=org", nil}},
+			wantWritten: []string{"uid=a,dc=org"},
+			wantPending: []string{"cn=staff,dc=org"},
+		},
+		{
+			name: "chains",
+			writes: []write{
+				{"cn=all,dc=org", []string{"cn=staff,dc=org"}},
+				{"cn=staff,dc=org", []string{"uid=a,dc=org"}},
+				{"UID=A, DC=org", nil},
+			},
+			wantWritten: []string{"UID=A, DC=org", "cn=staff,dc=org", "cn=all,dc=org"},
+		},
+		{
+			name:        "written at once when the dependency is synced",
+			writes:      []write{{"uid=a,dc=org", nil}, {"cn=staff,dc=org", []string{"uid=a,dc=org"}}},
+			wantWritten: []string{"uid=a,dc=org", "cn=staff,dc=org"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := useRecordingTarget(t)
+			store := useRecordingDB(t)
+			d := newDependencyState()
+			for _, w := range tt.writes {
+				d.handleEntry(&TransformedEntry{DN: w.dn, Content: map[string]interface{}{"cn": "x"}}, w.deps, nil)
+			}
+			if got := target.written(); !reflect.DeepEqual(got, tt.wantWritten) {
+				t.Errorf("written %q, want %q", got, tt.wantWritten)
+			}
+			var pending []string
+			for key := range d.pending {
+				pending = append(pending, key)
+			}
+			sort.Strings(pending)
+			if !reflect.DeepEqual(pending, tt.wantPending) {
+				t.Errorf("pending %q, want %q", pending, tt.wantPending)
+			}
+			if len(d.waitingSince) != len(tt.wantPending) {
+				t.Errorf("waiting since recorded for %d entries, want %d", len(d.waitingSince), len(tt.wantPending))
+			}
+			// Every entry persisted as pending and since written must be
+			// removed from the database again.
+			persisted := make(map[string]int)
+			for _, args := range store.execsOf("INSERT INTO pending_entries") {
+				persisted[args[0].(string)]++
+			}
+			for _, args := range store.execsOf("DELETE FROM pending_entries") {
+				persisted[args[0].(string)]--
+			}
+			for _, dn := range tt.wantPending {
+				persisted[normalizeDN(dn)]--
+			}
+			for key, n := range persisted {
+				if n != 0 {
+					t.Errorf("pending_entries row of %s left behind (%d)", key, n)
+				}
+			}
+		})
+	}
+}
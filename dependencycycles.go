@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Hooks can make pending entries depend on each other, which would leave
+// them waiting forever. A cycle is detected when an entry is registered as
+// pending and dependency_cycles.policy decides what happens: report
+// (default) logs it once and lists it under cycles in GET /dependencies,
+// break writes the cycle in DN order (the first member, parents before
+// children, is written without waiting for the others, which then follow as
+// their dependencies sync) and reject drops every member of the cycle.
+
+// DependencyCycleConfig configures the handling of dependency cycles.
+type DependencyCycleConfig struct {
+	Policy string `yaml:"policy"` // report (default), break or reject
+}
+
+const (
+	cyclePolicyReport = "report"
+	cyclePolicyBreak  = "break"
+	cyclePolicyReject = "reject"
+)
+
+var mDependencyCycles = describeMetric("ldapsync_dependency_cycles_total", "counter",
+	"Dependency cycles detected among pending entries, by policy.")
+
+func validateDependencyCycles() error {
+	switch config.DependencyCycles.Policy {
+	case "", cyclePolicyReport, cyclePolicyBreak, cyclePolicyReject:
+		return nil
+	}
+	return fmt.Errorf("dependency_cycles: unknown policy %q", config.DependencyCycles.Policy)
+}
+
+// cyclePathLocked returns the keys of a cycle of pending entries through
+// start, beginning and ending with start, or nil; d.mu must be held.
+func (d *dependencyState) cyclePathLocked(start string) []string {
+	visited := make(map[string]struct{})
+	var path []string
+	var visit func(key string) bool
+	visit = func(key string) bool {
+		p := d.pending[key]
+		if p == nil {
+			return false
+		}
+		path = append(path, key)
+		for _, dep := range sortedKeys(p.deps) {
+			if dep == start {
+				path = append(path, start)
+				return true
+			}
+			if _, seen := visited[dep]; seen {
+				continue
+			}
+			visited[dep] = struct{}{}
+			if visit(dep) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	visited[start] = struct{}{}
+	if visit(start) {
+		return path
+	}
+	return nil
+}
+
+// cycleSignature identifies a cycle regardless of where it was entered.
+func cycleSignature(path []string) string {
+	members := append([]string{}, path[:len(path)-1]...)
+	sort.Strings(members)
+	return strings.Join(members, "\x00")
+}
+
+// pruneCyclesLocked forgets reported cycles whose members are no longer all
+// pending; d.mu must be held.
+func (d *dependencyState) pruneCyclesLocked() {
+	for sig, path := range d.cycles {
+		for _, key := range path {
+			if d.pending[key] == nil {
+				delete(d.cycles, sig)
+				break
+			}
+		}
+	}
+}
+
+// cycleDNsLocked returns the DNs of the members of a cycle path; d.mu must
+// be held.
+func (d *dependencyState) cycleDNsLocked(path []string) []string {
+	dns := make([]string, len(path))
+	for i, key := range path {
+		dns[i] = key
+		if p := d.pending[key]; p != nil && p.entry != nil {
+			dns[i] = p.entry.DN
+		}
+	}
+	return dns
+}
+
+// reportedCyclesLocked returns the cycles left pending under the report
+// policy, as DN paths; d.mu must be held.
+func (d *dependencyState) reportedCyclesLocked() [][]string {
+	d.pruneCyclesLocked()
+	sigs := make([]string, 0, len(d.cycles))
+	for sig := range d.cycles {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	out := make([][]string, 0, len(sigs))
+	for _, sig := range sigs {
+		out = append(out, d.cycleDNsLocked(d.cycles[sig]))
+	}
+	return out
+}
+
+// dnOrderLess orders DNs parents first, then alphabetically.
+func dnOrderLess(a, b string) bool {
+	da, errA := ldap.ParseDN(a)
+	db, errB := ldap.ParseDN(b)
+	if errA == nil && errB == nil && len(da.RDNs) != len(db.RDNs) {
+		return len(da.RDNs) < len(db.RDNs)
+	}
+	return a < b
+}
+
+// handleCycle applies the cycle policy to a cycle found when registering a
+// pending entry.
+func (d *dependencyState) handleCycle(path []string) {
+	policy := config.DependencyCycles.Policy
+	if policy == "" {
+		policy = cyclePolicyReport
+	}
+	sig := cycleSignature(path)
+
+	d.mu.Lock()
+	d.pruneCyclesLocked()
+	if _, reported := d.cycles[sig]; reported {
+		d.mu.Unlock()
+		return
+	}
+	dns := d.cycleDNsLocked(path)
+	members := path[:len(path)-1]
+	switch policy {
+	case cyclePolicyReport:
+		d.cycles[sig] = path
+		d.mu.Unlock()
+		incCounter(mDependencyCycles, "policy", policy)
+		depLogger.Warn("Dependency cycle detected; its entries wait until one is dropped or written", "Cycle", dns)
+	case cyclePolicyReject:
+		d.mu.Unlock()
+		incCounter(mDependencyCycles, "policy", policy)
+		depLogger.Error("Dependency cycle rejected; dropping its entries", "Cycle", dns)
+		for _, key := range members {
+			d.dropPending(key)
+		}
+	case cyclePolicyBreak:
+		firstIdx := 0
+		for i := range members {
+			if dnOrderLess(dns[i], dns[firstIdx]) {
+				firstIdx = i
+			}
+		}
+		first := members[firstIdx]
+		p := d.pending[first]
+		if p == nil || p.entry == nil {
+			d.mu.Unlock()
+			return
+		}
+		exempt := d.cycleExempt[first]
+		if exempt == nil {
+			exempt = make(map[string]struct{})
+			d.cycleExempt[first] = exempt
+		}
+		for _, key := range members {
+			if key != first {
+				exempt[key] = struct{}{}
+			}
+		}
+		// Take the entry out and register it again without the cycle's
+		// dependencies; its waiting time is kept.
+		for depKey := range p.deps {
+			if parents := d.reverse[depKey]; parents != nil {
+				delete(parents, first)
+				if len(parents) == 0 {
+					delete(d.reverse, depKey)
+				}
+			}
+		}
+		delete(d.pending, first)
+		d.mu.Unlock()
+		incCounter(mDependencyCycles, "policy", policy)
+		depLogger.Warn("Dependency cycle detected; breaking it in DN order", "Cycle", dns, "WritingFirst", p.entry.DN)
+		d.handleEntry(p.entry, p.rawDeps, p.group)
+	default:
+		d.mu.Unlock()
+	}
+}
+
+// cycleExemptions returns the dependencies an entry no longer waits on
+// because they formed a broken cycle.
+func (d *dependencyState) cycleExemptions(key string) map[string]struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return maps.Clone(d.cycleExempt[key])
+}
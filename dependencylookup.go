@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// With dependency_lookup enabled, a dependency not in the synced set is
+// looked up on the target with a base-scope search before its entry is
+// deferred; if the DN exists it is marked synced, so entries do not wait for
+// DNs provisioned out of band or written before a restart. DNs found missing
+// are not looked up again for negative_ttl_s, which bounds the target load
+// while entries are reprocessed.
+
+// DependencyLookupConfig configures verifying dependencies on the target.
+type DependencyLookupConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	NegativeTTLSec int  `yaml:"negative_ttl_s"` // Wait before looking up a missing DN again (default: 30)
+}
+
+// dependencyLookupMisses holds when each DN found missing may be looked up
+// again.
+var dependencyLookupMisses = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+var mDependencyLookups = describeMetric("ldapsync_dependency_lookups_total", "counter",
+	"Dependencies looked up on the target, by result (found, missing, cached or error).")
+
+func dependencyLookupNegativeTTL() time.Duration {
+	if ttl := config.DependencyLookup.NegativeTTLSec; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// pruneDependencyLookupMisses forgets the missing DNs due for a new lookup.
+func pruneDependencyLookupMisses() {
+	now := time.Now()
+	dependencyLookupMisses.Lock()
+	defer dependencyLookupMisses.Unlock()
+	for key, until := range dependencyLookupMisses.until {
+		if now.After(until) {
+			delete(dependencyLookupMisses.until, key)
+		}
+	}
+}
+
+// verifyDependencies looks up the dependencies (DNs by key) that are not
+// synced on the target and marks those that exist as synced.
+func (d *dependencyState) verifyDependencies(deps map[string]string) {
+	if !config.DependencyLookup.Enabled || len(deps) == 0 {
+		return
+	}
+	now := time.Now()
+	var unknown []string
+	d.mu.Lock()
+	for key := range deps {
+		if _, ok := d.synced[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	d.mu.Unlock()
+	if len(unknown) == 0 {
+		return
+	}
+	dependencyLookupMisses.Lock()
+	lookup := unknown[:0]
+	for _, key := range unknown {
+		if until, ok := dependencyLookupMisses.until[key]; ok && now.Before(until) {
+			incCounter(mDependencyLookups, "result", "cached")
+			continue
+		}
+		delete(dependencyLookupMisses.until, key)
+		lookup = append(lookup, key)
+	}
+	dependencyLookupMisses.Unlock()
+	if len(lookup) == 0 {
+		return
+	}
+
+	l, release, err := acquireTargetConn()
+	if err != nil {
+		incCounter(mDependencyLookups, "result", "error")
+		depLogger.Warn("Failed to connect to the target to look up dependencies", "Err", err)
+		return
+	}
+	var found []string
+	var opErr error
+	for _, key := range lookup {
+		_, err := l.Search(ldap.NewSearchRequest(deps[key], ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+			1, 0, false, "(objectClass=*)", []string{"1.1"}, nil))
+		switch {
+		case err == nil:
+			incCounter(mDependencyLookups, "result", "found")
+			found = append(found, key)
+		case isNoSuchObject(err):
+			incCounter(mDependencyLookups, "result", "missing")
+			dependencyLookupMisses.Lock()
+			dependencyLookupMisses.until[key] = time.Now().Add(dependencyLookupNegativeTTL())
+			dependencyLookupMisses.Unlock()
+		default:
+			incCounter(mDependencyLookups, "result", "error")
+			depLogger.Warn("Failed to look up dependency on the target", "DN", deps[key], "Err", err)
+			if isNetworkError(err) {
+				opErr = err
+			}
+		}
+		if opErr != nil {
+			break
+		}
+	}
+	release(opErr)
+	for _, key := range found {
+		depLogger.Debug("Dependency found on the target", "DN", deps[key])
+		d.markSyncedAndRelease(deps[key])
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// The synced set of the dependency tracker starts empty, so after a restart
+// every entry with dependencies would wait for them to be written again even
+// though they exist on the target. Warming searches the target subtrees in
+// dependency_warmup for existing entries and marks their DNs as synced,
+// releasing the entries waiting on them; it runs at startup with on_startup
+// and on demand with POST /dependencies/warm.
+
+// DependencyWarmupConfig configures warming the synced set from the target.
+type DependencyWarmupConfig struct {
+	OnStartup bool `yaml:"on_startup"`
+	// Subtrees are searched for existing entries (default: the target base_dn).
+	Subtrees []DependencyWarmupSubtree `yaml:"subtrees"`
+	PageSize uint32                    `yaml:"page_size"` // Paged results page size (default: 500)
+}
+
+// DependencyWarmupSubtree is a target subtree whose entries are marked synced.
+type DependencyWarmupSubtree struct {
+	BaseDN string `yaml:"base_dn"`
+	Filter string `yaml:"filter"` // Default: (objectClass=*)
+}
+
+// DependencyWarmResult is the outcome of warming the synced set.
+type DependencyWarmResult struct {
+	// Entries counts the target entries found, NewlySynced those not
+	// already marked synced.
+	Entries     int   `json:"entries"`
+	NewlySynced int   `json:"newlySynced"`
+	DurationMs  int64 `json:"durationMs"`
+}
+
+func validateDependencyWarmup() error {
+	for i, s := range config.DependencyWarmup.Subtrees {
+		if s.BaseDN == "" {
+			return fmt.Errorf("dependency_warmup: subtrees[%d]: base_dn is required", i)
+		}
+		if _, err := ldap.ParseDN(s.BaseDN); err != nil {
+			return fmt.Errorf("dependency_warmup: subtrees[%d]: %w", i, err)
+		}
+		if s.Filter != "" {
+			if _, err := ldap.CompileFilter(s.Filter); err != nil {
+				return fmt.Errorf("dependency_warmup: subtrees[%d]: filter: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// warmDependencies marks the existing entries of the warmup subtrees as synced.
+func warmDependencies() (DependencyWarmResult, error) {
+	start := time.Now()
+	var out DependencyWarmResult
+	cfg := config.DependencyWarmup
+	subtrees := cfg.Subtrees
+	if len(subtrees) == 0 {
+		subtrees = []DependencyWarmupSubtree{{BaseDN: config.Target.BaseDN}}
+	}
+	pageSize := cfg.PageSize
+	if pageSize == 0 {
+		pageSize = 500
+	}
+	l, err := dialTarget()
+	if err != nil {
+		return out, err
+	}
+	defer l.Close()
+	for _, s := range subtrees {
+		filter := s.Filter
+		if filter == "" {
+			filter = "(objectClass=*)"
+		}
+		sr, err := l.SearchWithPaging(ldap.NewSearchRequest(s.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, 0, false, filter, []string{"1.1"}, nil), pageSize)
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", s.BaseDN, err)
+		}
+		for _, e := range sr.Entries {
+			out.Entries++
+			if dependencyTracker.markSyncedAndRelease(e.DN) {
+				out.NewlySynced++
+			}
+		}
+	}
+	out.DurationMs = time.Since(start).Milliseconds()
+	depLogger.Info("Warmed synced set from target", "Entries", out.Entries, "NewlySynced", out.NewlySynced,
+		"Duration", time.Since(start).Round(time.Millisecond))
+	return out, nil
+}
+
+// warmDependenciesHandler godoc
+// @Summary Warm the synced set from the target
+// @Description Searches the dependency_warmup subtrees (default: the target base DN) and marks existing entries as synced, releasing the pending entries waiting on them.
+// @Tags dependencies
+// @Produce json
+// @Success 200 {object} DependencyWarmResult
+// @Failure 502 {object} map[string]string "Target search failed"
+// @Router /dependencies/warm [post]
+func warmDependenciesHandler(c echo.Context) error {
+	out, err := warmDependencies()
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, out)
+}
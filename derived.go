@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DerivedSearchesConfig controls the cleanup of searches created by hook
+// responses. A derived search is removed, with its results, when its TTL or
+// idle expiry (set per search by the hook) passes, or when the search or
+// source entry whose hook response created it has been gone for the grace
+// period.
+type DerivedSearchesConfig struct {
+	// OrphanGraceMin keeps a derived search this long after its parent
+	// search was deleted or its originating entry disappeared from the
+	// parent's results (default: 60).
+	OrphanGraceMin int `yaml:"orphan_grace_m"`
+	IntervalMin    int `yaml:"interval_m"` // Time between cleanup passes (default: 5)
+	// ConflictPolicy decides what happens when a hook response derives a
+	// search whose ID is owned by another producer or parent entry, or by
+	// a search created through the API: last-wins (default) replaces it,
+	// first-wins keeps it, error keeps it and logs an error.
+	ConflictPolicy string `yaml:"conflict_policy"`
+}
+
+const (
+	derivedConflictLastWins  = "last-wins"
+	derivedConflictFirstWins = "first-wins"
+	derivedConflictError     = "error"
+)
+
+var (
+	mDerivedRemoved = describeMetric("ldapsync_derived_searches_removed_total", "counter",
+		"Derived searches removed by the cleanup, by reason (ttl, idle, orphaned).")
+	mDerivedConflicts = describeMetric("ldapsync_derived_search_conflicts_total", "counter",
+		"Derived searches returned for an ID owned by someone else, by resolution (replaced, kept, rejected).")
+)
+
+func initDerivedSearches() error {
+	switch config.DerivedSearches.ConflictPolicy {
+	case "", derivedConflictLastWins, derivedConflictFirstWins, derivedConflictError:
+		return nil
+	}
+	return fmt.Errorf("derived_searches: unknown conflict_policy %q (expected last-wins, first-wins or error)",
+		config.DerivedSearches.ConflictPolicy)
+}
+
+// derivedOwner identifies who owns a search: "api" for searches created
+// through the API, otherwise the producer (hook URL or transform) and the
+// parent entry that derived it.
+func derivedOwner(spec *SearchSpec) string {
+	if spec.Parent == "" {
+		return "api"
+	}
+	return spec.Owner + " for " + spec.ParentDN
+}
+
+// claimDerivedSearch reports whether a derived search returned by producer
+// for source may replace the existing search with the same ID.
+func claimDerivedSearch(existing *SearchSpec, ds DerivedSearchSpec, producer string, source sourceRef) bool {
+	owner := derivedOwner(existing)
+	claimant := producer + " for " + source.DN
+	if owner == claimant {
+		return true
+	}
+	switch config.DerivedSearches.ConflictPolicy {
+	case derivedConflictFirstWins:
+		hookLogger.Warn("Derived search conflicts with its owner; keeping the existing search",
+			"SearchId", ds.ID, "Owner", owner, "Claimant", claimant, "Filter", existing.Filter, "ClaimedFilter", ds.Filter)
+		incCounter(mDerivedConflicts, "resolution", "kept")
+		return false
+	case derivedConflictError:
+		hookLogger.Error("Derived search conflicts with its owner; rejected",
+			"SearchId", ds.ID, "Owner", owner, "Claimant", claimant, "Filter", existing.Filter, "ClaimedFilter", ds.Filter)
+		incCounter(mDerivedConflicts, "resolution", "rejected")
+		return false
+	}
+	hookLogger.Warn("Derived search conflicts with its owner; replacing it",
+		"SearchId", ds.ID, "Owner", owner, "Claimant", claimant, "Filter", existing.Filter, "ClaimedFilter", ds.Filter)
+	incCounter(mDerivedConflicts, "resolution", "replaced")
+	return true
+}
+
+// parentEntries holds the normalized DNs each search found in its last full
+// search, so derived searches can tell whether their originating entry
+// still exists. Searches without a completed full search are absent.
+var parentEntries = struct {
+	sync.Mutex
+	dns map[string]map[string]struct{}
+}{dns: make(map[string]map[string]struct{})}
+
+// orphanedSince records when each derived search was first seen without
+// its parent search or originating entry.
+var orphanedSince = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// recordParentEntries stores the normalized DNs of the entries a full
+// search of id returned.
+func recordParentEntries(id string, dns map[string]struct{}) {
+	parentEntries.Lock()
+	parentEntries.dns[id] = dns
+	parentEntries.Unlock()
+}
+
+// markDerivedActive notes that a run of a derived search found changes,
+// postponing its idle expiry.
+func markDerivedActive(id string) {
+	now := time.Now()
+	searchesMu.Lock()
+	spec, ok := searches[id]
+	ok = ok && spec.Parent != ""
+	if ok {
+		spec.LastChange = now
+	}
+	searchesMu.Unlock()
+	if !ok || db == nil {
+		return
+	}
+	if _, err := db.Exec(`UPDATE searches SET last_change_at = $2 WHERE id = $1`, id, now); err != nil {
+		syncLogger.Error("Failed to record derived search activity", "SearchId", id, "Err", err)
+	}
+}
+
+// saveDerivedSearch persists a search created or updated by a hook
+// response, with its provenance and expiry, so it survives restarts.
+func saveDerivedSearch(id string, spec *SearchSpec) {
+	if db == nil {
+		return
+	}
+	if err := saveSearchToDB(id, spec); err != nil {
+		hookLogger.Error("Failed to save derived search to database", "SearchId", id, "Err", err)
+	}
+}
+
+// deriveExpiry sets the provenance and expiry of a search created or
+// renewed by a hook response of producer for source, an entry of the
+// parent search.
+func deriveExpiry(spec *SearchSpec, ds DerivedSearchSpec, parent, producer string, source sourceRef) {
+	now := time.Now()
+	spec.Parent = parent
+	spec.ParentDN = source.DN
+	spec.Owner = producer
+	spec.LastChange = now
+	spec.ExpiresAt = time.Time{}
+	if ds.TTL > 0 {
+		spec.ExpiresAt = now.Add(time.Duration(ds.TTL) * time.Second)
+	}
+	spec.IdleExpiry = time.Duration(ds.IdleExpiry) * time.Second
+	orphanedSince.Lock()
+	delete(orphanedSince.at, ds.ID)
+	orphanedSince.Unlock()
+}
+
+// derivedExpiry returns when a derived search's TTL passes, or nil.
+func derivedExpiry(spec *SearchSpec) *time.Time {
+	if spec.ExpiresAt.IsZero() {
+		return nil
+	}
+	at := spec.ExpiresAt
+	return &at
+}
+
+func runDerivedSearchGC() {
+	interval := time.Duration(config.DerivedSearches.IntervalMin) * time.Minute
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		collectDerivedSearches()
+	}
+}
+
+// collectDerivedSearches removes the derived searches that expired or
+// whose parent has been gone for longer than the grace period.
+func collectDerivedSearches() {
+	grace := time.Duration(config.DerivedSearches.OrphanGraceMin) * time.Minute
+	if grace <= 0 {
+		grace = time.Hour
+	}
+	now := time.Now()
+	expired := make(map[string]string)
+
+	searchesMu.RLock()
+	parentEntries.Lock()
+	orphanedSince.Lock()
+	for id, spec := range searches {
+		if spec.Parent == "" {
+			continue
+		}
+		switch {
+		case !spec.ExpiresAt.IsZero() && now.After(spec.ExpiresAt):
+			expired[id] = "ttl"
+			continue
+		case spec.IdleExpiry > 0 && now.Sub(spec.LastChange) > spec.IdleExpiry:
+			expired[id] = "idle"
+			continue
+		}
+		orphaned := false
+		if _, ok := searches[spec.Parent]; !ok {
+			orphaned = true
+		} else if dns, ok := parentEntries.dns[spec.Parent]; ok && spec.ParentDN != "" {
+			_, found := dns[normalizeDN(spec.ParentDN)]
+			orphaned = !found
+		}
+		if !orphaned {
+			delete(orphanedSince.at, id)
+			continue
+		}
+		since, ok := orphanedSince.at[id]
+		if !ok {
+			orphanedSince.at[id] = now
+			syncLogger.Info("Derived search lost its parent", "SearchId", id, "Parent", spec.Parent, "ParentDN", spec.ParentDN)
+			continue
+		}
+		if now.Sub(since) > grace {
+			expired[id] = "orphaned"
+		}
+	}
+	for id := range orphanedSince.at {
+		if _, ok := searches[id]; !ok {
+			delete(orphanedSince.at, id)
+		}
+	}
+	orphanedSince.Unlock()
+	parentEntries.Unlock()
+	searchesMu.RUnlock()
+
+	for id, reason := range expired {
+		if removeSearch(id) {
+			syncLogger.Info("Removed derived search", "SearchId", id, "Reason", reason)
+			incCounter(mDerivedRemoved, "reason", reason)
+		}
+		orphanedSince.Lock()
+		delete(orphanedSince.at, id)
+		orphanedSince.Unlock()
+	}
+}
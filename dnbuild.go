@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/helxplatform/ldap-sync/hooksdk"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// The DN helpers live in the hook SDK, so hooks and embedded transforms
+// escape the same way.
+
+// RDNAttribute is one type=value pair of a (possibly multi-valued) RDN.
+type RDNAttribute = hooksdk.RDNAttribute
+
+var attributeTypePattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*|[0-9]+(\.[0-9]+)*)$`)
+
+// escapeRDNValue escapes a value for use in an RDN (RFC 4514 section 2.4).
+func escapeRDNValue(v string) string {
+	return hooksdk.EscapeRDNValue(v)
+}
+
+// formatRDN builds an RDN from one or more attributes, joined with '+' in the
+// given order.
+func formatRDN(attrs ...RDNAttribute) (string, error) {
+	return hooksdk.FormatRDN(attrs...)
+}
+
+// buildDN joins already formatted RDNs and parent DNs, leaf first, and
+// checks that each part and the result parse. Parts are never trimmed: an
+// escaped trailing space is part of the value.
+func buildDN(parts ...string) (string, error) {
+	dn, err := hooksdk.BuildDN(parts...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ldap.ParseDN(dn); err != nil {
+		return "", fmt.Errorf("invalid DN %q: %w", dn, err)
+	}
+	return dn, nil
+}
+
+// starlarkDNModule exposes the DN helpers to embedded transforms:
+//
+//	dn.escape("Smith, John")                   # Smith\, John
+//	dn.rdn("cn", "Smith, John")                # cn=Smith\, John
+//	dn.rdn("cn", "x", "uid", "jsmith")         # cn=x+uid=jsmith
+//	dn.join(dn.rdn("uid", uid), "ou=users,dc=example,dc=org")
+var starlarkDNModule = &starlarkstruct.Module{
+	Name: "dn",
+	Members: starlark.StringDict{
+		"escape": starlark.NewBuiltin("dn.escape", starlarkDNEscape),
+		"rdn":    starlark.NewBuiltin("dn.rdn", starlarkDNRDN),
+		"join":   starlark.NewBuiltin("dn.join", starlarkDNJoin),
+	},
+}
+
+func starlarkDNEscape(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	return starlark.String(escapeRDNValue(v)), nil
+}
+
+func starlarkDNRDN(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 || len(args) == 0 || len(args)%2 != 0 {
+		return nil, fmt.Errorf("%s: want type, value pairs", b.Name())
+	}
+	attrs := make([]RDNAttribute, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		t, ok1 := starlark.AsString(args[i])
+		v, ok2 := starlark.AsString(args[i+1])
+		if !ok2 {
+			// Numbers and other scalars are rendered as in hook content.
+			v, ok2 = args[i+1].String(), args[i+1] != starlark.None
+		}
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s: argument %d: want a type and a value", b.Name(), i+1)
+		}
+		attrs = append(attrs, RDNAttribute{Type: t, Value: v})
+	}
+	rdn, err := formatRDN(attrs...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.String(rdn), nil
+}
+
+func starlarkDNJoin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	parts := make([]string, len(args))
+	for i, a := range args {
+		s, ok := starlark.AsString(a)
+		if !ok {
+			return nil, fmt.Errorf("%s: argument %d: want a string", b.Name(), i+1)
+		}
+		parts[i] = s
+	}
+	dn, err := buildDN(parts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.String(dn), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestFormatRDN(t *testing.T) {
+	tests := []struct {
+		name    string
+		attrs   []RDNAttribute
+		want    string
+		wantErr bool
+	}{
+		{"plain", []RDNAttribute{{Type: "cn", Value: "jdoe"}}, "cn=jdoe", false},
+		{"comma", []RDNAttribute{{Type: "cn", Value: "Smith, John"}}, `cn=Smith\, John`, false},
+		{"specials", []RDNAttribute{{Type: "cn", Value: `a+b;c<d>e"f\g=h`}}, `cn=a\+b\;c\<d\>e\"f\\g\=h`, false},
+		{"leading hash", []RDNAttribute{{Type: "cn", Value: "#1"}}, `cn=\#1`, false},
+		{"inner hash", []RDNAttribute{{Type: "cn", Value: "a#1"}}, "cn=a#1", false},
+		{"outer spaces", []RDNAttribute{{Type: "cn", Value: " x y "}}, `cn=\ x y\ `, false},
+		{"control character", []RDNAttribute{{Type: "cn", Value: "a\nb"}}, `cn=a\0ab`, false},
+		{"multi-valued", []RDNAttribute{{Type: "cn", Value: "x"}, {Type: "uid", Value: "jsmith"}}, "cn=x+uid=jsmith", false},
+		{"numeric oid", []RDNAttribute{{Type: "2.5.4.3", Value: "x"}}, "2.5.4.3=x", false},
+		{"no attributes", nil, "", true},
+		{"empty type", []RDNAttribute{{Type: "", Value: "x"}}, "", true},
+		{"invalid type", []RDNAttribute{{Type: "c n", Value: "x"}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatRDN(tt.attrs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("formatRDN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("formatRDN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDN(t *testing.T) {
+	tests := []struct {
+		name    string
+		parts   []string
+		want    string
+		wantErr bool
+	}{
+		{"rdn and parent", []string{"uid=jdoe", "ou=people,dc=example,dc=org"}, "uid=jdoe,ou=people,dc=example,dc=org", false},
+		{"escaped comma", []string{`cn=Smith\, John`, "dc=org"}, `cn=Smith\, John,dc=org`, false},
+		{"escaped trailing space kept", []string{`cn=x\ `, "dc=org"}, `cn=x\ ,dc=org`, false},
+		{"empty parts skipped", []string{"", "cn=x", "", "dc=org"}, "cn=x,dc=org", false},
+		{"no parts", nil, "", false},
+		{"dangling escape", []string{`cn=x\`, "dc=org"}, "", true},
+		{"invalid escape", []string{`cn=x\q`}, "", true},
+		{"missing value separator", []string{"cnx", "dc=org"}, "", true},
+		{"invalid attribute type", []string{"c n=x"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildDN(tt.parts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildDN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("buildDN() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// DNRewriteRule translates a source DN into a target DN. A rule matches either
+// by regular expression (Match) or by position in the tree (Suffix, optionally
+// constrained to a leading RDN attribute type).
+//
+// The DN template may use {n} or {name} for regex capture groups, {rdn} for
+// the value of the source DN's leading RDN, and {attr} for source attribute
+// values. $binding references are left in place and resolved at write time.
+type DNRewriteRule struct {
+	Match   string `yaml:"match"`
+	Suffix  string `yaml:"suffix"`
+	RDNType string `yaml:"rdn_type"`
+	DN      string `yaml:"dn"`
+}
+
+type compiledDNRewrite struct {
+	rule   DNRewriteRule
+	re     *regexp.Regexp
+	suffix *ldap.DN
+}
+
+var dnRewrites = make(map[string][]compiledDNRewrite)
+
+// validateDNSyntax checks that a DN (or DN template after placeholder and
+// binding substitution) parses under RFC 4514.
+func validateDNSyntax(dn string) error {
+	probe := mappingPlaceholder.ReplaceAllString(dn, "x")
+	probe = replaceTemplateRefs(probe, "x")
+	if _, err := ldap.ParseDN(probe); err != nil {
+		return fmt.Errorf("invalid DN %q: %w", dn, err)
+	}
+	return nil
+}
+
+// initDNRewrites compiles the configured rule sets and validates their templates.
+func initDNRewrites() error {
+	for name, rules := range config.DNRewrites {
+		compiled := make([]compiledDNRewrite, 0, len(rules))
+		for i, rule := range rules {
+			c := compiledDNRewrite{rule: rule}
+			switch {
+			case rule.Match != "" && rule.Suffix != "":
+				return fmt.Errorf("dn_rewrites %q rule %d: match and suffix are mutually exclusive", name, i)
+			case rule.Match != "":
+				re, err := regexp.Compile("(?i)" + rule.Match)
+				if err != nil {
+					return fmt.Errorf("dn_rewrites %q rule %d: %w", name, i, err)
+				}
+				c.re = re
+			case rule.Suffix != "":
+				suffix, err := ldap.ParseDN(rule.Suffix)
+				if err != nil {
+					return fmt.Errorf("dn_rewrites %q rule %d: invalid suffix: %w", name, i, err)
+				}
+				c.suffix = suffix
+			default:
+				return fmt.Errorf("dn_rewrites %q rule %d: match or suffix is required", name, i)
+			}
+			if rule.DN == "" {
+				return fmt.Errorf("dn_rewrites %q rule %d: dn template is required", name, i)
+			}
+			if err := validateDNSyntax(rule.DN); err != nil {
+				return fmt.Errorf("dn_rewrites %q rule %d: %w", name, i, err)
+			}
+			compiled = append(compiled, c)
+		}
+		dnRewrites[name] = compiled
+	}
+	return nil
+}
+
+func dnRewriteExists(name string) bool {
+	_, ok := dnRewrites[name]
+	return ok
+}
+
+// match reports whether the rule applies to the source DN and returns the
+// placeholder values it contributes.
+func (c compiledDNRewrite) match(sourceDN string, parsed *ldap.DN) (map[string]string, bool) {
+	vars := make(map[string]string)
+	if parsed != nil && len(parsed.RDNs) > 0 && len(parsed.RDNs[0].Attributes) > 0 {
+		vars["rdn"] = parsed.RDNs[0].Attributes[0].Value
+	}
+	if c.re != nil {
+		m := c.re.FindStringSubmatch(sourceDN)
+		if m == nil {
+			return nil, false
+		}
+		for i, v := range m {
+			vars[fmt.Sprint(i)] = v
+			if name := c.re.SubexpNames()[i]; name != "" {
+				vars[name] = v
+			}
+		}
+		return vars, true
+	}
+	if parsed == nil || len(parsed.RDNs) <= len(c.suffix.RDNs) || !c.suffix.AncestorOfFold(parsed) {
+		return nil, false
+	}
+	if c.rule.RDNType != "" && !strings.EqualFold(parsed.RDNs[0].Attributes[0].Type, c.rule.RDNType) {
+		return nil, false
+	}
+	return vars, true
+}
+
+// expandDNTemplate fills {placeholders} in a DN template from vars first and
+// then from source attributes, escaping each value as an RDN value.
+func expandDNTemplate(tmpl string, vars map[string]string, content map[string]interface{}) string {
+	return mappingPlaceholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		if v, ok := vars[m[1:len(m)-1]]; ok {
+			return escapeRDNValue(v)
+		}
+		return escapeRDNValue(expandMappingTemplate(m, content))
+	})
+}
+
+// rewriteDN applies the first matching rule of a rule set to a source entry
+// and validates the resulting DN.
+func rewriteDN(ruleSet string, result LDAPResult) (string, error) {
+	rules, ok := dnRewrites[ruleSet]
+	if !ok {
+		return "", fmt.Errorf("unknown dn_rewrites rule set %q", ruleSet)
+	}
+	parsed, _ := ldap.ParseDN(result.DN)
+	for _, rule := range rules {
+		vars, ok := rule.match(result.DN, parsed)
+		if !ok {
+			continue
+		}
+		dn := expandDNTemplate(rule.rule.DN, vars, result.Content)
+		if err := validateDNSyntax(dn); err != nil {
+			return "", err
+		}
+		return dn, nil
+	}
+	return "", fmt.Errorf("no dn_rewrites rule in %q matched %q", ruleSet, result.DN)
+}
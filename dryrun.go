@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// ChangePreview is a target write computed but not performed because the
+// originating search (or the whole service) runs in dry-run mode.
+type ChangePreview struct {
+	Time      time.Time                  `json:"time"`
+	Search    string                     `json:"search,omitempty"`
+	Operation string                     `json:"operation"` // add, modify or delete
+	DN        string                     `json:"dn"`
+	Changes   map[string]AttributeChange `json:"changes,omitempty"`
+}
+
+// AttributeChange shows an attribute's target values before and after the
+// previewed write. Binary values are base64-encoded.
+type AttributeChange struct {
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// previewLimit bounds the previews kept in memory (default: 10000).
+func previewLimit() int {
+	if config.DryRunPreviewSize > 0 {
+		return config.DryRunPreviewSize
+	}
+	return 10000
+}
+
+type changePreviews struct {
+	mu      sync.Mutex
+	entries []ChangePreview
+}
+
+var previews = &changePreviews{}
+
+// isDryRun reports whether writes for an entry must only be previewed.
+func isDryRun(entry *TransformedEntry) bool {
+	if config.DryRun {
+		return true
+	}
+	if entry.Search == "" {
+		return false
+	}
+	searchesMu.RLock()
+	defer searchesMu.RUnlock()
+	spec, ok := searches[entry.Search]
+	return ok && spec.DryRun
+}
+
+func previewValues(attr string, vals []string) []string {
+	if isPasswordAttr(attr) {
+		return redactedValues(attr, vals)
+	}
+	if !isBinaryAttr(attr) {
+		return vals
+	}
+	raw := make([][]byte, len(vals))
+	for i, v := range vals {
+		raw[i] = []byte(v)
+	}
+	return encodeBinaryValues(raw)
+}
+
+// diffAttributes computes the attribute-level diff of a write against the
+// current target entry (nil for an add), omitting unchanged attributes.
+func diffAttributes(attributes map[string][]string, current *ldap.Entry) map[string]AttributeChange {
+	changes := make(map[string]AttributeChange)
+	for attr, after := range attributes {
+		var before []string
+		if current != nil {
+			before = getEntryAttributeValues(current, attr)
+			if sameValues(before, after) {
+				continue
+			}
+		}
+		changes[attr] = AttributeChange{Before: previewValues(attr, before), After: previewValues(attr, after)}
+	}
+	return changes
+}
+
+// readTargetAttributes reads the named attributes of a target entry; it
+// returns nil if the entry does not exist.
+func readTargetAttributes(l *ldap.Conn, dn string, attributes map[string][]string) (*ldap.Entry, error) {
+	sr, err := l.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", attributeNames(attributes), nil))
+	if err != nil {
+		if ldapErr, ok := err.(*ldap.Error); ok && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	return sr.Entries[0], nil
+}
+
+// recordPreview records a previewed write against the current target entry
+// (nil for an add).
+func recordPreview(entry *TransformedEntry, op string, attributes map[string][]string, current *ldap.Entry) {
+	changes := diffAttributes(attributes, current)
+	if op == "modify" && len(changes) == 0 {
+		logger.Debug("Dry run: no change", "DN", entry.DN, "SearchId", entry.Search)
+		return
+	}
+	p := ChangePreview{Time: time.Now(), Search: entry.Search, Operation: op, DN: entry.DN, Changes: changes}
+	previews.mu.Lock()
+	previews.entries = append(previews.entries, p)
+	if over := len(previews.entries) - previewLimit(); over > 0 {
+		previews.entries = append([]ChangePreview{}, previews.entries[over:]...)
+	}
+	previews.mu.Unlock()
+	attrs := make([]string, 0, len(changes))
+	for attr := range changes {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	logger.Info("Dry run: change not written", "Operation", op, "DN", entry.DN, "SearchId", entry.Search, "Attributes", attrs)
+}
+
+// sameValues compares two value lists as sets.
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, v := range a {
+		seen[v]++
+	}
+	for _, v := range b {
+		if seen[v] == 0 {
+			return false
+		}
+		seen[v]--
+	}
+	return true
+}
+
+// previewTargetChange reads the attributes a write would touch and records
+// the change instead of performing it.
+func previewTargetChange(l *ldap.Conn, entry *TransformedEntry, op string, attributes map[string][]string) error {
+	if op == "add" {
+		recordPreview(entry, op, attributes, nil)
+		return nil
+	}
+	current, err := readTargetAttributes(l, entry.DN, attributes)
+	if err != nil {
+		return err
+	}
+	recordPreview(entry, op, attributes, current)
+	return nil
+}
+
+// getChangePreviewHandler godoc
+// @Summary List previewed changes
+// @Description Lists target writes computed in dry-run mode but not performed, oldest first.
+// @Tags changes
+// @Produce json
+// @Param search query string false "Only changes originating from this search"
+// @Param limit query int false "Return at most this many of the most recent changes"
+// @Success 200 {array} ChangePreview
+// @Router /changes/preview [get]
+func getChangePreviewHandler(c echo.Context) error {
+	search := c.QueryParam("search")
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	previews.mu.Lock()
+	out := make([]ChangePreview, 0, len(previews.entries))
+	for _, p := range previews.entries {
+		if search == "" || p.Search == search {
+			out = append(out, p)
+		}
+	}
+	previews.mu.Unlock()
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// clearChangePreviewHandler godoc
+// @Summary Clear previewed changes
+// @Description Discards recorded dry-run previews.
+// @Tags changes
+// @Produce plain
+// @Success 200 {string} string "Previews cleared"
+// @Router /changes/preview [delete]
+func clearChangePreviewHandler(c echo.Context) error {
+	previews.mu.Lock()
+	previews.entries = nil
+	previews.mu.Unlock()
+	return c.String(http.StatusOK, "Previews cleared")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// With events configured, every successful target write (add, modify,
+// rename) and, with source_changes, every new, changed or removed source
+// entry is published as a ChangeEvent to a NATS subject or Kafka topic, so
+// other systems can react to identity changes without polling. Events are
+// queued and published in the background: a slow or unreachable bus never
+// holds up synchronization, and events arriving while the queue is full
+// are dropped and counted.
+
+// EventsConfig configures change event publishing.
+type EventsConfig struct {
+	Type string `yaml:"type"` // nats or kafka ("": disabled)
+	// SourceChanges also publishes changes seen in the source.
+	SourceChanges bool `yaml:"source_changes"`
+	// QueueSize is the number of events buffered for publishing (default:
+	// 10000).
+	QueueSize int               `yaml:"queue_size"`
+	NATS      NATSEventsConfig  `yaml:"nats"`
+	Kafka     KafkaEventsConfig `yaml:"kafka"`
+}
+
+// KafkaEventsConfig configures publishing to Kafka, keyed by DN.
+type KafkaEventsConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"` // default: ldapsync.changes
+}
+
+// ChangeEvent is the published envelope, encoded as JSON.
+type ChangeEvent struct {
+	Version   int       `json:"version"` // Envelope version, currently 1
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Origin    string    `json:"origin"`    // target or source
+	Operation string    `json:"operation"` // add, modify, rename or delete
+	DN        string    `json:"dn"`
+	Search    string    `json:"search,omitempty"`
+	Producer  string    `json:"producer,omitempty"`
+	// Changes are the attributes written to the target, with their
+	// previous values when known.
+	Changes map[string]AttributeChange `json:"changes,omitempty"`
+	// Content and Previous are a source entry after and before the change.
+	Content  map[string]interface{} `json:"content,omitempty"`
+	Previous map[string]interface{} `json:"previous,omitempty"`
+}
+
+const changeEventVersion = 1
+
+// eventPublisher sends encoded events to a message bus.
+type eventPublisher interface {
+	Publish(ev *ChangeEvent, data []byte) error
+	Close() error
+}
+
+// eventPublisherTypes builds publishers by type; kafka registers itself when
+// built with the kafka tag.
+var eventPublisherTypes = map[string]func(EventsConfig) (eventPublisher, error){
+	"nats": newNATSPublisher,
+}
+
+var (
+	mEventsPublished = describeMetric("ldapsync_events_published_total", "counter",
+		"Change events published, by origin (target, source).")
+	mEventsDropped = describeMetric("ldapsync_events_dropped_total", "counter",
+		"Change events dropped because the publish queue was full.")
+	mEventPublishErrors = describeMetric("ldapsync_event_publish_errors_total", "counter",
+		"Failed attempts to publish a change event.")
+	mEventQueueDepth = describeMetric("ldapsync_event_queue_depth", "gauge",
+		"Change events waiting to be published.")
+)
+
+var (
+	eventQueue     chan *ChangeEvent
+	eventPublish   eventPublisher
+	eventSeq       atomic.Uint64
+	eventStartTime = time.Now().UnixNano()
+)
+
+func init() {
+	registerCollector(func() {
+		setGauge(mEventQueueDepth, float64(len(eventQueue)))
+	})
+}
+
+func initEvents() error {
+	if config.Events.Type == "" {
+		return nil
+	}
+	build, ok := eventPublisherTypes[config.Events.Type]
+	if !ok {
+		if config.Events.Type == "kafka" {
+			return fmt.Errorf("events: kafka requires a binary built with -tags kafka")
+		}
+		return fmt.Errorf("events: unknown type %q (want nats or kafka)", config.Events.Type)
+	}
+	p, err := build(config.Events)
+	if err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+	eventPublish = p
+	return nil
+}
+
+// eventsEnabled reports whether change events are published.
+func eventsEnabled() bool {
+	return eventPublish != nil
+}
+
+// startEventPublisher starts publishing queued events.
+func startEventPublisher() {
+	if !eventsEnabled() {
+		return
+	}
+	size := config.Events.QueueSize
+	if size <= 0 {
+		size = 10000
+	}
+	eventQueue = make(chan *ChangeEvent, size)
+	go runEventPublisher()
+	logger.Info("Publishing change events", "Type", config.Events.Type, "SourceChanges", config.Events.SourceChanges)
+}
+
+// runEventPublisher publishes queued events in order, retrying each with
+// backoff until the bus accepts it.
+func runEventPublisher() {
+	for ev := range eventQueue {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			logger.Error("Failed to encode change event", "DN", ev.DN, "Err", err)
+			continue
+		}
+		delay := 100 * time.Millisecond
+		for {
+			err := eventPublish.Publish(ev, data)
+			if err == nil {
+				break
+			}
+			incCounter(mEventPublishErrors)
+			logger.Warn("Failed to publish change event; retrying", "DN", ev.DN, "Delay", delay, "Err", err)
+			time.Sleep(delay)
+			delay = min(delay*2, 30*time.Second)
+		}
+		incCounter(mEventsPublished, "origin", ev.Origin)
+	}
+}
+
+// publishEvent queues an event without waiting.
+func publishEvent(ev *ChangeEvent) {
+	if eventQueue == nil {
+		return
+	}
+	ev.Version = changeEventVersion
+	ev.ID = fmt.Sprintf("%x-%x", eventStartTime, eventSeq.Add(1))
+	ev.Time = time.Now().UTC()
+	select {
+	case eventQueue <- ev:
+	default:
+		incCounter(mEventsDropped)
+	}
+}
+
+// publishTargetEvent publishes a successful target write.
+func publishTargetEvent(entry *TransformedEntry, op string, changes map[string]AttributeChange) {
+	if eventQueue == nil {
+		return
+	}
+	publishEvent(&ChangeEvent{Origin: "target", Operation: op, DN: entry.DN,
+		Search: entry.Search, Producer: entry.Producer, Changes: changes})
+}
+
+// publishSourceEvent publishes a change seen in the source.
+func publishSourceEvent(search, op string, result LDAPResult) {
+	if eventQueue == nil || !config.Events.SourceChanges {
+		return
+	}
+	publishEvent(&ChangeEvent{Origin: "source", Operation: op, DN: result.DN, Search: search,
+		Content: redactPasswords(result.Content), Previous: redactPasswords(result.previous)})
+}
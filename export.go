@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// GET /export writes the runtime state (searches, bindings and optionally
+// the cached results) as one JSON document, and POST /import restores it,
+// so a deployment can be moved to another environment or upgraded
+// blue/green without sharing a database.
+
+const stateExportVersion = 1
+
+// StateExport is the document produced by GET /export.
+type StateExport struct {
+	Version    int                     `json:"version"`
+	ExportedAt time.Time               `json:"exported_at"`
+	Searches   []ExportedSearch        `json:"searches"`
+	Bindings   map[string]BindingValue `json:"bindings"`
+	// NullBindings are keys hooks explicitly bound to null.
+	NullBindings []string `json:"null_bindings,omitempty"`
+	// Results are the cached results by search id; only with results=true.
+	Results map[string][]ExportedResult `json:"results,omitempty"`
+}
+
+// ExportedSearch is a search with the settings SearchInfo does not show.
+type ExportedSearch struct {
+	SearchInfo
+	// IdleExpiry is the idle expiry of a derived search, in seconds.
+	IdleExpiry int `json:"idle_expiry,omitempty"`
+}
+
+// ExportedResult is a cached result. Key is the result's key in its search
+// (the normalized DN or the correlation key); Hash replaces Content for
+// results restored from a database that stores hashes only.
+type ExportedResult struct {
+	Key     string                 `json:"key"`
+	DN      string                 `json:"dn"`
+	Content map[string]interface{} `json:"content,omitempty"`
+	Hash    string                 `json:"hash,omitempty"`
+}
+
+// ImportResult reports what POST /import restored.
+type ImportResult struct {
+	Imported []string `json:"imported"`
+	// Skipped are searches that already existed (without replace=true).
+	Skipped  []string `json:"skipped"`
+	Bindings int      `json:"bindings"`
+	Results  int      `json:"results"`
+}
+
+// exportHandler godoc
+// @Summary Export the runtime state
+// @Description Returns all searches and bindings, and with results=true the cached search results, as one JSON document that POST /import restores.
+// @Tags state
+// @Produce json
+// @Param results query boolean false "Include the cached search results"
+// @Success 200 {object} StateExport
+// @Router /export [get]
+func exportHandler(c echo.Context) error {
+	withResults, _ := strconv.ParseBool(c.QueryParam("results"))
+	out := StateExport{
+		Version:    stateExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Searches:   []ExportedSearch{},
+	}
+	searchesMu.RLock()
+	for id, spec := range searches {
+		out.Searches = append(out.Searches, ExportedSearch{
+			SearchInfo: searchInfo(id, spec),
+			IdleExpiry: int(spec.IdleExpiry / time.Second),
+		})
+	}
+	searchesMu.RUnlock()
+	sort.Slice(out.Searches, func(i, j int) bool { return out.Searches[i].ID < out.Searches[j].ID })
+
+	var nulls map[string]struct{}
+	out.Bindings, nulls = getBindingsSnapshot()
+	for k := range nulls {
+		out.NullBindings = append(out.NullBindings, k)
+	}
+	sort.Strings(out.NullBindings)
+
+	if withResults {
+		out.Results = make(map[string][]ExportedResult)
+		searchResultsMu.RLock()
+		for id, results := range searchResults {
+			list := make([]ExportedResult, 0, len(results))
+			for key, r := range results {
+				list = append(list, ExportedResult{Key: key, DN: r.DN, Content: withoutPasswords(r.Content), Hash: r.hash})
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+			out.Results[id] = list
+		}
+		searchResultsMu.RUnlock()
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// importHandler godoc
+// @Summary Import runtime state
+// @Description Restores a document produced by GET /export: creates and starts its searches with their cached results and sets its bindings. Searches that already exist are skipped unless replace=true, which deletes them first.
+// @Tags state
+// @Accept json
+// @Produce json
+// @Param replace query boolean false "Replace searches that already exist"
+// @Param state body StateExport true "Exported state"
+// @Success 200 {object} ImportResult
+// @Failure 400 {string} string "Invalid document or search"
+// @Router /import [post]
+func importHandler(c echo.Context) error {
+	replace, _ := strconv.ParseBool(c.QueryParam("replace"))
+	var in StateExport
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid state document: "+err.Error())
+	}
+	if in.Version != stateExportVersion {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Unsupported state version %d (want %d)", in.Version, stateExportVersion))
+	}
+	// Validate everything before changing anything.
+	for _, s := range in.Searches {
+		if err := validateImportedSearch(s); err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Search %q: %v", s.ID, err))
+		}
+	}
+
+	out := ImportResult{Imported: []string{}, Skipped: []string{}}
+	for _, s := range in.Searches {
+		searchesMu.RLock()
+		_, exists := searches[s.ID]
+		searchesMu.RUnlock()
+		if exists {
+			if !replace {
+				out.Skipped = append(out.Skipped, s.ID)
+				continue
+			}
+			removeSearch(s.ID)
+		}
+		out.Results += importSearch(s, in.Results[s.ID])
+		out.Imported = append(out.Imported, s.ID)
+	}
+
+	updates := make(map[string]*BindingValue, len(in.Bindings)+len(in.NullBindings))
+	for k, v := range in.Bindings {
+		updates[k] = &v
+	}
+	for _, k := range in.NullBindings {
+		updates[k] = nil
+	}
+	updateBindings(updates, bindingOrigin{})
+	out.Bindings = len(updates)
+
+	logger.Info("Imported runtime state", "Searches", len(out.Imported), "Skipped", len(out.Skipped),
+		"Bindings", out.Bindings, "Results", out.Results, "ExportedAt", in.ExportedAt)
+	return c.JSON(http.StatusOK, out)
+}
+
+func validateImportedSearch(s ExportedSearch) error {
+	switch {
+	case s.ID == "" || s.Filter == "":
+		return fmt.Errorf("id and filter are required")
+	case s.Refresh <= 0 && s.Schedule == "":
+		return fmt.Errorf("refresh or schedule is required")
+	case s.Transform != "" && !transformExists(s.Transform):
+		return fmt.Errorf("unknown transform %q", s.Transform)
+	case s.Mapping != "" && !mappingExists(s.Mapping):
+		return fmt.Errorf("unknown mapping %q", s.Mapping)
+	case !validChangeDetection(s.ChangeDetection):
+		return fmt.Errorf("invalid change_detection %q", s.ChangeDetection)
+	case s.CorrelationAttribute != "" && !attributeTypePattern.MatchString(s.CorrelationAttribute):
+		return fmt.Errorf("invalid correlation_attribute %q", s.CorrelationAttribute)
+	}
+	if _, err := ldap.CompileFilter(s.Filter); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	if err := validateSearchOptions(s.Scope, s.Deref, s.SizeLimit, s.TimeLimit); err != nil {
+		return err
+	}
+	if s.Schedule != "" {
+		if _, err := parseCronSchedule(s.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// importSearch creates, persists and starts an imported search with its
+// results, and returns the number of results restored.
+func importSearch(s ExportedSearch, exported []ExportedResult) int {
+	baseDN := s.BaseDN
+	if baseDN == "" {
+		baseDN = config.Source.BaseDN
+	}
+	spec := &SearchSpec{
+		Filter:    s.Filter,
+		Refresh:   s.Refresh,
+		Stop:      make(chan struct{}),
+		BaseDN:    baseDN,
+		Transform: s.Transform,
+		Mapping:   s.Mapping,
+
+		RunOnce:           s.RunOnce || s.Oneshot,
+		SuppressHooks:     s.SuppressHooks || s.Oneshot,
+		Attributes:        s.Attributes,
+		ExcludeAttributes: s.ExcludeAttributes,
+		DryRun:            s.DryRun,
+		ChangeDetection:   s.ChangeDetection,
+		Rename:            s.Rename,
+
+		CorrelationAttribute: s.CorrelationAttribute,
+		Scope:                s.Scope,
+		Deref:                s.Deref,
+		SizeLimit:            s.SizeLimit,
+		TimeLimit:            s.TimeLimit,
+		Schedule:             s.Schedule,
+		Parent:               s.Parent,
+		ParentDN:             s.ParentDN,
+		Owner:                s.Owner,
+		IdleExpiry:           time.Duration(s.IdleExpiry) * time.Second,
+		LastChange:           time.Now(),
+	}
+	if s.LastRun != nil {
+		spec.LastRun = *s.LastRun
+	}
+	if s.ExpiresAt != nil {
+		spec.ExpiresAt = *s.ExpiresAt
+	}
+	if s.CompletedAt != nil && s.ResultCount != nil {
+		spec.CompletedAt, spec.ResultCount = *s.CompletedAt, *s.ResultCount
+	}
+
+	results := make(map[string]LDAPResult, len(exported))
+	for _, r := range exported {
+		if r.Key == "" {
+			r.Key = normalizeDN(r.DN)
+		}
+		result := LDAPResult{DN: r.DN, Content: r.Content, hash: r.Hash}
+		if r.Content != nil {
+			restoreResultValues(result.Content)
+		}
+		if r.Key != normalizeDN(r.DN) {
+			result.correlation = r.Key
+		}
+		persistResult(s.ID, r.Key, result)
+		results[r.Key] = cachedResult(result)
+	}
+
+	searchesMu.Lock()
+	searches[s.ID] = spec
+	searchesMu.Unlock()
+	searchResultsMu.Lock()
+	searchResults[s.ID] = results
+	searchResultsMu.Unlock()
+	if err := saveSearchToDB(s.ID, spec); err != nil {
+		logger.Error("Failed to save search to database", "SearchId", s.ID, "Err", err)
+	}
+	if spec.CompletedAt.IsZero() {
+		go ldapSearchAndSync(s.ID, *spec)
+	}
+	return len(results)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/lib/pq"
+)
+
+// FaultInjectionConfig randomly fails hook calls, LDAP connections and
+// database statements so retries, dead-lettering and dependency recovery
+// can be exercised before they are relied on. Rates are probabilities
+// between 0 and 1. It refuses to start unless the environment variable
+// LDAP_SYNC_ALLOW_FAULT_INJECTION is also set to "true", so a copied config
+// cannot enable it in production by accident.
+type FaultInjectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// HookErrorRate answers hook requests with a synthetic 500 response;
+	// HookTimeoutRate fails them like a transport timeout.
+	HookErrorRate   float64 `yaml:"hook_error_rate"`
+	HookTimeoutRate float64 `yaml:"hook_timeout_rate"`
+	// LDAPErrorRate fails source and target connection attempts with a
+	// network error.
+	LDAPErrorRate float64 `yaml:"ldap_error_rate"`
+	// DBErrorRate fails database statements.
+	DBErrorRate float64 `yaml:"db_error_rate"`
+}
+
+const (
+	faultHookError   = "hook_error"
+	faultHookTimeout = "hook_timeout"
+	faultLDAP        = "ldap"
+	faultDB          = "db"
+
+	faultGuardEnv        = "LDAP_SYNC_ALLOW_FAULT_INJECTION"
+	faultInjectingDriver = "postgres-fault-injecting"
+)
+
+// errInjectedFault is wrapped by every injected failure.
+var errInjectedFault = errors.New("injected fault")
+
+var mFaultsInjected = describeMetric("ldapsync_faults_injected_total", "counter",
+	"Failures injected by fault injection mode, by kind.")
+
+// faultsArmed is set once startup has finished; faults are never injected
+// while searches and state are being restored.
+var faultsArmed atomic.Bool
+
+// initFaultInjection validates the fault rates and the environment guard.
+func initFaultInjection() error {
+	f := config.FaultInjection
+	if !f.Enabled {
+		return nil
+	}
+	if os.Getenv(faultGuardEnv) != "true" {
+		return fmt.Errorf("fault_injection is enabled but %s is not set to \"true\"", faultGuardEnv)
+	}
+	for name, rate := range map[string]float64{
+		"hook_error_rate":   f.HookErrorRate,
+		"hook_timeout_rate": f.HookTimeoutRate,
+		"ldap_error_rate":   f.LDAPErrorRate,
+		"db_error_rate":     f.DBErrorRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("fault_injection: %s must be between 0 and 1", name)
+		}
+	}
+	if f.DBErrorRate > 0 {
+		sql.Register(faultInjectingDriver, faultInjectingPQ{&pq.Driver{}})
+	}
+	logger.Warn("FAULT INJECTION ENABLED: hooks, LDAP and database operations will fail at random",
+		"HookErrorRate", f.HookErrorRate, "HookTimeoutRate", f.HookTimeoutRate,
+		"LDAPErrorRate", f.LDAPErrorRate, "DBErrorRate", f.DBErrorRate)
+	return nil
+}
+
+// injectFault reports whether the operation of the given kind should fail,
+// counting the fault if so.
+func injectFault(kind string) bool {
+	f := config.FaultInjection
+	if !f.Enabled || !faultsArmed.Load() {
+		return false
+	}
+	var rate float64
+	switch kind {
+	case faultHookError:
+		rate = f.HookErrorRate
+	case faultHookTimeout:
+		rate = f.HookTimeoutRate
+	case faultLDAP:
+		rate = f.LDAPErrorRate
+	case faultDB:
+		rate = f.DBErrorRate
+	}
+	if rate <= 0 || rand.Float64() >= rate {
+		return false
+	}
+	incCounter(mFaultsInjected, "kind", kind)
+	return true
+}
+
+// injectedHookResponse returns a synthetic hook failure, if one is due;
+// both results are nil otherwise.
+func injectedHookResponse(hookURL string) (*http.Response, error) {
+	if injectFault(faultHookTimeout) {
+		logger.Debug("Injecting hook timeout", "URL", hookURL)
+		return nil, fmt.Errorf("%w: hook request timed out", errInjectedFault)
+	}
+	if injectFault(faultHookError) {
+		logger.Debug("Injecting hook error", "URL", hookURL)
+		body := "injected fault"
+		return &http.Response{
+			Status:        "500 Internal Server Error",
+			StatusCode:    http.StatusInternalServerError,
+			Header:        http.Header{"Content-Type": {"text/plain"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}, nil
+	}
+	return nil, nil
+}
+
+// injectedLDAPError returns a network error for a connection attempt, if
+// one is due.
+func injectedLDAPError(server string) error {
+	if !injectFault(faultLDAP) {
+		return nil
+	}
+	logger.Debug("Injecting LDAP connection failure", "Server", server)
+	return ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("%w: i/o timeout", errInjectedFault))
+}
+
+// dbDriverName is the driver initDB opens the database with.
+func dbDriverName() string {
+	if config.FaultInjection.Enabled && config.FaultInjection.DBErrorRate > 0 {
+		return faultInjectingDriver
+	}
+	return "postgres"
+}
+
+// faultInjectingPQ wraps the Postgres driver. Its connections expose only
+// the basic driver.Conn methods, so every statement goes through Prepare,
+// where faults are injected.
+type faultInjectingPQ struct{ driver.Driver }
+
+type faultInjectingConn struct{ driver.Conn }
+
+func (d faultInjectingPQ) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return faultInjectingConn{c}, nil
+}
+
+func (c faultInjectingConn) Prepare(query string) (driver.Stmt, error) {
+	if injectFault(faultDB) {
+		return nil, fmt.Errorf("%w: database statement failed", errInjectedFault)
+	}
+	return c.Conn.Prepare(query)
+}
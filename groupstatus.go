@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// A hook marks the entries of a response as an atomic group with
+// "atomic": true. Like every multi-entry response they are written together
+// once all are ready, and a failure sends the whole group back for retry;
+// for atomic groups the entries the failed attempt added to the target LDAP
+// server are also deleted again first, unless write_groups.atomic_failure is
+// retry. Only the target LDAP server is rolled back; additional targets keep
+// what they accepted. The state of recent groups is listed by GET
+// /writegroups.
+
+// WriteGroupsConfig configures write groups.
+type WriteGroupsConfig struct {
+	// AtomicFailure is what a failed atomic group does with the entries
+	// it added: rollback (default) deletes them, retry keeps them.
+	AtomicFailure string `yaml:"atomic_failure"`
+	History       int    `yaml:"history"` // Groups listed by GET /writegroups (default: 1000)
+}
+
+// Write group states.
+const (
+	groupStatePending      = "pending"
+	groupStateRetrying     = "retrying"
+	groupStateCommitted    = "committed"
+	groupStateDeadLettered = "dead_lettered"
+)
+
+// WriteGroupStatus is the state of a write group, across its retries.
+type WriteGroupStatus struct {
+	ID     uint64 `json:"id"`
+	Atomic bool   `json:"atomic"`
+	// State is pending (waiting for members), retrying, committed or
+	// dead_lettered.
+	State    string   `json:"state"`
+	Search   string   `json:"search,omitempty"`
+	Members  []string `json:"members"`
+	Attempts int      `json:"attempts"`
+	// RolledBack lists the DNs deleted after the last failed attempt.
+	RolledBack []string  `json:"rolledBack,omitempty"`
+	LastError  string    `json:"lastError,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+var writeGroupStatuses = struct {
+	sync.Mutex
+	byID  map[uint64]*WriteGroupStatus
+	order []uint64
+}{byID: make(map[uint64]*WriteGroupStatus)}
+
+var mWriteGroupRollbacks = describeMetric("ldapsync_write_group_rollbacks_total", "counter",
+	"Entries deleted to roll back failed atomic write groups, by result (success or failure).")
+
+func validateWriteGroups() error {
+	switch config.WriteGroups.AtomicFailure {
+	case "", "rollback", "retry":
+		return nil
+	}
+	return fmt.Errorf("write_groups: unknown atomic_failure %q", config.WriteGroups.AtomicFailure)
+}
+
+func writeGroupRollback() bool {
+	return config.WriteGroups.AtomicFailure != "retry"
+}
+
+// registerWriteGroup records a new group.
+func registerWriteGroup(g *writeGroup) {
+	status := &WriteGroupStatus{ID: g.id, Atomic: g.atomic, State: groupStatePending, CreatedAt: time.Now()}
+	status.UpdatedAt = status.CreatedAt
+	for _, e := range g.raw {
+		status.Members = append(status.Members, e.DN)
+	}
+	if len(g.raw) > 0 {
+		status.Search = g.raw[0].Search
+	}
+	limit := config.WriteGroups.History
+	if limit <= 0 {
+		limit = 1000
+	}
+	writeGroupStatuses.Lock()
+	defer writeGroupStatuses.Unlock()
+	writeGroupStatuses.byID[g.id] = status
+	writeGroupStatuses.order = append(writeGroupStatuses.order, g.id)
+	for len(writeGroupStatuses.order) > limit {
+		delete(writeGroupStatuses.byID, writeGroupStatuses.order[0])
+		writeGroupStatuses.order = writeGroupStatuses.order[1:]
+	}
+}
+
+// updateWriteGroup changes the recorded state of a group and its retries.
+func updateWriteGroup(g *writeGroup, update func(*WriteGroupStatus)) {
+	writeGroupStatuses.Lock()
+	defer writeGroupStatuses.Unlock()
+	if status, ok := writeGroupStatuses.byID[g.origin]; ok {
+		update(status)
+		status.UpdatedAt = time.Now()
+	}
+}
+
+// targetEntryExists reports whether a DN exists on the target.
+func targetEntryExists(dn string) (bool, error) {
+	l, release, err := acquireTargetConn()
+	if err != nil {
+		return false, err
+	}
+	_, err = l.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, 0, false, "(objectClass=*)", []string{"1.1"}, nil))
+	if isNoSuchObject(err) {
+		release(nil)
+		return false, nil
+	}
+	release(err)
+	return err == nil, err
+}
+
+// targetEntryAdded reports whether a write that failed still left the entry
+// on the target, e.g. when the write to an additional target failed.
+func targetEntryAdded(dn string) bool {
+	exists, err := targetEntryExists(dn)
+	return err == nil && exists
+}
+
+// rollbackGroup deletes the entries a failed attempt added, last first, and
+// returns the DNs deleted.
+func rollbackGroup(g *writeGroup, added []*TransformedEntry) []string {
+	var deleted []string
+	for i := len(added) - 1; i >= 0; i-- {
+		e := added[i]
+		if err := deleteTargetEntry(e); err != nil {
+			incCounter(mWriteGroupRollbacks, "result", "failure")
+			depLogger.Error("Failed to roll back grouped entry", "GroupId", g.origin, "DN", e.DN, "Err", err)
+			continue
+		}
+		incCounter(mWriteGroupRollbacks, "result", "success")
+		deleted = append(deleted, e.DN)
+	}
+	depLogger.Warn("Rolled back atomic write group", "GroupId", g.origin, "Deleted", deleted)
+	return deleted
+}
+
+// deleteTargetEntry deletes an entry from the target LDAP server, subject to
+// the destructive-operation policy, and forgets what ldap-sync recorded for
+// it.
+func deleteTargetEntry(entry *TransformedEntry) (err error) {
+	defer lockDN(entry.DN)()
+	defer targetMirror.invalidate(entry.DN)
+	l, release, err := acquireTargetConn()
+	if err != nil {
+		return err
+	}
+	defer func() { release(err) }()
+	// Only the ownership marker is needed for the policy check.
+	attrs := map[string][]string{"1.1": nil}
+	if m := config.Policy.OwnershipMarker; m != nil {
+		attrs = map[string][]string{m.Attribute: nil}
+	}
+	existing, err := readTargetAttributes(l, entry.DN, attrs)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		// Already gone; nothing to roll back.
+		forgetTargetEntry(entry)
+		return nil
+	}
+	if err := checkPolicy(policyDelete, entry.DN, existing); err != nil {
+		journalWrite(entry, policyDelete, nil, nil, err)
+		return err
+	}
+	waitTargetWrite()
+	err = l.Del(ldap.NewDelRequest(entry.DN, nil))
+	journalWrite(entry, policyDelete, nil, nil, err)
+	if err == nil {
+		forgetTargetEntry(entry)
+	}
+	return err
+}
+
+// forgetTargetEntry drops the DN mapping and managed values of a deleted
+// entry, so the next write of its source entry adds it again.
+func forgetTargetEntry(entry *TransformedEntry) {
+	if enabled, _ := renameEnabled(entry); enabled {
+		dnMappings.forget(entry.Search, sourceKey(entry), entry.DN)
+	}
+	managedValues.forget(entry.DN)
+}
+
+// getWriteGroupsHandler godoc
+// @Summary List write groups
+// @Description Lists recent write groups (the entries of one hook response written together), newest first, with their state, attempts and rollbacks.
+// @Tags dependencies
+// @Produce json
+// @Param state query string false "Only groups in this state (pending, retrying, committed, dead_lettered)"
+// @Success 200 {array} WriteGroupStatus
+// @Router /writegroups [get]
+func getWriteGroupsHandler(c echo.Context) error {
+	state := c.QueryParam("state")
+	writeGroupStatuses.Lock()
+	out := make([]WriteGroupStatus, 0, len(writeGroupStatuses.order))
+	for _, id := range slices.Backward(writeGroupStatuses.order) {
+		if status := writeGroupStatuses.byID[id]; state == "" || status.State == state {
+			out = append(out, *status)
+		}
+	}
+	writeGroupStatuses.Unlock()
+	return c.JSON(http.StatusOK, out)
+}
+
+// getWriteGroupHandler godoc
+// @Summary Get a write group
+// @Tags dependencies
+// @Produce json
+// @Param id path int true "Write group ID"
+// @Success 200 {object} WriteGroupStatus
+// @Failure 404 {string} string "Write group not found"
+// @Router /writegroups/{id} [get]
+func getWriteGroupHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusNotFound, "Write group not found")
+	}
+	writeGroupStatuses.Lock()
+	status, ok := writeGroupStatuses.byID[id]
+	var out WriteGroupStatus
+	if ok {
+		out = *status
+	}
+	writeGroupStatuses.Unlock()
+	if !ok {
+		return c.String(http.StatusNotFound, "Write group not found")
+	}
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ComponentHealth is the status of one dependency in GET /health/details.
+type ComponentHealth struct {
+	Status    string  `json:"status"` // ok, error or disabled
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthDetails is the component diagnostics returned by GET /health/details.
+type HealthDetails struct {
+	Status        string                     `json:"status"` // ok when every enabled component is ok
+	Role          string                     `json:"role"`   // leader or standby
+	StartedAt     time.Time                  `json:"startedAt"`
+	UptimeSeconds float64                    `json:"uptimeSeconds"`
+	Source        ComponentHealth            `json:"source"`
+	Target        ComponentHealth            `json:"target"`
+	Database      ComponentHealth            `json:"database"`
+	Hooks         map[string]ComponentHealth `json:"hooks"`
+	Goroutines    int                        `json:"goroutines"`
+	// SearchGoroutines counts the running sync loops of each search; a
+	// value above 1 means a restarted loop has not exited yet.
+	SearchGoroutines map[string]int `json:"searchGoroutines"`
+	PendingEntries   int            `json:"pendingEntries"`
+	// QueuedSearchRuns counts search runs waiting for a concurrency slot.
+	QueuedSearchRuns int `json:"queuedSearchRuns"`
+	// HookHealth is the monitored state of each hook (hook_health), with
+	// the requests held for degraded ones.
+	HookHealth map[string]HookHealthState `json:"hookHealth,omitempty"`
+}
+
+var processStart = time.Now()
+
+// searchLoops counts the running ldapSearchAndSync goroutines per search.
+var searchLoops = struct {
+	sync.Mutex
+	running map[string]int
+}{running: make(map[string]int)}
+
+// trackSearchLoop registers a running sync loop and returns the function
+// that unregisters it.
+func trackSearchLoop(id string) func() {
+	searchLoops.Lock()
+	searchLoops.running[id]++
+	searchLoops.Unlock()
+	return func() {
+		searchLoops.Lock()
+		if searchLoops.running[id]--; searchLoops.running[id] <= 0 {
+			delete(searchLoops.running, id)
+		}
+		searchLoops.Unlock()
+	}
+}
+
+// timeCheck runs check and reports its outcome and duration.
+func timeCheck(check func() error) ComponentHealth {
+	start := time.Now()
+	err := check()
+	h := ComponentHealth{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		h.Status = "error"
+		h.Error = err.Error()
+	}
+	return h
+}
+
+// probeHook reports whether a hook endpoint answers HTTP at all; any status
+// code counts as reachable since hooks only accept POSTs of entries.
+func probeHook(hookURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, hookURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hookHTTPClient(hookURL).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// healthDetailsHandler godoc
+// @Summary Component diagnostics
+// @Description Checks source and target LDAP binds, the database and hook endpoints on the spot, with their latency, and reports goroutine counts, pending dependency entries and uptime. Always answers 200; inspect status for the overall result.
+// @Tags probes
+// @Produce json
+// @Success 200 {object} HealthDetails
+// @Router /health/details [get]
+func healthDetailsHandler(c echo.Context) error {
+	out := HealthDetails{
+		Status:    "ok",
+		Role:      "leader",
+		StartedAt: processStart,
+		Hooks:     make(map[string]ComponentHealth, len(config.Hooks)),
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	run := func(set func(ComponentHealth), check func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := timeCheck(check)
+			mu.Lock()
+			set(h)
+			mu.Unlock()
+		}()
+	}
+	run(func(h ComponentHealth) { out.Source = h }, func() error {
+		l, err := connectAndBindLDAP()
+		if err == nil {
+			l.Close()
+		}
+		return err
+	})
+	run(func(h ComponentHealth) { out.Target = h }, func() error {
+		l, err := dialTarget()
+		if err == nil {
+			l.Close()
+		}
+		return err
+	})
+	if db != nil {
+		run(func(h ComponentHealth) { out.Database = h }, func() error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+			defer cancel()
+			err := db.PingContext(ctx)
+			recordHealth(readyDatabase, err)
+			return err
+		})
+	} else {
+		out.Database = ComponentHealth{Status: "disabled"}
+	}
+	for _, hookURL := range config.Hooks {
+		hookURL := hookURL
+		probe := probeHook
+		if config.HookHealth.Enabled {
+			probe = probeHookHealth
+		}
+		run(func(h ComponentHealth) { out.Hooks[hookURL] = h }, func() error { return probe(hookURL) })
+	}
+	wg.Wait()
+	if config.HookHealth.Enabled {
+		out.HookHealth = hookHealthStates()
+		for _, state := range out.HookHealth {
+			if state.Degraded {
+				out.Status = "degraded"
+			}
+		}
+	}
+
+	checked := []ComponentHealth{out.Source, out.Target, out.Database}
+	for _, h := range out.Hooks {
+		checked = append(checked, h)
+	}
+	for _, h := range checked {
+		if h.Status == "error" {
+			out.Status = "degraded"
+		}
+	}
+
+	out.UptimeSeconds = time.Since(processStart).Seconds()
+	out.Goroutines = runtime.NumGoroutine()
+	searchLoops.Lock()
+	out.SearchGoroutines = make(map[string]int, len(searchLoops.running))
+	for id, n := range searchLoops.running {
+		out.SearchGoroutines[id] = n
+	}
+	searchLoops.Unlock()
+	dependencyTracker.mu.Lock()
+	out.PendingEntries = len(dependencyTracker.pending)
+	dependencyTracker.mu.Unlock()
+	out.QueuedSearchRuns = searchSlots.queueDepth()
+	if !isLeader() {
+		out.Role = "standby"
+	}
+	return c.JSON(http.StatusOK, out)
+}
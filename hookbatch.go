@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// HookBatchConfig accumulates changed entries and posts them to the hooks as
+// a JSON array instead of one request per entry. Hooks answer with an array
+// of responses; when it has one response per posted entry, in order, each
+// response is attributed to its entry's source (needed for rename tracking).
+type HookBatchConfig struct {
+	MaxSize      int `yaml:"max_size"`       // Entries per request; batching is off below 2
+	MaxLatencyMs int `yaml:"max_latency_ms"` // Longest an entry waits for its batch (default: 1000)
+	// Hooks limits batching to these hook URLs (default: all hooks).
+	Hooks []string `yaml:"hooks"`
+}
+
+var mHookBatchSize = describeMetric("ldapsync_hook_batch_entries_total", "counter",
+	"Entries posted to hooks in batches.")
+
+type hookBatch struct {
+	results []HookRequest
+	sources []sourceRef
+	timer   *time.Timer
+}
+
+// hookBatcher holds one open batch per hook URL and search, so the hook
+// identity and response processing stay per search.
+type hookBatcher struct {
+	mu      sync.Mutex
+	batches map[string]*hookBatch
+}
+
+var hookBatches = &hookBatcher{batches: make(map[string]*hookBatch)}
+
+func hookBatchingEnabled(hookURL string) bool {
+	b := config.HookBatch
+	if b.MaxSize < 2 {
+		return false
+	}
+	if len(b.Hooks) == 0 {
+		return true
+	}
+	for _, h := range b.Hooks {
+		if h == hookURL {
+			return true
+		}
+	}
+	return false
+}
+
+// add queues result for hookURL, posting the batch once it is full.
+func (b *hookBatcher) add(hookURL, searchID string, source sourceRef, result HookRequest) {
+	latency := time.Duration(config.HookBatch.MaxLatencyMs) * time.Millisecond
+	if latency <= 0 {
+		latency = time.Second
+	}
+	key := hookURL + "\x00" + searchID
+	b.mu.Lock()
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &hookBatch{}
+		b.batches[key] = batch
+		batch.timer = time.AfterFunc(latency, func() { b.flush(key, batch, hookURL, searchID) })
+	}
+	batch.results = append(batch.results, result)
+	batch.sources = append(batch.sources, source)
+	full := len(batch.results) >= config.HookBatch.MaxSize
+	b.mu.Unlock()
+	if full {
+		batch.timer.Stop()
+		b.flush(key, batch, hookURL, searchID)
+	}
+}
+
+// flush posts a batch unless it was already posted.
+func (b *hookBatcher) flush(key string, batch *hookBatch, hookURL, searchID string) {
+	b.mu.Lock()
+	if b.batches[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, key)
+	b.mu.Unlock()
+
+	payload, err := encodeHookPayload(hookURL, batch.results, true)
+	if err != nil {
+		hookLogger.Error("Error marshalling hook batch", "URL", hookURL, "Entries", len(batch.results), "Err", err)
+		return
+	}
+	addCounter(mHookBatchSize, float64(len(batch.results)))
+	hookLogger.Debug("Posting hook batch", "URL", hookURL, "SearchId", searchID, "Entries", len(batch.results))
+	go deliverToHook(hookURL, searchID, payload, batch.results, batch.sources)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// With hook_cache enabled, the responses of hooks are cached by hook,
+// search, DN and canonical content hash for ttl_s, and an entry posted again
+// with the same content is answered from the cache instead of the hook. This
+// keeps restarts and oscillating searches from re-posting unchanged entries.
+// Deletions, replays and reconciliation always reach the hook, and only
+// hooks whose responses depend on nothing but the entry content should be
+// cached. With database persistence the cache is also stored in the
+// hook_response_cache table, so it survives restarts.
+
+// HookCacheConfig configures the hook response cache.
+type HookCacheConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	TTLSec     int  `yaml:"ttl_s"`       // How long responses are reused (default: 3600)
+	MaxEntries int  `yaml:"max_entries"` // Responses kept in memory (default: 10000)
+	// Hooks limits caching to these hook URLs (default: all hooks).
+	Hooks []string `yaml:"hooks"`
+	// Persist stores the cache in the database (default: true when
+	// database persistence is enabled).
+	Persist *bool `yaml:"persist"`
+}
+
+type hookCacheEntry struct {
+	hook    string
+	key     string
+	data    []byte // The JSON encoded responses, decoded afresh for each use
+	expires time.Time
+}
+
+// hookResponseCache is an LRU of hook responses by cache key.
+type hookResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+var hookCache = &hookResponseCache{entries: make(map[string]*list.Element), order: list.New()}
+
+var mHookCache = describeMetric("ldapsync_hook_cache_requests_total", "counter",
+	"Hook requests looked up in the response cache, by hook and result (hit or miss).")
+
+func hookCacheEnabled(hookURL string) bool {
+	c := config.HookCache
+	return c.Enabled && (len(c.Hooks) == 0 || slices.Contains(c.Hooks, hookURL))
+}
+
+func hookCachePersisted() bool {
+	c := config.HookCache
+	return db != nil && (c.Persist == nil || *c.Persist)
+}
+
+func hookCacheTTL() time.Duration {
+	if ttl := config.HookCache.TTLSec; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return time.Hour
+}
+
+// hookCacheKey returns the cache key of a request, or "" when its response
+// is not to be cached.
+func hookCacheKey(hookURL, searchID string, req HookRequest) string {
+	if !hookCacheEnabled(hookURL) || req.ChangeType == changeTypeDelete || req.Replay || req.Reconcile {
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range []string{hookURL, searchID, normalizeDN(req.DN), canonicalResultHash(req.Content)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedHookResponses returns the cached responses of a request.
+func cachedHookResponses(hookURL, key string) ([]HookResponse, bool) {
+	if key == "" {
+		return nil, false
+	}
+	data, ok := hookCache.get(key)
+	if !ok && hookCachePersisted() {
+		var expires time.Time
+		var err error
+		data, expires, err = loadCachedHookResponses(hookURL, key)
+		if err != nil {
+			hookLogger.Warn("Failed to read hook response cache", "URL", hookURL, "Err", err)
+		} else if data != nil {
+			hookCache.put(hookURL, key, data, expires)
+			ok = true
+		}
+	}
+	var responses []HookResponse
+	if ok {
+		if err := json.Unmarshal(data, &responses); err != nil {
+			hookLogger.Warn("Failed to decode cached hook responses", "URL", hookURL, "Err", err)
+			ok = false
+		}
+	}
+	if !ok {
+		incCounter(mHookCache, "hook", hookURL, "result", "miss")
+		return nil, false
+	}
+	incCounter(mHookCache, "hook", hookURL, "result", "hit")
+	return responses, true
+}
+
+// cacheHookResponses stores the responses of a request.
+func cacheHookResponses(hookURL, key string, responses []HookResponse) {
+	if key == "" {
+		return
+	}
+	data, err := json.Marshal(responses)
+	if err != nil {
+		hookLogger.Warn("Failed to encode hook responses for the cache", "URL", hookURL, "Err", err)
+		return
+	}
+	expires := time.Now().Add(hookCacheTTL())
+	hookCache.put(hookURL, key, data, expires)
+	if !hookCachePersisted() {
+		return
+	}
+	const upsertSQL = `
+	INSERT INTO hook_response_cache (hook, cache_key, responses, expires_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (hook, cache_key) DO UPDATE SET responses = $3, expires_at = $4;`
+	if _, err := db.Exec(upsertSQL, hookURL, key, string(data), expires); err != nil {
+		hookLogger.Warn("Failed to persist hook response cache", "URL", hookURL, "Err", err)
+	}
+}
+
+func loadCachedHookResponses(hookURL, key string) ([]byte, time.Time, error) {
+	var data string
+	var expires time.Time
+	err := db.QueryRow(`SELECT responses, expires_at FROM hook_response_cache
+		WHERE hook = $1 AND cache_key = $2 AND expires_at > $3`, hookURL, key, time.Now()).Scan(&data, &expires)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return []byte(data), expires, nil
+}
+
+// get returns the unexpired responses cached under key.
+func (c *hookResponseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*hookCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.data, true
+}
+
+func (c *hookResponseCache) put(hookURL, key string, data []byte, expires time.Time) {
+	max := config.HookCache.MaxEntries
+	if max <= 0 {
+		max = 10000
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*hookCacheEntry)
+		entry.data, entry.expires = data, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&hookCacheEntry{hook: hookURL, key: key, data: data, expires: expires})
+	for c.order.Len() > max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*hookCacheEntry).key)
+	}
+}
+
+// clear drops the cached responses of a hook, or of all hooks when hookURL
+// is empty, and returns how many were dropped from memory.
+func (c *hookResponseCache) clear(hookURL string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if entry := el.Value.(*hookCacheEntry); hookURL == "" || entry.hook == hookURL {
+			c.order.Remove(el)
+			delete(c.entries, entry.key)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// clearHookCacheHandler godoc
+// @Summary Clear the hook response cache
+// @Description Drops the cached responses of one hook, or of all hooks, from memory and the database, so entries are posted to the hooks again; use it after changing a hook.
+// @Tags hooks
+// @Produce json
+// @Param hook query string false "Only this hook URL"
+// @Success 200 {object} map[string]int64 "Responses dropped from memory and the database"
+// @Failure 500 {string} string "Database error"
+// @Router /hooks/cache [delete]
+func clearHookCacheHandler(c echo.Context) error {
+	hookURL := c.QueryParam("hook")
+	out := map[string]int64{"memory": int64(hookCache.clear(hookURL))}
+	if db != nil && config.HookCache.Enabled {
+		var res sql.Result
+		var err error
+		if hookURL == "" {
+			res, err = db.Exec(`DELETE FROM hook_response_cache`)
+		} else {
+			res, err = db.Exec(`DELETE FROM hook_response_cache WHERE hook = $1`, hookURL)
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		out["database"], _ = res.RowsAffected()
+	}
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HookHTTPConfig configures the HTTP clients used to call hooks: default
+// applies to every hook, and the settings under hooks, by hook URL, override
+// it field by field.
+type HookHTTPConfig struct {
+	Default HookHTTPClientConfig            `yaml:"default"`
+	Hooks   map[string]HookHTTPClientConfig `yaml:"hooks"`
+}
+
+// HookHTTPClientConfig holds the proxy, TLS and connection settings of a
+// hook client.
+type HookHTTPClientConfig struct {
+	// ProxyURL is the proxy to use; empty uses HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY from the environment and "none" connects directly.
+	ProxyURL string `yaml:"proxy_url"`
+	// CAFile is a PEM bundle of CAs trusted in addition to the system ones.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are a PEM client certificate and key for mTLS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the name verified in the server certificate.
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify *bool  `yaml:"insecure_skip_verify"` // Skip certificate verification (default: false)
+	TimeoutSec         int    `yaml:"timeout_s"`            // Per-request timeout (default: none)
+	DisableKeepAlives  *bool  `yaml:"disable_keep_alives"`  // Close connections after each request (default: false)
+	// MaxIdleConnsPerHost and IdleConnTimeoutSec tune connection reuse
+	// (default: 2 and 90).
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeoutSec  int `yaml:"idle_conn_timeout_s"`
+}
+
+var (
+	// hookClients holds the client of each hook with its own settings;
+	// defaultHookClient serves the others.
+	hookClients       = map[string]*http.Client{}
+	defaultHookClient = http.DefaultClient
+)
+
+// initHookHTTP builds the hook clients. Certificates and CA bundles are read
+// here, so changing them requires a restart or config reload.
+func initHookHTTP() error {
+	cfg := config.HookHTTP
+	def, err := newHookHTTPClient(cfg.Default)
+	if err != nil {
+		return fmt.Errorf("hook_http: default: %w", err)
+	}
+	clients := make(map[string]*http.Client, len(cfg.Hooks))
+	for hook, override := range cfg.Hooks {
+		if !slices.Contains(config.Hooks, hook) {
+			return fmt.Errorf("hook_http: %s is not a configured hook", hook)
+		}
+		client, err := newHookHTTPClient(mergeHookHTTPConfig(cfg.Default, override))
+		if err != nil {
+			return fmt.Errorf("hook_http: %s: %w", hook, err)
+		}
+		clients[hook] = client
+	}
+	hookClients, defaultHookClient = clients, def
+	return nil
+}
+
+// mergeHookHTTPConfig overrides the set fields of base with those of o.
+func mergeHookHTTPConfig(base, o HookHTTPClientConfig) HookHTTPClientConfig {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&base.ProxyURL, o.ProxyURL)
+	set(&base.CAFile, o.CAFile)
+	set(&base.CertFile, o.CertFile)
+	set(&base.KeyFile, o.KeyFile)
+	set(&base.ServerName, o.ServerName)
+	if o.InsecureSkipVerify != nil {
+		base.InsecureSkipVerify = o.InsecureSkipVerify
+	}
+	if o.DisableKeepAlives != nil {
+		base.DisableKeepAlives = o.DisableKeepAlives
+	}
+	if o.TimeoutSec != 0 {
+		base.TimeoutSec = o.TimeoutSec
+	}
+	if o.MaxIdleConnsPerHost != 0 {
+		base.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeoutSec != 0 {
+		base.IdleConnTimeoutSec = o.IdleConnTimeoutSec
+	}
+	return base
+}
+
+func newHookHTTPClient(cfg HookHTTPClientConfig) (*http.Client, error) {
+	if cfg == (HookHTTPClientConfig{}) {
+		return http.DefaultClient, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch strings.ToLower(cfg.ProxyURL) {
+	case "":
+	case "none":
+		transport.Proxy = nil
+	default:
+		proxy, err := url.Parse(cfg.ProxyURL)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy_url %q", cfg.ProxyURL)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+	if cfg.InsecureSkipVerify != nil && *cfg.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file: no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if cfg.DisableKeepAlives != nil {
+		transport.DisableKeepAlives = *cfg.DisableKeepAlives
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeoutSec > 0 {
+		transport.IdleConnTimeout = time.Duration(cfg.IdleConnTimeoutSec) * time.Second
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(cfg.TimeoutSec) * time.Second,
+	}, nil
+}
+
+// hookHTTPClient returns the client to call a hook with.
+func hookHTTPClient(hookURL string) *http.Client {
+	if client, ok := hookClients[hookURL]; ok {
+		return client
+	}
+	return defaultHookClient
+}
+
+// hookHTTPClientWithTimeout returns the hook's client, bounded by timeout
+// when it has no timeout of its own.
+func hookHTTPClientWithTimeout(hookURL string, timeout time.Duration) *http.Client {
+	client := hookHTTPClient(hookURL)
+	if client.Timeout != 0 {
+		return client
+	}
+	bounded := *client
+	bounded.Timeout = timeout
+	return &bounded
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// A hook can declare which entries it wants under hook_conditions: an LDAP
+// filter over the result content and/or a regular expression over the DN.
+// Results that do not match are not posted to it. A modified entry is posted
+// when its current or its previous content matches, so the hook also sees
+// entries leaving its condition; deletions are matched on the previous
+// content.
+
+// HookConditionConfig limits the entries posted to a hook.
+type HookConditionConfig struct {
+	// Filter is an LDAP filter evaluated against the result content, e.g.
+	// (objectClass=posixGroup).
+	Filter string `yaml:"filter"`
+	// DNPattern is a regular expression the DN must match, e.g.
+	// (?i),ou=groups,dc=example,dc=org$.
+	DNPattern string `yaml:"dn_pattern"`
+}
+
+type hookCondition struct {
+	filter    *contentFilter
+	dnPattern *regexp.Regexp
+}
+
+var (
+	hookConditions = map[string]*hookCondition{}
+
+	mHookDispatchSkipped = describeMetric("ldapsync_hook_dispatch_skipped_total", "counter",
+		"Results not posted to a hook because they did not match its condition, by hook.")
+)
+
+func initHookConditions() error {
+	hookConditions = make(map[string]*hookCondition, len(config.HookConditions))
+	for hook, cfg := range config.HookConditions {
+		if !slices.Contains(config.Hooks, hook) {
+			return fmt.Errorf("hook_conditions: %s is not a configured hook", hook)
+		}
+		cond := &hookCondition{}
+		if cfg.Filter != "" {
+			f, err := compileContentFilter(cfg.Filter)
+			if err != nil {
+				return fmt.Errorf("hook_conditions: %s: filter: %w", hook, err)
+			}
+			cond.filter = f
+		}
+		if cfg.DNPattern != "" {
+			re, err := regexp.Compile(cfg.DNPattern)
+			if err != nil {
+				return fmt.Errorf("hook_conditions: %s: dn_pattern: %w", hook, err)
+			}
+			cond.dnPattern = re
+		}
+		hookConditions[hook] = cond
+	}
+	return nil
+}
+
+// hookWants reports whether a result is to be posted to a hook, counting
+// those that are not.
+func hookWants(hookURL string, result LDAPResult) bool {
+	if hookMatches(hookURL, result) {
+		return true
+	}
+	incCounter(mHookDispatchSkipped, "hook", hookURL)
+	return false
+}
+
+// hookMatches reports whether a result satisfies a hook's condition.
+func hookMatches(hookURL string, result LDAPResult) bool {
+	cond, ok := hookConditions[hookURL]
+	if !ok {
+		return true
+	}
+	return cond.matches(result.DN, result.Content, result.changeType != changeTypeDelete) ||
+		(result.previous != nil && cond.matches(result.DN, result.previous, true))
+}
+
+func (c *hookCondition) matches(dn string, content map[string]interface{}, useContent bool) bool {
+	if c.dnPattern != nil && !c.dnPattern.MatchString(dn) {
+		return false
+	}
+	if c.filter == nil {
+		return true
+	}
+	return useContent && c.filter.matches(content)
+}
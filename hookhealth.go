@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// With hook_health enabled, each hook's health endpoint is probed
+// periodically. After failure_threshold consecutive failed probes the hook
+// is marked degraded and no traffic is sent to it: the requests it would
+// have received are held in memory, the latest per DN and search, up to
+// buffer_size per hook, and delivered in order once a probe succeeds again.
+// Requests beyond the buffer are dropped and counted; replaying the searches
+// resends them.
+
+// HookHealthConfig configures hook health monitoring.
+type HookHealthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the health endpoint, resolved against each hook URL (default:
+	// /health); Paths overrides it by hook URL.
+	Path             string            `yaml:"path"`
+	Paths            map[string]string `yaml:"paths"`
+	IntervalSec      int               `yaml:"interval_s"`        // Probe interval (default: 30)
+	TimeoutSec       int               `yaml:"timeout_s"`         // Probe timeout (default: 5)
+	FailureThreshold int               `yaml:"failure_threshold"` // Consecutive failures before degrading (default: 3)
+	BufferSize       int               `yaml:"buffer_size"`       // Requests held per degraded hook (default: 1000)
+}
+
+// HookHealthState is the monitored health of a hook.
+type HookHealthState struct {
+	Degraded bool `json:"degraded"`
+	// Failures counts consecutive failed probes.
+	Failures  int       `json:"failures"`
+	LastCheck time.Time `json:"lastCheck,omitempty"`
+	LatencyMs float64   `json:"latencyMs,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+	// DegradedSince is when the hook was marked degraded.
+	DegradedSince *time.Time `json:"degradedSince,omitempty"`
+	// Held counts the requests held for delivery on recovery.
+	Held int `json:"held"`
+}
+
+type heldHookRequest struct {
+	searchID string
+	source   sourceRef
+	req      HookRequest
+}
+
+type hookHealth struct {
+	state HookHealthState
+	held  []heldHookRequest
+	index map[string]int // search and DN -> position in held
+}
+
+var hookHealthMonitor = struct {
+	sync.Mutex
+	hooks map[string]*hookHealth
+}{hooks: make(map[string]*hookHealth)}
+
+var (
+	mHookHealthChecks = describeMetric("ldapsync_hook_health_checks_total", "counter",
+		"Hook health probes, by hook and result (ok or error).")
+	mHookDegraded = describeMetric("ldapsync_hook_degraded", "gauge",
+		"1 while a hook is degraded and its traffic is held.")
+	mHookHeld = describeMetric("ldapsync_hook_held_requests", "gauge",
+		"Requests held for a degraded hook.")
+	mHookHeldDropped = describeMetric("ldapsync_hook_held_dropped_total", "counter",
+		"Requests dropped because a degraded hook's buffer was full, by hook.")
+)
+
+func init() {
+	registerCollector(func() {
+		resetGauge(mHookDegraded)
+		resetGauge(mHookHeld)
+		hookHealthMonitor.Lock()
+		defer hookHealthMonitor.Unlock()
+		for hook, h := range hookHealthMonitor.hooks {
+			degraded := 0.0
+			if h.state.Degraded {
+				degraded = 1
+			}
+			setGauge(mHookDegraded, degraded, "hook", hook)
+			setGauge(mHookHeld, float64(len(h.held)), "hook", hook)
+		}
+	})
+}
+
+// hookHealthURL returns the health endpoint of a hook.
+func hookHealthURL(hookURL string) (string, error) {
+	path := config.HookHealth.Paths[hookURL]
+	if path == "" {
+		path = config.HookHealth.Path
+	}
+	if path == "" {
+		path = "/health"
+	}
+	base, err := url.Parse(hookURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// startHookHealthMonitor starts probing the hooks when enabled.
+func startHookHealthMonitor() {
+	if !config.HookHealth.Enabled {
+		return
+	}
+	interval := time.Duration(config.HookHealth.IntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go func() {
+		checkHooksHealth()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkHooksHealth()
+		}
+	}()
+}
+
+func checkHooksHealth() {
+	var wg sync.WaitGroup
+	for _, hookURL := range config.Hooks {
+		wg.Add(1)
+		go func(hookURL string) {
+			defer wg.Done()
+			h := timeCheck(func() error { return probeHookHealth(hookURL) })
+			recordHookHealth(hookURL, h)
+		}(hookURL)
+	}
+	wg.Wait()
+}
+
+// probeHookHealth GETs a hook's health endpoint; any 2xx status is healthy.
+func probeHookHealth(hookURL string) error {
+	target, err := hookHealthURL(hookURL)
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(config.HookHealth.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hookHTTPClient(hookURL).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// recordHookHealth updates a hook's state with a probe result, degrading
+// or recovering it.
+func recordHookHealth(hookURL string, probe ComponentHealth) {
+	threshold := config.HookHealth.FailureThreshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+	now := time.Now()
+
+	hookHealthMonitor.Lock()
+	h := hookHealthMonitor.hooks[hookURL]
+	if h == nil {
+		h = &hookHealth{index: make(map[string]int)}
+		hookHealthMonitor.hooks[hookURL] = h
+	}
+	h.state.LastCheck = now
+	h.state.LatencyMs = probe.LatencyMs
+	var release []heldHookRequest
+	if probe.Status == "ok" {
+		incCounter(mHookHealthChecks, "hook", hookURL, "result", "ok")
+		h.state.Failures = 0
+		h.state.LastError = ""
+		if h.state.Degraded {
+			hookLogger.Info("Hook recovered, delivering held requests", "URL", hookURL,
+				"Held", len(h.held), "DegradedFor", now.Sub(*h.state.DegradedSince).Round(time.Second))
+			h.state.Degraded = false
+			h.state.DegradedSince = nil
+			release = h.held
+			h.held, h.index = nil, make(map[string]int)
+		}
+	} else {
+		incCounter(mHookHealthChecks, "hook", hookURL, "result", "error")
+		h.state.Failures++
+		h.state.LastError = probe.Error
+		if !h.state.Degraded && h.state.Failures >= threshold {
+			h.state.Degraded = true
+			h.state.DegradedSince = &now
+			hookLogger.Error("Hook degraded, holding its traffic", "URL", hookURL,
+				"Failures", h.state.Failures, "Err", probe.Error)
+		}
+	}
+	hookHealthMonitor.Unlock()
+
+	if len(release) > 0 {
+		go func() {
+			for _, held := range release {
+				dispatchToHook(hookURL, held.searchID, held.source, held.req, false)
+			}
+		}()
+	}
+}
+
+// holdForDegradedHook holds a request when its hook is degraded and reports
+// whether it did.
+func holdForDegradedHook(hookURL, searchID string, source sourceRef, req HookRequest) bool {
+	if !config.HookHealth.Enabled {
+		return false
+	}
+	hookHealthMonitor.Lock()
+	defer hookHealthMonitor.Unlock()
+	h := hookHealthMonitor.hooks[hookURL]
+	if h == nil || !h.state.Degraded {
+		return false
+	}
+	held := heldHookRequest{searchID: searchID, source: source, req: req}
+	key := searchID + "\x00" + normalizeDN(req.DN)
+	if i, ok := h.index[key]; ok {
+		h.held[i] = held
+		return true
+	}
+	size := config.HookHealth.BufferSize
+	if size <= 0 {
+		size = 1000
+	}
+	if len(h.held) >= size {
+		incCounter(mHookHeldDropped, "hook", hookURL)
+		hookLogger.Warn("Degraded hook buffer full, dropping request", "URL", hookURL, "DN", req.DN, "Search", searchID)
+		return true
+	}
+	h.index[key] = len(h.held)
+	h.held = append(h.held, held)
+	return true
+}
+
+// hookHealthStates returns the monitored state of each hook.
+func hookHealthStates() map[string]HookHealthState {
+	hookHealthMonitor.Lock()
+	defer hookHealthMonitor.Unlock()
+	out := make(map[string]HookHealthState, len(hookHealthMonitor.hooks))
+	for hook, h := range hookHealthMonitor.hooks {
+		state := h.state
+		state.Held = len(h.held)
+		out[hook] = state
+	}
+	return out
+}
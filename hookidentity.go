@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// HookIdentityConfig configures the identity assertion sent to hooks: a
+// signed JWT describing this syncer instance and its tenant, so multi-tenant
+// hook services can apply per-tenant behavior and audit callers.
+type HookIdentityConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Algorithm string `yaml:"algorithm"` // HS256 (default), RS256 or ES256
+	// KeyFile holds the HMAC secret (HS256) or a PEM private key (RS256, ES256).
+	KeyFile  string `yaml:"key_file"`
+	KeyID    string `yaml:"key_id"`   // Optional kid header
+	Issuer   string `yaml:"issuer"`   // default: ldap-sync
+	Instance string `yaml:"instance"` // default: hostname
+	Tenant   string `yaml:"tenant"`
+	// Audience defaults to the hook URL.
+	Audience string `yaml:"audience"`
+	TTLSec   int    `yaml:"ttl_s"`  // Token lifetime (default: 300)
+	Header   string `yaml:"header"` // default: Authorization (as "Bearer <token>")
+}
+
+// hookSigner signs identity assertions with the configured key.
+type hookSigner struct {
+	alg  string
+	sign func(signingInput []byte) ([]byte, error)
+}
+
+var hookIdentity *hookSigner
+
+// initHookIdentity loads the signing key.
+func initHookIdentity() error {
+	cfg := config.HookIdentity
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.KeyFile == "" {
+		return fmt.Errorf("hook_identity: key_file is required")
+	}
+	keyData, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("hook_identity: %w", err)
+	}
+	alg := strings.ToUpper(cfg.Algorithm)
+	if alg == "" {
+		alg = "HS256"
+	}
+	s := &hookSigner{alg: alg}
+	switch alg {
+	case "HS256":
+		secret := []byte(strings.TrimSpace(string(keyData)))
+		s.sign = func(in []byte) ([]byte, error) {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(in)
+			return mac.Sum(nil), nil
+		}
+	case "RS256", "ES256":
+		key, err := parsePrivateKey(keyData)
+		if err != nil {
+			return fmt.Errorf("hook_identity: %w", err)
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if alg != "RS256" {
+				return fmt.Errorf("hook_identity: RSA key requires algorithm RS256")
+			}
+			s.sign = func(in []byte) ([]byte, error) {
+				digest := sha256.Sum256(in)
+				return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+			}
+		case *ecdsa.PrivateKey:
+			if alg != "ES256" {
+				return fmt.Errorf("hook_identity: EC key requires algorithm ES256")
+			}
+			if k.Curve != elliptic.P256() {
+				return fmt.Errorf("hook_identity: ES256 requires a P-256 key, not %s", k.Curve.Params().Name)
+			}
+			s.sign = func(in []byte) ([]byte, error) {
+				digest := sha256.Sum256(in)
+				r, sig, err := ecdsa.Sign(rand.Reader, k, digest[:])
+				if err != nil {
+					return nil, err
+				}
+				// JWS uses the fixed-width r||s encoding, not ASN.1.
+				out := make([]byte, 64)
+				r.FillBytes(out[:32])
+				sig.FillBytes(out[32:])
+				return out, nil
+			}
+		default:
+			return fmt.Errorf("hook_identity: unsupported key type %T", key)
+		}
+	default:
+		return fmt.Errorf("hook_identity: unsupported algorithm %q", cfg.Algorithm)
+	}
+	hookIdentity = s
+	return nil
+}
+
+func parsePrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in key file")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func jwtSegment(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hookIdentityToken builds a signed assertion for one request to hookURL on
+// behalf of the given search.
+func hookIdentityToken(hookURL, searchID string) (string, error) {
+	cfg := config.HookIdentity
+	now := time.Now()
+	ttl := time.Duration(cfg.TTLSec) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	issuer := cfg.Issuer
+	if issuer == "" {
+		issuer = "ldap-sync"
+	}
+	instance := cfg.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	audience := cfg.Audience
+	if audience == "" {
+		audience = hookURL
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	header := map[string]string{"alg": hookIdentity.alg, "typ": "JWT"}
+	if cfg.KeyID != "" {
+		header["kid"] = cfg.KeyID
+	}
+	claims := map[string]interface{}{
+		"iss":      issuer,
+		"sub":      instance,
+		"aud":      audience,
+		"iat":      now.Unix(),
+		"nbf":      now.Unix(),
+		"exp":      now.Add(ttl).Unix(),
+		"jti":      hex.EncodeToString(nonce),
+		"instance": instance,
+	}
+	if cfg.Tenant != "" {
+		claims["tenant"] = cfg.Tenant
+	}
+	if searchID != "" {
+		claims["search"] = searchID
+	}
+	h, err := jwtSegment(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := jwtSegment(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := h + "." + c
+	sig, err := hookIdentity.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// setHookIdentity adds the identity assertion to a hook request.
+func setHookIdentity(req *http.Request, hookURL, searchID string) error {
+	if hookIdentity == nil {
+		return nil
+	}
+	token, err := hookIdentityToken(hookURL, searchID)
+	if err != nil {
+		return err
+	}
+	header := config.HookIdentity.Header
+	if header == "" || strings.EqualFold(header, "Authorization") {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.Header.Set(header, token)
+	}
+	return nil
+}
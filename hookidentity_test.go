@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitHookIdentityCurves(t *testing.T) {
+	tests := []struct {
+		curve   elliptic.Curve
+		wantErr string
+	}{
+		{elliptic.P256(), ""},
+		{elliptic.P384(), "ES256 requires a P-256 key, not P-384"},
+		{elliptic.P521(), "ES256 requires a P-256 key, not P-521"},
+	}
+	defer func(c Config) { config = c }(config)
+	defer func(s *hookSigner) { hookIdentity = s }(hookIdentity)
+	for _, tt := range tests {
+		t.Run(tt.curve.Params().Name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, err := x509.MarshalECPrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyFile := filepath.Join(t.TempDir(), "key.pem")
+			if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config.HookIdentity = HookIdentityConfig{Enabled: true, Algorithm: "ES256", KeyFile: keyFile}
+			hookIdentity = nil
+			err = initHookIdentity()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := hookIdentity.sign([]byte("header.payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sig) != 64 {
+				t.Errorf("signature is %d bytes, want 64", len(sig))
+			}
+		})
+	}
+}
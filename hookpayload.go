@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/helxplatform/ldap-sync/hooksdk"
+)
+
+// HookRequest is the payload posted to hooks and passed to embedded
+// transforms for each changed entry.
+type HookRequest = hooksdk.Request
+
+const (
+	changeTypeAdd    = hooksdk.ChangeAdd
+	changeTypeModify = hooksdk.ChangeModify
+	changeTypeDelete = hooksdk.ChangeDelete
+)
+
+var hookSequence atomic.Uint64
+
+func init() {
+	hookSequence.Store(uint64(time.Now().UnixMicro()))
+}
+
+// newHookRequest builds the payload for a result of the given search.
+func newHookRequest(searchID string, result LDAPResult) HookRequest {
+	content := result.Content
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	return HookRequest{
+		Version:         hookProtocolVersion,
+		DN:              result.DN,
+		Content:         content,
+		SearchID:        searchID,
+		ChangeType:      result.changeType,
+		PreviousContent: result.previous,
+		Sequence:        hookSequence.Add(1),
+		Replay:          result.replay,
+	}
+}
+
+// notifyDeleted tells the hooks that an entry left the search, if
+// hook_deletes is enabled. Searches with a mapping or embedded transform are
+// not notified.
+func notifyDeleted(searchID string, removed LDAPResult) {
+	if !config.HookDeletes {
+		return
+	}
+	searchesMu.RLock()
+	spec, ok := searches[searchID]
+	searchesMu.RUnlock()
+	if !ok || spec.SuppressHooks || spec.Mapping != "" || spec.Transform != "" {
+		return
+	}
+	result := LDAPResult{
+		DN:          removed.DN,
+		changeType:  changeTypeDelete,
+		previous:    removed.Content,
+		correlation: removed.correlation,
+	}
+	logger.Info("Notifying hooks of removed entry", "DN", removed.DN, "SearchId", searchID)
+	sendHooks(searchID, sourceRef{DN: removed.DN, Correlation: removed.correlation}, result)
+}
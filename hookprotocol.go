@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/helxplatform/ldap-sync/hooksdk"
+)
+
+// The hook contract is versioned so it can evolve while old hooks keep
+// working. Version 1 is the original contract: each changed entry is posted
+// as {"dn", "content"}, with no deletion notifications and no batches.
+// Version 2 adds the fields of HookRequest, "version" among them, delete
+// payloads (hook_deletes) and batched delivery (hook_batch). Responses are
+// the same in both versions.
+//
+// Every request carries its version in the X-Hook-Protocol-Version header.
+// A hook announces the version it speaks with the same header on its
+// responses, or a "version" field in them, and is sent that version from
+// then on; hooks that never announce one get default_version. Requests are
+// translated down for older hooks, which skip delete payloads and batching.
+// Versions listed under hook_protocol.versions are pinned and not
+// negotiated.
+
+const (
+	hookProtocolVersion    = hooksdk.ProtocolVersion // Current version
+	minHookProtocolVersion = 1                       // Oldest version translated to
+	hookProtocolHeader     = hooksdk.ProtocolHeader
+)
+
+// HookProtocolConfig configures the hook protocol versions used.
+type HookProtocolConfig struct {
+	// DefaultVersion is sent to hooks that have not announced a version
+	// (default: the current version).
+	DefaultVersion int `yaml:"default_version"`
+	// Versions pins the version of hooks by URL.
+	Versions map[string]int `yaml:"versions"`
+}
+
+var mHookProtocolVersion = describeMetric("ldapsync_hook_protocol_version", "gauge",
+	"Hook protocol version used for each hook.")
+
+// negotiatedVersions holds the versions announced by hooks, by URL.
+var negotiatedVersions sync.Map
+
+func init() {
+	registerCollector(func() {
+		resetGauge(mHookProtocolVersion)
+		for _, hook := range config.Hooks {
+			setGauge(mHookProtocolVersion, float64(hookVersion(hook)), "hook", hook)
+		}
+	})
+}
+
+func validateHookProtocol() error {
+	check := func(what string, v int) error {
+		if v < minHookProtocolVersion || v > hookProtocolVersion {
+			return fmt.Errorf("hook_protocol: %s: unsupported version %d (want %d to %d)",
+				what, v, minHookProtocolVersion, hookProtocolVersion)
+		}
+		return nil
+	}
+	if v := config.HookProtocol.DefaultVersion; v != 0 {
+		if err := check("default_version", v); err != nil {
+			return err
+		}
+	}
+	for hook, v := range config.HookProtocol.Versions {
+		if err := check(hook, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookVersion returns the protocol version to send to a hook.
+func hookVersion(hookURL string) int {
+	if v, ok := config.HookProtocol.Versions[hookURL]; ok {
+		return v
+	}
+	if v, ok := negotiatedVersions.Load(hookURL); ok {
+		return v.(int)
+	}
+	if v := config.HookProtocol.DefaultVersion; v != 0 {
+		return v
+	}
+	return hookProtocolVersion
+}
+
+// hookAccepts reports whether a hook's version supports a payload of the
+// change type; older hooks are not sent deletions.
+func hookAccepts(hookURL, changeType string) bool {
+	return changeType != changeTypeDelete || hookVersion(hookURL) >= 2
+}
+
+// hookBatchable reports whether a hook's version supports batches.
+func hookBatchable(hookURL string) bool {
+	return hookVersion(hookURL) >= 2 && hookBatchingEnabled(hookURL)
+}
+
+// hookRequestV1 is a request in version 1 of the protocol.
+type hookRequestV1 struct {
+	DN      string                 `json:"dn"`
+	Content map[string]interface{} `json:"content"`
+}
+
+// encodeHookPayload encodes a request, or a batch of them, in the version
+// the hook speaks.
+func encodeHookPayload(hookURL string, reqs []HookRequest, batch bool) ([]byte, error) {
+	version := hookVersion(hookURL)
+	if version < 2 {
+		if batch || len(reqs) != 1 {
+			return nil, fmt.Errorf("hook protocol version %d does not support batches", version)
+		}
+		return json.Marshal(hookRequestV1{DN: reqs[0].DN, Content: reqs[0].Content})
+	}
+	out := make([]HookRequest, len(reqs))
+	for i, r := range reqs {
+		r.Version = version
+		out[i] = r
+	}
+	if batch {
+		return json.Marshal(out)
+	}
+	return json.Marshal(out[0])
+}
+
+// setHookProtocol sets the version header of a request to a hook.
+func setHookProtocol(req *http.Request, hookURL string) {
+	req.Header.Set(hookProtocolHeader, strconv.Itoa(hookVersion(hookURL)))
+}
+
+// noteHookVersion records the version a hook announced in its response, by
+// header or by the version field of its (first) response.
+func noteHookVersion(hookURL string, header http.Header, body []byte) {
+	if _, pinned := config.HookProtocol.Versions[hookURL]; pinned {
+		return
+	}
+	announced := 0
+	if h := strings.TrimSpace(header.Get(hookProtocolHeader)); h != "" {
+		announced, _ = strconv.Atoi(h)
+	} else {
+		var probe struct {
+			Version int `json:"version"`
+		}
+		trimmed := strings.TrimSpace(string(body))
+		if strings.HasPrefix(trimmed, "[") {
+			var list []json.RawMessage
+			if json.Unmarshal(body, &list) == nil && len(list) > 0 {
+				json.Unmarshal(list[0], &probe)
+			}
+		} else {
+			json.Unmarshal(body, &probe)
+		}
+		announced = probe.Version
+	}
+	if announced == 0 {
+		return
+	}
+	announced = max(minHookProtocolVersion, min(announced, hookProtocolVersion))
+	if prev, loaded := negotiatedVersions.Swap(hookURL, announced); !loaded || prev.(int) != announced {
+		hookLogger.Info("Hook protocol version negotiated", "URL", hookURL, "Version", announced)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook requests are retried on transport errors and on the response
+// statuses in hook_retry.retry_status_codes (default: 429 and 5xx); other
+// non-2xx responses fail at once. A Retry-After header on a retried response
+// is honored as the minimum delay before the next attempt; when it asks for
+// longer than max_delay_ms, or retrying would exceed max_elapsed_ms, the
+// request is given up instead of retried early.
+
+var mHookRetries = describeMetric("ldapsync_hook_retries_total", "counter",
+	"Hook requests retried, by hook and reason (transport or the HTTP status).")
+
+// hookStatusError is a non-2xx hook response.
+type hookStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *hookStatusError) Error() string {
+	return "hook answered " + e.Status
+}
+
+// hookRetryableStatus reports whether a hook response status is retried.
+func hookRetryableStatus(code int) bool {
+	if codes := config.HookRetry.RetryStatusCodes; len(codes) > 0 {
+		return slices.Contains(codes, code)
+	}
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// hookRetryBudget returns the longest time spent on one hook request,
+// retries included; 0 means unlimited.
+func hookRetryBudget() time.Duration {
+	return time.Duration(config.HookRetry.MaxElapsedMs) * time.Millisecond
+}
+
+// parseRetryAfter returns the delay a Retry-After header asks for, given in
+// seconds or as an HTTP date; 0 when absent or invalid.
+func parseRetryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// validateHookRetry checks the retryable status codes.
+func validateHookRetry() error {
+	for _, code := range config.HookRetry.RetryStatusCodes {
+		if code < 300 || code > 599 {
+			return fmt.Errorf("hook_retry: retry_status_codes: %d is not an error status", code)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// Hook responses are checked against the response schema before they are
+// applied, so a hook author learns which field is wrong instead of seeing a
+// bare decode error. Problems are logged with the path of the offending
+// field and counted per hook. By default a response that still decodes is
+// applied anyway; with hook_validation.strict any problem rejects the whole
+// response, which is kept in memory for inspection at /hooks/quarantine.
+//
+// Responses may declare the hook protocol version they follow (see
+// hookprotocol.go); versions newer than this ldap-sync speaks are rejected.
+
+// HookValidationConfig configures the checking of hook responses.
+type HookValidationConfig struct {
+	// Strict rejects and quarantines responses with any problem.
+	Strict bool `yaml:"strict"`
+	// QuarantineSize is the number of rejected responses kept (default: 100).
+	QuarantineSize int `yaml:"quarantine_size"`
+}
+
+// HookValidationError is one problem found in a hook response.
+type HookValidationError struct {
+	// Path locates the field, e.g. $[0].transformed[2].content.mail.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	// Fragment is the offending value, truncated.
+	Fragment string `json:"fragment,omitempty"`
+}
+
+func (e HookValidationError) String() string {
+	if e.Fragment == "" {
+		return e.Path + ": " + e.Message
+	}
+	return e.Path + ": " + e.Message + " (got " + e.Fragment + ")"
+}
+
+// QuarantinedResponse is a hook response rejected in strict mode.
+type QuarantinedResponse struct {
+	ID      int64                 `json:"id"`
+	Time    time.Time             `json:"time"`
+	Hook    string                `json:"hook"`
+	Search  string                `json:"search"`
+	Sources []string              `json:"sources,omitempty"` // Source DNs of the posted results
+	Errors  []HookValidationError `json:"errors"`
+	Payload string                `json:"payload"`
+}
+
+var mHookResponsesInvalid = describeMetric("ldapsync_hook_responses_invalid_total", "counter",
+	"Hook responses that failed schema validation, by hook.")
+
+// maxValidationErrors bounds the problems reported for one response.
+const maxValidationErrors = 20
+
+var (
+	derivedStringFields = []string{"id", "filter", "baseDN", "transform", "mapping", "change_detection", "correlation_attribute", "schedule", "scope", "deref"}
+	derivedBoolFields   = []string{"oneshot", "runOnce", "suppressHooks", "dry_run", "rename"}
+	derivedIntFields    = []string{"refresh", "ttl", "idle_expiry", "sizeLimit", "timeLimit"}
+	derivedListFields   = []string{"attributes", "exclude_attributes"}
+)
+
+type hookValidator struct {
+	errs []HookValidationError
+}
+
+func (v *hookValidator) fail(path, msg string, value interface{}) {
+	if len(v.errs) >= maxValidationErrors {
+		return
+	}
+	fragment := ""
+	if value != nil {
+		data, _ := json.Marshal(value)
+		fragment = string(data)
+		if len(fragment) > 200 {
+			fragment = fragment[:200] + "..."
+		}
+	}
+	v.errs = append(v.errs, HookValidationError{Path: path, Message: msg, Fragment: fragment})
+}
+
+// validateHookResponseBody checks a hook response body against the schema.
+func validateHookResponseBody(body []byte) []HookValidationError {
+	v := &hookValidator{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		v.fail("$", "invalid JSON: "+err.Error(), nil)
+		return v.errs
+	}
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		v.response("$", d)
+	case []interface{}:
+		for i, item := range d {
+			path := fmt.Sprintf("$[%d]", i)
+			if obj, ok := item.(map[string]interface{}); ok {
+				v.response(path, obj)
+			} else {
+				v.fail(path, "expected a response object", item)
+			}
+		}
+	default:
+		v.fail("$", "expected a response object or an array of them", doc)
+	}
+	return v.errs
+}
+
+func (v *hookValidator) response(path string, obj map[string]interface{}) {
+	for _, key := range sortedFields(obj) {
+		val := obj[key]
+		p := path + "." + key
+		switch key {
+		case "version":
+			n, ok := val.(json.Number)
+			ver, err := n.Int64()
+			if !ok || err != nil || ver < 1 {
+				v.fail(p, "expected a positive integer", val)
+			} else if ver > hookProtocolVersion {
+				v.fail(p, fmt.Sprintf("unsupported version; this ldap-sync supports up to %d", hookProtocolVersion), val)
+			}
+		case "transformed":
+			v.eachObject(p, val, v.transformedEntry)
+		case "derived":
+			v.eachObject(p, val, v.derivedSearch)
+		case "dependencies":
+			v.stringList(p, val)
+		case "bindings":
+			bindings, ok := val.(map[string]interface{})
+			if !ok {
+				if val != nil {
+					v.fail(p, "expected an object of binding values", val)
+				}
+				continue
+			}
+			for _, k := range sortedFields(bindings) {
+				v.bindingValue(p+"."+k, bindings[k])
+			}
+		case "bindingTtls":
+			ttls, ok := val.(map[string]interface{})
+			if !ok {
+				if val != nil {
+					v.fail(p, "expected an object of TTLs in seconds", val)
+				}
+				continue
+			}
+			for _, k := range sortedFields(ttls) {
+				n, ok := ttls[k].(json.Number)
+				if ttl, err := n.Int64(); !ok || err != nil || ttl < 0 {
+					v.fail(p+"."+k, "expected a non-negative integer", ttls[k])
+				}
+			}
+		case "reset", "atomic":
+			if _, ok := val.(bool); !ok && val != nil {
+				v.fail(p, "expected a boolean", val)
+			}
+		default:
+			v.fail(p, "unknown field", nil)
+		}
+	}
+}
+
+// bindingValue checks a binding value: a string, number, list of them or
+// null.
+func (v *hookValidator) bindingValue(path string, val interface{}) {
+	switch b := val.(type) {
+	case nil, string, json.Number:
+	case []interface{}:
+		for i, item := range b {
+			switch item.(type) {
+			case string, json.Number:
+			default:
+				v.fail(fmt.Sprintf("%s[%d]", path, i), "expected a string or number", item)
+			}
+		}
+	default:
+		v.fail(path, "expected a string, number, list of them or null", val)
+	}
+}
+
+// transformedEntry checks an entry's DN and content. Other fields are
+// ignored by the engine, and hooks (including the bundled ones) carry
+// extras, so they are not reported.
+func (v *hookValidator) transformedEntry(path string, obj map[string]interface{}) {
+	if dn, ok := obj["dn"].(string); !ok || dn == "" {
+		v.fail(path+".dn", "expected a non-empty DN string", obj["dn"])
+	}
+	val, ok := obj["content"]
+	if !ok || val == nil {
+		return
+	}
+	content, ok := val.(map[string]interface{})
+	if !ok {
+		v.fail(path+".content", "expected an object of attribute values", val)
+		return
+	}
+	for _, attr := range sortedFields(content) {
+		failed := len(v.errs)
+		v.attributeValue(path+".content."+attr, content[attr])
+		if isPasswordAttr(attr) {
+			for i := failed; i < len(v.errs); i++ {
+				v.errs[i].Fragment = passwordPlaceholder
+			}
+		}
+	}
+}
+
+func (v *hookValidator) attributeValue(path string, val interface{}) {
+	switch x := val.(type) {
+	case string, json.Number, bool:
+	case []interface{}:
+		for i, item := range x {
+			switch item.(type) {
+			case string, json.Number, bool:
+			default:
+				v.fail(fmt.Sprintf("%s[%d]", path, i), "expected a string, number or boolean", item)
+			}
+		}
+	default:
+		v.fail(path, "expected a value or a list of values", val)
+	}
+}
+
+func (v *hookValidator) derivedSearch(path string, obj map[string]interface{}) {
+	for _, key := range []string{"id", "filter"} {
+		if s, ok := obj[key].(string); !ok || s == "" {
+			v.fail(path+"."+key, "required", obj[key])
+		}
+	}
+	for _, key := range sortedFields(obj) {
+		val := obj[key]
+		p := path + "." + key
+		switch {
+		case slices.Contains(derivedStringFields, key):
+			s, ok := val.(string)
+			if !ok {
+				v.fail(p, "expected a string", val)
+			} else if key == "filter" {
+				if _, err := ldap.CompileFilter(s); err != nil {
+					v.fail(p, "invalid LDAP filter: "+err.Error(), val)
+				}
+			}
+		case slices.Contains(derivedBoolFields, key):
+			if _, ok := val.(bool); !ok {
+				v.fail(p, "expected a boolean", val)
+			}
+		case slices.Contains(derivedIntFields, key):
+			n, ok := val.(json.Number)
+			if i, err := n.Int64(); !ok || err != nil || i < 0 {
+				v.fail(p, "expected a non-negative integer", val)
+			}
+		case slices.Contains(derivedListFields, key):
+			v.stringList(p, val)
+		default:
+			v.fail(p, "unknown field", nil)
+		}
+	}
+}
+
+func (v *hookValidator) eachObject(path string, val interface{}, check func(string, map[string]interface{})) {
+	if val == nil {
+		return
+	}
+	list, ok := val.([]interface{})
+	if !ok {
+		v.fail(path, "expected an array", val)
+		return
+	}
+	for i, item := range list {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if obj, ok := item.(map[string]interface{}); ok {
+			check(p, obj)
+		} else {
+			v.fail(p, "expected an object", item)
+		}
+	}
+}
+
+func (v *hookValidator) stringList(path string, val interface{}) {
+	if val == nil {
+		return
+	}
+	list, ok := val.([]interface{})
+	if !ok {
+		v.fail(path, "expected an array of strings", val)
+		return
+	}
+	for i, item := range list {
+		if _, ok := item.(string); !ok {
+			v.fail(fmt.Sprintf("%s[%d]", path, i), "expected a string", item)
+		}
+	}
+}
+
+func sortedFields(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkHookResponse validates a hook's response body, logging and counting
+// problems. It reports whether the response may be applied: in strict mode
+// a response with problems is quarantined instead.
+func checkHookResponse(hookURL, searchID string, sources []sourceRef, body []byte) bool {
+	problems := validateHookResponseBody(body)
+	if len(problems) == 0 {
+		return true
+	}
+	incCounter(mHookResponsesInvalid, "hook", hookURL)
+	details := make([]string, len(problems))
+	for i, p := range problems {
+		details[i] = p.String()
+	}
+	hookLogger.Error("Hook response failed validation", "URL", hookURL, "SearchId", searchID,
+		"Strict", config.HookValidation.Strict, "Problems", details)
+	if !config.HookValidation.Strict {
+		return true
+	}
+	quarantine.add(hookURL, searchID, sources, body, problems)
+	return false
+}
+
+var quarantine = &responseQuarantine{}
+
+type responseQuarantine struct {
+	mu        sync.Mutex
+	seq       int64
+	responses []QuarantinedResponse
+}
+
+func (q *responseQuarantine) add(hookURL, searchID string, sources []sourceRef, body []byte, problems []HookValidationError) {
+	r := QuarantinedResponse{Time: time.Now(), Hook: hookURL, Search: searchID, Errors: problems, Payload: string(body)}
+	for _, s := range sources {
+		if s.DN != "" {
+			r.Sources = append(r.Sources, s.DN)
+		}
+	}
+	size := config.HookValidation.QuarantineSize
+	if size <= 0 {
+		size = 100
+	}
+	q.mu.Lock()
+	q.seq++
+	r.ID = q.seq
+	q.responses = append(q.responses, r)
+	if over := len(q.responses) - size; over > 0 {
+		q.responses = append([]QuarantinedResponse{}, q.responses[over:]...)
+	}
+	q.mu.Unlock()
+}
+
+// getQuarantineHandler godoc
+// @Summary List quarantined hook responses
+// @Description Lists hook responses rejected by strict schema validation, oldest first, with the problems found and the raw payload.
+// @Tags hooks
+// @Produce json
+// @Param hook query string false "Only responses of this hook URL"
+// @Success 200 {array} QuarantinedResponse
+// @Router /hooks/quarantine [get]
+func getQuarantineHandler(c echo.Context) error {
+	hook := c.QueryParam("hook")
+	quarantine.mu.Lock()
+	out := []QuarantinedResponse{}
+	for _, r := range quarantine.responses {
+		if hook == "" || r.Hook == hook {
+			out = append(out, r)
+		}
+	}
+	quarantine.mu.Unlock()
+	return c.JSON(http.StatusOK, out)
+}
+
+// getQuarantinedResponseHandler godoc
+// @Summary Get a quarantined hook response
+// @Tags hooks
+// @Produce json
+// @Param id path int true "Quarantine id"
+// @Success 200 {object} QuarantinedResponse
+// @Failure 404 {string} string "Not found"
+// @Router /hooks/quarantine/{id} [get]
+func getQuarantinedResponseHandler(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+	for _, r := range quarantine.responses {
+		if r.ID == id {
+			return c.JSON(http.StatusOK, r)
+		}
+	}
+	return c.String(http.StatusNotFound, "Quarantined response not found")
+}
+
+// deleteQuarantinedResponseHandler godoc
+// @Summary Discard a quarantined hook response
+// @Tags hooks
+// @Param id path int true "Quarantine id"
+// @Success 204
+// @Failure 404 {string} string "Not found"
+// @Router /hooks/quarantine/{id} [delete]
+func deleteQuarantinedResponseHandler(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+	for i, r := range quarantine.responses {
+		if r.ID == id {
+			quarantine.responses = append(quarantine.responses[:i], quarantine.responses[i+1:]...)
+			return c.NoContent(http.StatusNoContent)
+		}
+	}
+	return c.String(http.StatusNotFound, "Quarantined response not found")
+}
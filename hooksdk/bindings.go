@@ -0,0 +1,153 @@
+package hooksdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Bindings are named values hooks publish for each other: an entry or
+// dependency can reference $key before any hook has bound it, and ldap-sync
+// holds the entry until the binding arrives. A binding set to null resolves
+// references to nothing. Values are strings or lists of strings.
+
+var bindingKey = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// ValidBindingKey reports whether key can be referenced as $key.
+func ValidBindingKey(key string) bool {
+	return bindingKey.MatchString(key)
+}
+
+// Ref returns the template referencing a binding, for use in DNs, values
+// and dependencies.
+func Ref(key string) string {
+	return "$" + key
+}
+
+// Bind publishes a binding.
+func (r *Response) Bind(key, value string) {
+	if r.Bindings == nil {
+		r.Bindings = make(map[string]*BindingValue)
+	}
+	v := StringValue(value)
+	r.Bindings[key] = &v
+}
+
+// BindList publishes a list binding; a value referencing it expands into
+// one value per element.
+func (r *Response) BindList(key string, values ...string) {
+	if r.Bindings == nil {
+		r.Bindings = make(map[string]*BindingValue)
+	}
+	v := ListValue(values...)
+	r.Bindings[key] = &v
+}
+
+// BindNull publishes a null binding: references to it resolve to nothing
+// instead of waiting.
+func (r *Response) BindNull(key string) {
+	if r.Bindings == nil {
+		r.Bindings = make(map[string]*BindingValue)
+	}
+	r.Bindings[key] = nil
+}
+
+// ExpireBinding sets the lifetime of a published binding, overriding the
+// TTL configured for its namespace; ldap-sync drops it once it passes.
+func (r *Response) ExpireBinding(key string, ttl time.Duration) {
+	if r.BindingTTLs == nil {
+		r.BindingTTLs = make(map[string]int)
+	}
+	r.BindingTTLs[key] = int(ttl / time.Second)
+}
+
+// BindingValue is the value of a binding: a string, or a list of strings
+// when set to a JSON array. Numbers are accepted and kept as their JSON
+// text. A reference to a list binding in a value expands it into one value
+// per element.
+type BindingValue struct {
+	values []string
+	list   bool
+}
+
+// StringValue returns a string binding value.
+func StringValue(s string) BindingValue {
+	return BindingValue{values: []string{s}}
+}
+
+// ListValue returns a list binding value.
+func ListValue(values ...string) BindingValue {
+	return BindingValue{values: append([]string{}, values...), list: true}
+}
+
+// IsList reports whether the value is a list.
+func (v BindingValue) IsList() bool {
+	return v.list
+}
+
+// Values returns the elements of a list, or the string as one element.
+func (v BindingValue) Values() []string {
+	return v.values
+}
+
+// String returns the string value, or the JSON encoding of a list.
+func (v BindingValue) String() string {
+	if !v.list {
+		if len(v.values) == 0 {
+			return ""
+		}
+		return v.values[0]
+	}
+	data, _ := json.Marshal(v.values)
+	return string(data)
+}
+
+// MarshalJSON encodes the value as a JSON string or array.
+func (v BindingValue) MarshalJSON() ([]byte, error) {
+	if v.list {
+		if v.values == nil {
+			return []byte("[]"), nil
+		}
+		return json.Marshal(v.values)
+	}
+	return json.Marshal(v.String())
+}
+
+// UnmarshalJSON decodes a JSON string, number or array of them.
+func (v *BindingValue) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	scalar := func(x interface{}) (string, bool) {
+		switch x := x.(type) {
+		case string:
+			return x, true
+		case json.Number:
+			return x.String(), true
+		}
+		return "", false
+	}
+	if items, ok := raw.([]interface{}); ok {
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := scalar(item)
+			if !ok {
+				return fmt.Errorf("binding list elements must be strings or numbers")
+			}
+			values = append(values, s)
+		}
+		*v = BindingValue{values: values, list: true}
+		return nil
+	}
+	s, ok := scalar(raw)
+	if !ok {
+		return fmt.Errorf("binding values must be strings, numbers or lists of them")
+	}
+	*v = StringValue(s)
+	return nil
+}
@@ -0,0 +1,136 @@
+package hooksdk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DNs built by concatenating values break on users such as "Smith, John":
+// build them from RDNs instead, which escape their values (RFC 4514).
+//
+//	rdn, err := hooksdk.FormatRDN(hooksdk.RDNAttribute{Type: "cn", Value: req.Value("cn")})
+//	dn, err := hooksdk.BuildDN(rdn, "ou=people,dc=example,dc=org")
+
+// RDNAttribute is one type=value pair of a (possibly multi-valued) RDN.
+type RDNAttribute struct {
+	Type  string
+	Value string
+}
+
+var attributeTypePattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*|[0-9]+(\.[0-9]+)*)$`)
+
+// EscapeRDNValue escapes a value for use in an RDN (RFC 4514 section 2.4):
+// the special characters, a leading '#', leading and trailing spaces, and
+// control characters, so values such as "Smith, John" or " x+y " produce a
+// single, valid RDN.
+func EscapeRDNValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == ' ' && (i == 0 || i == len(v)-1):
+			b.WriteString(`\ `)
+		case c == '#' && i == 0:
+			b.WriteString(`\#`)
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// FormatRDN builds an RDN from one or more attributes, joined with '+' in
+// the given order.
+func FormatRDN(attrs ...RDNAttribute) (string, error) {
+	if len(attrs) == 0 {
+		return "", fmt.Errorf("RDN requires at least one attribute")
+	}
+	parts := make([]string, len(attrs))
+	for i, a := range attrs {
+		if !attributeTypePattern.MatchString(a.Type) {
+			return "", fmt.Errorf("invalid RDN attribute type %q", a.Type)
+		}
+		parts[i] = a.Type + "=" + EscapeRDNValue(a.Value)
+	}
+	return strings.Join(parts, "+"), nil
+}
+
+// BuildDN joins already formatted RDNs and parent DNs, leaf first, skipping
+// empty parts. Each part must be a valid DN on its own, so an escape at the
+// end of one cannot swallow the comma that joins it to the next.
+func BuildDN(parts ...string) (string, error) {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+		if err := ValidateDN(p); err != nil {
+			return "", fmt.Errorf("invalid DN part %q: %w", p, err)
+		}
+		nonEmpty = append(nonEmpty, p)
+	}
+	return strings.Join(nonEmpty, ","), nil
+}
+
+// ValidateDN checks the syntax of a string DN (RFC 4514): comma-separated
+// RDNs of '+'-separated type=value pairs with valid escapes. The empty DN
+// is valid.
+func ValidateDN(dn string) error {
+	if dn == "" {
+		return nil
+	}
+	rdns, err := splitUnescaped(dn, ',')
+	if err != nil {
+		return err
+	}
+	for _, rdn := range rdns {
+		avas, err := splitUnescaped(rdn, '+')
+		if err != nil {
+			return err
+		}
+		for _, ava := range avas {
+			typ, _, ok := strings.Cut(ava, "=")
+			if !ok {
+				return fmt.Errorf("%q is not type=value", ava)
+			}
+			if !attributeTypePattern.MatchString(strings.TrimSpace(typ)) {
+				return fmt.Errorf("invalid attribute type %q", typ)
+			}
+		}
+	}
+	return nil
+}
+
+// splitUnescaped splits s at each sep not escaped with a backslash, and
+// rejects backslashes that escape nothing.
+func splitUnescaped(s string, sep byte) ([]string, error) {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			switch {
+			case i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+				i += 2
+			case i+1 < len(s) && strings.IndexByte(`"+,;<>\= #`, s[i+1]) >= 0:
+				i++
+			default:
+				return nil, fmt.Errorf("invalid escape at offset %d of %q", i, s)
+			}
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:]), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
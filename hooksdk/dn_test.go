@@ -0,0 +1,54 @@
+package hooksdk
+
+import "testing"
+
+func TestEscapeRDNValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"jdoe", "jdoe"},
+		{"Smith, John", `Smith\, John`},
+		{`a+b;c<d>e"f\g=h`, `a\+b\;c\<d\>e\"f\\g\=h`},
+		{"#1", `\#1`},
+		{"a#1", "a#1"},
+		{" x ", `\ x\ `},
+		{" ", `\ `},
+		{"a b", "a b"},
+		{"a\x00b\x7f", `a\00b\7f`},
+		{"Zoë", "Zoë"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := EscapeRDNValue(tt.in); got != tt.want {
+			t.Errorf("EscapeRDNValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDN(t *testing.T) {
+	tests := []struct {
+		dn      string
+		wantErr bool
+	}{
+		{"", false},
+		{"dc=org", false},
+		{"cn=x+uid=y,ou=people,dc=org", false},
+		{`cn=Smith\, John,dc=org`, false},
+		{`cn=x\ `, false},
+		{`cn=a\2cb`, false},
+		{"1.3.6.1.4.1.1466.0=x", false},
+		{" cn = x , dc = org", false},
+		{`cn=x\`, true},
+		{`cn=x\q`, true},
+		{`cn=x\2`, true},
+		{"cnx", true},
+		{"cn=x,,dc=org", true},
+		{"=x", true},
+		{"c n=x", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateDN(tt.dn); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateDN(%q) error = %v, wantErr %v", tt.dn, err, tt.wantErr)
+		}
+	}
+}
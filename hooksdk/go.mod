@@ -0,0 +1,3 @@
+module github.com/helxplatform/ldap-sync/hooksdk
+
+go 1.23.2
@@ -0,0 +1,72 @@
+package hooksdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// HandlerFunc transforms one request. A nil response means no changes.
+type HandlerFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// maxRequestBytes bounds the request body the Handler reads.
+const maxRequestBytes = 32 << 20
+
+// Handler serves a hook endpoint: it decodes a request, or a batch of them
+// (hook_batch), calls fn for each and answers with one response per
+// request, in order, announcing ProtocolVersion. An error from fn fails the
+// whole call with 500.
+func Handler(fn HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batch := len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '['
+		var reqs []*Request
+		if batch {
+			err = json.Unmarshal(body, &reqs)
+		} else {
+			req := &Request{}
+			err = json.Unmarshal(body, req)
+			reqs = []*Request{req}
+		}
+		if err != nil {
+			http.Error(w, "invalid hook request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resps := make([]*Response, len(reqs))
+		for i, req := range reqs {
+			resp, err := fn(r.Context(), req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if resp == nil {
+				resp = &Response{}
+			}
+			if resp.Transformed == nil {
+				resp.Transformed = []Entry{}
+			}
+			resp.Version = ProtocolVersion
+			resps[i] = resp
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(ProtocolHeader, strconv.Itoa(ProtocolVersion))
+		if batch {
+			json.NewEncoder(w).Encode(resps)
+		} else {
+			json.NewEncoder(w).Encode(resps[0])
+		}
+	})
+}
@@ -0,0 +1,180 @@
+// Package hooksdk holds the wire types of the ldap-sync hook protocol and a
+// net/http handler scaffold for writing hooks. ldap-sync uses the same
+// types, so hooks built on this package stay in step with the daemon.
+//
+// A minimal hook:
+//
+//	http.Handle("/hook", hooksdk.Handler(func(ctx context.Context, req *hooksdk.Request) (*hooksdk.Response, error) {
+//		resp := &hooksdk.Response{}
+//		resp.Add(hooksdk.Entry{
+//			DN:      "uid=" + req.Value("uid") + ",ou=people,dc=example,dc=org",
+//			Content: map[string]interface{}{"uid": req.Value("uid")},
+//		})
+//		return resp, nil
+//	}))
+//
+// With Echo, register it as echo.WrapHandler(hooksdk.Handler(fn)).
+package hooksdk
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ProtocolVersion is the hook protocol version this package speaks.
+	ProtocolVersion = 2
+	// ProtocolHeader carries the protocol version on requests and
+	// responses.
+	ProtocolHeader = "X-Hook-Protocol-Version"
+)
+
+// Change types of a Request.
+const (
+	ChangeAdd    = "add"
+	ChangeModify = "modify"
+	ChangeDelete = "delete"
+)
+
+// Request is the payload posted to hooks for each changed entry.
+type Request struct {
+	// Version is the hook protocol version; 0 for version 1 payloads.
+	Version int                    `json:"version,omitempty"`
+	DN      string                 `json:"dn"`
+	Content map[string]interface{} `json:"content"`
+	// SearchID is the search that produced the entry.
+	SearchID string `json:"searchId"`
+	// ChangeType is add for an entry new to the search, modify for a
+	// changed entry and delete for one that left it (hook_deletes).
+	ChangeType string `json:"changeType"`
+	// PreviousContent is the content last seen for the entry, when known;
+	// it is omitted for new entries and entries restored without content.
+	PreviousContent map[string]interface{} `json:"previousContent,omitempty"`
+	// Sequence increases with every payload built. It is seeded from the
+	// clock at startup, so it keeps increasing across restarts.
+	Sequence uint64 `json:"sequence"`
+	// Replay is set on results re-sent by POST /search/:id/replay.
+	Replay bool `json:"replay,omitempty"`
+	// Reconcile is set on requests made by POST /reconcile/:id; only the
+	// transformed entries of the response are used and nothing is written.
+	Reconcile bool `json:"reconcile,omitempty"`
+}
+
+// Entry is a target entry returned by a hook. Values are strings, numbers,
+// booleans or lists of them; they may contain $key binding references.
+type Entry struct {
+	DN      string                 `json:"dn"`
+	Content map[string]interface{} `json:"content"`
+}
+
+// DerivedSearch describes a search a hook asks ldap-sync to create.
+type DerivedSearch struct {
+	ID                string   `json:"id"`
+	Filter            string   `json:"filter"`
+	Refresh           int      `json:"refresh"`
+	BaseDN            string   `json:"baseDN"`
+	RunOnce           bool     `json:"runOnce"`       // Stop after the first complete run
+	SuppressHooks     bool     `json:"suppressHooks"` // Only cache results; write nothing
+	Transform         string   `json:"transform"`
+	Mapping           string   `json:"mapping"`
+	Attributes        []string `json:"attributes"`
+	ExcludeAttributes []string `json:"exclude_attributes"`
+	DryRun            bool     `json:"dry_run"`
+	ChangeDetection   string   `json:"change_detection"`
+	Rename            bool     `json:"rename"`
+	// CorrelationAttribute identifies entries instead of their DN.
+	CorrelationAttribute string `json:"correlation_attribute"`
+	// Scope is base, one or sub (default) and Deref never (default), search,
+	// find or always; SizeLimit (entries) and TimeLimit (seconds) bound
+	// each run. 0 is no limit.
+	Scope     string `json:"scope"`
+	Deref     string `json:"deref"`
+	SizeLimit int    `json:"sizeLimit"`
+	TimeLimit int    `json:"timeLimit"`
+	// Schedule is a cron expression for when the search runs.
+	Schedule string `json:"schedule"`
+	// Oneshot sets both RunOnce and SuppressHooks.
+	//
+	// Deprecated: set RunOnce and SuppressHooks.
+	Oneshot bool `json:"oneshot"`
+	// TTL removes the search this many seconds after the hook last derived
+	// it; IdleExpiry removes it after this many seconds without its runs
+	// finding changes. 0 keeps it.
+	TTL        int `json:"ttl"`
+	IdleExpiry int `json:"idle_expiry"`
+}
+
+// Response is a hook's answer to a Request.
+type Response struct {
+	// Version is the protocol version the hook speaks; the Handler sets it.
+	Version      int                      `json:"version,omitempty"`
+	Transformed  []Entry                  `json:"transformed"`
+	Derived      []DerivedSearch          `json:"derived,omitempty"`
+	Reset        bool                     `json:"reset,omitempty"`
+	Dependencies []string                 `json:"dependencies,omitempty"`
+	Bindings     map[string]*BindingValue `json:"bindings,omitempty"`
+	// BindingTTLs sets the lifetime of bindings in seconds, by key.
+	BindingTTLs map[string]int `json:"bindingTtls,omitempty"`
+	// Atomic asks for the transformed entries to be written in full or
+	// rolled back.
+	Atomic bool `json:"atomic,omitempty"`
+}
+
+// Values returns the values of an attribute, matched case-insensitively as
+// LDAP attribute names are.
+func (r *Request) Values(attr string) []string {
+	return values(r.Content, attr)
+}
+
+// Value returns the first value of an attribute, "" if it has none.
+func (r *Request) Value(attr string) string {
+	if v := r.Values(attr); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// PreviousValues returns the values an attribute had before the change.
+func (r *Request) PreviousValues(attr string) []string {
+	return values(r.PreviousContent, attr)
+}
+
+func values(content map[string]interface{}, attr string) []string {
+	for name, v := range content {
+		if !strings.EqualFold(name, attr) {
+			continue
+		}
+		switch v := v.(type) {
+		case nil:
+			return nil
+		case string:
+			return []string{v}
+		case []string:
+			return v
+		case []interface{}:
+			out := make([]string, 0, len(v))
+			for _, x := range v {
+				out = append(out, fmt.Sprint(x))
+			}
+			return out
+		default:
+			return []string{fmt.Sprint(v)}
+		}
+	}
+	return nil
+}
+
+// Add appends a transformed entry.
+func (r *Response) Add(entries ...Entry) {
+	r.Transformed = append(r.Transformed, entries...)
+}
+
+// DependOn adds DNs that must be synced before the entries are written.
+func (r *Response) DependOn(dns ...string) {
+	r.Dependencies = append(r.Dependencies, dns...)
+}
+
+// Derive asks ldap-sync to create or update a search.
+func (r *Response) Derive(search DerivedSearch) {
+	r.Derived = append(r.Derived, search)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HookSimulation is the outcome of posting a result to one hook in
+// POST /hooks/simulate.
+type HookSimulation struct {
+	Hook   string `json:"hook"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Skipped is set when the result does not match the hook's condition
+	// (hook_conditions); the hook is then not called.
+	Skipped bool `json:"skipped,omitempty"`
+	// Problems are the schema validation problems of the response body.
+	Problems []HookValidationError `json:"problems,omitempty"`
+	// Responses are the decoded hook responses.
+	Responses []HookResponse `json:"responses,omitempty"`
+	// Entries is what the sync engine would do with each transformed entry.
+	Entries []SimulatedEntry `json:"entries,omitempty"`
+}
+
+// SimulatedEntry is what the sync engine would do with a transformed entry.
+type SimulatedEntry struct {
+	// Resolved is the entry after binding substitution, including the
+	// bindings returned in the same response.
+	Resolved *TransformedEntry `json:"resolved"`
+	// Action is write when the entry would be written now and wait when it
+	// would be parked on missing bindings or dependencies.
+	Action          string   `json:"action"`
+	MissingBindings []string `json:"missingBindings,omitempty"`
+	// Dependencies are the resolved dependency DNs; MissingDependencies
+	// those not yet synced (dependencies on entries of the same response
+	// are satisfied by write ordering).
+	Dependencies        []string `json:"dependencies,omitempty"`
+	MissingDependencies []string `json:"missingDependencies,omitempty"`
+}
+
+// simulateHooksHandler godoc
+// @Summary Simulate hooks
+// @Description Posts a result to the configured hooks (or one of them) as a new entry of the search and returns the decoded responses with what the sync engine would do for each transformed entry: the resolved entry, missing bindings and missing dependencies. Nothing is written and no bindings, derived searches or resets are applied. Hooks are called once, without retries; hooks whose condition (hook_conditions) the result does not match are reported as skipped.
+// @Tags hooks
+// @Accept json
+// @Produce json
+// @Param result body LDAPResult true "Source entry (dn and content)"
+// @Param hook query string false "Only this configured hook URL"
+// @Param search query string false "Search id sent to the hook (default: simulate)"
+// @Success 200 {array} HookSimulation
+// @Failure 400 {string} string "Invalid body or unknown hook"
+// @Router /hooks/simulate [post]
+func simulateHooksHandler(c echo.Context) error {
+	var result LDAPResult
+	if err := c.Bind(&result); err != nil || result.DN == "" {
+		return c.String(http.StatusBadRequest, "Body must be an LDAP result with a dn")
+	}
+	hooks := config.Hooks
+	if hook := c.QueryParam("hook"); hook != "" {
+		if !slices.Contains(config.Hooks, hook) {
+			return c.String(http.StatusBadRequest, "Unknown hook; it must be one of the configured hooks")
+		}
+		hooks = []string{hook}
+	}
+	if len(hooks) == 0 {
+		return c.String(http.StatusBadRequest, "No hooks are configured")
+	}
+	searchID := c.QueryParam("search")
+	if searchID == "" {
+		searchID = "simulate"
+	}
+	result.changeType = changeTypeAdd
+	req := newHookRequest(searchID, result)
+	hidePasswords(&req)
+
+	out := make([]HookSimulation, 0, len(hooks))
+	for _, hook := range hooks {
+		out = append(out, simulateHook(hook, searchID, req))
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// simulateHook posts one request to a hook, as a batch of one for hooks that
+// are batched, and evaluates its responses.
+func simulateHook(hookURL, searchID string, hookReq HookRequest) HookSimulation {
+	sim := HookSimulation{Hook: hookURL}
+	if !hookMatches(hookURL, LDAPResult{DN: hookReq.DN, Content: hookReq.Content, changeType: hookReq.ChangeType}) {
+		sim.Skipped = true
+		return sim
+	}
+	payload, err := encodeHookPayload(hookURL, []HookRequest{hookReq}, hookBatchable(hookURL))
+	if err != nil {
+		sim.Error = err.Error()
+		return sim
+	}
+	req, err := http.NewRequest(http.MethodPost, hookURL, bytes.NewReader(payload))
+	if err != nil {
+		sim.Error = err.Error()
+		return sim
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setHookProtocol(req, hookURL)
+	if err := setHookIdentity(req, hookURL, searchID); err != nil {
+		sim.Error = fmt.Sprintf("signing hook identity: %v", err)
+		return sim
+	}
+	client := hookHTTPClientWithTimeout(hookURL, 30*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		sim.Error = err.Error()
+		return sim
+	}
+	defer resp.Body.Close()
+	sim.Status = resp.StatusCode
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		sim.Error = err.Error()
+		return sim
+	}
+	noteHookVersion(hookURL, resp.Header, data)
+	sim.Problems = validateHookResponseBody(data)
+	if sim.Responses, err = decodeHookResponses(data); err != nil {
+		sim.Error = err.Error()
+		return sim
+	}
+	for _, hookResp := range sim.Responses {
+		sim.Entries = append(sim.Entries, simulateResponse(hookResp, searchID, hookURL)...)
+	}
+	return sim
+}
+
+// simulateResponse evaluates the transformed entries of a response as
+// handleEntry would, against the current bindings and synced DNs.
+func simulateResponse(hookResp HookResponse, searchID, producer string) []SimulatedEntry {
+	bindingsSnapshot, nullSnapshot := getBindingsSnapshot()
+	for k, v := range hookResp.Bindings {
+		if v == nil {
+			delete(bindingsSnapshot, k)
+			nullSnapshot[k] = struct{}{}
+		} else {
+			bindingsSnapshot[k] = *v
+			delete(nullSnapshot, k)
+		}
+	}
+	grouped := make(map[string]bool)
+	if len(hookResp.Transformed) > 1 {
+		for _, t := range hookResp.Transformed {
+			grouped[normalizeDN(t.DN)] = true
+		}
+	}
+
+	var out []SimulatedEntry
+	for _, entry := range hookEntries(hookResp.Transformed) {
+		entry.Search, entry.Producer = searchID, producer
+		resolved, entryMissing := resolveEntryTemplates(&entry, bindingsSnapshot, nullSnapshot)
+		deps, depsMissing := resolveDependencies(hookResp.Dependencies, bindingsSnapshot, nullSnapshot)
+		sim := SimulatedEntry{Resolved: resolved, Action: "write"}
+		if entryMissing || depsMissing {
+			sim.MissingBindings = collectMissingBindings(&entry, hookResp.Dependencies, bindingsSnapshot, nullSnapshot)
+			sort.Strings(sim.MissingBindings)
+		}
+		self := normalizeDN(entry.DN)
+		dependencyTracker.mu.Lock()
+		for _, dep := range deps {
+			key := normalizeDN(dep)
+			if key == "" || key == self {
+				continue
+			}
+			sim.Dependencies = append(sim.Dependencies, dep)
+			if _, synced := dependencyTracker.synced[key]; !synced && !grouped[key] {
+				sim.MissingDependencies = append(sim.MissingDependencies, dep)
+			}
+		}
+		dependencyTracker.mu.Unlock()
+		if len(sim.MissingBindings) > 0 || len(sim.MissingDependencies) > 0 {
+			sim.Action = "wait"
+		}
+		out = append(out, sim)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// identity_tracking makes searches follow their source entries by an
+// immutable identifier (entryUUID, objectGUID, nsUniqueId) instead of their
+// DN: it is the correlation attribute of every search that does not name
+// its own, and it turns on rename tracking. A renamed or moved source entry
+// is then an update of the same entry, and the target entry written for it
+// is renamed rather than orphaned next to a duplicate. The identifier to
+// target DN mapping is kept in dn_mappings and listed by GET /identities.
+
+// IdentityTrackingConfig configures tracking source entries by identifier.
+type IdentityTrackingConfig struct {
+	Attribute string   `yaml:"attribute"` // e.g. entryUUID, objectGUID or nsUniqueId
+	Searches  []string `yaml:"searches"`  // Default: all searches
+}
+
+// IdentityMapping is the target DN written for a source entry.
+type IdentityMapping struct {
+	Search string `json:"search"`
+	// Source is the source key: attr=value for tracked searches, otherwise
+	// the normalized source DN.
+	Source    string    `json:"source"`
+	SourceDN  string    `json:"sourceDn,omitempty"`
+	TargetDN  string    `json:"targetDn"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func validateIdentityTracking() error {
+	cfg := config.IdentityTracking
+	if strings.ContainsAny(cfg.Attribute, ", ;") {
+		return fmt.Errorf("identity_tracking: invalid attribute name %q", cfg.Attribute)
+	}
+	if cfg.Attribute == "" && len(cfg.Searches) > 0 {
+		return fmt.Errorf("identity_tracking: attribute is required")
+	}
+	return nil
+}
+
+// identityAttribute returns the identifier attribute a search tracks by
+// default, or "".
+func identityAttribute(searchID string) string {
+	cfg := config.IdentityTracking
+	if cfg.Attribute == "" || (len(cfg.Searches) > 0 && !slices.Contains(cfg.Searches, searchID)) {
+		return ""
+	}
+	return cfg.Attribute
+}
+
+// correlationAttribute returns the attribute identifying the source entries
+// of a search: its own correlation attribute, else the identity attribute.
+func correlationAttribute(searchID string, spec *SearchSpec) string {
+	if spec.CorrelationAttribute != "" {
+		return spec.CorrelationAttribute
+	}
+	return identityAttribute(searchID)
+}
+
+// getIdentitiesHandler godoc
+// @Summary List source identity mappings
+// @Description Lists the target DN last written for each tracked source entry, by search and source key (attr=value, or the normalized source DN), with the source DN last seen.
+// @Tags searches
+// @Produce json
+// @Param search query string false "Only mappings of this search"
+// @Param source query string false "Only mappings whose source key or source DN contains this text (case-insensitive)"
+// @Param limit query int false "Return at most this many mappings"
+// @Success 200 {array} IdentityMapping
+// @Failure 400 {string} string "Invalid limit parameter"
+// @Failure 500 {string} string "Failed to read mappings"
+// @Router /identities [get]
+func getIdentitiesHandler(c echo.Context) error {
+	search := c.QueryParam("search")
+	source := strings.ToLower(c.QueryParam("source"))
+	limit := 0
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.String(http.StatusBadRequest, "Invalid limit parameter")
+		}
+		limit = n
+	}
+	all, err := dnMappings.list(search)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to read mappings: "+err.Error())
+	}
+	out := make([]IdentityMapping, 0, len(all))
+	for _, m := range all {
+		if source != "" && !strings.Contains(strings.ToLower(m.Source), source) &&
+			!strings.Contains(strings.ToLower(m.SourceDN), source) {
+			continue
+		}
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Search != out[j].Search {
+			return out[i].Search < out[j].Search
+		}
+		return out[i].Source < out[j].Source
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// JanitorConfig enables periodic cleanup of the database: rows of searches
+// that no longer run, state rows left behind by them, and journal/audit rows
+// older than their retention.
+type JanitorConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	IntervalMin int  `yaml:"interval_m"` // Time between runs (default: 60)
+	// GracePeriodH keeps rows of searches missing from memory for this long
+	// after their last update before removing them (default: 24).
+	GracePeriodH int `yaml:"grace_period_h"`
+	// ChangeLogRetentionD, PolicyViolationsRetentionD, APIAuditRetentionD
+	// and SearchRunsRetentionD prune change_log, policy_violations,
+	// api_audit and search_runs rows older than this many days (0 keeps
+	// them).
+	ChangeLogRetentionD        int `yaml:"change_log_retention_d"`
+	PolicyViolationsRetentionD int `yaml:"policy_violations_retention_d"`
+	APIAuditRetentionD         int `yaml:"api_audit_retention_d"`
+	SearchRunsRetentionD       int `yaml:"search_runs_retention_d"`
+	// Vacuum runs VACUUM ANALYZE on tables rows were deleted from.
+	Vacuum bool `yaml:"vacuum"`
+}
+
+var mJanitorDeleted = describeMetric("ldapsync_janitor_deleted_rows_total", "counter",
+	"Database rows removed by the janitor, by table.")
+
+func runJanitorLoop() {
+	j := config.Janitor
+	interval := time.Duration(j.IntervalMin) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := runJanitor(); err != nil {
+			logger.Error("Database janitor failed", "Err", err)
+		}
+	}
+}
+
+// runJanitor performs one cleanup pass.
+func runJanitor() error {
+	j := config.Janitor
+	grace := time.Duration(j.GracePeriodH) * time.Hour
+	if grace <= 0 {
+		grace = 24 * time.Hour
+	}
+	cutoff := time.Now().Add(-grace)
+
+	searchesMu.RLock()
+	active := make([]string, 0, len(searches))
+	for id := range searches {
+		active = append(active, id)
+	}
+	searchesMu.RUnlock()
+
+	// Search ids are passed as one array argument so the statements stay
+	// static.
+	notActive, activeIDs := sqlNotInList("id", 1, active)
+	resultsNotActive, _ := sqlNotInList("search_id", 1, active)
+	type cleanup struct {
+		table string
+		query string
+		args  []interface{}
+	}
+	steps := []cleanup{
+		{"searches", `DELETE FROM searches WHERE ` + notActive + ` AND updated_at < $2`,
+			[]interface{}{activeIDs, cutoff}},
+		// State of searches that exist neither here nor in the searches
+		// table (another replica may own them).
+		{"search_results", `DELETE FROM search_results WHERE ` + resultsNotActive + `
+			AND search_id NOT IN (SELECT id FROM searches) AND updated_at < $2`,
+			[]interface{}{activeIDs, cutoff}},
+		{"dn_mappings", `DELETE FROM dn_mappings WHERE ` + resultsNotActive + `
+			AND search_id NOT IN (SELECT id FROM searches) AND updated_at < $2`,
+			[]interface{}{activeIDs, cutoff}},
+	}
+	if j.ChangeLogRetentionD > 0 {
+		steps = append(steps, cleanup{"change_log", `DELETE FROM change_log WHERE time < $1`,
+			[]interface{}{time.Now().AddDate(0, 0, -j.ChangeLogRetentionD)}})
+	}
+	if j.PolicyViolationsRetentionD > 0 {
+		steps = append(steps, cleanup{"policy_violations", `DELETE FROM policy_violations WHERE time < $1`,
+			[]interface{}{time.Now().AddDate(0, 0, -j.PolicyViolationsRetentionD)}})
+	}
+	if j.APIAuditRetentionD > 0 {
+		steps = append(steps, cleanup{"api_audit", `DELETE FROM api_audit WHERE time < $1`,
+			[]interface{}{time.Now().AddDate(0, 0, -j.APIAuditRetentionD)}})
+	}
+	if config.HookCache.Enabled {
+		steps = append(steps, cleanup{"hook_response_cache", `DELETE FROM hook_response_cache WHERE expires_at < $1`,
+			[]interface{}{time.Now()}})
+	}
+	if j.SearchRunsRetentionD > 0 {
+		steps = append(steps, cleanup{"search_runs", `DELETE FROM search_runs WHERE started_at < $1`,
+			[]interface{}{time.Now().AddDate(0, 0, -j.SearchRunsRetentionD)}})
+	}
+
+	var firstErr error
+	for _, s := range steps {
+		res, err := db.Exec(s.query, s.args...)
+		if err != nil {
+			logger.Error("Janitor cleanup failed", "Table", s.table, "Err", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", s.table, err)
+			}
+			continue
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			continue
+		}
+		addCounter(mJanitorDeleted, float64(n), "table", s.table)
+		logger.Info("Janitor removed database rows", "Table", s.table, "Rows", n)
+		if j.Vacuum {
+			// VACUUM cannot take parameters; table names are constants.
+			// SQLite only vacuums whole files, so it just analyzes.
+			stmt := "VACUUM ANALYZE " + s.table
+			if usingSQLite() {
+				stmt = "ANALYZE " + s.table
+			}
+			if _, err := db.Exec(stmt); err != nil {
+				logger.Warn("Janitor vacuum failed", "Table", s.table, "Err", err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// quoteArrayElements renders ids as the elements of a Postgres array
+// literal.
+func quoteArrayElements(ids []string) string {
+	out := make([]byte, 0, len(ids)*16)
+	for i, id := range ids {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, '"')
+		for j := 0; j < len(id); j++ {
+			if id[j] == '"' || id[j] == '\\' {
+				out = append(out, '\\')
+			}
+			out = append(out, id[j])
+		}
+		out = append(out, '"')
+	}
+	return string(out)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// ChangeRecord is one target write in the change journal.
+type ChangeRecord struct {
+	ID        int64                      `json:"id"`
+	Time      time.Time                  `json:"time"`
+	DN        string                     `json:"dn"`
+	Operation string                     `json:"operation"` // add, modify or delete
+	Search    string                     `json:"search,omitempty"`
+	Producer  string                     `json:"producer,omitempty"`
+	Changes   map[string]AttributeChange `json:"changes,omitempty"`
+	Result    string                     `json:"result"` // success or error
+	Error     string                     `json:"error,omitempty"`
+}
+
+// journalEnabled reports whether target writes are journaled; the journal
+// lives in the change_log table, so it requires database persistence.
+func journalEnabled() bool {
+	return db != nil
+}
+
+// journalBefore reads the current values of the attributes a modify will
+// replace, so the journal and change events can record a before/after diff.
+func journalBefore(l *ldap.Conn, dn string, attributes map[string][]string) *ldap.Entry {
+	if !journalEnabled() && !eventsEnabled() {
+		return nil
+	}
+	current, err := readTargetAttributes(l, dn, attributes)
+	if err != nil {
+		logger.Warn("Failed to read target entry for change journal", "DN", dn, "Err", err)
+	}
+	return current
+}
+
+// journalWrite records a target write and its outcome, and publishes
+// successful writes as change events.
+func journalWrite(entry *TransformedEntry, op string, attributes map[string][]string, before *ldap.Entry, writeErr error) {
+	if writeErr == nil && eventsEnabled() {
+		publishTargetEvent(entry, op, diffAttributes(attributes, before))
+	}
+	if !journalEnabled() {
+		return
+	}
+	changes, err := json.Marshal(diffAttributes(attributes, before))
+	if err != nil {
+		logger.Error("Failed to encode change journal diff", "DN", entry.DN, "Err", err)
+		return
+	}
+	result, errText := "success", ""
+	if writeErr != nil {
+		result, errText = "error", writeErr.Error()
+	}
+	const insertSQL = `
+	INSERT INTO change_log (time, dn, operation, search_id, producer, changes, result, error)
+	VALUES (NOW(), $1, $2, $3, $4, $5, $6, $7);`
+	if _, err := db.Exec(insertSQL, entry.DN, op, entry.Search, entry.Producer, string(changes), result, errText); err != nil {
+		logger.Error("Failed to record change journal entry", "DN", entry.DN, "Err", err)
+	}
+}
+
+// getChangesHandler godoc
+// @Summary List journaled target writes
+// @Description Lists target writes recorded in the change journal, newest first. Requires database persistence.
+// @Tags changes
+// @Produce json
+// @Param dn query string false "Only writes to this DN (case-insensitive)"
+// @Param since query string false "Only writes at or after this RFC 3339 time"
+// @Param until query string false "Only writes before this RFC 3339 time"
+// @Param limit query int false "Maximum records to return (default 100, max 1000)"
+// @Success 200 {array} ChangeRecord
+// @Failure 400 {string} string "Invalid parameter"
+// @Failure 503 {string} string "Database persistence is disabled"
+// @Router /changes [get]
+func getChangesHandler(c echo.Context) error {
+	if !journalEnabled() {
+		return c.String(http.StatusServiceUnavailable, "The change journal requires database persistence")
+	}
+	query := `SELECT id, time, dn, operation, search_id, producer, changes, result, error FROM change_log WHERE TRUE`
+	var args []interface{}
+	if dn := c.QueryParam("dn"); dn != "" {
+		args = append(args, dn)
+		query += ` AND lower(dn) = lower($` + strconv.Itoa(len(args)) + `)`
+	}
+	for _, p := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := c.QueryParam(p.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid "+p.param+" parameter; expected RFC 3339 time")
+		}
+		args = append(args, t)
+		query += ` AND time ` + p.op + ` $` + strconv.Itoa(len(args))
+	}
+	limit := 100
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.String(http.StatusBadRequest, "Invalid limit parameter")
+		}
+		limit = n
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	args = append(args, limit)
+	query += ` ORDER BY id DESC LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error("Failed to query change journal", "Err", err)
+		return c.String(http.StatusInternalServerError, "Failed to query change journal")
+	}
+	defer rows.Close()
+	out := []ChangeRecord{}
+	for rows.Next() {
+		var rec ChangeRecord
+		var changes []byte
+		if err := rows.Scan(&rec.ID, &rec.Time, &rec.DN, &rec.Operation, &rec.Search, &rec.Producer, &changes, &rec.Result, &rec.Error); err != nil {
+			logger.Error("Error scanning change journal row", "Err", err)
+			continue
+		}
+		if err := json.Unmarshal(changes, &rec.Changes); err != nil {
+			logger.Warn("Invalid change journal diff", "Id", rec.ID, "Err", err)
+		}
+		out = append(out, rec)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("Error iterating change journal rows", "Err", err)
+		return c.String(http.StatusInternalServerError, "Failed to query change journal")
+	}
+	return c.JSON(http.StatusOK, out)
+}
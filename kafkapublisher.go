@@ -0,0 +1,50 @@
+//go:build kafka
+
+package main
+
+// Publishes change events to Kafka. Build with -tags kafka.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func init() {
+	eventPublisherTypes["kafka"] = newKafkaPublisher
+}
+
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func newKafkaPublisher(cfg EventsConfig) (eventPublisher, error) {
+	k := cfg.Kafka
+	if len(k.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka.brokers is required")
+	}
+	topic := k.Topic
+	if topic == "" {
+		topic = "ldapsync.changes"
+	}
+	return &kafkaPublisher{w: &kafka.Writer{
+		Addr:         kafka.TCP(k.Brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}}, nil
+}
+
+// Publish keys events by normalized DN so the changes to one entry stay in
+// order within their partition.
+func (p *kafkaPublisher) Publish(ev *ChangeEvent, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return p.w.WriteMessages(ctx, kafka.Message{Key: []byte(normalizeDN(ev.DN)), Value: data})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.w.Close()
+}
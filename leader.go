@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LeaderElectionConfig runs several replicas as active/passive. The replica
+// holding a Postgres advisory lock is the leader: it runs the search loops
+// and everything else that writes to the target or the database. Standbys
+// serve read-only API traffic from the database and take over when the
+// leader's database session ends.
+type LeaderElectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// LockID is the advisory lock key; replicas sharing a database but
+	// syncing different directories need different keys.
+	LockID int64 `yaml:"lock_id"`
+	// RetryIntervalSec is how often standbys try to take the lock and the
+	// leader checks it still holds it (default: 5).
+	RetryIntervalSec int `yaml:"retry_interval_s"`
+	// StandbyRefreshSec is how often standbys reload searches and results
+	// for the read-only API (default: 30).
+	StandbyRefreshSec int `yaml:"standby_refresh_s"`
+}
+
+// defaultLeaderLockID is "ldapsync" in ASCII.
+const defaultLeaderLockID = 0x6c64617073796e63
+
+var mLeader = describeMetric("ldapsync_leader", "gauge",
+	"1 while this replica is the leader (or leader election is disabled), 0 on standbys.")
+
+var leading atomic.Bool
+
+// isLeader reports whether this replica runs the searches.
+func isLeader() bool {
+	return !config.LeaderElection.Enabled || leading.Load()
+}
+
+func initLeaderElection() error {
+	if config.LeaderElection.Enabled && !config.Database.Enabled {
+		return fmt.Errorf("leader_election: requires database persistence")
+	}
+	if config.LeaderElection.Enabled && usingSQLite() {
+		return fmt.Errorf("leader_election: requires the postgres database driver")
+	}
+	return nil
+}
+
+func leaderRetryInterval() time.Duration {
+	if s := config.LeaderElection.RetryIntervalSec; s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return 5 * time.Second
+}
+
+// startLeaderElection calls elected once this replica holds the leader
+// lock, waiting in the background; without leader election it calls elected
+// right away. The lock lives as long as the database session holding it,
+// so a leader that loses the session exits rather than keep writing next
+// to a new leader, and comes back as a standby.
+func startLeaderElection(elected func()) {
+	if !config.LeaderElection.Enabled {
+		setGauge(mLeader, 1)
+		elected()
+		return
+	}
+	setGauge(mLeader, 0)
+	logger.Info("Starting as a standby until elected leader")
+	go refreshStandbyState()
+	go runLeaderElection(elected)
+}
+
+func runLeaderElection(elected func()) {
+	lockID := config.LeaderElection.LockID
+	if lockID == 0 {
+		lockID = defaultLeaderLockID
+	}
+	interval := leaderRetryInterval()
+	for {
+		conn, err := tryLeaderLock(lockID)
+		if err != nil {
+			logger.Error("Leader election failed", "Err", err)
+		}
+		if conn != nil {
+			logger.Info("Elected leader; starting searches", "LockId", lockID)
+			leading.Store(true)
+			setGauge(mLeader, 1)
+			elected()
+			go holdLeaderLock(conn, interval)
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// tryLeaderLock takes the advisory lock on a dedicated connection, which it
+// returns, or returns nil if another replica holds the lock.
+func tryLeaderLock(lockID int64) (*sql.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockID).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return conn, nil
+}
+
+// holdLeaderLock keeps the lock's session alive and exits the process when
+// it is lost.
+func holdLeaderLock(conn *sql.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := conn.PingContext(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("Lost the leader lock's database session; exiting", "Err", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// refreshStandbyState reloads the searches and their results from the
+// database until this replica becomes the leader.
+func refreshStandbyState() {
+	interval := time.Duration(config.LeaderElection.StandbyRefreshSec) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	for !leading.Load() {
+		loadSearchState(false)
+		time.Sleep(interval)
+	}
+}
+
+// standbyMiddleware rejects changes on standbys; reads, the read-only POST
+// endpoints and the replica's own log level are served.
+func standbyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isLeader() {
+			return next(c)
+		}
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+		switch c.Path() {
+		case "/compare", "/reconcile/:id", "/loglevel":
+			return next(c)
+		}
+		c.Response().Header().Set("Retry-After", "5")
+		return c.String(http.StatusServiceUnavailable, "This replica is a standby; send changes to the leader")
+	}
+}
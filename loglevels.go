@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Log records of these components carry a "component" attribute and can be
+// given their own level; all other records follow the global level.
+const (
+	logComponentSync         = "sync"         // Search loop, change detection, dampening
+	logComponentHooks        = "hooks"        // Hook delivery and responses, transforms
+	logComponentDependencies = "dependencies" // Dependency tracker, bindings, write groups
+	logComponentHTTP         = "http"         // API request log
+)
+
+var logComponents = []string{logComponentSync, logComponentHooks, logComponentDependencies, logComponentHTTP}
+
+// Component loggers; installLogger rebuilds them whenever logger changes.
+var (
+	syncLogger *slog.Logger
+	hookLogger *slog.Logger
+	depLogger  *slog.Logger
+	httpLogger *slog.Logger
+)
+
+// componentLevels holds per-component level overrides.
+var componentLevels = struct {
+	sync.RWMutex
+	levels map[string]slog.Level
+}{levels: make(map[string]slog.Level)}
+
+func validLogComponent(name string) bool {
+	for _, c := range logComponents {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validLogLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// setComponentLevel overrides a component's level; an empty level makes it
+// follow the global level again.
+func setComponentLevel(component, level string) {
+	componentLevels.Lock()
+	if level == "" {
+		delete(componentLevels.levels, component)
+	} else {
+		componentLevels.levels[component] = parseLogLevel(level)
+	}
+	componentLevels.Unlock()
+}
+
+// componentLevel returns the level records of component are filtered at.
+func componentLevel(component string) slog.Level {
+	if component != "" {
+		componentLevels.RLock()
+		level, ok := componentLevels.levels[component]
+		componentLevels.RUnlock()
+		if ok {
+			return level
+		}
+	}
+	return logLevel.Level()
+}
+
+// componentLevelNames returns the overridden component levels.
+func componentLevelNames() map[string]string {
+	componentLevels.RLock()
+	defer componentLevels.RUnlock()
+	out := make(map[string]string, len(componentLevels.levels))
+	for c, l := range componentLevels.levels {
+		out[c] = strings.ToLower(l.String())
+	}
+	return out
+}
+
+// initComponentLevels applies logging.levels from the config.
+func initComponentLevels() error {
+	names := make([]string, 0, len(config.Logging.Levels))
+	for c := range config.Logging.Levels {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	for _, c := range names {
+		level := config.Logging.Levels[c]
+		if !validLogComponent(c) {
+			return fmt.Errorf("logging.levels: unknown component %q (known: %s)", c, strings.Join(logComponents, ", "))
+		}
+		if !validLogLevel(level) {
+			return fmt.Errorf("logging.levels: invalid level %q for %s", level, c)
+		}
+		setComponentLevel(c, level)
+	}
+	return nil
+}
+
+// componentHandler filters records by the level of the component named in
+// the logger's "component" attribute. The sinks below it accept every
+// level.
+type componentHandler struct {
+	inner     slog.Handler
+	component string
+}
+
+func (h componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= componentLevel(h.component)
+}
+
+func (h componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := componentHandler{inner: h.inner.WithAttrs(attrs), component: h.component}
+	for _, a := range attrs {
+		if a.Key == "component" {
+			out.component = a.Value.String()
+		}
+	}
+	return out
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{inner: h.inner.WithGroup(name), component: h.component}
+}
+
+// installLogger makes h the destination of logger and the component loggers.
+func installLogger(h slog.Handler) {
+	logger = slog.New(componentHandler{inner: h})
+	syncLogger = logger.With("component", logComponentSync)
+	hookLogger = logger.With("component", logComponentHooks)
+	depLogger = logger.With("component", logComponentDependencies)
+	httpLogger = logger.With("component", logComponentHTTP)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LoggingConfig lists where log records are shipped. Without sinks the
+// service logs to stdout in Format.
+type LoggingConfig struct {
+	Sinks  []LogSinkConfig `yaml:"sinks"`
+	Format string          `yaml:"format"` // stdout without sinks: text (default) or json
+	// Levels overrides the level of components (sync, hooks, dependencies,
+	// http); others follow the global level.
+	Levels map[string]string `yaml:"levels"`
+}
+
+// LogSinkConfig configures one log destination. Type is "stdout", "syslog"
+// or "otlp"; the remaining fields apply to the type named in their comment.
+type LogSinkConfig struct {
+	Type   string `yaml:"type"`
+	Format string `yaml:"format"` // stdout: text (default) or json
+
+	Network  string `yaml:"network"`  // syslog: "", udp or tcp ("" is the local socket)
+	Address  string `yaml:"address"`  // syslog: host:port for udp/tcp
+	Tag      string `yaml:"tag"`      // syslog: program tag (default: ldap-sync)
+	Facility string `yaml:"facility"` // syslog: daemon (default), local0-local7, ...
+
+	Endpoint         string            `yaml:"endpoint"`         // otlp: logs URL, e.g. http://collector:4318/v1/logs
+	Headers          map[string]string `yaml:"headers"`          // otlp: extra request headers
+	ServiceName      string            `yaml:"service_name"`     // otlp: service.name resource attribute (default: ldap-sync)
+	BatchSize        int               `yaml:"batch_size"`       // otlp: records per export (default: 512)
+	FlushIntervalSec int               `yaml:"flush_interval_s"` // otlp: max delay before export (default: 5)
+	QueueSize        int               `yaml:"queue_size"`       // otlp: buffered records before dropping (default: 8192)
+}
+
+// logLevel is the global level, applied by componentHandler in front of
+// every sink so PUT /loglevel applies everywhere.
+var logLevel = new(slog.LevelVar)
+
+func parseLogLevel(s string) slog.Level {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// logHandlerOptions lets every record through; componentHandler filters
+// by level before records reach a sink.
+func logHandlerOptions() *slog.HandlerOptions {
+	return &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: true}
+}
+
+// initLogSinks applies the logging config: component levels, then the
+// configured sinks or the stdout format.
+func initLogSinks() error {
+	if err := initComponentLevels(); err != nil {
+		return err
+	}
+	if len(config.Logging.Sinks) == 0 {
+		switch strings.ToLower(config.Logging.Format) {
+		case "", "text":
+		case "json":
+			installLogger(slog.NewJSONHandler(os.Stdout, logHandlerOptions()))
+		default:
+			return fmt.Errorf("logging.format: unknown format %q", config.Logging.Format)
+		}
+		return nil
+	}
+	var handlers []slog.Handler
+	for i, sink := range config.Logging.Sinks {
+		h, err := newSinkHandler(sink)
+		if err != nil {
+			return fmt.Errorf("logging sink %d (%s): %w", i, sink.Type, err)
+		}
+		handlers = append(handlers, h)
+	}
+	if len(handlers) == 1 {
+		installLogger(handlers[0])
+	} else {
+		installLogger(fanoutHandler(handlers))
+	}
+	logger.Info("Log sinks initialized", "Count", len(handlers))
+	return nil
+}
+
+func newSinkHandler(sink LogSinkConfig) (slog.Handler, error) {
+	switch strings.ToLower(sink.Type) {
+	case "", "stdout":
+		if strings.EqualFold(sink.Format, "json") {
+			return slog.NewJSONHandler(os.Stdout, logHandlerOptions()), nil
+		}
+		return slog.NewTextHandler(os.Stdout, logHandlerOptions()), nil
+	case "syslog":
+		return newSyslogHandler(sink)
+	case "otlp":
+		return newOTLPHandler(sink)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sink.Type)
+	}
+}
+
+// fanoutHandler sends each record to every sink.
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// syslogHandler formats records as text and writes them at the syslog
+// severity matching their level. One text handler per severity keeps the
+// formatting identical to stdout.
+type syslogHandler struct {
+	debug, info, warn, err slog.Handler
+}
+
+type syslogWriterFunc func(string) error
+
+func (fn syslogWriterFunc) Write(p []byte) (int, error) {
+	return len(p), fn(strings.TrimRight(string(p), "\n"))
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"local0":   syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+func newSyslogHandler(sink LogSinkConfig) (slog.Handler, error) {
+	facility := syslog.LOG_DAEMON
+	if sink.Facility != "" {
+		f, ok := syslogFacilities[strings.ToLower(sink.Facility)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", sink.Facility)
+		}
+		facility = f
+	}
+	tag := sink.Tag
+	if tag == "" {
+		tag = "ldap-sync"
+	}
+	w, err := syslog.Dial(sink.Network, sink.Address, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	// syslog adds its own timestamp.
+	opts := logHandlerOptions()
+	opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	text := func(fn func(string) error) slog.Handler {
+		return slog.NewTextHandler(syslogWriterFunc(fn), opts)
+	}
+	return &syslogHandler{
+		debug: text(w.Debug),
+		info:  text(w.Info),
+		warn:  text(w.Warning),
+		err:   text(w.Err),
+	}, nil
+}
+
+func (s *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return s.info.Enabled(ctx, level)
+}
+
+func (s *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	switch {
+	case r.Level >= slog.LevelError:
+		return s.err.Handle(ctx, r)
+	case r.Level >= slog.LevelWarn:
+		return s.warn.Handle(ctx, r)
+	case r.Level >= slog.LevelInfo:
+		return s.info.Handle(ctx, r)
+	default:
+		return s.debug.Handle(ctx, r)
+	}
+}
+
+func (s *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{s.debug.WithAttrs(attrs), s.info.WithAttrs(attrs), s.warn.WithAttrs(attrs), s.err.WithAttrs(attrs)}
+}
+
+func (s *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{s.debug.WithGroup(name), s.info.WithGroup(name), s.warn.WithGroup(name), s.err.WithGroup(name)}
+}
+
+// otlpExporter batches log records and posts them to an OTLP/HTTP logs
+// endpoint as JSON.
+type otlpExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	batchSize   int
+	interval    time.Duration
+	queue       chan map[string]interface{}
+	client      *http.Client
+}
+
+// otlpHandler converts records to OTLP log records. attrs and groups carry
+// the state accumulated by WithAttrs and WithGroup.
+type otlpHandler struct {
+	exp    *otlpExporter
+	attrs  []map[string]interface{}
+	prefix string
+}
+
+var mLogRecordsDropped = describeMetric("ldapsync_log_records_dropped_total", "counter",
+	"Log records dropped by a sink, by sink type.")
+
+func newOTLPHandler(sink LogSinkConfig) (slog.Handler, error) {
+	if sink.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	exp := &otlpExporter{
+		endpoint:    sink.Endpoint,
+		headers:     sink.Headers,
+		serviceName: sink.ServiceName,
+		batchSize:   sink.BatchSize,
+		interval:    time.Duration(sink.FlushIntervalSec) * time.Second,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	if exp.serviceName == "" {
+		exp.serviceName = "ldap-sync"
+	}
+	if exp.batchSize <= 0 {
+		exp.batchSize = 512
+	}
+	if exp.interval <= 0 {
+		exp.interval = 5 * time.Second
+	}
+	queueSize := sink.QueueSize
+	if queueSize <= 0 {
+		queueSize = 8192
+	}
+	exp.queue = make(chan map[string]interface{}, queueSize)
+	go exp.run()
+	return &otlpHandler{exp: exp}, nil
+}
+
+func (o *otlpHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelDebug
+}
+
+func otlpSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 17
+	case level >= slog.LevelWarn:
+		return 13
+	case level >= slog.LevelInfo:
+		return 9
+	default:
+		return 5
+	}
+}
+
+func otlpAttr(key string, v slog.Value) map[string]interface{} {
+	v = v.Resolve()
+	var value map[string]interface{}
+	switch v.Kind() {
+	case slog.KindBool:
+		value = map[string]interface{}{"boolValue": v.Bool()}
+	case slog.KindInt64:
+		value = map[string]interface{}{"intValue": fmt.Sprint(v.Int64())}
+	case slog.KindFloat64:
+		value = map[string]interface{}{"doubleValue": v.Float64()}
+	default:
+		value = map[string]interface{}{"stringValue": v.String()}
+	}
+	return map[string]interface{}{"key": key, "value": value}
+}
+
+// flattenAttr appends a slog attribute, expanding groups into dotted keys.
+func flattenAttr(out []map[string]interface{}, prefix string, a slog.Attr) []map[string]interface{} {
+	if a.Equal(slog.Attr{}) {
+		return out
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			out = flattenAttr(out, p, ga)
+		}
+		return out
+	}
+	return append(out, otlpAttr(prefix+a.Key, a.Value))
+}
+
+func (o *otlpHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]map[string]interface{}{}, o.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = flattenAttr(attrs, o.prefix, a)
+		return true
+	})
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		attrs = append(attrs, otlpAttr("code.filepath", slog.StringValue(frame.File)),
+			otlpAttr("code.lineno", slog.Int64Value(int64(frame.Line))))
+	}
+	rec := map[string]interface{}{
+		"timeUnixNano":   fmt.Sprint(r.Time.UnixNano()),
+		"severityNumber": otlpSeverity(r.Level),
+		"severityText":   r.Level.String(),
+		"body":           map[string]interface{}{"stringValue": r.Message},
+		"attributes":     attrs,
+	}
+	select {
+	case o.exp.queue <- rec:
+	default:
+		incCounter(mLogRecordsDropped, "sink", "otlp")
+	}
+	return nil
+}
+
+func (o *otlpHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &otlpHandler{exp: o.exp, prefix: o.prefix, attrs: append([]map[string]interface{}{}, o.attrs...)}
+	for _, a := range attrs {
+		next.attrs = flattenAttr(next.attrs, o.prefix, a)
+	}
+	return next
+}
+
+func (o *otlpHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return o
+	}
+	return &otlpHandler{exp: o.exp, attrs: o.attrs, prefix: o.prefix + name + "."}
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	batch := make([]map[string]interface{}, 0, e.batchSize)
+	for {
+		select {
+		case rec := <-e.queue:
+			batch = append(batch, rec)
+			if len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			// The logger may be the failing sink, so report on stderr.
+			fmt.Fprintf(os.Stderr, "otlp log export failed, dropped %d records: %v\n", len(batch), err)
+			addCounter(mLogRecordsDropped, float64(len(batch)), "sink", "otlp")
+		}
+		batch = batch[:0]
+	}
+}
+
+func (e *otlpExporter) export(batch []map[string]interface{}) error {
+	payload := map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{otlpAttr("service.name", slog.StringValue(e.serviceName))},
+			},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]interface{}{"name": "ldap-sync"},
+				"logRecords": batch,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
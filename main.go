@@ -4,23 +4,25 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"reflect"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	_ "main/docs" // Replace with your actual module path.
+	_ "github.com/helxplatform/ldap-sync/docs"
 
 	"github.com/go-ldap/ldap/v3"
+	"github.com/helxplatform/ldap-sync/hooksdk"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
@@ -34,6 +36,16 @@ type LDAPConfig struct {
 	BindDN       string `yaml:"bind_dn"`
 	BindPassword string `yaml:"bind_password"`
 	BaseDN       string `yaml:"base_dn"`
+	// BindPasswordFile reads the bind password from a file instead, re-read
+	// on invalid credentials and every BindPasswordRefreshSec (0: only then).
+	BindPasswordFile       string `yaml:"bind_password_file"`
+	BindPasswordRefreshSec int    `yaml:"bind_password_refresh_s"`
+	// DCLocator finds Active Directory domain controllers through DNS.
+	DCLocator DCLocatorConfig `yaml:"dc_locator"`
+	// SASL binds with GSSAPI (Kerberos) or EXTERNAL instead of bind_dn.
+	SASL SASLConfig `yaml:"sasl"`
+	// Referrals controls whether search references are chased (source only).
+	Referrals ReferralConfig `yaml:"referrals"`
 }
 
 // DatabaseConfig holds database connection details.
@@ -45,6 +57,24 @@ type DatabaseConfig struct {
 	Database     string `yaml:"database"`
 	PasswordFile string `yaml:"password_file"`
 	SSLMode      string `yaml:"sslmode"`
+	// PersistResults stores the last-seen search results so change detection
+	// survives restarts: "hash" (content hash only), "content" or "" (off).
+	PersistResults string `yaml:"persist_results"`
+	// Driver is "postgres" (default) or "sqlite"; SQLite keeps the state in
+	// the file at Path and ignores the connection settings.
+	Driver string `yaml:"driver"`
+	Path   string `yaml:"path"`
+	// Pool settings; 0 keeps the database/sql defaults (unlimited open
+	// connections, 2 idle, no lifetime limits).
+	MaxOpenConns       int `yaml:"max_open_conns"`
+	MaxIdleConns       int `yaml:"max_idle_conns"`
+	ConnMaxLifetimeSec int `yaml:"conn_max_lifetime_s"`
+	ConnMaxIdleTimeSec int `yaml:"conn_max_idle_time_s"`
+	// StartupTimeoutSec keeps retrying the first connection with backoff
+	// for this long before giving up (default: 30).
+	StartupTimeoutSec int `yaml:"startup_timeout_s"`
+	// HealthIntervalSec is the time between health pings (default: 15).
+	HealthIntervalSec int `yaml:"health_interval_s"`
 }
 
 // HookRetryConfig holds retry configuration for hook requests.
@@ -52,53 +82,260 @@ type HookRetryConfig struct {
 	MaxRetries     int `yaml:"max_retries"`
 	InitialDelayMs int `yaml:"initial_delay_ms"`
 	MaxDelayMs     int `yaml:"max_delay_ms"`
+	// RetryStatusCodes are the hook response statuses retried (default: 429
+	// and 5xx) and MaxElapsedMs bounds the time spent on one request
+	// (default: unlimited). Hook requests only.
+	RetryStatusCodes []int `yaml:"retry_status_codes"`
+	MaxElapsedMs     int   `yaml:"max_elapsed_ms"`
 }
 
 // Config holds the configuration for both source and target LDAP servers.
 type Config struct {
-	Source    LDAPConfig      `yaml:"source"`
-	Target    LDAPConfig      `yaml:"target"`
-	Hooks     []string        `yaml:"hooks"`
-	Database  DatabaseConfig  `yaml:"database"`
-	HookRetry HookRetryConfig `yaml:"hook_retry"`
+	Source         LDAPConfig                 `yaml:"source"`
+	Target         LDAPConfig                 `yaml:"target"`
+	Hooks          []string                   `yaml:"hooks"`
+	Database       DatabaseConfig             `yaml:"database"`
+	HookRetry      HookRetryConfig            `yaml:"hook_retry"`
+	Transforms     map[string]TransformConfig `yaml:"transforms"`
+	Mappings       map[string]MappingConfig   `yaml:"mappings"`
+	DNRewrites     map[string][]DNRewriteRule `yaml:"dn_rewrites"`
+	TargetPipeline PipelineConfig             `yaml:"target_pipeline"`
+	DeadLetter     DeadLetterConfig           `yaml:"dead_letter"`
+	TargetRetry    HookRetryConfig            `yaml:"target_retry"` // Defaults to the hook_retry settings
+	// BinaryAttributes overrides the attributes carried base64-encoded
+	// (default: jpegPhoto, userCertificate, objectGUID and similar).
+	BinaryAttributes []string      `yaml:"binary_attributes"`
+	Logging          LoggingConfig `yaml:"logging"`
+	Policy           PolicyConfig  `yaml:"policy"`
+	// DryRun previews every target write instead of performing it; searches
+	// can also opt in individually.
+	DryRun            bool `yaml:"dry_run"`
+	DryRunPreviewSize int  `yaml:"dry_run_preview_size"` // Previews kept for /changes/preview (default: 10000)
+	// Changelog describes the source's retro changelog for incremental sync.
+	Changelog ChangelogConfig `yaml:"changelog"`
+	// HookIdentity signs an identity assertion sent with every hook request.
+	HookIdentity HookIdentityConfig `yaml:"hook_identity"`
+	// Checksum records a checksum of the managed content on target entries.
+	Checksum ChecksumConfig `yaml:"checksum"`
+	// MergeAttributes maps attributes to the strategy used to combine
+	// written values with the target's (default: memberUid: union).
+	MergeAttributes map[string]string `yaml:"merge_attributes"`
+	// ProtectedMembers are values never removed from attributes merged with
+	// the exact strategy (e.g. service accounts added on the target).
+	ProtectedMembers []string `yaml:"protected_members"`
+	// Dampening limits how often a flapping entry is sent to the hooks.
+	Dampening DampeningConfig `yaml:"dampening"`
+	// ProvisioningWebhooks are notified when an entry is first added to the target.
+	ProvisioningWebhooks []ProvisioningWebhook `yaml:"provisioning_webhooks"`
+	// CreateParents creates missing intermediate containers on add.
+	CreateParents ParentsConfig `yaml:"create_parents"`
+	// ObjectClasses chooses object classes for new entries that have none.
+	ObjectClasses ObjectClassConfig `yaml:"object_classes"`
+	// TargetMirror tunes the cached target lookups served to hooks.
+	TargetMirror TargetMirrorConfig `yaml:"target_mirror"`
+	// FaultInjection randomly fails operations for resilience testing.
+	FaultInjection FaultInjectionConfig `yaml:"fault_injection"`
+	// SchemaValidation checks entries against the target schema before writing.
+	SchemaValidation SchemaValidationConfig `yaml:"schema_validation"`
+	// Readiness makes /readyz depend on the source, target and database.
+	Readiness ReadinessConfig `yaml:"readiness"`
+	// HookDeletes notifies the hooks of entries that left a search.
+	HookDeletes bool `yaml:"hook_deletes"`
+	// HookBatch posts changed entries to the hooks in batches.
+	HookBatch HookBatchConfig `yaml:"hook_batch"`
+	// TargetRateLimit throttles target writes across all searches.
+	TargetRateLimit TargetRateLimitConfig `yaml:"target_rate_limit"`
+	// Janitor periodically removes orphaned and expired database rows.
+	Janitor JanitorConfig `yaml:"janitor"`
+	// Refresh adds jitter and adaptive lengthening to refresh intervals.
+	Refresh RefreshConfig `yaml:"refresh"`
+	// Auth requires API keys or OIDC tokens for the management API.
+	Auth AuthConfig `yaml:"auth"`
+	// LeaderElection runs replicas as active/passive.
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+	// Concurrency caps how many search runs execute at once.
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+	// DerivedSearches controls the cleanup of hook-derived searches.
+	DerivedSearches DerivedSearchesConfig `yaml:"derived_searches"`
+	// SearchStats configures the run history of searches.
+	SearchStats SearchStatsConfig `yaml:"search_stats"`
+	// SearchStream tunes how full searches read the source.
+	SearchStream SearchStreamConfig `yaml:"search_stream"`
+	// TargetWriteQueue moves target writes to a worker pool.
+	TargetWriteQueue TargetWriteQueueConfig `yaml:"target_write_queue"`
+	// WriteCoalescing merges writes to one DN made within a window.
+	WriteCoalescing WriteCoalescingConfig `yaml:"write_coalescing"`
+	// Targets are written to in addition to the target LDAP server.
+	Targets []TargetConfig `yaml:"targets"`
+	// Events publishes target writes and source changes to a message bus.
+	Events EventsConfig `yaml:"events"`
+	// HookValidation checks hook responses against the response schema.
+	HookValidation HookValidationConfig `yaml:"hook_validation"`
+	// HookProtocol pins or defaults the hook protocol versions.
+	HookProtocol HookProtocolConfig `yaml:"hook_protocol"`
+	// HookConditions limits the entries posted to hooks, by hook URL.
+	HookConditions map[string]HookConditionConfig `yaml:"hook_conditions"`
+	// HookHTTP configures the proxy, TLS and connections of hook calls.
+	HookHTTP HookHTTPConfig `yaml:"hook_http"`
+	// HookHealth probes hooks and holds the traffic of failing ones.
+	HookHealth HookHealthConfig `yaml:"hook_health"`
+	// HookCache reuses hook responses for entries posted again unchanged.
+	HookCache HookCacheConfig `yaml:"hook_cache"`
+	// CacheLimits bounds the cached results and per-DN locks.
+	CacheLimits CacheLimitsConfig `yaml:"cache_limits"`
+	// ResultCache is what is kept in memory of each search result for
+	// change detection: "content" (default) or only a "hash" of it.
+	ResultCache string `yaml:"result_cache"`
+	// BindingNamespaces sets the TTL and scope of bindings by key namespace.
+	BindingNamespaces map[string]BindingNamespaceConfig `yaml:"binding_namespaces"`
+	// PendingTimeout flags, writes or drops entries stuck in the dependency tracker.
+	PendingTimeout PendingTimeoutConfig `yaml:"pending_timeout"`
+	// DependencyCycles reports, breaks or rejects cycles among pending entries.
+	DependencyCycles DependencyCycleConfig `yaml:"dependency_cycles"`
+	// DependencyWarmup marks existing target entries as synced.
+	DependencyWarmup DependencyWarmupConfig `yaml:"dependency_warmup"`
+	// DependencyLookup checks the target for dependencies not known as synced.
+	DependencyLookup DependencyLookupConfig `yaml:"dependency_lookup"`
+	// WriteGroups configures how grouped hook entries are written.
+	WriteGroups WriteGroupsConfig `yaml:"write_groups"`
+	// PasswordSync opts in to replicating password hashes.
+	PasswordSync PasswordSyncConfig `yaml:"password_sync"`
+	// IdentityTracking identifies source entries by an immutable attribute.
+	IdentityTracking IdentityTrackingConfig `yaml:"identity_tracking"`
+	// CompletedSearches removes runOnce searches some time after they complete.
+	CompletedSearches CompletedSearchesConfig `yaml:"completed_searches"`
+	// WriteVerification reads entries back after writes to check the values.
+	WriteVerification WriteVerificationConfig `yaml:"write_verification"`
 }
 
 // SearchSpec represents a running search instance.
 type SearchSpec struct {
-	Filter  string
-	Refresh int
-	Stop    chan struct{}
-	BaseDN  string // The base DN to use for this search.
-	Oneshot bool   // one-shot -- don't involve the hook
+	Filter    string
+	Refresh   int
+	Stop      chan struct{}
+	BaseDN    string // The base DN to use for this search.
+	RunOnce   bool   // Stop after the first complete run.
+	Transform string // Embedded transform to use instead of the hooks.
+	Mapping   string // Declarative attribute mapping applied before or instead of the hooks.
+	// SuppressHooks only caches the results: no hooks, transform or mapping.
+	SuppressHooks bool
+	// Attributes narrows the attributes requested from the source (default: all user attributes).
+	Attributes []string
+	// ExcludeAttributes are stripped from entries before they are stored or sent to hooks.
+	ExcludeAttributes []string
+	// DryRun previews the search's target writes without performing them.
+	DryRun bool
+	// ChangeDetection is "poll" (default) to re-run the search every refresh,
+	// or "changelog" to apply only the source's changelog entries after an
+	// initial full search.
+	ChangeDetection string
+	// Rename moves the target entry (ModifyDN) when the DN produced for a
+	// source entry changes, instead of leaving the old entry behind.
+	Rename bool
+	// CorrelationAttribute identifies source entries (e.g. entryUUID) for
+	// change detection and rename tracking instead of their DN.
+	CorrelationAttribute string
+	// Scope (base, one or sub) and Deref (never, search, find or always)
+	// default to sub and never; SizeLimit and TimeLimit (seconds) to none.
+	Scope     string
+	Deref     string
+	SizeLimit int
+	TimeLimit int
+	// Schedule is a cron expression for when the search runs; Refresh then
+	// only paces retries after errors. LastRun is its last scheduled run.
+	Schedule string
+	LastRun  time.Time
+	// Parent is the search whose hook response derived this one, if any,
+	// and ParentDN the source entry it was derived for. Derived searches
+	// are removed after ExpiresAt, after IdleExpiry without finding
+	// changes (counted from LastChange), or when their parent is gone.
+	Parent     string
+	ParentDN   string
+	Owner      string // Hook URL or transform whose response derived it
+	ExpiresAt  time.Time
+	IdleExpiry time.Duration
+	LastChange time.Time
+	// CompletedAt is when a runOnce search completed, with ResultCount
+	// results.
+	CompletedAt time.Time
+	ResultCount int
 }
 
 // LogLevelRequest represents the payload for updating the log level.
 type LogLevelRequest struct {
 	Level string `json:"level"`
+	// Component sets the level of one component (sync, hooks, dependencies,
+	// http) instead of the global level; level "default" clears it.
+	Component string `json:"component,omitempty"`
+}
+
+// LogLevelResponse reports the global level and component overrides.
+type LogLevelResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
 }
 
 // SearchInfo represents the JSON structure for a search.
 type SearchInfo struct {
-	ID      string `json:"id"`
-	Filter  string `json:"filter"`
-	Refresh int    `json:"refresh"`
-	BaseDN  string
-	Oneshot bool
+	ID                string `json:"id"`
+	Filter            string `json:"filter"`
+	Refresh           int    `json:"refresh"`
+	BaseDN            string
+	Oneshot           bool     // RunOnce and SuppressHooks together, for older clients
+	RunOnce           bool     `json:"runOnce"`
+	SuppressHooks     bool     `json:"suppressHooks"`
+	Transform         string   `json:"transform,omitempty"`
+	Mapping           string   `json:"mapping,omitempty"`
+	Attributes        []string `json:"attributes,omitempty"`
+	ExcludeAttributes []string `json:"exclude_attributes,omitempty"`
+	DryRun            bool     `json:"dry_run,omitempty"`
+	ChangeDetection   string   `json:"change_detection,omitempty"`
+	Rename            bool     `json:"rename,omitempty"`
+	// CorrelationAttribute identifies entries instead of their DN.
+	CorrelationAttribute string `json:"correlation_attribute,omitempty"`
+	Scope                string `json:"scope,omitempty"`
+	Deref                string `json:"deref,omitempty"`
+	SizeLimit            int    `json:"sizeLimit,omitempty"`
+	TimeLimit            int    `json:"timeLimit,omitempty"` // Seconds
+	// Schedule is the cron expression the search runs on, if any.
+	Schedule string     `json:"schedule,omitempty"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	// Parent is the search that derived this one, if any, and ParentDN
+	// the source entry it was derived for.
+	Parent    string     `json:"parent,omitempty"`
+	ParentDN  string     `json:"parent_dn,omitempty"`
+	Owner     string     `json:"owner,omitempty"` // Hook URL or transform that derived it
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// State is active, or completed for a runOnce search that finished
+	// at CompletedAt with ResultCount results.
+	State       string     `json:"state"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ResultCount *int       `json:"result_count,omitempty"`
 }
 
 // DerivedSearchSpec describes a search as provided via a hook response.
-type DerivedSearchSpec struct {
-	ID      string `json:"id"`
-	Filter  string `json:"filter"`
-	Refresh int    `json:"refresh"`
-	BaseDN  string `json:"baseDN"`
-	Oneshot bool   `json:"oneshot"`
-}
+type DerivedSearchSpec = hooksdk.DerivedSearch
+
+// BindingValue is the value of a binding: a string or a list of strings.
+type BindingValue = hooksdk.BindingValue
 
 // LDAPResult holds an LDAP entry in a structured way.
 type LDAPResult struct {
 	DN      string                 `json:"dn"`
 	Content map[string]interface{} `json:"content"`
+	// hash is the content hash of a result restored without its content.
+	hash string
+	// touched is when the result was last seen (UnixNano), for eviction.
+	touched int64
+	// correlation is the result's correlation key, if its search has a
+	// correlation attribute.
+	correlation string
+	// changeType and previous describe the change for hook payloads;
+	// replay marks results re-sent by POST /search/:id/replay.
+	changeType string
+	previous   map[string]interface{}
+	replay     bool
 }
 
 // Define two result types.
@@ -111,42 +348,63 @@ type ResultEntryFull struct {
 	Content map[string]interface{} `json:"content"`
 }
 
+// TransformedEntry is an entry to write to the target: the DN and content a
+// hook, transform or mapping produced, and the bookkeeping ldap-sync keeps
+// with it. Hooks only return the DN and content (hooksdk.Entry).
 type TransformedEntry struct {
 	DN      string                 `json:"dn"`
 	Content map[string]interface{} `json:"content"`
-}
-
-// HookResponse represents the hook response JSON.
-type HookResponse struct {
-	Transformed  []TransformedEntry  `json:"transformed"`
-	Derived      []DerivedSearchSpec `json:"derived"`
-	Reset        bool                `json:"reset"`
-	Dependencies []string            `json:"dependencies"`
-	Bindings     map[string]*string  `json:"bindings"`
+	// Search is the id of the search whose result produced the entry and
+	// Producer the hook URL, transform or mapping that produced it.
+	Search   string `json:"search,omitempty"`
+	Producer string `json:"producer,omitempty"`
+	// Source is the source DN the entry was produced from, and Correlation
+	// the source entry's correlation key; set only when it was the sole
+	// entry produced from that source entry.
+	Source      string `json:"source,omitempty"`
+	Correlation string `json:"correlation,omitempty"`
+	// Origin is the source DN the entry was produced from, also when it was
+	// produced along with others. Values merged with remove-absent are
+	// managed per search and origin.
+	Origin string `json:"origin,omitempty"`
+	// Contributions holds the content of each contributor (see
+	// managedContributor) of an entry coalesced from the writes of several
+	// source entries; Content is their union.
+	Contributions map[string]map[string]interface{} `json:"contributions,omitempty"`
+}
+
+// HookResponse represents the hook response JSON. It is the SDK's type, so
+// hooks and the daemon cannot drift apart.
+type HookResponse = hooksdk.Response
+
+// hookEntries wraps the entries of a hook response for writing.
+func hookEntries(entries []hooksdk.Entry) []TransformedEntry {
+	out := make([]TransformedEntry, len(entries))
+	for i, e := range entries {
+		out[i] = TransformedEntry{DN: e.DN, Content: e.Content}
+	}
+	return out
 }
 
 var config Config
 var logger *slog.Logger
-var currentLogLevel string
 var searches = make(map[string]*SearchSpec)
 var searchResults = make(map[string]map[string]LDAPResult)
 var searchesMu sync.RWMutex
 var searchResultsMu sync.RWMutex
 var dependencyTracker = newDependencyState()
-var mergeAttributes = map[string]struct{}{
-	"memberuid": {},
-}
-var dnLocks sync.Map
-var bindings = make(map[string]string)
+var bindings = make(map[string]BindingValue)
 var nullBindings = make(map[string]struct{})
 var bindingsMu sync.RWMutex
-var bindingPattern = regexp.MustCompile(`\$[A-Za-z0-9_.]+`)
 var db *sql.DB
 
 type pendingEntry struct {
 	entry   *TransformedEntry
 	deps    map[string]struct{}
 	rawDeps []string
+	group   *writeGroup
+	// missingBindings lists the binding keys the entry is waiting on.
+	missingBindings []string
 }
 
 type dependencyState struct {
@@ -154,18 +412,42 @@ type dependencyState struct {
 	synced  map[string]struct{}
 	pending map[string]*pendingEntry
 	reverse map[string]map[string]struct{}
+	// waitingSince records when a pending entry was first deferred, so the
+	// wait survives reprocessing.
+	waitingSince map[string]time.Time
+	// cycles holds the reported dependency cycles by signature, and
+	// cycleExempt the dependencies an entry skips to break a cycle.
+	cycles      map[string][]string
+	cycleExempt map[string]map[string]struct{}
 }
 
 func newDependencyState() *dependencyState {
 	return &dependencyState{
-		synced:  make(map[string]struct{}),
-		pending: make(map[string]*pendingEntry),
-		reverse: make(map[string]map[string]struct{}),
+		synced:       make(map[string]struct{}),
+		pending:      make(map[string]*pendingEntry),
+		reverse:      make(map[string]map[string]struct{}),
+		waitingSince: make(map[string]time.Time),
+		cycles:       make(map[string][]string),
+		cycleExempt:  make(map[string]map[string]struct{}),
 	}
 }
 
+// normalizeDN returns the canonical form of a DN used as a key by the
+// dependency tracker, DN locks and results maps. It parses the DN per RFC 4514
+// so escaping, spacing around separators and the order of multi-valued RDN
+// components do not produce distinct keys. Values are compared
+// case-insensitively. Strings that do not parse (for example templates with
+// unresolved bindings) fall back to trimming and lowercasing.
 func normalizeDN(dn string) string {
-	return strings.ToLower(strings.TrimSpace(dn))
+	trimmed := strings.TrimSpace(dn)
+	if trimmed == "" {
+		return ""
+	}
+	parsed, err := ldap.ParseDN(trimmed)
+	if err != nil {
+		return strings.ToLower(trimmed)
+	}
+	return strings.ToLower(parsed.String())
 }
 
 func sortedKeys(set map[string]struct{}) []string {
@@ -180,17 +462,19 @@ func sortedKeys(set map[string]struct{}) []string {
 	return keys
 }
 
-func getDNLock(dn string) *sync.Mutex {
-	key := normalizeDN(dn)
-	if key == "" {
-		key = dn
-	}
-	lock, _ := dnLocks.LoadOrStore(key, &sync.Mutex{})
-	return lock.(*sync.Mutex)
-}
-
 // initDB initializes the database connection and creates the searches table if it doesn't exist.
 func initDB(dbConfig DatabaseConfig) error {
+	if dbConfig.Driver == dbDriverSQLite {
+		sdb, err := openSQLite(dbConfig.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open SQLite database: %w", err)
+		}
+		db = sdb
+		configureDBPool(db, dbConfig)
+		logger.Info("SQLite database opened successfully", "Path", dbConfig.Path)
+		return nil
+	}
+
 	// Read password from file
 	passwordBytes, err := os.ReadFile(dbConfig.PasswordFile)
 	if err != nil {
@@ -220,13 +504,19 @@ func initDB(dbConfig DatabaseConfig) error {
 		sslMode,
 	)
 
-	db, err = sql.Open("postgres", dbURL)
+	db, err = sql.Open(dbDriverName(), dbURL)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 
-	// Test the connection
-	if err = db.Ping(); err != nil {
+	configureDBPool(db, dbConfig)
+
+	// Test the connection, waiting for a database that is still starting.
+	startupTimeout := time.Duration(dbConfig.StartupTimeoutSec) * time.Second
+	if startupTimeout <= 0 {
+		startupTimeout = 30 * time.Second
+	}
+	if err = pingDBWithBackoff(db, startupTimeout); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
@@ -241,12 +531,25 @@ func saveSearchToDB(id string, spec *SearchSpec) error {
 	}
 
 	insertSQL := `
-	INSERT INTO searches (id, filter, refresh, base_dn, oneshot, created_at, updated_at)
-	VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+	INSERT INTO searches (id, filter, refresh, base_dn, oneshot, transform, mapping, attributes, exclude_attributes, dry_run,
+	                      change_detection, rename, correlation_attribute, schedule, scope, deref, size_limit, time_limit,
+	                      run_once, suppress_hooks, completed_at, result_count, parent, parent_dn, owner, expires_at,
+	                      idle_expiry_s, last_change_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+	        $23, $24, $25, $26, $27, $28, NOW(), NOW())
 	ON CONFLICT (id) DO UPDATE
-	SET filter = $2, refresh = $3, base_dn = $4, oneshot = $5, updated_at = NOW();`
-
-	_, err := db.Exec(insertSQL, id, spec.Filter, spec.Refresh, spec.BaseDN, spec.Oneshot)
+	SET filter = $2, refresh = $3, base_dn = $4, oneshot = $5, transform = $6, mapping = $7,
+	    attributes = $8, exclude_attributes = $9, dry_run = $10, change_detection = $11, rename = $12,
+	    correlation_attribute = $13, schedule = $14, scope = $15, deref = $16, size_limit = $17, time_limit = $18,
+	    run_once = $19, suppress_hooks = $20, completed_at = $21, result_count = $22, parent = $23, parent_dn = $24,
+	    owner = $25, expires_at = $26, idle_expiry_s = $27, last_change_at = $28, updated_at = NOW();`
+
+	_, err := db.Exec(insertSQL, id, spec.Filter, spec.Refresh, spec.BaseDN, spec.RunOnce && spec.SuppressHooks, spec.Transform, spec.Mapping,
+		strings.Join(spec.Attributes, ","), strings.Join(spec.ExcludeAttributes, ","), spec.DryRun, spec.ChangeDetection,
+		spec.Rename, spec.CorrelationAttribute, spec.Schedule, spec.Scope, spec.Deref, spec.SizeLimit, spec.TimeLimit,
+		spec.RunOnce, spec.SuppressHooks, completedAtParam(spec), spec.ResultCount, spec.Parent, spec.ParentDN, spec.Owner,
+		sql.NullTime{Time: spec.ExpiresAt, Valid: !spec.ExpiresAt.IsZero()}, int(spec.IdleExpiry/time.Second),
+		sql.NullTime{Time: spec.LastChange, Valid: !spec.LastChange.IsZero()})
 	if err != nil {
 		return fmt.Errorf("failed to save search to database: %w", err)
 	}
@@ -261,7 +564,9 @@ func loadSearchesFromDB() (map[string]*SearchSpec, error) {
 		return nil, fmt.Errorf("database not initialized")
 	}
 
-	selectSQL := `SELECT id, filter, refresh, base_dn, oneshot FROM searches;`
+	selectSQL := `SELECT id, filter, refresh, base_dn, oneshot, transform, mapping, attributes, exclude_attributes, dry_run, change_detection, rename,
+	       correlation_attribute, schedule, last_run_at, scope, deref, size_limit, time_limit, run_once, suppress_hooks,
+	       completed_at, result_count, parent, parent_dn, owner, expires_at, idle_expiry_s, last_change_at FROM searches;`
 	rows, err := db.Query(selectSQL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query searches: %w", err)
@@ -270,23 +575,55 @@ func loadSearchesFromDB() (map[string]*SearchSpec, error) {
 
 	loadedSearches := make(map[string]*SearchSpec)
 	for rows.Next() {
-		var id, filter, baseDN string
-		var refresh int
-		var oneshot bool
-
-		if err := rows.Scan(&id, &filter, &refresh, &baseDN, &oneshot); err != nil {
+		var id, filter, baseDN, transform, mapping, attributes, excludeAttributes, changeDetection, correlationAttribute, schedule string
+		var scope, deref, parent, parentDN, owner string
+		var refresh, sizeLimit, timeLimit, resultCount, idleExpiry int
+		var oneshot, dryRun, rename bool
+		var runOnce, suppressHooks sql.NullBool
+		var lastRun, completedAt, expiresAt, lastChange sql.NullTime
+
+		if err := rows.Scan(&id, &filter, &refresh, &baseDN, &oneshot, &transform, &mapping, &attributes, &excludeAttributes, &dryRun, &changeDetection, &rename, &correlationAttribute,
+			&schedule, &lastRun, &scope, &deref, &sizeLimit, &timeLimit, &runOnce, &suppressHooks,
+			&completedAt, &resultCount, &parent, &parentDN, &owner, &expiresAt, &idleExpiry, &lastChange); err != nil {
 			logger.Error("Error scanning search row", "Err", err)
 			continue
 		}
 
 		stopChan := make(chan struct{})
 		spec := &SearchSpec{
-			Filter:  filter,
-			Refresh: refresh,
-			BaseDN:  baseDN,
-			Oneshot: oneshot,
-			Stop:    stopChan,
+			Filter:    filter,
+			Refresh:   refresh,
+			BaseDN:    baseDN,
+			Transform: transform,
+			Mapping:   mapping,
+			Stop:      stopChan,
+
+			Attributes:        parseAttributeList(attributes),
+			ExcludeAttributes: parseAttributeList(excludeAttributes),
+			DryRun:            dryRun,
+			ChangeDetection:   changeDetection,
+			Rename:            rename,
+
+			CorrelationAttribute: correlationAttribute,
+			Scope:                scope,
+			Deref:                deref,
+			SizeLimit:            sizeLimit,
+			TimeLimit:            timeLimit,
+			Schedule:             schedule,
+			LastRun:              lastRun.Time,
+			CompletedAt:          completedAt.Time,
+			ResultCount:          resultCount,
+			Parent:               parent,
+			ParentDN:             parentDN,
+			Owner:                owner,
+			ExpiresAt:            expiresAt.Time,
+			IdleExpiry:           time.Duration(idleExpiry) * time.Second,
+			LastChange:           lastChange.Time,
 		}
+		if spec.Parent != "" && spec.LastChange.IsZero() {
+			spec.LastChange = time.Now()
+		}
+		spec.RunOnce, spec.SuppressHooks = storedRunFlags(oneshot, runOnce, suppressHooks)
 		loadedSearches[id] = spec
 	}
 
@@ -314,11 +651,6 @@ func deleteSearchFromDB(id string) error {
 	return nil
 }
 
-func isMergeAttr(attr string) bool {
-	_, ok := mergeAttributes[strings.ToLower(attr)]
-	return ok
-}
-
 func isSliceValue(val interface{}) bool {
 	switch val.(type) {
 	case []interface{}, []string:
@@ -388,6 +720,15 @@ func getEntryAttributeValues(entry *ldap.Entry, attr string) []string {
 	return nil
 }
 
+func attributeNames(attributes map[string][]string) []string {
+	names := make([]string, 0, len(attributes))
+	for attr := range attributes {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func mergeUnique(existing, incoming []string) []string {
 	if len(existing) == 0 {
 		return append([]string{}, incoming...)
@@ -411,10 +752,10 @@ func mergeUnique(existing, incoming []string) []string {
 	return merged
 }
 
-func getBindingsSnapshot() (map[string]string, map[string]struct{}) {
+func getBindingsSnapshot() (map[string]BindingValue, map[string]struct{}) {
 	bindingsMu.RLock()
 	defer bindingsMu.RUnlock()
-	snapshot := make(map[string]string, len(bindings))
+	snapshot := make(map[string]BindingValue, len(bindings))
 	for k, v := range bindings {
 		snapshot[k] = v
 	}
@@ -425,15 +766,31 @@ func getBindingsSnapshot() (map[string]string, map[string]struct{}) {
 	return snapshot, nullSnapshot
 }
 
-func updateBindings(newBindings map[string]*string) {
+func updateBindings(newBindings map[string]*BindingValue, origin bindingOrigin) {
 	if len(newBindings) == 0 {
 		return
 	}
+	now := time.Now()
+	metas := make(map[string]bindingMeta)
+	for k := range newBindings {
+		if m, ok := newBindingMeta(k, origin, now); ok {
+			metas[k] = m
+		}
+		incCounter(mBindingUpdates, "namespace", bindingPrefix(k))
+	}
+	if err := persistBindings(newBindings, metas); err != nil {
+		depLogger.Error("Failed to persist bindings", "Err", err)
+	}
 	bindingsMu.Lock()
 	prevCount := len(bindings)
 	prevNullCount := len(nullBindings)
 	nullCount := 0
 	for k, v := range newBindings {
+		if m, ok := metas[k]; ok {
+			bindingMetas[k] = m
+		} else {
+			delete(bindingMetas, k)
+		}
 		if v == nil {
 			nullBindings[k] = struct{}{}
 			delete(bindings, k)
@@ -446,7 +803,7 @@ func updateBindings(newBindings map[string]*string) {
 	total := len(bindings)
 	totalNull := len(nullBindings)
 	bindingsMu.Unlock()
-	logger.Debug(
+	depLogger.Debug(
 		"Bindings updated",
 		"NewCount", len(newBindings),
 		"NullCount", nullCount,
@@ -458,49 +815,90 @@ func updateBindings(newBindings map[string]*string) {
 	dependencyTracker.reprocessPending()
 }
 
-func resolveString(input string, bindings map[string]string, nullBindings map[string]struct{}) (string, bool, bool) {
-	locs := bindingPattern.FindAllStringIndex(input, -1)
-	if len(locs) == 0 {
-		return input, false, false
+// expandString substitutes the bindings and template expressions referenced
+// in input (see templatefuncs.go). A reference to a list binding expands the
+// string into one value per element (every combination for several lists);
+// an empty list expands it to none. list reports whether a list binding was
+// referenced.
+func expandString(input string, bindings map[string]BindingValue, nullBindings map[string]struct{}) (out []string, list, missing, hasNull bool) {
+	refs := templateRefs(input)
+	if len(refs) == 0 {
+		return []string{input}, false, false, false
+	}
+	out = []string{""}
+	appendAll := func(s string) {
+		for i := range out {
+			out[i] += s
+		}
 	}
-	var b strings.Builder
-	b.Grow(len(input))
-	missing := false
-	hasNull := false
 	last := 0
-	for _, loc := range locs {
-		b.WriteString(input[last:loc[0]])
-		key := input[loc[0]+1 : loc[1]]
-		if val, ok := bindings[key]; ok {
-			b.WriteString(val)
-		} else if _, ok := nullBindings[key]; ok {
-			hasNull = true
-		} else {
+	for _, ref := range refs {
+		appendAll(input[last:ref.start])
+		values, isList, null, miss := ref.expr.eval(bindings, nullBindings)
+		switch {
+		case len(miss) > 0:
 			missing = true
-			b.WriteString(input[loc[0]:loc[1]])
+			hasNull = hasNull || null
+			appendAll(input[ref.start:ref.end])
+		case null:
+			hasNull = true
+		case isList:
+			list = true
+			expanded := make([]string, 0, len(out)*len(values))
+			for _, prefix := range out {
+				for _, v := range values {
+					expanded = append(expanded, prefix+v)
+				}
+			}
+			out = expanded
+		default:
+			appendAll(strings.Join(values, ""))
 		}
-		last = loc[1]
+		last = ref.end
+	}
+	appendAll(input[last:])
+	return out, list, missing, hasNull
+}
+
+// resolveString substitutes the bindings referenced in a single value. A
+// list binding must expand it to exactly one value: an empty list counts as
+// null and a longer one leaves the value unresolved.
+func resolveString(input string, bindings map[string]BindingValue, nullBindings map[string]struct{}) (string, bool, bool) {
+	out, _, missing, hasNull := expandString(input, bindings, nullBindings)
+	switch len(out) {
+	case 0:
+		return "", missing, true
+	case 1:
+		return out[0], missing, hasNull
 	}
-	b.WriteString(input[last:])
-	return b.String(), missing, hasNull
+	return input, true, hasNull
 }
 
-func resolveValue(val interface{}, bindings map[string]string, nullBindings map[string]struct{}) (interface{}, bool) {
+func resolveValue(val interface{}, bindings map[string]BindingValue, nullBindings map[string]struct{}) (interface{}, bool) {
 	switch v := val.(type) {
 	case string:
-		resolved, missing, _ := resolveString(v, bindings, nullBindings)
-		return resolved, missing
+		out, list, missing, _ := expandString(v, bindings, nullBindings)
+		if !list {
+			return out[0], missing
+		}
+		values := make([]interface{}, len(out))
+		for i, s := range out {
+			values[i] = s
+		}
+		return values, missing
 	case []interface{}:
 		out := make([]interface{}, 0, len(v))
 		missing := false
 		for _, item := range v {
 			if s, ok := item.(string); ok {
-				resolved, miss, hasNull := resolveString(s, bindings, nullBindings)
+				expanded, _, miss, hasNull := expandString(s, bindings, nullBindings)
 				missing = missing || miss
 				if hasNull {
 					continue
 				}
-				out = append(out, resolved)
+				for _, e := range expanded {
+					out = append(out, e)
+				}
 				continue
 			}
 			out = append(out, item)
@@ -510,12 +908,12 @@ func resolveValue(val interface{}, bindings map[string]string, nullBindings map[
 		out := make([]string, 0, len(v))
 		missing := false
 		for _, item := range v {
-			resolved, miss, hasNull := resolveString(item, bindings, nullBindings)
+			expanded, _, miss, hasNull := expandString(item, bindings, nullBindings)
 			missing = missing || miss
 			if hasNull {
 				continue
 			}
-			out = append(out, resolved)
+			out = append(out, expanded...)
 		}
 		return out, missing
 	default:
@@ -523,7 +921,7 @@ func resolveValue(val interface{}, bindings map[string]string, nullBindings map[
 	}
 }
 
-func resolveEntryTemplates(entry *TransformedEntry, bindings map[string]string, nullBindings map[string]struct{}) (*TransformedEntry, bool) {
+func resolveEntryTemplates(entry *TransformedEntry, bindings map[string]BindingValue, nullBindings map[string]struct{}) (*TransformedEntry, bool) {
 	resolvedDN, missingDN, dnNull := resolveString(entry.DN, bindings, nullBindings)
 	resolvedContent := make(map[string]interface{}, len(entry.Content))
 	missingContent := false
@@ -536,43 +934,41 @@ func resolveEntryTemplates(entry *TransformedEntry, bindings map[string]string,
 		missingDN = true
 	}
 	return &TransformedEntry{
-		DN:      resolvedDN,
-		Content: resolvedContent,
+		DN:       resolvedDN,
+		Content:  resolvedContent,
+		Search:   entry.Search,
+		Producer: entry.Producer,
+		Source:   entry.Source,
+
+		Correlation: entry.Correlation,
+		Origin:      entry.Origin,
 	}, missingDN || missingContent
 }
 
-func resolveDependencies(deps []string, bindings map[string]string, nullBindings map[string]struct{}) ([]string, bool) {
+func resolveDependencies(deps []string, bindings map[string]BindingValue, nullBindings map[string]struct{}) ([]string, bool) {
 	resolved := make([]string, 0, len(deps))
 	missing := false
 	for _, dep := range deps {
-		resolvedDep, miss, hasNull := resolveString(dep, bindings, nullBindings)
+		expanded, _, miss, hasNull := expandString(dep, bindings, nullBindings)
 		if hasNull {
 			continue
 		}
 		missing = missing || miss
-		resolved = append(resolved, resolvedDep)
+		resolved = append(resolved, expanded...)
 	}
 	return resolved, missing
 }
 
-func collectMissingBindingsFromString(input string, bindings map[string]string, nullBindings map[string]struct{}, missing map[string]struct{}) {
-	locs := bindingPattern.FindAllStringIndex(input, -1)
-	if len(locs) == 0 {
-		return
-	}
-	for _, loc := range locs {
-		key := input[loc[0]+1 : loc[1]]
-		if _, ok := bindings[key]; ok {
-			continue
-		}
-		if _, ok := nullBindings[key]; ok {
-			continue
+func collectMissingBindingsFromString(input string, bindings map[string]BindingValue, nullBindings map[string]struct{}, missing map[string]struct{}) {
+	for _, ref := range templateRefs(input) {
+		_, _, _, keys := ref.expr.eval(bindings, nullBindings)
+		for _, key := range keys {
+			missing[key] = struct{}{}
 		}
-		missing[key] = struct{}{}
 	}
 }
 
-func collectMissingBindingsFromValue(val interface{}, bindings map[string]string, nullBindings map[string]struct{}, missing map[string]struct{}) {
+func collectMissingBindingsFromValue(val interface{}, bindings map[string]BindingValue, nullBindings map[string]struct{}, missing map[string]struct{}) {
 	switch v := val.(type) {
 	case string:
 		collectMissingBindingsFromString(v, bindings, nullBindings, missing)
@@ -589,10 +985,19 @@ func collectMissingBindingsFromValue(val interface{}, bindings map[string]string
 	}
 }
 
-func collectMissingBindings(entry *TransformedEntry, deps []string, bindings map[string]string, nullBindings map[string]struct{}) []string {
+func collectMissingBindings(entry *TransformedEntry, deps []string, bindings map[string]BindingValue, nullBindings map[string]struct{}) []string {
 	missing := make(map[string]struct{})
 	if entry != nil {
 		collectMissingBindingsFromString(entry.DN, bindings, nullBindings, missing)
+		// A DN cannot expand to several values: list bindings holding more
+		// than one leave it unresolved.
+		for _, ref := range templateRefs(entry.DN) {
+			if values, list, _, _ := ref.expr.eval(bindings, nullBindings); list && len(values) > 1 {
+				for _, key := range ref.expr.keys() {
+					missing[key] = struct{}{}
+				}
+			}
+		}
 		for _, v := range entry.Content {
 			collectMissingBindingsFromValue(v, bindings, nullBindings, missing)
 		}
@@ -610,16 +1015,22 @@ func collectMissingBindings(entry *TransformedEntry, deps []string, bindings map
 	return keys
 }
 
-func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
+// handleEntry resolves an entry's templates and dependencies and either writes it,
+// hands it to its write group, or parks it until its dependencies are synced.
+func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string, group *writeGroup) {
 	parentKey := normalizeDN(entry.DN)
 	if parentKey == "" {
-		logger.Error("Transformed entry has empty DN; skipping dependency processing")
+		depLogger.Error("Transformed entry has empty DN; skipping dependency processing")
 		return
 	}
 
 	rawDeps := append([]string{}, deps...)
+	var supersededGroup *writeGroup
 	d.mu.Lock()
 	if existing, ok := d.pending[parentKey]; ok {
+		if existing.group != nil && existing.group != group {
+			supersededGroup = existing.group
+		}
 		if existing.entry != nil {
 			entry.Content = mergeEntryContent(existing.entry.Content, entry.Content)
 		}
@@ -639,10 +1050,14 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 	}
 	d.mu.Unlock()
 
+	if supersededGroup != nil && supersededGroup.drop(parentKey) {
+		d.commitGroup(supersededGroup)
+	}
+
 	bindingsSnapshot, nullSnapshot := getBindingsSnapshot()
 	resolvedEntry, entryMissing := resolveEntryTemplates(entry, bindingsSnapshot, nullSnapshot)
 	resolvedDeps, depsMissing := resolveDependencies(rawDeps, bindingsSnapshot, nullSnapshot)
-	logger.Debug(
+	depLogger.Debug(
 		"Resolved dependencies",
 		"DN", entry.DN,
 		"RawDeps", len(rawDeps),
@@ -654,12 +1069,35 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 	)
 
 	depSet := make(map[string]struct{})
+	depDNs := make(map[string]string)
+	groupDeps := make(map[string]struct{})
+	exempt := d.cycleExemptions(parentKey)
 	for _, dep := range resolvedDeps {
 		depKey := normalizeDN(dep)
 		if depKey == "" || depKey == parentKey {
 			continue
 		}
+		if _, ok := exempt[depKey]; ok {
+			continue
+		}
+		// Dependencies on other members of the same group are satisfied by
+		// write ordering within the group rather than by the synced set.
+		if group != nil && group.contains(depKey) {
+			groupDeps[depKey] = struct{}{}
+			continue
+		}
 		depSet[depKey] = struct{}{}
+		depDNs[depKey] = dep
+	}
+	if group != nil {
+		group.setDeps(parentKey, groupDeps)
+	}
+	d.verifyDependencies(depDNs)
+
+	var missingKeys []string
+	if entryMissing || depsMissing {
+		missingKeys = collectMissingBindings(entry, rawDeps, bindingsSnapshot, nullSnapshot)
+		sort.Strings(missingKeys)
 	}
 
 	d.mu.Lock()
@@ -672,7 +1110,7 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 	}
 	missingList := sortedKeys(missing)
 	resolvedList := sortedKeys(depSet)
-	logger.Debug(
+	depLogger.Debug(
 		"Dependency state for entry",
 		"DN", entry.DN,
 		"ResolvedDependencies", resolvedList,
@@ -681,19 +1119,34 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 	)
 
 	if len(missing) == 0 && !entryMissing && !depsMissing {
+		_, wasPending := d.waitingSince[parentKey]
+		delete(d.waitingSince, parentKey)
+		delete(d.cycleExempt, parentKey)
 		d.mu.Unlock()
-		if err := storeDestinationLDAP(resolvedEntry); err != nil {
-			logger.Error("Error storing entry in destination LDAP", "DN", resolvedEntry.DN, "Err", err)
+		if wasPending {
+			unpersistPending(parentKey)
+		}
+		if group != nil {
+			if group.markReady(parentKey, resolvedEntry) {
+				d.commitGroup(group)
+			}
 			return
 		}
-		d.markSyncedAndRelease(resolvedEntry.DN)
+		d.submitWrite(resolvedEntry, false)
 		return
 	}
 
 	d.pending[parentKey] = &pendingEntry{
-		entry:   entry,
-		deps:    missing,
-		rawDeps: rawDeps,
+		entry:           entry,
+		deps:            missing,
+		rawDeps:         rawDeps,
+		group:           group,
+		missingBindings: missingKeys,
+	}
+	since, ok := d.waitingSince[parentKey]
+	if !ok {
+		since = time.Now()
+		d.waitingSince[parentKey] = since
 	}
 	for depKey := range missing {
 		parents := d.reverse[depKey]
@@ -702,19 +1155,20 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 			d.reverse[depKey] = parents
 		}
 		parents[parentKey] = struct{}{}
-		logger.Debug("Adding dependency", "DN", entry.DN, "Dependency", depKey)
+		depLogger.Debug("Adding dependency", "DN", entry.DN, "Dependency", depKey)
 	}
-	logger.Debug(
+	depLogger.Debug(
 		"Pending entry stored",
 		"DN", entry.DN,
 		"MissingDependencies", missingList,
 		"MissingCount", len(missingList),
 	)
+	cycle := d.cyclePathLocked(parentKey)
 	d.mu.Unlock()
+	persistPending(parentKey, entry, rawDeps, since)
 
 	if entryMissing || depsMissing {
-		missingKeys := collectMissingBindings(entry, rawDeps, bindingsSnapshot, nullSnapshot)
-		logger.Info(
+		depLogger.Info(
 			"Deferred entry until bindings are resolved",
 			"DN", entry.DN,
 			"MissingDependencies", len(missing),
@@ -723,7 +1177,7 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 			"NullBindingsCount", len(nullSnapshot),
 		)
 	} else {
-		logger.Info(
+		depLogger.Info(
 			"Deferred entry until dependencies are synced",
 			"DN", entry.DN,
 			"MissingDependencies", len(missing),
@@ -731,6 +1185,9 @@ func (d *dependencyState) handleEntry(entry *TransformedEntry, deps []string) {
 			"NullBindingsCount", len(nullSnapshot),
 		)
 	}
+	if cycle != nil {
+		d.handleCycle(cycle)
+	}
 }
 
 func (d *dependencyState) reprocessPending() {
@@ -756,24 +1213,27 @@ func (d *dependencyState) reprocessPending() {
 	d.mu.Unlock()
 
 	if pendingCount > 0 {
-		logger.Debug("Reprocessing pending entries", "Count", pendingCount)
+		depLogger.Debug("Reprocessing pending entries", "Count", pendingCount)
 	}
 	for _, pending := range pendingEntries {
 		if pending.entry == nil {
 			continue
 		}
-		logger.Debug("Reprocessing pending entry", "DN", pending.entry.DN, "RawDeps", len(pending.rawDeps))
-		d.handleEntry(pending.entry, pending.rawDeps)
+		depLogger.Debug("Reprocessing pending entry", "DN", pending.entry.DN, "RawDeps", len(pending.rawDeps))
+		d.handleEntry(pending.entry, pending.rawDeps, pending.group)
 	}
 }
 
-func (d *dependencyState) markSyncedAndRelease(dn string) {
+// markSyncedAndRelease marks a DN as synced and releases the entries waiting
+// on it. It reports whether the DN was not already synced.
+func (d *dependencyState) markSyncedAndRelease(dn string) bool {
 	dnKey := normalizeDN(dn)
 	if dnKey == "" {
-		return
+		return false
 	}
 
 	var ready []*pendingEntry
+	var readyKeys []string
 	type depReleaseLog struct {
 		parentDN       string
 		resolvedDepDN  string
@@ -786,7 +1246,7 @@ func (d *dependencyState) markSyncedAndRelease(dn string) {
 	d.mu.Lock()
 	if _, exists := d.synced[dnKey]; exists {
 		d.mu.Unlock()
-		return
+		return false
 	}
 	d.synced[dnKey] = struct{}{}
 
@@ -814,14 +1274,17 @@ func (d *dependencyState) markSyncedAndRelease(dn string) {
 		})
 		if len(pending.deps) == 0 {
 			ready = append(ready, pending)
+			readyKeys = append(readyKeys, parentKey)
 			delete(d.pending, parentKey)
+			delete(d.waitingSince, parentKey)
+			delete(d.cycleExempt, parentKey)
 		}
 	}
 	d.mu.Unlock()
 
 	if len(parentDNs) > 0 {
 		sort.Strings(parentDNs)
-		logger.Debug(
+		depLogger.Debug(
 			"Dependency synced",
 			"DN", dn,
 			"Parents", len(parentDNs),
@@ -830,7 +1293,7 @@ func (d *dependencyState) markSyncedAndRelease(dn string) {
 		)
 	}
 	for _, logEntry := range releaseLogs {
-		logger.Debug(
+		depLogger.Debug(
 			"Dependency resolved for parent",
 			"ParentDN", logEntry.parentDN,
 			"ResolvedDependency", logEntry.resolvedDepDN,
@@ -840,27 +1303,32 @@ func (d *dependencyState) markSyncedAndRelease(dn string) {
 	}
 	if len(ready) > 0 {
 		bindingsSnapshot, nullSnapshot := getBindingsSnapshot()
-		for _, pending := range ready {
+		for i, pending := range ready {
 			if pending == nil || pending.entry == nil {
 				continue
 			}
 			resolvedEntry, missing := resolveEntryTemplates(pending.entry, bindingsSnapshot, nullSnapshot)
 			if missing {
-				logger.Info(
+				depLogger.Info(
 					"Deferred entry still missing bindings on release",
 					"DN", pending.entry.DN,
 				)
-				d.handleEntry(pending.entry, pending.rawDeps)
+				d.handleEntry(pending.entry, pending.rawDeps, pending.group)
 				continue
 			}
-			if err := storeDestinationLDAP(resolvedEntry); err != nil {
-				logger.Error("Error storing deferred entry in destination LDAP", "DN", resolvedEntry.DN, "Err", err)
+			// The entry is no longer pending, so it is not restored and
+			// written again after a restart.
+			unpersistPending(readyKeys[i])
+			if pending.group != nil {
+				if pending.group.markReady(normalizeDN(pending.entry.DN), resolvedEntry) {
+					d.commitGroup(pending.group)
+				}
 				continue
 			}
-			logger.Info("Storing deferred entry in destination LDAP", "DN", resolvedEntry.DN)
-			d.markSyncedAndRelease(resolvedEntry.DN)
+			d.submitWrite(resolvedEntry, true)
 		}
 	}
+	return true
 }
 
 // initLogger initializes the logger using log/slog.
@@ -874,109 +1342,96 @@ func initLogger(loglevel string) {
 	if lvlStr == "" {
 		lvlStr = "info"
 	}
-	var lvl slog.Level = slog.LevelInfo
-	switch strings.ToLower(lvlStr) {
-	case "debug":
-		lvl = slog.LevelDebug
-	case "warn":
-		lvl = slog.LevelWarn
-	case "error":
-		lvl = slog.LevelError
-	case "info":
-		lvl = slog.LevelInfo
-	}
-	h := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level:     lvl,
-		AddSource: true,
-	})
-	logger = slog.New(h)
+	logLevel.Set(parseLogLevel(lvlStr))
+	installLogger(slog.NewTextHandler(os.Stdout, logHandlerOptions()))
 	logger.Info("Logger initialized", "level", lvlStr)
 }
 
-// setLogLevel updates the global logger to the new level.
+// setLogLevel updates the level of every log sink.
 func setLogLevel(newLevel string) {
-	var lvl slog.Level = slog.LevelInfo
-	switch strings.ToLower(newLevel) {
-	case "debug":
-		lvl = slog.LevelDebug
-	case "warn":
-		lvl = slog.LevelWarn
-	case "error":
-		lvl = slog.LevelError
-	case "info":
-		lvl = slog.LevelInfo
-	}
-	h := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level:     lvl,
-		AddSource: true,
-	})
-	logger = slog.New(h)
+	logLevel.Set(parseLogLevel(newLevel))
 	logger.Info("Log level updated", "newLevel", newLevel)
 }
 
-// loadConfig reads the YAML config file
+// loadConfig reads the YAML config file, or the fragments of a config
+// directory
 func loadConfig(path string) error {
-	data, err := ioutil.ReadFile(path)
+	c, err := readConfigFile(path)
 	if err != nil {
 		return err
 	}
-	return yaml.Unmarshal(data, &config)
+	applied, err := applyConfigEnvOverrides(c)
+	if err != nil {
+		return fmt.Errorf("environment override %w", err)
+	}
+	if len(applied) > 0 {
+		logger.Info("Applied config overrides from the environment", "Variables", applied)
+	}
+	config = *c
+	return nil
 }
 
-// connectAndBindLDAP connects to the LDAP server using the source configuration and binds using the credentials.
-// Returns an established connection or an error.
-func connectAndBindLDAP() (*ldap.Conn, error) {
-	l, err := ldap.DialURL(config.Source.URL)
+// readConfigFile parses a YAML config file or directory, expanding
+// environment references, without installing it.
+func readConfigFile(path string) (*Config, error) {
+	data, err := readConfigData(path)
 	if err != nil {
 		return nil, err
 	}
-	if err = l.Bind(config.Source.BindDN, config.Source.BindPassword); err != nil {
-		l.Close()
-		return nil, err
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
-	return l, nil
+	return &c, nil
 }
 
-// performLDAPSearch performs an LDAP search using the provided connection, baseDN, and filter.
-func performLDAPSearch(l *ldap.Conn, baseDN, filter string) (*ldap.SearchResult, error) {
-	searchRequest := ldap.NewSearchRequest(
-		baseDN,
-		ldap.ScopeWholeSubtree,
-		ldap.NeverDerefAliases,
-		0,
-		0,
-		false,
-		filter,
-		[]string{"*"},
-		nil,
-	)
-	return l.Search(searchRequest)
+// connectAndBindLDAP connects to the LDAP server using the source configuration and binds using the credentials.
+// Returns an established connection or an error.
+func connectAndBindLDAP() (*ldap.Conn, error) {
+	if err := injectedLDAPError(config.Source.URL); err != nil {
+		return nil, err
+	}
+	l, err := dialLDAP(config.Source)
+	recordHealth(readySource, err)
+	return l, err
 }
 
-func storeDestinationLDAP(entry *TransformedEntry) error {
-	lock := getDNLock(entry.DN)
-	lock.Lock()
-	defer lock.Unlock()
+func storeDestinationLDAP(entry *TransformedEntry) (err error) {
+	// Writes into denied subtrees are dropped, not failed, so they are not
+	// retried from the dead-letter queue.
+	if isDeniedWrite("write", entry.DN) {
+		return nil
+	}
+
+	defer lockDN(entry.DN)()
+	defer targetMirror.invalidate(entry.DN)
+	defer func() {
+		if err == nil {
+			targetRetries.supersede(entry.DN)
+			if !isDryRun(entry) {
+				deadLetters.supersede(entry.DN)
+			}
+		}
+	}()
 
-	// Connect to destination LDAP.
-	l, err := ldap.DialURL(config.Target.URL)
+	// Get a bound destination connection (shared when pipelining is enabled).
+	l, release, err := acquireTargetConn()
 	if err != nil {
 		return err
 	}
-	defer l.Close()
+	defer func() { release(err) }()
 
-	// Bind with destination credentials.
-	if err = l.Bind(config.Target.BindDN, config.Target.BindPassword); err != nil {
+	// Move the entry first if the DN produced for its source entry changed.
+	if err = renameIfMoved(l, entry); err != nil {
 		return err
 	}
 
 	// Check if the entry exists.
 	searchAttrs := []string{"dn"}
-	if len(mergeAttributes) > 0 {
-		for attr := range mergeAttributes {
-			searchAttrs = append(searchAttrs, attr)
-		}
-	}
+	searchAttrs = append(searchAttrs, mergeAttributeNames()...)
+	searchAttrs = append(searchAttrs, policySearchAttributes()...)
+	searchAttrs = append(searchAttrs, checksumSearchAttributes()...)
+	searchAttrs = append(searchAttrs, schemaSearchAttributes()...)
 	searchRequest := ldap.NewSearchRequest(
 		entry.DN,
 		ldap.ScopeBaseObject,
@@ -1017,132 +1472,353 @@ func storeDestinationLDAP(entry *TransformedEntry) error {
 		default:
 			attributes[attr] = []string{fmt.Sprintf("%v", v)}
 		}
+		if isBinaryAttr(attr) {
+			if attributes[attr], err = decodeBinaryValues(attr, attributes[attr]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The checksum covers the content as produced, before merging with the
+	// target's values.
+	var checksum string
+	if checksumEnabled() {
+		checksum = entryChecksum(attributes)
 	}
 
 	// If the entry doesn't exist, add it.
 	if len(sr.Entries) == 0 {
+		if err = ensureObjectClass(entry, attributes); err != nil {
+			return err
+		}
+		stampOwnershipMarker(attributes, true)
+		if checksum != "" {
+			stampChecksum(attributes, checksum)
+		}
+		if err = checkSchema(l, entry, "add", attributes, nil); err != nil {
+			return err
+		}
+		if isDryRun(entry) {
+			return previewTargetChange(l, entry, "add", attributes)
+		}
 		addReq := ldap.NewAddRequest(entry.DN, nil)
 		for attr, values := range attributes {
 			addReq.Attribute(attr, values)
 		}
-		// Optionally, ensure an objectClass is set.
-		if _, exists := attributes["objectClass"]; !exists {
-			addReq.Attribute("objectClass", []string{"top", "inetOrgPerson"})
+		waitTargetWrite()
+		err = l.Add(addReq)
+		if isNoSuchObject(err) && config.CreateParents.Enabled {
+			if err = ensureParents(l, entry); err == nil {
+				waitTargetWrite()
+				err = l.Add(addReq)
+			}
 		}
-		if err = l.Add(addReq); err != nil {
+		journalWrite(entry, "add", attributes, nil, err)
+		if err != nil {
 			return err
 		}
 		logger.Info("Added entry to destination LDAP", "DN", entry.DN)
+		verifyTargetWrite(l, entry, "add", attributes)
+		rememberManagedValues(entry, attributes)
+		recordDNMapping(entry)
+		notifyProvisioned(entry, attributes)
+		recordProvenance(entry, "add", attributeNames(attributes))
 	} else {
 		entryData := sr.Entries[0]
+		if checksum != "" && config.Checksum.SkipUnchanged && storedChecksum(entryData) == checksum {
+			incCounter(mChecksumUnchanged)
+			logger.Debug("Entry unchanged in destination LDAP", "DN", entry.DN)
+			recordDNMapping(entry)
+			return nil
+		}
+		dryRun := isDryRun(entry)
+		if !dryRun {
+			if err = checkPolicy(policyModify, entry.DN, entryData); err != nil {
+				return err
+			}
+		}
+		written := make(map[string][]string)
 		for attr, values := range attributes {
-			if !isMergeAttr(attr) {
+			// Password hashes are always replaced, never merged.
+			if isPasswordAttr(attr) {
+				written[attr] = values
+				continue
+			}
+			strategy, ok := mergeStrategy(attr)
+			if !ok {
 				if _, ok := aggregateAttrs[attr]; !ok {
 					continue
 				}
+				strategy = mergeUnion
 			}
-			if len(values) == 0 {
-				continue
-			}
+			written[attr] = values
 			existing := getEntryAttributeValues(entryData, attr)
-			if len(existing) == 0 {
+			merged, keep := mergeValues(strategy, entry.DN, attr, managedContributors(entry), existing, values)
+			if !keep {
+				delete(attributes, attr)
 				continue
 			}
-			attributes[attr] = mergeUnique(existing, values)
+			attributes[attr] = merged
+		}
+		stampOwnershipMarker(attributes, false)
+		if checksum != "" {
+			stampChecksum(attributes, checksum)
+		}
+		if err = checkSchema(l, entry, "modify", attributes, entryData); err != nil {
+			return err
+		}
+		if dryRun {
+			return previewTargetChange(l, entry, "modify", attributes)
 		}
 		// If the entry exists, update it.
+		before := journalBefore(l, entry.DN, attributes)
 		modReq := ldap.NewModifyRequest(entry.DN, nil)
 		for attr, values := range attributes {
 			modReq.Replace(attr, values)
 		}
-		if err = l.Modify(modReq); err != nil {
+		waitTargetWrite()
+		err = l.Modify(modReq)
+		journalWrite(entry, "modify", attributes, before, err)
+		if err != nil {
 			return err
 		}
 		logger.Info("Modified entry in destination LDAP", "DN", entry.DN)
+		verifyTargetWrite(l, entry, "modify", attributes)
+		rememberManagedValues(entry, written)
+		recordDNMapping(entry)
+		recordProvenance(entry, "modify", attributeNames(attributes))
 	}
 	return nil
 }
 
 // ldapSearchAndSync performs the LDAP search on the source server and synchronizes the results.
-func ldapSearchAndSync(id, filter, baseDN string, refresh int, oneshot bool, stopChan chan struct{}) {
+// The spec is a private copy; updates to the search restart the goroutine with a fresh copy.
+func ldapSearchAndSync(id string, spec SearchSpec) {
+	defer trackSearchLoop(id)()
+	stopChan := spec.Stop
+	timer := newSearchTimer(id, &spec)
+	var cursor changelogCursor
+	if wait := timer.untilFirst(); wait > 0 {
+		syncLogger.Info("Waiting before the first search run", "SearchId", id, "Schedule", spec.Schedule, "Wait", wait.Round(time.Second))
+		select {
+		case <-stopChan:
+			syncLogger.Info("Search cancelled", "SearchId", id)
+			return
+		case <-time.After(wait):
+		}
+	}
 	for {
 		select {
 		case <-stopChan:
-			logger.Info("Search cancelled", "SearchId", id)
+			syncLogger.Info("Search cancelled", "SearchId", id)
 			return
 		default:
 		}
 
-		logger.Debug("Performing LDAP search with filter", "Filter", filter, "SearchId", id, "BaseDN", baseDN)
+		release := searchSlots.acquire(id, spec.Parent, stopChan)
+		if release == nil {
+			syncLogger.Info("Search cancelled", "SearchId", id)
+			return
+		}
+		syncLogger.Debug("Performing LDAP search with filter", "Filter", spec.Filter, "SearchId", id, "BaseDN", spec.BaseDN)
+		mode := runModeFull
+		if spec.ChangeDetection == changeDetectionChangelog && cursor.valid {
+			mode = runModeChangelog
+		}
+		run := startSearchRun(id, mode)
 		l, err := connectAndBindLDAP()
 		if err != nil {
-			logger.Error("Error connecting and binding to LDAP", "Err", err)
+			syncLogger.Error("Error connecting and binding to LDAP", "Err", err)
+			run.finish(err)
+			release()
 			select {
 			case <-stopChan:
 				return
-			case <-time.After(time.Duration(refresh) * time.Second):
+			case <-time.After(timer.untilRetry()):
 			}
 			continue
 		}
 
-		sr, err := performLDAPSearch(l, baseDN, filter)
-		if err != nil {
-			logger.Error("Error performing search", "Err", err)
+		if spec.ChangeDetection == changeDetectionChangelog && cursor.valid {
+			err := syncFromChangelog(l, id, &spec, &cursor)
+			if err != nil {
+				syncLogger.Error("Changelog sync failed; falling back to a full search", "SearchId", id, "Err", err)
+				cursor.valid = false
+			} else {
+				timer.completed()
+			}
+			run.finish(err)
 			l.Close()
+			release()
 			select {
 			case <-stopChan:
+				syncLogger.Debug("Search cancelled", "SearchId", id)
 				return
-			case <-time.After(time.Duration(refresh) * time.Second):
+			case <-time.After(timer.untilNext()):
 			}
 			continue
 		}
-		l.Close()
 
-		for _, entry := range sr.Entries {
-			processLDAPEntry(id, entry, oneshot)
+		// Read the change number before the baseline search so changes made
+		// while it runs are applied afterwards.
+		if spec.ChangeDetection == changeDetectionChangelog && !spec.RunOnce {
+			if last, err := readLastChangeNumber(l); err != nil {
+				syncLogger.Error("Error reading source changelog; polling this cycle", "SearchId", id, "Err", err)
+			} else {
+				cursor = changelogCursor{last: last, valid: true}
+			}
 		}
 
-		// If one-shot mode is active, exit after one iteration.
-		if oneshot {
-			logger.Info("One-shot search completed", "SearchId", id)
+		changed := false
+		seen := make(map[string]struct{})
+		// Entries with ranged attributes are completed once the stream is
+		// done, as the connection cannot be used while it runs.
+		var ranged []*ldap.Entry
+		err = streamLDAPSearch(l, sourceSearchRequest(id, &spec), stopChan, func(entry *ldap.Entry) {
+			seen[normalizeDN(entry.DN)] = struct{}{}
+			if hasRangedAttributes(entry) {
+				ranged = append(ranged, entry)
+				return
+			}
+			completeRangedAttributes(l, entry) // Only renames complete ranges.
+			if processLDAPEntry(id, entry, &spec) {
+				changed = true
+			}
+		})
+		// A run cut short by its limits is complete as far as it goes.
+		limited := err != nil && searchLimitExceeded(id, err)
+		if limited {
+			err = nil
+		}
+		for _, entry := range ranged {
+			if err != nil {
+				break
+			}
+			if err = completeRangedAttributes(l, entry); err == nil && processLDAPEntry(id, entry, &spec) {
+				changed = true
+			}
+		}
+		if errors.Is(err, errSearchStopped) {
+			// An abandoned run is not recorded in the search's stats.
+			l.Close()
+			release()
+			syncLogger.Info("Search cancelled", "SearchId", id)
+			return
+		}
+		if err != nil {
+			cursor.valid = false
+			syncLogger.Error("Error performing search", "Err", err)
+			run.finish(err)
+			l.Close()
+			release()
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(timer.untilRetry()):
+			}
+			continue
+		}
+		l.Close()
+		if !limited {
+			// Only a complete run shows which entries left the search.
+			forgetUnseenResults(id, seen)
+			recordParentEntries(id, seen)
+		}
+		if changed && spec.Parent != "" {
+			markDerivedActive(id)
+		}
+		run.finish(nil)
+		release()
+		timer.completed()
+		timer.polled(changed)
+
+		// A run-once search exits after one complete iteration.
+		if spec.RunOnce {
+			markSearchCompleted(id, spec.Stop)
 			return
 		}
 
 		select {
 		case <-stopChan:
-			logger.Debug("Search cancelled", "SearchId", id)
+			syncLogger.Debug("Search cancelled", "SearchId", id)
 			return
-		case <-time.After(time.Duration(refresh) * time.Second):
+		case <-time.After(timer.untilNext()):
 		}
 	}
 }
 
-// processHookResponse is a stub for processing the hook response.
-func processHookResponse(hookResp HookResponse) {
-	// Log the parsed hook response values.
-	logger.Debug("Processing Hook response", "Transformed", hookResp.Transformed, "Derived", hookResp.Derived, "Reset", hookResp.Reset)
+// processHookResponse applies a hook response produced by producer (a hook
+// URL or transform) for the result of the given search read from source.
+func processHookResponse(hookResp HookResponse, searchID, producer string, source sourceRef) {
+	// Log the shape of the response only: its content may hold password
+	// hashes.
+	hookLogger.Debug("Processing Hook response", "Transformed", len(hookResp.Transformed), "Derived", len(hookResp.Derived), "Reset", hookResp.Reset)
 
 	if len(hookResp.Bindings) > 0 {
-		logger.Debug("Hook bindings received", "Count", len(hookResp.Bindings))
-		updateBindings(hookResp.Bindings)
+		hookLogger.Debug("Hook bindings received", "Count", len(hookResp.Bindings))
+		updateBindings(hookResp.Bindings, bindingOrigin{search: searchID, source: source.DN, hook: producer, ttls: hookResp.BindingTTLs})
 	}
 
 	// Process the transformed element (if present).
 	if len(hookResp.Transformed) > 0 {
-		for i := range hookResp.Transformed {
-			transformed := hookResp.Transformed[i]
-			logger.Debug("Processing transformed hook response for DN", "DN", transformed.DN)
-			dependencyTracker.handleEntry(&transformed, hookResp.Dependencies)
+		entries := restorePasswords(hookEntries(hookResp.Transformed), source.passwords)
+		for i := range entries {
+			entries[i].Search = searchID
+			entries[i].Producer = producer
+			entries[i].Origin = source.DN
+			if len(entries) == 1 {
+				entries[i].Source = source.DN
+				entries[i].Correlation = source.Correlation
+			}
+		}
+		// Entries returned together are written together.
+		var group *writeGroup
+		if len(entries) > 1 || hookResp.Atomic {
+			group = newWriteGroup(entries, hookResp.Dependencies, 0, hookResp.Atomic)
+		}
+		for i := range entries {
+			transformed := entries[i]
+			hookLogger.Debug("Processing transformed hook response for DN", "DN", transformed.DN)
+			dependencyTracker.handleEntry(&transformed, hookResp.Dependencies, group)
 		}
 	} else {
-		logger.Info("No transformed data in hook response")
+		hookLogger.Info("No transformed data in hook response")
 	}
 
 	// Process each derived search provided.
 	for _, ds := range hookResp.Derived {
+		if _, err := ldap.CompileFilter(ds.Filter); err != nil {
+			hookLogger.Error("Derived search has an invalid filter", "SearchId", ds.ID, "Filter", ds.Filter, "Err", err)
+			continue
+		}
+		if ds.Transform != "" && !transformExists(ds.Transform) {
+			hookLogger.Error("Derived search references unknown transform", "SearchId", ds.ID, "Transform", ds.Transform)
+			continue
+		}
+		if ds.Mapping != "" && !mappingExists(ds.Mapping) {
+			hookLogger.Error("Derived search references unknown mapping", "SearchId", ds.ID, "Mapping", ds.Mapping)
+			continue
+		}
+		if !validChangeDetection(ds.ChangeDetection) {
+			hookLogger.Error("Derived search has unknown change detection mode", "SearchId", ds.ID, "ChangeDetection", ds.ChangeDetection)
+			continue
+		}
+		if ds.Schedule != "" {
+			if _, err := parseCronSchedule(ds.Schedule); err != nil {
+				hookLogger.Error("Derived search has an invalid schedule", "SearchId", ds.ID, "Schedule", ds.Schedule, "Err", err)
+				continue
+			}
+		}
+		if err := validateSearchOptions(ds.Scope, ds.Deref, ds.SizeLimit, ds.TimeLimit); err != nil {
+			hookLogger.Error("Derived search has invalid search options", "SearchId", ds.ID, "Err", err)
+			continue
+		}
 		searchesMu.RLock()
 		spec, exists := searches[ds.ID]
 		searchesMu.RUnlock()
+		if exists && !claimDerivedSearch(spec, ds, producer, source) {
+			continue
+		}
 		if exists {
 			// Update existing search.
 			close(spec.Stop)
@@ -1150,20 +1826,52 @@ func processHookResponse(hookResp HookResponse) {
 			spec.Filter = ds.Filter
 			spec.Refresh = ds.Refresh
 			spec.BaseDN = ds.BaseDN
-			spec.Oneshot = ds.Oneshot
+			spec.RunOnce, spec.SuppressHooks = derivedRunFlags(ds)
+			spec.CompletedAt, spec.ResultCount = time.Time{}, 0
+			spec.Transform = ds.Transform
+			spec.Mapping = ds.Mapping
+			spec.Attributes = ds.Attributes
+			spec.ExcludeAttributes = ds.ExcludeAttributes
+			spec.DryRun = ds.DryRun
+			spec.ChangeDetection = ds.ChangeDetection
+			spec.Rename = ds.Rename
+			spec.CorrelationAttribute = ds.CorrelationAttribute
+			spec.Scope = ds.Scope
+			spec.Deref = ds.Deref
+			spec.SizeLimit = ds.SizeLimit
+			spec.TimeLimit = ds.TimeLimit
+			spec.Schedule = ds.Schedule
+			deriveExpiry(spec, ds, searchID, producer, source)
 			spec.Stop = stopChan
-			go ldapSearchAndSync(ds.ID, ds.Filter, ds.BaseDN, ds.Refresh, ds.Oneshot, stopChan)
-			logger.Info("Derived search updated", "SearchId", ds.ID)
+			saveDerivedSearch(ds.ID, spec)
+			go ldapSearchAndSync(ds.ID, *spec)
+			hookLogger.Info("Derived search updated", "SearchId", ds.ID)
 		} else {
 			// Create a new search.
 			stopChan := make(chan struct{})
 			spec := &SearchSpec{
-				Filter:  ds.Filter,
-				Refresh: ds.Refresh,
-				BaseDN:  ds.BaseDN,
-				Oneshot: ds.Oneshot,
-				Stop:    stopChan,
+				Filter:    ds.Filter,
+				Refresh:   ds.Refresh,
+				BaseDN:    ds.BaseDN,
+				Transform: ds.Transform,
+				Mapping:   ds.Mapping,
+				Stop:      stopChan,
+
+				Attributes:        ds.Attributes,
+				ExcludeAttributes: ds.ExcludeAttributes,
+				DryRun:            ds.DryRun,
+				ChangeDetection:   ds.ChangeDetection,
+				Rename:            ds.Rename,
+
+				CorrelationAttribute: ds.CorrelationAttribute,
+				Scope:                ds.Scope,
+				Deref:                ds.Deref,
+				SizeLimit:            ds.SizeLimit,
+				TimeLimit:            ds.TimeLimit,
+				Schedule:             ds.Schedule,
 			}
+			spec.RunOnce, spec.SuppressHooks = derivedRunFlags(ds)
+			deriveExpiry(spec, ds, searchID, producer, source)
 			searchesMu.Lock()
 			searches[ds.ID] = spec
 			searchesMu.Unlock()
@@ -1171,20 +1879,22 @@ func processHookResponse(hookResp HookResponse) {
 			searchResultsMu.Lock()
 			searchResults[ds.ID] = make(map[string]LDAPResult)
 			searchResultsMu.Unlock()
-			go ldapSearchAndSync(ds.ID, ds.Filter, ds.BaseDN, ds.Refresh, ds.Oneshot, stopChan)
-			logger.Info("Derived search created", "SearchId", ds.ID)
+			saveDerivedSearch(ds.ID, spec)
+			go ldapSearchAndSync(ds.ID, *spec)
+			hookLogger.Info("Derived search created", "SearchId", ds.ID)
 		}
 	}
 	// Process the reset directive.
 	if hookResp.Reset {
 		// TODO: Reset is a legacy workaround; dependency handling should eventually make this obsolete.
-		logger.Info("Reset directive received. Discarding internal search results")
+		hookLogger.Info("Reset directive received. Discarding internal search results")
 		// Clear all internal search results.
 		searchResultsMu.Lock()
 		for id := range searchResults {
 			searchResults[id] = make(map[string]LDAPResult)
 		}
 		searchResultsMu.Unlock()
+		unpersistResults("", "")
 	}
 }
 
@@ -1202,11 +1912,8 @@ func decodeHookResponses(body []byte) ([]HookResponse, error) {
 	return nil, fmt.Errorf("invalid hook response: expected object or array")
 }
 
-// postToHookWithRetry posts to a hook URL with exponential backoff retry logic.
-func postToHookWithRetry(hookURL string, payload []byte) (*http.Response, error) {
-	const backoffFactor = 2.0
-
-	// Get retry configuration with defaults
+// hookRetrySettings returns the hook retry configuration with defaults applied.
+func hookRetrySettings() (int, time.Duration, time.Duration) {
 	maxRetries := config.HookRetry.MaxRetries
 	if maxRetries == 0 {
 		maxRetries = 10
@@ -1219,19 +1926,41 @@ func postToHookWithRetry(hookURL string, payload []byte) (*http.Response, error)
 	if maxDelayMs == 0 {
 		maxDelayMs = 30000
 	}
+	return maxRetries, time.Duration(initialDelayMs) * time.Millisecond, time.Duration(maxDelayMs) * time.Millisecond
+}
+
+// postToHookWithRetry posts to a hook URL with exponential backoff retry
+// logic. It returns the response only for a 2xx status; see hookretry.go
+// for which failures are retried.
+func postToHookWithRetry(hookURL, searchID string, payload []byte) (*http.Response, error) {
+	const backoffFactor = 2.0
 
-	initialDelay := time.Duration(initialDelayMs) * time.Millisecond
-	maxDelay := time.Duration(maxDelayMs) * time.Millisecond
+	// Get retry configuration with defaults
+	maxRetries, initialDelay, maxDelay := hookRetrySettings()
+	budget := hookRetryBudget()
+	start := time.Now()
 
 	var lastErr error
+	var retryAfter time.Duration
+	var retryReason string
 	delay := initialDelay
+	attempts := 0
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			// Add jitter to prevent thundering herd (±10%)
 			jitter := time.Duration(float64(delay) * 0.1)
 			sleepTime := delay + time.Duration(float64(jitter)*(2.0*float64(time.Now().UnixNano()%1000)/1000.0-1.0))
-			logger.Debug("Retrying hook request", "URL", hookURL, "Attempt", attempt+1, "Delay", sleepTime)
+			if retryAfter > sleepTime {
+				sleepTime = retryAfter
+			}
+			if retryAfter > maxDelay || (budget > 0 && time.Since(start)+sleepTime > budget) {
+				hookLogger.Warn("Giving up on hook request: retry would exceed the retry budget",
+					"URL", hookURL, "Attempt", attempt, "Delay", sleepTime)
+				break
+			}
+			incCounter(mHookRetries, "hook", hookURL, "reason", retryReason)
+			hookLogger.Debug("Retrying hook request", "URL", hookURL, "Attempt", attempt+1, "Delay", sleepTime)
 			time.Sleep(sleepTime)
 
 			// Exponential backoff with cap
@@ -1241,72 +1970,184 @@ func postToHookWithRetry(hookURL string, payload []byte) (*http.Response, error)
 			}
 		}
 
-		resp, err := http.Post(hookURL, "application/json", bytes.NewBuffer(payload))
+		req, err := http.NewRequest(http.MethodPost, hookURL, bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		setHookProtocol(req, hookURL)
+		if err := setHookIdentity(req, hookURL, searchID); err != nil {
+			return nil, fmt.Errorf("signing hook identity: %w", err)
+		}
+		attempts++
+		resp, err := injectedHookResponse(hookURL)
+		if resp == nil && err == nil {
+			resp, err = hookHTTPClient(hookURL).Do(req)
+		}
+		retryReason, retryAfter = "transport", 0
 		if err == nil {
-			return resp, nil
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return resp, nil
+			}
+			statusErr := &hookStatusError{Code: resp.StatusCode, Status: resp.Status}
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if !hookRetryableStatus(resp.StatusCode) {
+				return nil, statusErr
+			}
+			err = statusErr
+			retryReason = strconv.Itoa(resp.StatusCode)
 		}
 
 		lastErr = err
 		if attempt < maxRetries {
-			logger.Warn("Hook request failed, will retry", "URL", hookURL, "Attempt", attempt+1, "Err", err)
+			hookLogger.Warn("Hook request failed, will retry", "URL", hookURL, "Attempt", attempt+1, "Err", err)
 		}
 	}
 
-	return nil, fmt.Errorf("failed after %d attempts: %w", maxRetries+1, lastErr)
+	return nil, fmt.Errorf("failed after %d attempts: %w", attempts, lastErr)
 }
 
 // sendHooks posts the LDAP result to each URL specified in config.Hooks.
-func sendHooks(result LDAPResult) {
-	payload, err := json.Marshal(result)
-	if err != nil {
-		logger.Error("Error marshalling hook payload for DN", "DN", result.DN, "Err", err)
-		return
-	}
+// source identifies the source entry, whose DN differs from result.DN when a
+// mapping ran first.
+func sendHooks(searchID string, source sourceRef, result LDAPResult) {
+	req := newHookRequest(searchID, result)
+	source.passwords = hidePasswords(&req)
 	for _, url := range config.Hooks {
+		if !hookAccepts(url, req.ChangeType) || !hookWants(url, result) {
+			continue
+		}
+		if holdForDegradedHook(url, searchID, source, req) {
+			continue
+		}
 		// Launch each hook call concurrently.
-		go func(hookURL string) {
-			resp, err := postToHookWithRetry(hookURL, payload)
-			if err != nil {
-				logger.Error("Error posting to hook after retries", "URL", hookURL, "Err", err)
-				return
-			}
-			defer resp.Body.Close()
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				logger.Error("Error reading hook response", "URL", hookURL, "Err", err)
-				return
-			}
+		dispatchToHook(url, searchID, source, req, true)
+	}
+}
 
-			hookResps, err := decodeHookResponses(body)
-			if err != nil {
-				logger.Error("Hook response decode failed", "URL", hookURL, "Err", err)
-				return
+// dispatchToHook adds a request to the hook's batch, or posts it on its
+// own, in a new goroutine when async is set.
+func dispatchToHook(hookURL, searchID string, source sourceRef, req HookRequest, async bool) {
+	if cached, ok := cachedHookResponses(hookURL, hookCacheKey(hookURL, searchID, req)); ok {
+		hookLogger.Debug("Using cached hook response", "URL", hookURL, "DN", req.DN)
+		process := func() {
+			for _, hookResp := range cached {
+				processHookResponse(hookResp, searchID, hookURL, source)
 			}
+		}
+		if async {
+			go process()
+		} else {
+			process()
+		}
+		return
+	}
+	if hookBatchable(hookURL) {
+		hookBatches.add(hookURL, searchID, source, req)
+		return
+	}
+	payload, err := encodeHookPayload(hookURL, []HookRequest{req}, false)
+	if err != nil {
+		hookLogger.Error("Error marshalling hook payload for DN", "DN", req.DN, "Err", err)
+		return
+	}
+	if async {
+		go deliverToHook(hookURL, searchID, payload, []HookRequest{req}, []sourceRef{source})
+	} else {
+		deliverToHook(hookURL, searchID, payload, []HookRequest{req}, []sourceRef{source})
+	}
+}
 
-			for _, hookResp := range hookResps {
-				processHookResponse(hookResp)
-			}
-		}(url)
+// deliverToHook posts payload (one result, or a batch of them) to a hook and
+// processes its responses. reqs and sources hold the request and source of
+// each posted result; a batch's responses are attributed to them only when
+// there is one response per result.
+func deliverToHook(hookURL, searchID string, payload []byte, reqs []HookRequest, sources []sourceRef) {
+	resp, err := postToHookWithRetry(hookURL, searchID, payload)
+	countHookCall(searchID, err)
+	if err != nil {
+		hookLogger.Error("Error posting to hook after retries", "URL", hookURL, "Err", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		hookLogger.Error("Error reading hook response", "URL", hookURL, "Err", err)
+		return
+	}
+
+	noteHookVersion(hookURL, resp.Header, body)
+	if !checkHookResponse(hookURL, searchID, sources, body) {
+		return
+	}
+	hookResps, err := decodeHookResponses(body)
+	if err != nil {
+		hookLogger.Error("Hook response decode failed", "URL", hookURL, "Err", err)
+		return
+	}
+	switch {
+	case len(reqs) == 1:
+		cacheHookResponses(hookURL, hookCacheKey(hookURL, searchID, reqs[0]), hookResps)
+	case len(hookResps) == len(reqs):
+		for i, req := range reqs {
+			cacheHookResponses(hookURL, hookCacheKey(hookURL, searchID, req), hookResps[i:i+1])
+		}
+	}
+
+	for i, hookResp := range hookResps {
+		var source sourceRef
+		switch {
+		case len(sources) == 1:
+			source = sources[0]
+		case len(hookResps) == len(sources):
+			source = sources[i]
+		}
+		processHookResponse(hookResp, searchID, hookURL, source)
 	}
 }
 
-// processLDAPEntry processes a single LDAP entry, updating the searchResults
-// for the given search id. It builds a structured attribute map, and logs whether
-// the entry is new, updated, or unchanged.
-func processLDAPEntry(id string, entry *ldap.Entry, oneshot bool) {
-	dn := entry.DN
+// resultContent converts a source entry to result content: single values as
+// strings, multiple values as lists, binary values base64-encoded, excluded
+// attributes and passwords the search does not sync left out.
+func resultContent(id string, entry *ldap.Entry, spec *SearchSpec) map[string]interface{} {
 	attrMap := make(map[string]interface{})
+	syncPasswords := syncsPasswords(id)
 	for _, attr := range entry.Attributes {
-		if len(attr.Values) == 1 {
-			attrMap[attr.Name] = attr.Values[0]
+		if isExcludedAttr(attr.Name, spec.ExcludeAttributes) {
+			continue
+		}
+		if !syncPasswords && isPasswordAttr(attr.Name) {
+			continue
+		}
+		values := attr.Values
+		if isBinaryAttr(attr.Name) {
+			values = encodeBinaryValues(attr.ByteValues)
+		}
+		if len(values) == 1 {
+			attrMap[attr.Name] = values[0]
 		} else {
-			attrMap[attr.Name] = attr.Values
+			attrMap[attr.Name] = values
 		}
 	}
+	return attrMap
+}
+
+// processLDAPEntry processes a single LDAP entry, updating the searchResults
+// for the given search id. It builds a structured attribute map, and logs whether
+// the entry is new, updated, or unchanged. New and changed entries are
+// dispatched, and reported by the return value.
+func processLDAPEntry(id string, entry *ldap.Entry, spec *SearchSpec) bool {
+	dn := entry.DN
+	attrMap := resultContent(id, entry, spec)
 
 	newResult := LDAPResult{
 		DN:      dn,
 		Content: attrMap,
+
+		correlation: correlationKey(id, spec, entry),
+		touched:     time.Now().UnixNano(),
 	}
 
 	var shouldSend bool
@@ -1316,35 +2157,103 @@ func processLDAPEntry(id string, entry *ldap.Entry, oneshot bool) {
 	results, ok := searchResults[id]
 	if !ok {
 		searchResultsMu.Unlock()
-		logger.Warn("Search results missing for id", "SearchId", id, "DN", dn)
-		return
+		syncLogger.Warn("Search results missing for id", "SearchId", id, "DN", dn)
+		return false
 	}
 
-	if existing, exists := results[dn]; !exists {
-		results[dn] = newResult
+	resultKey := newResult.correlation
+	if resultKey == "" {
+		resultKey = normalizeDN(dn)
+	}
+	migratedFrom := ""
+	if _, exists := results[resultKey]; !exists && newResult.correlation != "" {
+		// A search that starts identifying entries by a correlation
+		// attribute keeps the results cached under their DN.
+		if existing, ok := results[normalizeDN(dn)]; ok {
+			migratedFrom = normalizeDN(dn)
+			delete(results, migratedFrom)
+			results[resultKey] = existing
+		}
+	}
+	if existing, exists := results[resultKey]; !exists {
+		newResult.changeType = changeTypeAdd
+		results[resultKey] = cachedResult(newResult)
+		enforceSearchLimit(id, results)
 		logMsg = "New item retrieved"
-		shouldSend = !oneshot
+		shouldSend = !spec.SuppressHooks
 	} else {
-		if !reflect.DeepEqual(existing.Content, attrMap) {
-			results[dn] = newResult
+		if !sameResultContent(existing, attrMap) || normalizeDN(existing.DN) != normalizeDN(dn) {
+			newResult.changeType = changeTypeModify
+			newResult.previous = existing.Content
+			results[resultKey] = cachedResult(newResult)
 			logMsg = "Updated item search"
-			shouldSend = !oneshot
+			shouldSend = !spec.SuppressHooks
 		} else {
+			existing.touched = newResult.touched
+			results[resultKey] = existing
 			logMsg = "No change"
 		}
 	}
 	searchResultsMu.Unlock()
+	if migratedFrom != "" {
+		unpersistResults(id, migratedFrom)
+		persistResult(id, resultKey, newResult)
+		dnMappings.move(id, migratedFrom, resultKey)
+	}
+
+	counters := countersFor(id)
+	counters.seen.Add(1)
+	switch logMsg {
+	case "New item retrieved":
+		counters.added.Add(1)
+	case "Updated item search":
+		counters.updated.Add(1)
+	default:
+		counters.unchanged.Add(1)
+	}
 
 	switch logMsg {
 	case "New item retrieved", "Updated item search":
-		logger.Info(logMsg, "DN", dn, "SearchId", id)
+		syncLogger.Info(logMsg, "DN", dn, "SearchId", id)
+		persistResult(id, resultKey, newResult)
+		publishSourceEvent(id, newResult.changeType, newResult)
 	default:
-		logger.Debug(logMsg, "DN", dn, "SearchId", id)
+		syncLogger.Debug(logMsg, "DN", dn, "SearchId", id)
 	}
 
-	if shouldSend {
-		sendHooks(newResult)
+	if shouldSend && damper.admit(id, spec, newResult) {
+		dispatchResult(id, spec, newResult)
 	}
+	return logMsg != "No change"
+}
+
+// dispatchResult hands a new or changed result to the search's transformation
+// pipeline: the declarative mapping (if any), then the embedded transform or hooks.
+func dispatchResult(id string, spec *SearchSpec, result LDAPResult) {
+	source := sourceRef{DN: result.DN, Correlation: result.correlation}
+	if spec.Mapping != "" {
+		mapped, direct, err := applyMapping(spec.Mapping, result)
+		if err != nil {
+			syncLogger.Error("Mapping failed", "Mapping", spec.Mapping, "DN", result.DN, "Err", err)
+			return
+		}
+		if direct {
+			mapped.Search = id
+			mapped.Producer = "mapping:" + spec.Mapping
+			mapped.Source = source.DN
+			mapped.Correlation = source.Correlation
+			mapped.Origin = source.DN
+			dependencyTracker.handleEntry(mapped, nil, nil)
+			return
+		}
+		// The previous content is in source form and is not passed on.
+		result = LDAPResult{DN: mapped.DN, Content: mapped.Content, changeType: result.changeType, replay: result.replay}
+	}
+	if spec.Transform != "" {
+		applyTransform(spec.Transform, id, source, result)
+		return
+	}
+	sendHooks(id, source, result)
 }
 
 // createSearchHandler godoc
@@ -1355,9 +2264,24 @@ func processLDAPEntry(id string, entry *ldap.Entry, oneshot bool) {
 // @Produce json
 // @Param id formData string true "Unique search id"
 // @Param filter formData string true "LDAP search filter"
-// @Param refresh formData int true "Refresh interval in seconds"
+// @Param refresh formData int false "Refresh interval in seconds; required unless schedule is set"
 // @Param baseDN formData string false "Optional base DN for the search; defaults to global config if omitted"
-// @Param oneShot formData bool false "If set to true, the search will run in one-shot mode (hook subsystem will not be engaged). Defaults to true."
+// @Param runOnce formData bool false "If true, the search stops after its first complete run. Defaults to false."
+// @Param suppressHooks formData bool false "If true, results are only cached: no hooks, transform or mapping, and nothing is written to the target. Defaults to false."
+// @Param oneShot formData bool false "Deprecated: sets both runOnce and suppressHooks"
+// @Param transform formData string false "Optional name of an embedded transform (from config) to use instead of the hooks"
+// @Param mapping formData string false "Optional name of a declarative attribute mapping (from config)"
+// @Param attributes formData string false "Optional comma-separated attributes to request from the source; defaults to all user attributes"
+// @Param exclude_attributes formData string false "Optional comma-separated attributes stripped before results are stored and sent to hooks"
+// @Param dry_run formData bool false "If true, target writes are previewed via /changes/preview instead of performed"
+// @Param change_detection formData string false "poll (default) re-runs the search every refresh; changelog applies the source's retro changelog after an initial full search"
+// @Param rename formData bool false "If true, the target entry is renamed (ModifyDN) when the DN produced for a source entry changes"
+// @Param correlation_attribute formData string false "Optional attribute identifying source entries (e.g. entryUUID) instead of their DN"
+// @Param scope formData string false "Search scope: base, one or sub (default)"
+// @Param deref formData string false "Alias dereferencing: never (default), search, find or always"
+// @Param sizeLimit formData int false "Maximum number of entries the source returns per run (default: no limit)"
+// @Param timeLimit formData int false "Maximum seconds the source spends on a run (default: no limit)"
+// @Param schedule formData string false "Optional cron expression (e.g. \"0 2 * * *\") for when the search runs; refresh then only paces retries after errors and may be omitted"
 // @Success 200 {string} string "Search created"
 // @Failure 400 {string} string "Invalid parameters or search already exists"
 // @Router /search [post]
@@ -1369,8 +2293,17 @@ func createSearchHandler(c echo.Context) error {
 	if baseDN == "" {
 		baseDN = config.Source.BaseDN
 	}
-	if id == "" || filter == "" || refreshStr == "" {
-		return c.String(http.StatusBadRequest, "Missing required parameters (id, filter, refresh)")
+	schedule := strings.TrimSpace(c.FormValue("schedule"))
+	if id == "" || filter == "" || (refreshStr == "" && schedule == "") {
+		return c.String(http.StatusBadRequest, "Missing required parameters (id, filter, refresh or schedule)")
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid filter parameter: "+err.Error())
+	}
+	if schedule != "" {
+		if _, err := parseCronSchedule(schedule); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid schedule parameter: "+err.Error())
+		}
 	}
 	searchesMu.RLock()
 	_, exists := searches[id]
@@ -1378,29 +2311,75 @@ func createSearchHandler(c echo.Context) error {
 	if exists {
 		return c.String(http.StatusBadRequest, "Search with this id already exists")
 	}
-	refresh, err := strconv.Atoi(refreshStr)
+	refresh := 0
+	var err error
+	if refreshStr != "" {
+		if refresh, err = strconv.Atoi(refreshStr); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid refresh parameter")
+		}
+	}
+
+	runOnce, suppressHooks, err := runFlagsFromForm(c)
 	if err != nil {
-		return c.String(http.StatusBadRequest, "Invalid refresh parameter")
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
-	// Parse oneShot parameter; default to true if not provided.
-	oneShotStr := c.FormValue("oneShot")
-	oneshot := true
-	if oneShotStr != "" {
-		parsed, err := strconv.ParseBool(oneShotStr)
-		if err != nil {
-			return c.String(http.StatusBadRequest, "Invalid oneShot parameter")
+	transform := c.FormValue("transform")
+	if transform != "" && !transformExists(transform) {
+		return c.String(http.StatusBadRequest, "Unknown transform: "+transform)
+	}
+	mapping := c.FormValue("mapping")
+	if mapping != "" && !mappingExists(mapping) {
+		return c.String(http.StatusBadRequest, "Unknown mapping: "+mapping)
+	}
+	dryRun := false
+	if s := c.FormValue("dry_run"); s != "" {
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid dry_run parameter")
+		}
+	}
+	changeDetection := c.FormValue("change_detection")
+	if !validChangeDetection(changeDetection) {
+		return c.String(http.StatusBadRequest, "Invalid change_detection parameter; expected poll or changelog")
+	}
+	rename := false
+	if s := c.FormValue("rename"); s != "" {
+		if rename, err = strconv.ParseBool(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid rename parameter")
 		}
-		oneshot = parsed
+	}
+	correlationAttribute := strings.TrimSpace(c.FormValue("correlation_attribute"))
+	if correlationAttribute != "" && !attributeTypePattern.MatchString(correlationAttribute) {
+		return c.String(http.StatusBadRequest, "Invalid correlation_attribute parameter")
+	}
+	scope, deref, sizeLimit, timeLimit, err := searchOptionsFromForm(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
 	stopChan := make(chan struct{})
 	spec := &SearchSpec{
-		Filter:  filter,
-		Refresh: refresh,
-		Stop:    stopChan,
-		BaseDN:  baseDN,
-		Oneshot: oneshot,
+		Filter:    filter,
+		Refresh:   refresh,
+		Stop:      stopChan,
+		BaseDN:    baseDN,
+		Transform: transform,
+		Mapping:   mapping,
+
+		RunOnce:           runOnce,
+		SuppressHooks:     suppressHooks,
+		Attributes:        parseAttributeList(c.FormValue("attributes")),
+		ExcludeAttributes: parseAttributeList(c.FormValue("exclude_attributes")),
+		DryRun:            dryRun,
+		ChangeDetection:   changeDetection,
+		Rename:            rename,
+
+		CorrelationAttribute: correlationAttribute,
+		Scope:                scope,
+		Deref:                deref,
+		SizeLimit:            sizeLimit,
+		TimeLimit:            timeLimit,
+		Schedule:             schedule,
 	}
 	searchesMu.Lock()
 	searches[id] = spec
@@ -1416,8 +2395,8 @@ func createSearchHandler(c echo.Context) error {
 		// Continue anyway - the search will still work, just won't persist
 	}
 
-	// Pass the oneshot flag to the search routine.
-	go ldapSearchAndSync(id, filter, baseDN, refresh, oneshot, stopChan)
+	// Hand the search routine its own copy of the spec.
+	go ldapSearchAndSync(id, *spec)
 	return c.String(http.StatusOK, "Search created")
 }
 
@@ -1428,6 +2407,7 @@ func createSearchHandler(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Param id query string false "Search ID"
+// @Param state query string false "Only searches in this state (active or completed)"
 // @Success 200 {object} SearchInfo "When id is provided" or {array} SearchInfo "When id is not provided"
 // @Failure 404 {string} string "Search not found"
 // @Router /search [get]
@@ -1440,32 +2420,62 @@ func getSearchHandler(c echo.Context) error {
 		if !exists {
 			return c.String(http.StatusNotFound, "Search with given id not found")
 		}
-		result := SearchInfo{
-			ID:      id,
-			Filter:  spec.Filter,
-			Refresh: spec.Refresh,
-			BaseDN:  spec.BaseDN,
-			Oneshot: spec.Oneshot,
-		}
-		return c.JSON(http.StatusOK, result)
+		return c.JSON(http.StatusOK, searchInfo(id, spec))
 	}
 
 	// No id provided; return all searches.
+	state := c.QueryParam("state")
+	if state != "" && state != searchStateActive && state != searchStateCompleted {
+		return c.String(http.StatusBadRequest, "Invalid state parameter; expected active or completed")
+	}
 	var results []SearchInfo
 	searchesMu.RLock()
 	for k, spec := range searches {
-		results = append(results, SearchInfo{
-			ID:      k,
-			Filter:  spec.Filter,
-			Refresh: spec.Refresh,
-			BaseDN:  spec.BaseDN,
-			Oneshot: spec.Oneshot,
-		})
+		if state == "" || searchState(spec) == state {
+			results = append(results, searchInfo(k, spec))
+		}
 	}
 	searchesMu.RUnlock()
 	return c.JSON(http.StatusOK, results)
 }
 
+// searchInfo describes a search for the API.
+func searchInfo(id string, spec *SearchSpec) SearchInfo {
+	info := SearchInfo{
+		ID:        id,
+		Filter:    spec.Filter,
+		Refresh:   spec.Refresh,
+		BaseDN:    spec.BaseDN,
+		Oneshot:   spec.RunOnce && spec.SuppressHooks,
+		Transform: spec.Transform,
+		Mapping:   spec.Mapping,
+
+		RunOnce:           spec.RunOnce,
+		SuppressHooks:     spec.SuppressHooks,
+		Attributes:        spec.Attributes,
+		ExcludeAttributes: spec.ExcludeAttributes,
+		DryRun:            spec.DryRun,
+		ChangeDetection:   spec.ChangeDetection,
+		Rename:            spec.Rename,
+
+		CorrelationAttribute: spec.CorrelationAttribute,
+		Scope:                spec.Scope,
+		Deref:                spec.Deref,
+		SizeLimit:            spec.SizeLimit,
+		TimeLimit:            spec.TimeLimit,
+		Schedule:             spec.Schedule,
+		LastRun:              lastScheduledRun(spec),
+		NextRun:              nextScheduledRun(spec),
+		Parent:               spec.Parent,
+		ParentDN:             spec.ParentDN,
+		Owner:                spec.Owner,
+		ExpiresAt:            derivedExpiry(spec),
+	}
+	info.State = searchState(spec)
+	info.CompletedAt, info.ResultCount = searchCompletion(spec)
+	return info
+}
+
 // updateSearchHandler godoc
 // @Summary Update existing search
 // @Description Updates an existing search (complete replacement) with new filter, refresh, and optionally baseDN. If baseDN is omitted, the global config's BaseDN is used.
@@ -1474,9 +2484,24 @@ func getSearchHandler(c echo.Context) error {
 // @Produce json
 // @Param id path string true "Unique search id"
 // @Param filter formData string true "LDAP search filter"
-// @Param refresh formData int true "Refresh interval in seconds"
+// @Param refresh formData int false "Refresh interval in seconds; required unless schedule is set"
 // @Param baseDN formData string false "Optional base DN for the search; defaults to global config if omitted"
-// @Param oneShot formData bool false "If set to true, the search will run in one-shot mode (hook subsystem will not be engaged). Defaults to true."
+// @Param runOnce formData bool false "If true, the search stops after its first complete run. Defaults to false."
+// @Param suppressHooks formData bool false "If true, results are only cached: no hooks, transform or mapping, and nothing is written to the target. Defaults to false."
+// @Param oneShot formData bool false "Deprecated: sets both runOnce and suppressHooks"
+// @Param transform formData string false "Optional name of an embedded transform (from config) to use instead of the hooks"
+// @Param mapping formData string false "Optional name of a declarative attribute mapping (from config)"
+// @Param attributes formData string false "Optional comma-separated attributes to request from the source; defaults to all user attributes"
+// @Param exclude_attributes formData string false "Optional comma-separated attributes stripped before results are stored and sent to hooks"
+// @Param dry_run formData bool false "If true, target writes are previewed via /changes/preview instead of performed"
+// @Param change_detection formData string false "poll (default) re-runs the search every refresh; changelog applies the source's retro changelog after an initial full search"
+// @Param rename formData bool false "If true, the target entry is renamed (ModifyDN) when the DN produced for a source entry changes"
+// @Param correlation_attribute formData string false "Optional attribute identifying source entries (e.g. entryUUID) instead of their DN"
+// @Param scope formData string false "Search scope: base, one or sub (default)"
+// @Param deref formData string false "Alias dereferencing: never (default), search, find or always"
+// @Param sizeLimit formData int false "Maximum number of entries the source returns per run (default: no limit)"
+// @Param timeLimit formData int false "Maximum seconds the source spends on a run (default: no limit)"
+// @Param schedule formData string false "Optional cron expression (e.g. \"0 2 * * *\") for when the search runs; refresh then only paces retries after errors and may be omitted"
 // @Success 200 {string} string "Search updated"
 // @Failure 400 {string} string "Invalid parameters or search does not exist"
 // @Router /search/{id} [put]
@@ -1488,8 +2513,17 @@ func updateSearchHandler(c echo.Context) error {
 	if baseDN == "" {
 		baseDN = config.Source.BaseDN
 	}
-	if id == "" || filter == "" || refreshStr == "" {
-		return c.String(http.StatusBadRequest, "Missing required parameters (id, filter, refresh)")
+	schedule := strings.TrimSpace(c.FormValue("schedule"))
+	if id == "" || filter == "" || (refreshStr == "" && schedule == "") {
+		return c.String(http.StatusBadRequest, "Missing required parameters (id, filter, refresh or schedule)")
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid filter parameter: "+err.Error())
+	}
+	if schedule != "" {
+		if _, err := parseCronSchedule(schedule); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid schedule parameter: "+err.Error())
+		}
 	}
 	searchesMu.RLock()
 	spec, exists := searches[id]
@@ -1497,20 +2531,50 @@ func updateSearchHandler(c echo.Context) error {
 	if !exists {
 		return c.String(http.StatusBadRequest, "Search with this id does not exist")
 	}
-	refresh, err := strconv.Atoi(refreshStr)
+	refresh := 0
+	var err error
+	if refreshStr != "" {
+		if refresh, err = strconv.Atoi(refreshStr); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid refresh parameter")
+		}
+	}
+
+	runOnce, suppressHooks, err := runFlagsFromForm(c)
 	if err != nil {
-		return c.String(http.StatusBadRequest, "Invalid refresh parameter")
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
-	// Parse oneShot parameter; default to true if not provided.
-	oneShotStr := c.FormValue("oneShot")
-	oneshot := true
-	if oneShotStr != "" {
-		parsed, err := strconv.ParseBool(oneShotStr)
-		if err != nil {
-			return c.String(http.StatusBadRequest, "Invalid oneShot parameter")
+	transform := c.FormValue("transform")
+	if transform != "" && !transformExists(transform) {
+		return c.String(http.StatusBadRequest, "Unknown transform: "+transform)
+	}
+	mapping := c.FormValue("mapping")
+	if mapping != "" && !mappingExists(mapping) {
+		return c.String(http.StatusBadRequest, "Unknown mapping: "+mapping)
+	}
+	dryRun := false
+	if s := c.FormValue("dry_run"); s != "" {
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid dry_run parameter")
+		}
+	}
+	changeDetection := c.FormValue("change_detection")
+	if !validChangeDetection(changeDetection) {
+		return c.String(http.StatusBadRequest, "Invalid change_detection parameter; expected poll or changelog")
+	}
+	rename := false
+	if s := c.FormValue("rename"); s != "" {
+		if rename, err = strconv.ParseBool(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid rename parameter")
 		}
-		oneshot = parsed
+	}
+	correlationAttribute := strings.TrimSpace(c.FormValue("correlation_attribute"))
+	if correlationAttribute != "" && !attributeTypePattern.MatchString(correlationAttribute) {
+		return c.String(http.StatusBadRequest, "Invalid correlation_attribute parameter")
+	}
+	scope, deref, sizeLimit, timeLimit, err := searchOptionsFromForm(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
 	// Cancel the current search.
@@ -1520,7 +2584,25 @@ func updateSearchHandler(c echo.Context) error {
 	spec.Filter = filter
 	spec.Refresh = refresh
 	spec.BaseDN = baseDN
-	spec.Oneshot = oneshot
+	spec.RunOnce = runOnce
+	spec.SuppressHooks = suppressHooks
+	spec.Transform = transform
+	spec.Mapping = mapping
+	spec.Attributes = parseAttributeList(c.FormValue("attributes"))
+	spec.ExcludeAttributes = parseAttributeList(c.FormValue("exclude_attributes"))
+	spec.DryRun = dryRun
+	spec.ChangeDetection = changeDetection
+	spec.Rename = rename
+	spec.CorrelationAttribute = correlationAttribute
+	spec.Scope = scope
+	spec.Deref = deref
+	spec.SizeLimit = sizeLimit
+	spec.TimeLimit = timeLimit
+	if spec.Schedule != schedule {
+		spec.LastRun = time.Time{}
+	}
+	spec.Schedule = schedule
+	spec.CompletedAt, spec.ResultCount = time.Time{}, 0
 	spec.Stop = stopChan
 
 	// Update in database
@@ -1529,8 +2611,8 @@ func updateSearchHandler(c echo.Context) error {
 		// Continue anyway
 	}
 
-	// Restart the search goroutine with the new oneshot flag.
-	go ldapSearchAndSync(id, filter, baseDN, refresh, oneshot, stopChan)
+	// Restart the search goroutine with the updated spec.
+	go ldapSearchAndSync(id, *spec)
 	return c.String(http.StatusOK, "Search updated")
 }
 
@@ -1545,103 +2627,299 @@ func updateSearchHandler(c echo.Context) error {
 // @Router /search/{id} [delete]
 func deleteSearchHandler(c echo.Context) error {
 	id := c.Param("id")
-	searchesMu.RLock()
-	spec, exists := searches[id]
-	searchesMu.RUnlock()
-	if !exists {
+	if !removeSearch(id) {
 		return c.String(http.StatusNotFound, "Search not found")
 	}
-	// Cancel the running search.
-	close(spec.Stop)
-	// Remove from the map.
+	return c.String(http.StatusOK, "Search deleted")
+}
+
+// removeSearch stops a search and deletes it with its results from memory
+// and the database. It reports whether the search existed.
+func removeSearch(id string) bool {
 	searchesMu.Lock()
-	delete(searches, id)
+	spec, exists := searches[id]
+	if exists {
+		// Cancel the running search.
+		close(spec.Stop)
+		delete(searches, id)
+	}
 	searchesMu.Unlock()
+	if !exists {
+		return false
+	}
 	// Remove the results too
 	searchResultsMu.Lock()
 	delete(searchResults, id)
 	searchResultsMu.Unlock()
+	unpersistResults(id, "")
+	parentEntries.Lock()
+	delete(parentEntries.dns, id)
+	parentEntries.Unlock()
+	forgetSearchStats(id)
+	forgetSearchBindings(id)
 
 	// Delete from database
 	if err := deleteSearchFromDB(id); err != nil {
 		logger.Error("Failed to delete search from database", "SearchId", id, "Err", err)
 		// Continue anyway - the search is already stopped and removed from memory
 	}
-
-	return c.String(http.StatusOK, "Search deleted")
+	return true
 }
 
 // getResultsHandler godoc
 // @Summary Get search results
-// @Description Retrieves all LDAP objects for a given search id.
+// @Description Retrieves the LDAP objects of a given search id, sorted by DN.
 //
 //	If the optional query parameter "full" is true, returns both DN and content; otherwise, only DN is returned.
+//	Results can be filtered by DN substring and attribute values, projected to some attributes, and paged with
+//	limit and offset or with the after cursor. The X-Total-Count header holds the number of matching results.
 //
 // @Tags results
 // @Produce json
 // @Param id path string true "Unique search id"
 // @Param full query boolean false "Return full result (DN and content) if true, else only DN"
+// @Param attrs query string false "Comma-separated attributes to include in the content (implies full)"
+// @Param dnContains query string false "Only results whose DN contains this text (case-insensitive)"
+// @Param where query []string false "Only results with an attribute value, as attr=value (case-insensitive, repeatable)" collectionFormat(multi)
+// @Param limit query int false "Maximum results to return"
+// @Param offset query int false "Skip this many matching results"
+// @Param after query string false "Only results sorting after this DN (cursor; the last DN of the previous page)"
 // @Success 200 {array} ResultEntrySimple "When full is false"
 // @Success 200 {array} ResultEntryFull "When full is true"
+// @Failure 400 {string} string "Invalid parameter"
 // @Failure 404 {string} string "Search results not found"
 // @Router /results/{id} [get]
 func getResultsHandler(c echo.Context) error {
 	id := c.Param("id")
+	full, _ := strconv.ParseBool(c.QueryParam("full"))
+	var attrs []string
+	if v := c.QueryParam("attrs"); v != "" {
+		full = true
+		for _, a := range strings.Split(v, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				attrs = append(attrs, a)
+			}
+		}
+	}
+	dnContains := strings.ToLower(c.QueryParam("dnContains"))
+	type condition struct{ attr, value string }
+	var where []condition
+	for _, w := range c.QueryParams()["where"] {
+		attr, value, ok := strings.Cut(w, "=")
+		if !ok || attr == "" {
+			return c.String(http.StatusBadRequest, "Invalid where parameter; expected attr=value")
+		}
+		where = append(where, condition{attr, value})
+	}
+	if len(where) > 0 && !cacheContent() {
+		return c.String(http.StatusBadRequest, "The where parameter requires result_cache: content")
+	}
+	limit, offset := -1, 0
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &limit}, {"offset", &offset}} {
+		if v := c.QueryParam(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return c.String(http.StatusBadRequest, "Invalid "+p.name+" parameter")
+			}
+			*p.dst = n
+		}
+	}
+	after := c.QueryParam("after")
+
 	searchResultsMu.RLock()
 	results, exists := searchResults[id]
 	if !exists {
 		searchResultsMu.RUnlock()
 		return c.String(http.StatusNotFound, "Search results not found for id: "+id)
 	}
+	matched := make([]LDAPResult, 0, len(results))
+	for _, res := range results {
+		if dnContains != "" && !strings.Contains(strings.ToLower(res.DN), dnContains) {
+			continue
+		}
+		ok := true
+		for _, w := range where {
+			if !contentHasValue(res.Content, w.attr, w.value) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, res)
+		}
+	}
+	searchResultsMu.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool { return resultDNLess(matched[i].DN, matched[j].DN) })
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(len(matched)))
+	if after != "" {
+		i := sort.Search(len(matched), func(i int) bool { return resultDNLess(after, matched[i].DN) })
+		matched = matched[i:]
+	}
+	if offset >= len(matched) {
+		matched = nil
+	} else {
+		matched = matched[offset:]
+	}
+	if limit >= 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
 
-	full, _ := strconv.ParseBool(c.QueryParam("full"))
 	if full {
-		var entries []ResultEntryFull
-		for _, res := range results {
-			entries = append(entries, ResultEntryFull(res))
+		entries := make([]ResultEntryFull, 0, len(matched))
+		for _, res := range matched {
+			entries = append(entries, ResultEntryFull{DN: res.DN, Content: projectContent(resultsContent(res.Content), attrs)})
 		}
-		searchResultsMu.RUnlock()
 		return c.JSON(http.StatusOK, entries)
 	}
 
-	var entries []ResultEntrySimple
-	for _, res := range results {
+	entries := make([]ResultEntrySimple, 0, len(matched))
+	for _, res := range matched {
 		entries = append(entries, ResultEntrySimple{
 			DN: res.DN,
 		})
 	}
-	searchResultsMu.RUnlock()
 	return c.JSON(http.StatusOK, entries)
 }
 
+// ResultEntryDetail is a single cached result returned by GET /results/:id/entry.
+type ResultEntryDetail struct {
+	DN      string                 `json:"dn"`
+	Content map[string]interface{} `json:"content"`
+	// Key is the result's key in the search: the normalized DN, or the
+	// correlation key for searches with a correlation attribute.
+	Key string `json:"key"`
+	// ContentHash is set for results kept without their content
+	// (result_cache: hash), or restored without it
+	// (persist_results: hash) and not yet read again from the source.
+	ContentHash string `json:"contentHash,omitempty"`
+}
+
+// getResultEntryHandler godoc
+// @Summary Get a single search result
+// @Description Returns the cached result for one source DN of the search. DNs are compared in normalized form. A "+" in the DN may be sent unencoded (as in multi-valued RDNs) or as %2B.
+// @Tags results
+// @Produce json
+// @Param id path string true "Unique search id"
+// @Param dn query string true "Source DN"
+// @Success 200 {object} ResultEntryDetail
+// @Failure 400 {string} string "Missing dn parameter"
+// @Failure 404 {string} string "Search or entry not found"
+// @Router /results/{id}/entry [get]
+func getResultEntryHandler(c echo.Context) error {
+	id := c.Param("id")
+	dn := c.QueryParam("dn")
+	if strings.TrimSpace(dn) == "" {
+		return c.String(http.StatusBadRequest, "Missing dn parameter")
+	}
+	// Query decoding turns an unencoded "+" into a space; also try the DN
+	// with "+" kept literally.
+	candidates := []string{normalizeDN(dn)}
+	for _, pair := range strings.Split(c.Request().URL.RawQuery, "&") {
+		name, raw, _ := strings.Cut(pair, "=")
+		if name != "dn" {
+			continue
+		}
+		if literal, err := url.PathUnescape(raw); err == nil && normalizeDN(literal) != candidates[0] {
+			candidates = append(candidates, normalizeDN(literal))
+		}
+	}
+
+	searchResultsMu.RLock()
+	defer searchResultsMu.RUnlock()
+	results, exists := searchResults[id]
+	if !exists {
+		return c.String(http.StatusNotFound, "Search results not found for id: "+id)
+	}
+	for _, want := range candidates {
+		if res, ok := results[want]; ok {
+			return c.JSON(http.StatusOK, ResultEntryDetail{DN: res.DN, Content: resultsContent(res.Content), Key: want, ContentHash: res.hash})
+		}
+	}
+	// Results of searches with a correlation attribute are keyed by it.
+	for key, res := range results {
+		for _, want := range candidates {
+			if normalizeDN(res.DN) == want {
+				return c.JSON(http.StatusOK, ResultEntryDetail{DN: res.DN, Content: resultsContent(res.Content), Key: key, ContentHash: res.hash})
+			}
+		}
+	}
+	return c.String(http.StatusNotFound, "No result for DN "+dn+" in search "+id)
+}
+
+// resultDNLess orders results by DN, case-insensitively with ties broken by
+// the exact DN so the order is stable across requests.
+func resultDNLess(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	if la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+// contentHasValue reports whether the content has the attribute (matched
+// case-insensitively) with the value.
+func contentHasValue(content map[string]interface{}, attr, value string) bool {
+	for name, v := range content {
+		if !strings.EqualFold(name, attr) {
+			continue
+		}
+		for _, s := range toStringSlice(v) {
+			if strings.EqualFold(s, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// projectContent returns the content limited to attrs, or all of it when
+// attrs is empty.
+func projectContent(content map[string]interface{}, attrs []string) map[string]interface{} {
+	if len(attrs) == 0 || content == nil {
+		return content
+	}
+	out := make(map[string]interface{}, len(attrs))
+	for name, v := range content {
+		for _, a := range attrs {
+			if strings.EqualFold(name, a) {
+				out[name] = v
+				break
+			}
+		}
+	}
+	return out
+}
+
 // getLogLevelHandler is a REST endpoint that reports the current log level.
 // @Summary Get current log level
-// @Description Returns the current log level.
+// @Description Returns the global log level and the components whose level overrides it.
 // @Tags log
 // @Produce json
-// @Success 200 {object} map[string]string "current log level"
+// @Success 200 {object} LogLevelResponse "current log levels"
 // @Router /loglevel [get]
 func getLogLevelHandler(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]string{
-		"level": currentLogLevel,
+	return c.JSON(http.StatusOK, LogLevelResponse{
+		Level:      strings.ToLower(logLevel.Level().String()),
+		Components: componentLevelNames(),
 	})
 }
 
 // logLevelHandler is a REST endpoint to update log level at runtime.
 // @Summary Update log level
-// @Description Update the logging level at runtime.
+// @Description Update the global logging level at runtime, or with component the level of one component (sync, hooks, dependencies, http). Level "default" makes a component follow the global level again.
 // @Tags log
 // @Accept json
 // @Produce json
 // @Param level body LogLevelRequest true "New log level"
 // @Success 200 {object} map[string]string "Updated log level"
-// @Failure 400 {object} map[string]string "Invalid payload or log level"
+// @Failure 400 {object} map[string]string "Invalid payload, component or log level"
 // @Router /loglevel [put]
 func logLevelHandler(c echo.Context) error {
-	type reqBody struct {
-		Level string `json:"level"`
-	}
-	var req reqBody
+	var req LogLevelRequest
 	if err := c.Bind(&req); err != nil {
 		logger.Error("Failed to bind log level request", "Err", err)
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -1649,6 +2927,29 @@ func logLevelHandler(c echo.Context) error {
 		})
 	}
 	newLevel := strings.ToLower(req.Level)
+	if req.Component != "" {
+		if !validLogComponent(req.Component) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Unknown component; expected one of " + strings.Join(logComponents, ", "),
+			})
+		}
+		switch {
+		case newLevel == "default":
+			setComponentLevel(req.Component, "")
+		case validLogLevel(newLevel):
+			setComponentLevel(req.Component, newLevel)
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid log level",
+			})
+		}
+		logger.Info("Component log level updated", "Component", req.Component, "newLevel", newLevel)
+		return c.JSON(http.StatusOK, map[string]string{
+			"message":   "Log level updated",
+			"component": req.Component,
+			"level":     req.Level,
+		})
+	}
 	switch newLevel {
 	case "debug", "info", "warn", "error":
 		setLogLevel(newLevel)
@@ -1675,35 +2976,172 @@ func healthzHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// readyzHandler handles the readiness probe.
-// @Summary Readiness Probe
-// @Description Returns OK if the application is ready to serve traffic.
-// @Tags probes
-// @Produce json
-// @Success 200 {object} map[string]string "status: ready"
-// @Router /readyz [get]
-func readyzHandler(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]string{"status": "ready"})
-}
-
 // @title ldap-sync API
 // @version 1.0
 // @description API for synchronizing LDAP entries between two servers.
 // @host localhost:5500
 // @BasePath /
+// configInitStep compiles or validates one section of the config.
+type configInitStep struct {
+	section string // YAML section, for --validate-config
+	failure string // Startup error message
+	init    func() error
+}
+
+// configInitSteps run in order after the config is loaded, before the
+// database is opened. Embedded transforms come first so searches can
+// reference them.
+var configInitSteps = []configInitStep{
+	{"transforms", "Error initializing transforms", initTransforms},
+	{"dn_rewrites", "Error compiling DN rewrite rules", initDNRewrites},
+	{"policy", "Error initializing policy", initPolicy},
+	{"source/target", "Error reading bind password files", initBindPasswords},
+	{"derived_searches", "Error initializing derived searches", initDerivedSearches},
+	{"leader_election", "Error initializing leader election", initLeaderElection},
+	{"hook_identity", "Error initializing hook identity", initHookIdentity},
+	{"auth", "Error initializing API authentication", initAuth},
+	{"mappings", "Error validating mappings", validateMappings},
+	{"object_classes", "Error initializing object class rules", initObjectClassRules},
+	{"merge", "Error initializing merge strategies", initMergeStrategies},
+	{"database", "Error validating database configuration", validateDatabaseDriver},
+	{"database", "Error validating database configuration", validateResultPersistence},
+	{"result_cache", "Error validating result cache", validateResultCache},
+	{"targets", "Error initializing targets", initTargets},
+	{"events", "Error initializing event publishing", initEvents},
+	{"hook_protocol", "Error validating hook protocol", validateHookProtocol},
+	{"hook_conditions", "Error compiling hook conditions", initHookConditions},
+	{"hook_http", "Error initializing hook HTTP clients", initHookHTTP},
+	{"hook_retry", "Error validating hook retry settings", validateHookRetry},
+	{"binding_namespaces", "Error validating binding namespaces", validateBindingNamespaces},
+	{"pending_timeout", "Error validating pending timeout", validatePendingTimeout},
+	{"dependency_cycles", "Error validating dependency cycle policy", validateDependencyCycles},
+	{"dependency_warmup", "Error validating dependency warmup", validateDependencyWarmup},
+	{"write_groups", "Error validating write groups", validateWriteGroups},
+	{"password_sync", "Error validating password sync", validatePasswordSync},
+	{"identity_tracking", "Error validating identity tracking", validateIdentityTracking},
+	{"dc_locator", "Error validating domain controller locator", validateDCLocator},
+	{"sasl", "Error validating SASL bind settings", validateSASL},
+	{"referrals", "Error validating referral settings", validateReferrals},
+	{"completed_searches", "Error validating completed search retention", validateCompletedSearches},
+}
+
+// searchStateMu serializes loading the persisted searches, so a standby
+// reload cannot overwrite the searches a new leader started.
+var searchStateMu sync.Mutex
+
+// loadSearchState loads the persisted searches with their last-seen
+// results, replacing the ones in memory, and starts their sync loops if
+// start is set. Standbys load them without starting them; a load without
+// start is skipped once this replica leads.
+func loadSearchState(start bool) {
+	searchStateMu.Lock()
+	defer searchStateMu.Unlock()
+	if !start && leading.Load() {
+		return
+	}
+	// Restore the last-seen results so unchanged entries are not re-sent.
+	loadedResults, err := loadResultsFromDB()
+	if err != nil {
+		logger.Error("Error loading search results from database", "Err", err)
+		loadedResults = nil
+	}
+
+	// Load saved searches from database
+	loadedSearches, err := loadSearchesFromDB()
+	if err != nil {
+		logger.Error("Error loading searches from database", "Err", err)
+		recordRestoreFailure(err)
+		// Don't exit - continue with empty searches
+		return
+	}
+	searchesMu.Lock()
+	searchResultsMu.Lock()
+	clear(searches)
+	clear(searchResults)
+	for id, spec := range loadedSearches {
+		searches[id] = spec
+		// Initialize results store for this search
+		if results, ok := loadedResults[id]; ok {
+			searchResults[id] = results
+		} else {
+			searchResults[id] = make(map[string]LDAPResult)
+		}
+		if start && !spec.CompletedAt.IsZero() {
+			logger.Info("Restored completed search from database", "SearchId", id)
+		} else if start {
+			// Start the search goroutine
+			go ldapSearchAndSync(id, *spec)
+			logger.Info("Restored search from database", "SearchId", id)
+		}
+	}
+	searchResultsMu.Unlock()
+	searchesMu.Unlock()
+}
+
+// startSync restores the persisted searches and starts everything that
+// writes to the target or the database: the search loops, pending entries,
+// dead letter retries and the cleanup jobs. Only the leader runs it.
+func startSync() {
+	if db != nil {
+		go func() {
+			if err := restorePendingFromDB(); err != nil {
+				logger.Error("Error restoring pending entries from database", "Err", err)
+			}
+		}()
+		loadSearchState(true)
+	}
+
+	go runDeadLetterLoop()
+	go runDerivedSearchGC()
+	go runCompletedSearchGC()
+	if db != nil && config.Janitor.Enabled {
+		go runJanitorLoop()
+	}
+}
+
 func main() {
-	var loglevel string
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+
+	var loglevel, configPath string
+	var validateOnly, testBind bool
 
 	flag.StringVar(&loglevel, "loglevel", "", "Set the log level (debug, info, warn, error)")
+	flag.StringVar(&configPath, "config", configPathDefault(), "Path of the config file or conf.d directory (default from CONFIG_PATH)")
+	flag.BoolVar(&validateOnly, "validate-config", false, "Validate the config file and exit (non-zero if invalid)")
+	flag.BoolVar(&testBind, "test-bind", false, "With --validate-config, also bind to the source and target")
 	flag.Parse()
 	initLogger(loglevel)
 
-	// Load configuration from /etc/ldap-sync/config.yaml.
-	if err := loadConfig("/etc/ldap-sync/config.yaml"); err != nil {
+	if validateOnly {
+		os.Exit(runValidateConfig(configPath, testBind))
+	}
+
+	// Load configuration, by default from /etc/ldap-sync/config.yaml.
+	logger.Debug("Loading config", "Path", configPath)
+	if err := loadConfig(configPath); err != nil {
 		logger.Error("Error loading config", "Err", err)
 		os.Exit(1)
 	}
 
+	// Ship logs to the configured sinks from here on.
+	if err := initLogSinks(); err != nil {
+		logger.Error("Error configuring log sinks", "Err", err)
+		os.Exit(1)
+	}
+	if err := initFaultInjection(); err != nil {
+		logger.Error("Error initializing fault injection", "Err", err)
+		os.Exit(1)
+	}
+
+	for _, step := range configInitSteps {
+		if err := step.init(); err != nil {
+			logger.Error(step.failure, "Err", err)
+			os.Exit(1)
+		}
+	}
+
 	// Initialize database if enabled in config
 	if config.Database.Enabled {
 		if err := initDB(config.Database); err != nil {
@@ -1711,51 +3149,114 @@ func main() {
 			os.Exit(1)
 		}
 		defer db.Close()
+		go runDBMonitor()
 
-		// Load saved searches from database
-		loadedSearches, err := loadSearchesFromDB()
-		if err != nil {
-			logger.Error("Error loading searches from database", "Err", err)
-			// Don't exit - continue with empty searches
-		} else {
-			// Restore searches and start their goroutines
-			searchesMu.Lock()
-			for id, spec := range loadedSearches {
-				searches[id] = spec
-				// Initialize results store for this search
-				searchResultsMu.Lock()
-				searchResults[id] = make(map[string]LDAPResult)
-				searchResultsMu.Unlock()
-				// Start the search goroutine
-				go ldapSearchAndSync(id, spec.Filter, spec.BaseDN, spec.Refresh, spec.Oneshot, spec.Stop)
-				logger.Info("Restored search from database", "SearchId", id)
-			}
-			searchesMu.Unlock()
+		// Restore bindings before searches start so their entries resolve.
+		if err := loadBindingsFromDB(); err != nil {
+			logger.Error("Error loading bindings from database", "Err", err)
 		}
+		expireBindings()
+		pruneHookBindings()
 	} else {
 		logger.Info("Database persistence disabled, searches will not be persisted")
 	}
 
+	go runCacheSweeper()
+	startWriteQueue()
+	startEventPublisher()
+	startHookHealthMonitor()
+	startPendingTimeout()
+	if config.DependencyWarmup.OnStartup {
+		go func() {
+			if _, err := warmDependencies(); err != nil {
+				logger.Error("Error warming the synced set from the target", "Err", err)
+			}
+		}()
+	}
+	startLeaderElection(startSync)
+
 	// Initialize Echo.
 	e := echo.New()
 	e.Use(middleware.Recover())
-	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		Skipper: func(c echo.Context) bool {
 			path := c.Request().URL.Path
-			return path == "/healthz" || path == "/readyz"
+			return path == "/healthz" || path == "/readyz" || path == "/metrics"
+		},
+		LogMethod:   true,
+		LogURI:      true,
+		LogStatus:   true,
+		LogLatency:  true,
+		LogRemoteIP: true,
+		LogError:    true,
+		HandleError: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			level := slog.LevelInfo
+			if v.Error != nil || v.Status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			attrs := []any{"Method", v.Method, "URI", v.URI, "Status", v.Status, "Latency", v.Latency, "RemoteIP", v.RemoteIP, "Err", v.Error}
+			if principal, ok := c.Get("principal").(string); ok {
+				attrs = append(attrs, "Principal", principal)
+			}
+			httpLogger.Log(c.Request().Context(), level, "HTTP request", attrs...)
+			return nil
 		},
 	}))
+	e.Use(apiAuditMiddleware)
+	e.Use(authMiddleware)
+	e.Use(standbyMiddleware)
 
 	// Register endpoints.
 	e.POST("/search", createSearchHandler)
+	e.POST("/search/preview", previewSearchHandler)
 	e.GET("/search", getSearchHandler)
 	e.PUT("/search/:id", updateSearchHandler)
 	e.DELETE("/search/:id", deleteSearchHandler)
 	e.GET("/results/:id", getResultsHandler)
+	e.GET("/results/:id/entry", getResultEntryHandler)
+	e.POST("/search/:id/replay", replayResultsHandler)
 	e.PUT("/loglevel", logLevelHandler)
 	e.GET("/loglevel", getLogLevelHandler)
 	e.GET("/healthz", healthzHandler)
 	e.GET("/readyz", readyzHandler)
+	e.GET("/health/details", healthDetailsHandler)
+	e.GET("/search/:id/stats", searchStatsHandler)
+	e.GET("/config/validate", validateConfigHandler)
+	e.GET("/export", exportHandler)
+	e.POST("/import", importHandler)
+	e.GET("/metrics", metricsHandler)
+	e.GET("/bindings", getBindingsHandler)
+	e.PUT("/bindings/:key", putBindingHandler)
+	e.DELETE("/bindings/:key", deleteBindingHandler)
+	e.GET("/bindings/missing", getMissingBindingsHandler)
+	e.GET("/dependencies", getDependenciesHandler)
+	e.GET("/dependencies/:dn", getDependencyHandler)
+	e.DELETE("/dependencies/:dn", deleteDependencyHandler)
+	e.POST("/dependencies/warm", warmDependenciesHandler)
+	e.GET("/writegroups", getWriteGroupsHandler)
+	e.GET("/writegroups/:id", getWriteGroupHandler)
+	e.GET("/identities", getIdentitiesHandler)
+	e.GET("/policy/violations", getPolicyViolationsHandler)
+	e.GET("/schema/violations", getSchemaViolationsHandler)
+	e.GET("/verification/failures", getVerificationFailuresHandler)
+	e.GET("/trace", getTraceHandler)
+	e.GET("/target/entry", getTargetEntryHandler)
+	e.POST("/compare", compareHandler)
+	e.POST("/hooks/simulate", simulateHooksHandler)
+	e.GET("/hooks/quarantine", getQuarantineHandler)
+	e.GET("/hooks/quarantine/:id", getQuarantinedResponseHandler)
+	e.DELETE("/hooks/quarantine/:id", deleteQuarantinedResponseHandler)
+	e.DELETE("/hooks/cache", clearHookCacheHandler)
+	e.POST("/reconcile/:id", reconcileHandler)
+	e.GET("/changes", getChangesHandler)
+	e.GET("/audit", getAPIAuditHandler)
+	e.GET("/changes/preview", getChangePreviewHandler)
+	e.DELETE("/changes/preview", clearChangePreviewHandler)
+	e.GET("/deadletters", getDeadLettersHandler)
+	e.GET("/retries", getTargetRetriesHandler)
+	e.POST("/deadletters/:id/retry", retryDeadLetterHandler)
+	e.DELETE("/deadletters/:id", deleteDeadLetterHandler)
 
 	// Redirect /swagger to /swagger/index.html
 	e.GET("/swagger", func(c echo.Context) error {
@@ -1769,6 +3270,9 @@ func main() {
 		return c.Redirect(http.StatusFound, "/swagger/index.html")
 	})
 
+	// State is restored; injected faults may start now.
+	faultsArmed.Store(true)
+
 	logger.Info("Server started on :5500")
 	e.Logger.Fatal(e.Start(":5500"))
 }
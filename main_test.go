@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	installLogger(slog.NewTextHandler(io.Discard, nil))
+	sql.Register("recording", recordingDriver{})
+	os.Exit(m.Run())
+}
+
+// recordingTarget stands in for the target LDAP server and records the
+// entries written to it.
+type recordingTarget struct {
+	mu      sync.Mutex
+	entries []TransformedEntry
+}
+
+func (r *recordingTarget) Name() string { return "recording" }
+
+func (r *recordingTarget) Store(entry *TransformedEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+// written returns the DNs written so far, in order.
+func (r *recordingTarget) written() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dns := make([]string, len(r.entries))
+	for i, e := range r.entries {
+		dns[i] = e.DN
+	}
+	return dns
+}
+
+// useRecordingTarget makes a recordingTarget the target LDAP server for the
+// duration of a test.
+func useRecordingTarget(t *testing.T) *recordingTarget {
+	t.Helper()
+	target := &recordingTarget{}
+	prev := primaryTarget
+	primaryTarget = target
+	t.Cleanup(func() { primaryTarget = prev })
+	return target
+}
+
+// recordingDB records the statements executed against the database.
+// Queries return no rows.
+type recordingDB struct {
+	mu    sync.Mutex
+	execs []recordedExec
+}
+
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+var recordingDBs sync.Map // DSN -> *recordingDB
+
+// useRecordingDB makes a recordingDB the service database for the
+// duration of a test.
+func useRecordingDB(t *testing.T) *recordingDB {
+	t.Helper()
+	rec := &recordingDB{}
+	recordingDBs.Store(t.Name(), rec)
+	conn, err := sql.Open("recording", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = conn
+	t.Cleanup(func() {
+		db = prev
+		conn.Close()
+		recordingDBs.Delete(t.Name())
+	})
+	return rec
+}
+
+// execsOf returns the arguments of the statements starting with prefix.
+func (r *recordingDB) execsOf(prefix string) [][]driver.Value {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out [][]driver.Value
+	for _, e := range r.execs {
+		if strings.HasPrefix(strings.TrimSpace(e.query), prefix) {
+			out = append(out, e.args)
+		}
+	}
+	return out
+}
+
+type recordingDriver struct{}
+
+func (recordingDriver) Open(dsn string) (driver.Conn, error) {
+	rec, ok := recordingDBs.Load(dsn)
+	if !ok {
+		return nil, errors.New("unknown recording database")
+	}
+	return recordingConn{rec.(*recordingDB)}, nil
+}
+
+type recordingConn struct{ rec *recordingDB }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return recordingStmt{rec: c.rec, query: query}, nil
+}
+func (recordingConn) Close() error              { return nil }
+func (recordingConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions not supported") }
+
+type recordingStmt struct {
+	rec   *recordingDB
+	query string
+}
+
+func (recordingStmt) Close() error  { return nil }
+func (recordingStmt) NumInput() int { return -1 }
+
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.rec.mu.Lock()
+	defer s.rec.mu.Unlock()
+	s.rec.execs = append(s.rec.execs, recordedExec{query: s.query, args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (recordingStmt) Query([]driver.Value) (driver.Rows, error) { return noRows{}, nil }
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MappingConfig declares how a search's source entries map onto target entries.
+type MappingConfig struct {
+	// Mode is "before" (default) to feed the mapped entry to the hooks or
+	// transform, or "instead" to write the mapped entry directly.
+	Mode string `yaml:"mode"`
+	// DN is a template for the target DN. {attr} placeholders are replaced by
+	// source attribute values; $binding references are resolved at write time.
+	DN string `yaml:"dn"`
+	// DNRewrite names a dn_rewrites rule set used instead of the DN template.
+	DNRewrite string `yaml:"dn_rewrite"`
+	// KeepUnmapped copies attributes not mentioned by any rule unchanged.
+	KeepUnmapped bool          `yaml:"keep_unmapped"`
+	Rules        []MappingRule `yaml:"rules"`
+}
+
+// MappingRule is a single attribute rule. Exactly one of the following applies:
+// drop Source, set Target to Constant, set Target from Template, or copy/rename
+// Source to Target (Target defaults to Source).
+type MappingRule struct {
+	Source    string      `yaml:"source"`
+	Target    string      `yaml:"target"`
+	Constant  interface{} `yaml:"constant"`
+	Template  string      `yaml:"template"`
+	Drop      bool        `yaml:"drop"`
+	Lowercase bool        `yaml:"lowercase"`
+}
+
+var mappingPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_;-]+)\}`)
+
+func mappingExists(name string) bool {
+	_, ok := config.Mappings[name]
+	return ok
+}
+
+// validateMappings checks the mapping rules once at startup so misconfigured
+// rules fail fast rather than on every entry.
+func validateMappings() error {
+	for name, m := range config.Mappings {
+		switch strings.ToLower(m.Mode) {
+		case "", "before":
+		case "instead":
+			if m.DN == "" && m.DNRewrite == "" {
+				return fmt.Errorf("mapping %q: mode instead requires a dn template or dn_rewrite", name)
+			}
+		default:
+			return fmt.Errorf("mapping %q: unknown mode %q", name, m.Mode)
+		}
+		if m.DN != "" && m.DNRewrite != "" {
+			return fmt.Errorf("mapping %q: dn and dn_rewrite are mutually exclusive", name)
+		}
+		if m.DN != "" {
+			if err := validateDNSyntax(m.DN); err != nil {
+				return fmt.Errorf("mapping %q: %w", name, err)
+			}
+		}
+		if m.DNRewrite != "" && !dnRewriteExists(m.DNRewrite) {
+			return fmt.Errorf("mapping %q: unknown dn_rewrite %q", name, m.DNRewrite)
+		}
+		for i, rule := range m.Rules {
+			switch {
+			case rule.Drop:
+				if rule.Source == "" {
+					return fmt.Errorf("mapping %q rule %d: drop requires source", name, i)
+				}
+			case rule.Constant != nil, rule.Template != "":
+				if rule.Target == "" {
+					return fmt.Errorf("mapping %q rule %d: constant and template require target", name, i)
+				}
+			case rule.Source == "":
+				return fmt.Errorf("mapping %q rule %d: source is required", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupAttr finds an attribute value by case-insensitive name, as LDAP
+// attribute names are case-insensitive.
+func lookupAttr(content map[string]interface{}, name string) (string, interface{}, bool) {
+	if v, ok := content[name]; ok {
+		return name, v, true
+	}
+	for k, v := range content {
+		if strings.EqualFold(k, name) {
+			return k, v, true
+		}
+	}
+	return "", nil, false
+}
+
+func lowercaseValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return strings.ToLower(v)
+	case []interface{}, []string:
+		vals := toStringSlice(v)
+		out := make([]interface{}, len(vals))
+		for i, s := range vals {
+			out[i] = strings.ToLower(s)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// expandMappingTemplate replaces {attr} placeholders with source values.
+// Multi-valued attributes contribute their first value; missing ones expand
+// to an empty string.
+func expandMappingTemplate(tmpl string, content map[string]interface{}) string {
+	return mappingPlaceholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		_, v, ok := lookupAttr(content, m[1:len(m)-1])
+		if !ok {
+			return ""
+		}
+		vals := toStringSlice(v)
+		if len(vals) == 0 {
+			return ""
+		}
+		return vals[0]
+	})
+}
+
+// applyMapping maps a source result to a target entry. The returned flag is
+// true when the mapping is configured to be written instead of sent to hooks.
+func applyMapping(name string, result LDAPResult) (*TransformedEntry, bool, error) {
+	m, ok := config.Mappings[name]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown mapping %q", name)
+	}
+
+	out := make(map[string]interface{})
+	consumed := make(map[string]struct{})
+	for _, rule := range m.Rules {
+		if rule.Source == "" {
+			continue
+		}
+		if key, _, ok := lookupAttr(result.Content, rule.Source); ok {
+			consumed[key] = struct{}{}
+		}
+	}
+	if m.KeepUnmapped {
+		for k, v := range result.Content {
+			if _, ok := consumed[k]; !ok {
+				out[k] = v
+			}
+		}
+	}
+
+	for _, rule := range m.Rules {
+		switch {
+		case rule.Drop:
+			if key, _, ok := lookupAttr(out, rule.Source); ok {
+				delete(out, key)
+			}
+		case rule.Constant != nil:
+			out[rule.Target] = rule.Constant
+		case rule.Template != "":
+			out[rule.Target] = expandMappingTemplate(rule.Template, result.Content)
+		default:
+			_, v, ok := lookupAttr(result.Content, rule.Source)
+			if !ok {
+				continue
+			}
+			target := rule.Target
+			if target == "" {
+				target = rule.Source
+			}
+			if rule.Lowercase {
+				v = lowercaseValue(v)
+			}
+			out[target] = v
+		}
+	}
+
+	dn := result.DN
+	switch {
+	case m.DNRewrite != "":
+		rewritten, err := rewriteDN(m.DNRewrite, result)
+		if err != nil {
+			return nil, false, err
+		}
+		dn = rewritten
+	case m.DN != "":
+		dn = expandDNTemplate(m.DN, nil, result.Content)
+		if err := validateDNSyntax(dn); err != nil {
+			return nil, false, err
+		}
+	}
+	return &TransformedEntry{DN: dn, Content: out}, strings.EqualFold(m.Mode, "instead"), nil
+}
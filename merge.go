@@ -0,0 +1,351 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Merge strategies decide how values written to an attribute of an existing
+// target entry combine with the values already there.
+const (
+	// mergeUnion adds the written values to the target's (the default for
+	// multi-valued attributes named in merge_attributes).
+	mergeUnion = "union"
+	// mergeReplace writes the values as given; an empty list clears the
+	// attribute.
+	mergeReplace = "replace"
+	// mergeSourceWins replaces the target's values when the source provides
+	// any, and leaves them alone otherwise.
+	mergeSourceWins = "source-wins"
+	// mergeTargetWins only writes the attribute when the target has no
+	// values for it.
+	mergeTargetWins = "target-wins"
+	// mergeRemoveAbsent adds the written values and removes the values
+	// ldap-sync wrote earlier that are no longer produced, keeping values
+	// added by other writers. Values are tracked per contributor (search and
+	// source entry), so entries that each add their own value to a shared
+	// entry do not remove each other's.
+	mergeRemoveAbsent = "remove-absent"
+	// mergeExact makes the attribute hold exactly the written values, so
+	// removals propagate, keeping only the configured protected members.
+	mergeExact = "exact"
+)
+
+var mMembersRemoved = describeMetric("ldapsync_members_removed_total", "counter",
+	"Values removed from target attributes merged with the exact strategy.")
+
+// mergeStrategies maps lowercase attribute names to their strategy.
+// Configured by merge_attributes; memberUid is unioned by default.
+var mergeStrategies = map[string]string{
+	"memberuid": mergeUnion,
+}
+
+func validMergeStrategy(s string) bool {
+	switch s {
+	case mergeUnion, mergeReplace, mergeSourceWins, mergeTargetWins, mergeRemoveAbsent, mergeExact:
+		return true
+	}
+	return false
+}
+
+// initMergeStrategies replaces the default merge attributes with the
+// configured ones.
+func initMergeStrategies() error {
+	if config.MergeAttributes == nil {
+		return nil
+	}
+	strategies := make(map[string]string, len(config.MergeAttributes))
+	for attr, strategy := range config.MergeAttributes {
+		if strategy == "" {
+			strategy = mergeUnion
+		}
+		if !validMergeStrategy(strategy) {
+			return fmt.Errorf("merge_attributes: invalid strategy %q for %s", strategy, attr)
+		}
+		strategies[strings.ToLower(attr)] = strategy
+	}
+	mergeStrategies = strategies
+	return nil
+}
+
+// isProtectedMember reports whether a value is listed in protected_members.
+// DN values are compared in normalized form, others case-insensitively.
+func isProtectedMember(value string) bool {
+	for _, p := range config.ProtectedMembers {
+		if strings.EqualFold(p, value) || normalizeDN(p) == normalizeDN(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsValue(vals []string, v string) bool {
+	for _, x := range vals {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeStrategy(attr string) (string, bool) {
+	s, ok := mergeStrategies[strings.ToLower(attr)]
+	return s, ok
+}
+
+func mergeAttributeNames() []string {
+	names := make([]string, 0, len(mergeStrategies))
+	for attr := range mergeStrategies {
+		names = append(names, attr)
+	}
+	return names
+}
+
+// mergeValues combines the values being written to attr by contributors (see
+// managedContributors) with the target's existing values. keep is false when
+// the attribute should be left out of the modify so the target's values
+// stay untouched.
+func mergeValues(strategy, dn, attr string, contributors []string, existing, incoming []string) (merged []string, keep bool) {
+	switch strategy {
+	case mergeReplace:
+		return incoming, true
+	case mergeSourceWins:
+		return incoming, len(incoming) > 0
+	case mergeTargetWins:
+		return incoming, len(existing) == 0
+	case mergeRemoveAbsent:
+		// A value is removed when one of these contributors wrote it before
+		// (or it was written before contributors were tracked) and no
+		// contributor produces it now.
+		previous := make(map[string]struct{})
+		produced := make(map[string]struct{})
+		for _, v := range incoming {
+			produced[v] = struct{}{}
+		}
+		for c, vals := range managedValues.get(dn, attr) {
+			for _, v := range vals {
+				if c == legacyContributor || containsValue(contributors, c) {
+					previous[v] = struct{}{}
+				} else {
+					produced[v] = struct{}{}
+				}
+			}
+		}
+		var kept []string
+		for _, v := range existing {
+			_, wrote := previous[v]
+			_, wanted := produced[v]
+			if !wrote || wanted {
+				kept = append(kept, v)
+			}
+		}
+		return mergeUnique(kept, incoming), true
+	case mergeExact:
+		var protected []string
+		removed := 0
+		for _, v := range existing {
+			if isProtectedMember(v) {
+				protected = append(protected, v)
+			} else if !containsValue(incoming, v) {
+				removed++
+			}
+		}
+		if removed > 0 {
+			addCounter(mMembersRemoved, float64(removed), "attribute", strings.ToLower(attr))
+			logger.Info("Removing values absent from the source", "DN", dn, "Attribute", attr, "Count", removed)
+		}
+		return mergeUnique(protected, incoming), true
+	default:
+		if len(incoming) == 0 || len(existing) == 0 {
+			return incoming, true
+		}
+		return mergeUnique(existing, incoming), true
+	}
+}
+
+// managedValueStore remembers the values ldap-sync last wrote to attributes
+// merged with remove-absent, by contributor, so values it no longer produces
+// can be removed. With the database enabled the values are stored in
+// managed_contributions.
+type managedValueStore struct {
+	mu sync.Mutex
+	// values maps normalized DN + "\x00" + lowercase attribute to the
+	// values of each contributor.
+	values map[string]map[string][]string
+}
+
+var managedValues = &managedValueStore{values: make(map[string]map[string][]string)}
+
+// legacyContributor holds the values recorded before contributors were
+// tracked (the managed_values table). Every contributor may remove them,
+// and they are dropped once the attribute is written again.
+const legacyContributor = ""
+
+// managedContributor identifies what produced an entry for the values it
+// manages: its search and the source entry it was produced from.
+func managedContributor(entry *TransformedEntry) string {
+	return entry.Search + "\x00" + normalizeDN(entry.Origin)
+}
+
+// managedContributors returns the contributors of an entry: its own, or
+// those of the writes it was coalesced from.
+func managedContributors(entry *TransformedEntry) []string {
+	if len(entry.Contributions) == 0 {
+		return []string{managedContributor(entry)}
+	}
+	return sortedContributors(entry.Contributions)
+}
+
+// rememberManagedValues records the values each contributor of an entry
+// wrote to the attributes written.
+func rememberManagedValues(entry *TransformedEntry, written map[string][]string) {
+	if len(entry.Contributions) == 0 {
+		managedValues.remember(entry.DN, managedContributor(entry), written)
+		return
+	}
+	for c, content := range entry.Contributions {
+		own := make(map[string][]string, len(written))
+		for attr := range written {
+			own[attr] = toStringSlice(content[attr])
+		}
+		managedValues.remember(entry.DN, c, own)
+	}
+}
+
+func managedValueKey(dn, attr string) string {
+	return normalizeDN(dn) + "\x00" + strings.ToLower(attr)
+}
+
+// get returns a copy of the values last written to an attribute, by
+// contributor.
+func (s *managedValueStore) get(dn, attr string) map[string][]string {
+	key := managedValueKey(dn, attr)
+	s.mu.Lock()
+	byContributor, ok := s.values[key]
+	if ok || db == nil {
+		defer s.mu.Unlock()
+		return copyManagedValues(byContributor)
+	}
+	s.mu.Unlock()
+	byContributor, err := loadManagedValues(normalizeDN(dn), strings.ToLower(attr))
+	if err != nil {
+		logger.Error("Failed to read managed values", "DN", dn, "Attribute", attr, "Err", err)
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.values[key]; ok {
+		byContributor = current
+	} else {
+		s.values[key] = byContributor
+	}
+	return copyManagedValues(byContributor)
+}
+
+func copyManagedValues(byContributor map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(byContributor))
+	for c, vals := range byContributor {
+		out[c] = vals
+	}
+	return out
+}
+
+func loadManagedValues(dnKey, attr string) (map[string][]string, error) {
+	byContributor := make(map[string][]string)
+	rows, err := db.Query(`SELECT contributor, vals FROM managed_contributions WHERE dn_key = $1 AND attribute = $2`, dnKey, attr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var contributor string
+		var data []byte
+		if err := rows.Scan(&contributor, &data); err != nil {
+			return nil, err
+		}
+		var vals []string
+		if err := json.Unmarshal(data, &vals); err != nil {
+			return nil, err
+		}
+		byContributor[contributor] = vals
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = db.QueryRow(`SELECT vals FROM managed_values WHERE dn_key = $1 AND attribute = $2`, dnKey, attr).Scan(&data)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, err
+	default:
+		var vals []string
+		if err := json.Unmarshal(data, &vals); err != nil {
+			return nil, err
+		}
+		byContributor[legacyContributor] = vals
+	}
+	return byContributor, nil
+}
+
+// remember records the values a contributor wrote for the remove-absent
+// attributes of an entry after a successful write.
+func (s *managedValueStore) remember(dn, contributor string, written map[string][]string) {
+	for attr, vals := range written {
+		if strategy, ok := mergeStrategy(attr); !ok || strategy != mergeRemoveAbsent {
+			continue
+		}
+		vals = append([]string{}, vals...)
+		key := managedValueKey(dn, attr)
+		s.mu.Lock()
+		byContributor := s.values[key]
+		if byContributor == nil {
+			byContributor = make(map[string][]string)
+			s.values[key] = byContributor
+		}
+		byContributor[contributor] = vals
+		delete(byContributor, legacyContributor)
+		s.mu.Unlock()
+		if db == nil {
+			continue
+		}
+		data, err := json.Marshal(vals)
+		if err != nil {
+			continue
+		}
+		const upsertSQL = `
+		INSERT INTO managed_contributions (dn_key, attribute, contributor, vals, updated_at) VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (dn_key, attribute, contributor) DO UPDATE SET vals = $4, updated_at = NOW();`
+		if _, err := db.Exec(upsertSQL, normalizeDN(dn), strings.ToLower(attr), contributor, string(data)); err != nil {
+			logger.Error("Failed to persist managed values", "DN", dn, "Attribute", attr, "Err", err)
+			continue
+		}
+		if _, err := db.Exec(`DELETE FROM managed_values WHERE dn_key = $1 AND attribute = $2`, normalizeDN(dn), strings.ToLower(attr)); err != nil {
+			logger.Error("Failed to drop legacy managed values", "DN", dn, "Attribute", attr, "Err", err)
+		}
+	}
+}
+
+// forget drops the values remembered for every attribute of a deleted
+// entry.
+func (s *managedValueStore) forget(dn string) {
+	prefix := normalizeDN(dn) + "\x00"
+	s.mu.Lock()
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.values, key)
+		}
+	}
+	s.mu.Unlock()
+	if db == nil {
+		return
+	}
+	for _, table := range []string{"managed_contributions", "managed_values"} {
+		if _, err := db.Exec(`DELETE FROM `+table+` WHERE dn_key = $1`, normalizeDN(dn)); err != nil {
+			logger.Error("Failed to delete managed values", "DN", dn, "Err", err)
+		}
+	}
+}
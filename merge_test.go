@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeValues(t *testing.T) {
+	const dn = "cn=staff,ou=groups,dc=example,dc=org"
+	const self, other = "groups\x00uid=a,dc=org", "groups\x00uid=b,dc=org"
+	tests := []struct {
+		name      string
+		strategy  string
+		managed   map[string][]string // Values last written, by contributor
+		protected []string
+		existing  []string
+		incoming  []string
+		want      []string
+		wantKeep  bool
+	}{
+		{name: "union adds", strategy: mergeUnion, existing: []string{"a", "b"}, incoming: []string{"b", "c"}, want: []string{"a", "b", "c"}, wantKeep: true},
+		{name: "union onto empty", strategy: mergeUnion, incoming: []string{"a"}, want: []string{"a"}, wantKeep: true},
+		{name: "union of nothing clears", strategy: mergeUnion, existing: []string{"a"}, want: nil, wantKeep: true},
+		{name: "replace", strategy: mergeReplace, existing: []string{"a"}, incoming: []string{"b"}, want: []string{"b"}, wantKeep: true},
+		{name: "replace with nothing clears", strategy: mergeReplace, existing: []string{"a"}, want: nil, wantKeep: true},
+		{name: "source wins", strategy: mergeSourceWins, existing: []string{"a"}, incoming: []string{"b"}, want: []string{"b"}, wantKeep: true},
+		{name: "source wins without values keeps target", strategy: mergeSourceWins, existing: []string{"a"}, want: nil, wantKeep: false},
+		{name: "target wins keeps target", strategy: mergeTargetWins, existing: []string{"a"}, incoming: []string{"b"}, want: []string{"b"}, wantKeep: false},
+		{name: "target wins onto empty", strategy: mergeTargetWins, incoming: []string{"b"}, want: []string{"b"}, wantKeep: true},
+		{
+			name: "remove-absent drops own stale values", strategy: mergeRemoveAbsent,
+			managed:  map[string][]string{self: {"a", "b"}},
+			existing: []string{"a", "b", "manual"}, incoming: []string{"a", "c"},
+			want: []string{"a", "manual", "c"}, wantKeep: true,
+		},
+		{
+			name: "remove-absent keeps other contributors' values", strategy: mergeRemoveAbsent,
+			managed:  map[string][]string{self: {"a"}, other: {"b"}},
+			existing: []string{"a", "b"}, incoming: []string{"c"},
+			want: []string{"b", "c"}, wantKeep: true,
+		},
+		{
+			name: "remove-absent keeps values another contributor also wrote", strategy: mergeRemoveAbsent,
+			managed:  map[string][]string{self: {"a"}, other: {"a"}},
+			existing: []string{"a"}, incoming: nil,
+			want: []string{"a"}, wantKeep: true,
+		},
+		{
+			name: "remove-absent drops legacy values", strategy: mergeRemoveAbsent,
+			managed:  map[string][]string{legacyContributor: {"a"}},
+			existing: []string{"a", "manual"}, incoming: []string{"b"},
+			want: []string{"manual", "b"}, wantKeep: true,
+		},
+		{
+			name: "remove-absent without history only adds", strategy: mergeRemoveAbsent,
+			existing: []string{"a"}, incoming: []string{"b"},
+			want: []string{"a", "b"}, wantKeep: true,
+		},
+		{
+			name: "exact drops absent values", strategy: mergeExact,
+			existing: []string{"a", "b"}, incoming: []string{"b", "c"},
+			want: []string{"b", "c"}, wantKeep: true,
+		},
+		{
+			name: "exact keeps protected members", strategy: mergeExact,
+			protected: []string{"uid=admin,dc=org"},
+			existing:  []string{"UID=Admin, DC=org", "uid=x,dc=org"}, incoming: []string{"uid=y,dc=org"},
+			want: []string{"UID=Admin, DC=org", "uid=y,dc=org"}, wantKeep: true,
+		},
+	}
+	defer func(p []string) { config.ProtectedMembers = p }(config.ProtectedMembers)
+	defer func(s *managedValueStore) { managedValues = s }(managedValues)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ProtectedMembers = tt.protected
+			managedValues = &managedValueStore{values: make(map[string]map[string][]string)}
+			if tt.managed != nil {
+				managedValues.values[managedValueKey(dn, "member")] = tt.managed
+			}
+			got, keep := mergeValues(tt.strategy, dn, "member", []string{self}, tt.existing, tt.incoming)
+			if !reflect.DeepEqual(got, tt.want) || keep != tt.wantKeep {
+				t.Errorf("mergeValues() = %q, %v; want %q, %v", got, keep, tt.want, tt.wantKeep)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// metricFamily is a named counter or gauge with labelled series.
+type metricFamily struct {
+	kind   string // "counter" or "gauge"
+	help   string
+	series map[string]float64 // rendered label set -> value
+}
+
+// metricsRegistry is a minimal Prometheus-compatible registry; the service
+// only needs counters and gauges, so it avoids pulling in a client library.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	families   map[string]*metricFamily
+	collectors []func()
+}
+
+var metrics = &metricsRegistry{families: make(map[string]*metricFamily)}
+
+// describeMetric registers a metric family and returns its name, so metrics
+// can be declared once as package-level variables next to the code using them.
+func describeMetric(name, kind, help string) string {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.families[name] = &metricFamily{kind: kind, help: help, series: make(map[string]float64)}
+	return name
+}
+
+// registerCollector adds a function run before each scrape, for gauges that
+// are derived from state rather than updated as it changes.
+func registerCollector(fn func()) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.collectors = append(metrics.collectors, fn)
+}
+
+// resetGauge removes all series of a gauge so a collector can repopulate it
+// without leaving stale label sets behind.
+func resetGauge(name string) {
+	metrics.mu.Lock()
+	if f, ok := metrics.families[name]; ok {
+		f.series = make(map[string]float64)
+	}
+	metrics.mu.Unlock()
+}
+
+// labelKey renders alternating name/value pairs into a Prometheus label set.
+func labelKey(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// incCounter adds one to a counter. labels are alternating name/value pairs.
+func incCounter(name string, labels ...string) {
+	addCounter(name, 1, labels...)
+}
+
+func addCounter(name string, v float64, labels ...string) {
+	metrics.mu.Lock()
+	if f, ok := metrics.families[name]; ok {
+		f.series[labelKey(labels)] += v
+	}
+	metrics.mu.Unlock()
+}
+
+// setGauge sets a gauge to an absolute value.
+func setGauge(name string, v float64, labels ...string) {
+	metrics.mu.Lock()
+	if f, ok := metrics.families[name]; ok {
+		f.series[labelKey(labels)] = v
+	}
+	metrics.mu.Unlock()
+}
+
+// render writes all families in the Prometheus text exposition format.
+func (r *metricsRegistry) render() string {
+	r.mu.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s%s %g\n", name, k, f.series[k])
+		}
+	}
+	return b.String()
+}
+
+// metricsHandler exposes the service metrics.
+// @Summary Metrics
+// @Description Returns service metrics in the Prometheus text exposition format.
+// @Tags probes
+// @Produce plain
+// @Success 200 {string} string "metrics"
+// @Router /metrics [get]
+func metricsHandler(c echo.Context) error {
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(metrics.render()))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsPublisher speaks the core NATS text protocol, which is all publishing
+// needs: CONNECT once, then PUB per event, answering the server's PINGs.
+// Events go to <subject>.<origin>.<operation>, so subscribers can pick
+// changes with wildcards such as ldapsync.changes.target.>.
+
+// NATSEventsConfig configures publishing to NATS.
+type NATSEventsConfig struct {
+	URL     string `yaml:"url"`     // nats://host:4222 or tls://host:4222
+	Subject string `yaml:"subject"` // Subject prefix (default: ldapsync.changes)
+	// Token, or User and Password, authenticate the connection;
+	// PasswordFile is read for the password or token.
+	Token        string `yaml:"token"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type natsPublisher struct {
+	cfg     NATSEventsConfig
+	subject string
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newNATSPublisher(cfg EventsConfig) (eventPublisher, error) {
+	n := cfg.NATS
+	if n.URL == "" {
+		return nil, fmt.Errorf("nats.url is required")
+	}
+	u, err := url.Parse(n.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("nats.url: invalid URL %q", n.URL)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("nats.url: scheme must be nats or tls")
+	}
+	subject := n.Subject
+	if subject == "" {
+		subject = "ldapsync.changes"
+	}
+	return &natsPublisher{cfg: n, subject: subject}, nil
+}
+
+func (p *natsPublisher) Publish(ev *ChangeEvent, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	subject := p.subject + "." + ev.Origin + "." + ev.Operation
+	fmt.Fprintf(p.w, "PUB %s %d\r\n", subject, len(data))
+	p.w.Write(data)
+	p.w.WriteString("\r\n")
+	if err := p.w.Flush(); err != nil {
+		p.closeLocked()
+		return err
+	}
+	return nil
+}
+
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closeLocked()
+}
+
+func (p *natsPublisher) closeLocked() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.w = nil, nil
+	return err
+}
+
+// connect dials the server, reads its INFO, authenticates and waits for the
+// PONG confirming the connection was accepted. The caller holds p.mu.
+func (p *natsPublisher) connect() error {
+	u, _ := url.Parse(p.cfg.URL)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", u.Host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", u.Host)
+	}
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("NATS handshake failed: %q %v", strings.TrimSpace(line), err)
+	}
+
+	secret := p.cfg.Password
+	if p.cfg.PasswordFile != "" {
+		data, err := os.ReadFile(p.cfg.PasswordFile)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to read NATS password file: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "ldap-sync", "lang": "go", "version": "1"}
+	switch {
+	case p.cfg.User != "":
+		opts["user"], opts["pass"] = p.cfg.User, secret
+	case p.cfg.Token != "":
+		opts["auth_token"] = p.cfg.Token
+	case secret != "":
+		opts["auth_token"] = secret
+	}
+	connectJSON, _ := json.Marshal(opts)
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\nPING\r\n", connectJSON)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	line, err = r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "PONG" {
+		conn.Close()
+		return fmt.Errorf("NATS connect rejected: %q %v", strings.TrimSpace(line), err)
+	}
+	conn.SetDeadline(time.Time{})
+	p.conn, p.w = conn, w
+	go p.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers server PINGs and logs errors until the connection ends.
+func (p *natsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			p.mu.Lock()
+			if p.conn == conn {
+				p.closeLocked()
+			}
+			p.mu.Unlock()
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			p.mu.Lock()
+			if p.conn == conn {
+				p.w.WriteString("PONG\r\n")
+				p.w.Flush()
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			logger.Warn("NATS server error", "Err", line)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ObjectClassConfig decides the object classes of new target entries that
+// arrive without any. Rules are tried in order; the first matching rule
+// wins.
+type ObjectClassConfig struct {
+	Rules []ObjectClassRule `yaml:"rules"`
+	// Default applies when no rule matches (default: top, inetOrgPerson).
+	Default []string `yaml:"default"`
+	// RejectMissing fails the write instead of using Default.
+	RejectMissing bool `yaml:"reject_missing"`
+}
+
+// ObjectClassRule matches entries by search, DN regex and/or DN suffix; all
+// given conditions must hold.
+type ObjectClassRule struct {
+	Search        string   `yaml:"search"`
+	Match         string   `yaml:"match"`
+	Suffix        string   `yaml:"suffix"`
+	ObjectClasses []string `yaml:"object_classes"`
+}
+
+type compiledObjectClassRule struct {
+	rule   ObjectClassRule
+	re     *regexp.Regexp
+	suffix *ldap.DN
+}
+
+var objectClassRules []compiledObjectClassRule
+
+func initObjectClassRules() error {
+	for i, rule := range config.ObjectClasses.Rules {
+		c := compiledObjectClassRule{rule: rule}
+		if len(rule.ObjectClasses) == 0 {
+			return fmt.Errorf("object_classes rule %d: object_classes is required", i)
+		}
+		if rule.Match != "" {
+			re, err := regexp.Compile("(?i)" + rule.Match)
+			if err != nil {
+				return fmt.Errorf("object_classes rule %d: %w", i, err)
+			}
+			c.re = re
+		}
+		if rule.Suffix != "" {
+			suffix, err := ldap.ParseDN(rule.Suffix)
+			if err != nil {
+				return fmt.Errorf("object_classes rule %d: invalid suffix: %w", i, err)
+			}
+			c.suffix = suffix
+		}
+		objectClassRules = append(objectClassRules, c)
+	}
+	return nil
+}
+
+func (c compiledObjectClassRule) matches(entry *TransformedEntry, parsed *ldap.DN) bool {
+	if c.rule.Search != "" && c.rule.Search != entry.Search {
+		return false
+	}
+	if c.re != nil && !c.re.MatchString(entry.DN) {
+		return false
+	}
+	if c.suffix != nil && (parsed == nil || !c.suffix.AncestorOfFold(parsed)) {
+		return false
+	}
+	return true
+}
+
+// ensureObjectClass sets the object classes of an entry being added when the
+// hooks provided none.
+func ensureObjectClass(entry *TransformedEntry, attributes map[string][]string) error {
+	for attr, vals := range attributes {
+		if strings.EqualFold(attr, "objectClass") && len(vals) > 0 {
+			return nil
+		}
+	}
+	parsed, _ := ldap.ParseDN(entry.DN)
+	classes := config.ObjectClasses.Default
+	matched := false
+	for _, rule := range objectClassRules {
+		if rule.matches(entry, parsed) {
+			classes, matched = rule.rule.ObjectClasses, true
+			break
+		}
+	}
+	if !matched {
+		if config.ObjectClasses.RejectMissing {
+			return fmt.Errorf("entry %q has no objectClass and no object_classes rule matches", entry.DN)
+		}
+		if len(classes) == 0 {
+			classes = []string{"top", "inetOrgPerson"}
+		}
+	}
+	attributes["objectClass"] = append([]string{}, classes...)
+	return nil
+}
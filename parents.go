@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ParentsConfig creates missing intermediate containers when an add fails
+// because the new entry's parent does not exist.
+type ParentsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ObjectClasses maps the attribute type of a container's RDN to the
+	// object classes it is created with (default: ou: [top,
+	// organizationalUnit]). Containers with other RDN types are not created.
+	ObjectClasses map[string][]string `yaml:"object_classes"`
+}
+
+var mParentsCreated = describeMetric("ldapsync_parents_created_total", "counter",
+	"Intermediate target containers created for new entries.")
+
+func isNoSuchObject(err error) bool {
+	ldapErr, ok := err.(*ldap.Error)
+	return ok && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject
+}
+
+func parentObjectClasses(rdnType string) []string {
+	classes := config.CreateParents.ObjectClasses
+	if classes == nil {
+		classes = map[string][]string{"ou": {"top", "organizationalUnit"}}
+	}
+	for t, oc := range classes {
+		if strings.EqualFold(t, rdnType) {
+			return oc
+		}
+	}
+	return nil
+}
+
+// ensureParents creates the missing ancestors of entry.DN below the target
+// base DN, top-down. Containers created concurrently by another write are
+// accepted.
+func ensureParents(l *ldap.Conn, entry *TransformedEntry) error {
+	parsed, err := ldap.ParseDN(entry.DN)
+	if err != nil {
+		return err
+	}
+	base, err := ldap.ParseDN(config.Target.BaseDN)
+	if err != nil {
+		return fmt.Errorf("invalid target base DN: %w", err)
+	}
+
+	// Walk up from the immediate parent until an existing entry is found.
+	var missing []*ldap.DN
+	for i := 1; i < len(parsed.RDNs); i++ {
+		parent := &ldap.DN{RDNs: parsed.RDNs[i:]}
+		if !base.AncestorOfFold(parent) {
+			break
+		}
+		existing, err := readTargetAttributes(l, parent.String(), map[string][]string{"objectClass": nil})
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			break
+		}
+		missing = append(missing, parent)
+	}
+	if len(missing) == 0 {
+		return fmt.Errorf("parent of %q is missing and outside the target base DN", entry.DN)
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		parent := missing[i]
+		rdn := parent.RDNs[0]
+		dn := parent.String()
+		if isDeniedWrite("write", dn) {
+			return fmt.Errorf("parent %q is in a denied subtree", dn)
+		}
+		attrs := map[string][]string{}
+		for _, a := range rdn.Attributes {
+			oc := parentObjectClasses(a.Type)
+			if oc == nil {
+				return fmt.Errorf("cannot create parent %q: no object classes configured for %s", dn, a.Type)
+			}
+			attrs["objectClass"] = mergeUnique(attrs["objectClass"], oc)
+			attrs[a.Type] = append(attrs[a.Type], a.Value)
+		}
+		stampOwnershipMarker(attrs, true)
+		req := ldap.NewAddRequest(dn, nil)
+		for attr, vals := range attrs {
+			req.Attribute(attr, vals)
+		}
+		waitTargetWrite()
+		err := l.Add(req)
+		if ldapErr, ok := err.(*ldap.Error); ok && ldapErr.ResultCode == ldap.LDAPResultEntryAlreadyExists {
+			continue
+		}
+		journalWrite(&TransformedEntry{DN: dn, Search: entry.Search, Producer: entry.Producer}, "add", attrs, nil, err)
+		if err != nil {
+			return fmt.Errorf("creating parent %q: %w", dn, err)
+		}
+		targetMirror.invalidate(dn)
+		incCounter(mParentsCreated)
+		logger.Info("Created parent container in destination LDAP", "DN", dn, "For", entry.DN)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Password attributes (userPassword and authPassword unless
+// password_sync.attributes says otherwise) are only read from the source
+// when password_sync is enabled, and then only for the searches it lists.
+// Their values are hashes and are passed through unchanged: they are
+// requested explicitly, since servers often leave them out of "*", and
+// written with Replace, never merged. Wherever the sync exposes entry
+// content (GET /results, GET /target/entry, dead letters, the change
+// journal, dry-run previews, change events and provisioning webhooks) the
+// values read [redacted]. Hooks and embedded transforms receive the
+// placeholder as well unless hook_access is set; a hook that returns the
+// placeholder for an attribute gets the source entry's values written in its
+// place.
+
+// PasswordSyncConfig configures the replication of password hashes.
+type PasswordSyncConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Attributes are the password attributes (default: userPassword and
+	// authPassword).
+	Attributes []string `yaml:"attributes"`
+	// Searches read the password attributes (default: all searches).
+	Searches []string `yaml:"searches"`
+	// HookAccess sends the hashes to hooks instead of the placeholder.
+	HookAccess bool `yaml:"hook_access"`
+	// ShowInResults returns the hashes from GET /results and
+	// GET /target/entry.
+	ShowInResults bool `yaml:"show_in_results"`
+}
+
+// passwordPlaceholder replaces password values wherever they are exposed.
+const passwordPlaceholder = "[redacted]"
+
+var defaultPasswordAttributes = []string{"userPassword", "authPassword"}
+
+func validatePasswordSync() error {
+	for _, attr := range config.PasswordSync.Attributes {
+		if strings.TrimSpace(attr) == "" || strings.ContainsAny(attr, ", ") {
+			return fmt.Errorf("password_sync: invalid attribute name %q", attr)
+		}
+	}
+	return nil
+}
+
+func passwordAttributes() []string {
+	if len(config.PasswordSync.Attributes) > 0 {
+		return config.PasswordSync.Attributes
+	}
+	return defaultPasswordAttributes
+}
+
+// isPasswordAttr reports whether attr holds password hashes. Names are
+// compared case-insensitively and without attribute options.
+func isPasswordAttr(attr string) bool {
+	base, _, _ := strings.Cut(attr, ";")
+	for _, name := range passwordAttributes() {
+		if strings.EqualFold(base, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncsPasswords reports whether a search reads the password attributes.
+func syncsPasswords(searchID string) bool {
+	cfg := config.PasswordSync
+	return cfg.Enabled && (len(cfg.Searches) == 0 || slices.Contains(cfg.Searches, searchID))
+}
+
+// redactPasswords returns content with the values of password attributes
+// replaced by the placeholder; content itself is returned when it has none.
+func redactPasswords(content map[string]interface{}) map[string]interface{} {
+	var out map[string]interface{}
+	for attr, val := range content {
+		if !isPasswordAttr(attr) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]interface{}, len(content))
+			for k, v := range content {
+				out[k] = v
+			}
+		}
+		switch vals := val.(type) {
+		case []string:
+			out[attr] = redactedValues(attr, vals)
+		case []interface{}:
+			out[attr] = redactedValues(attr, make([]string, len(vals)))
+		default:
+			out[attr] = passwordPlaceholder
+		}
+	}
+	if out == nil {
+		return content
+	}
+	return out
+}
+
+// withoutPasswords returns content without its password attributes;
+// content itself is returned when it has none.
+func withoutPasswords(content map[string]interface{}) map[string]interface{} {
+	var out map[string]interface{}
+	for attr := range content {
+		if !isPasswordAttr(attr) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]interface{}, len(content))
+			for k, v := range content {
+				if !isPasswordAttr(k) {
+					out[k] = v
+				}
+			}
+		}
+	}
+	if out == nil {
+		return content
+	}
+	return out
+}
+
+// redactedValues returns a placeholder for each value of a password
+// attribute, and the values themselves for other attributes.
+func redactedValues(attr string, vals []string) []string {
+	if !isPasswordAttr(attr) {
+		return vals
+	}
+	out := make([]string, len(vals))
+	for i := range out {
+		out[i] = passwordPlaceholder
+	}
+	return out
+}
+
+// resultsContent returns result content as GET /results shows it.
+func resultsContent(content map[string]interface{}) map[string]interface{} {
+	if config.PasswordSync.ShowInResults {
+		return content
+	}
+	return redactPasswords(content)
+}
+
+// hidePasswords replaces the password values of a hook request with the
+// placeholder, unless hooks may see them, and returns the values hidden by
+// lower-cased attribute name for restorePasswords.
+func hidePasswords(req *HookRequest) map[string]interface{} {
+	if config.PasswordSync.HookAccess {
+		return nil
+	}
+	var hidden map[string]interface{}
+	for attr, val := range req.Content {
+		if isPasswordAttr(attr) {
+			if hidden == nil {
+				hidden = make(map[string]interface{})
+			}
+			hidden[strings.ToLower(attr)] = val
+		}
+	}
+	req.Content = redactPasswords(req.Content)
+	req.PreviousContent = redactPasswords(req.PreviousContent)
+	return hidden
+}
+
+// restorePasswords puts the hidden source values back into the attributes a
+// hook returned with the placeholder. A placeholder with no hidden value
+// behind it drops the attribute, so the placeholder is never written. The
+// entries are copied when changed, as cached hook responses share them.
+func restorePasswords(entries []TransformedEntry, hidden map[string]interface{}) []TransformedEntry {
+	var out []TransformedEntry
+	for i, e := range entries {
+		var content map[string]interface{}
+		for attr, val := range e.Content {
+			if !isPlaceholder(val) {
+				continue
+			}
+			if content == nil {
+				content = make(map[string]interface{}, len(e.Content))
+				for k, v := range e.Content {
+					content[k] = v
+				}
+			}
+			if orig, ok := hidden[strings.ToLower(attr)]; ok {
+				content[attr] = orig
+			} else {
+				delete(content, attr)
+			}
+		}
+		if content == nil {
+			continue
+		}
+		if out == nil {
+			out = append([]TransformedEntry{}, entries...)
+		}
+		out[i].Content = content
+	}
+	if out == nil {
+		return entries
+	}
+	return out
+}
+
+func isPlaceholder(val interface{}) bool {
+	switch v := val.(type) {
+	case string:
+		return v == passwordPlaceholder
+	case []interface{}:
+		return len(v) > 0 && v[0] == passwordPlaceholder
+	case []string:
+		return len(v) > 0 && v[0] == passwordPlaceholder
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// With pending_timeout.max_age_s set, entries that wait in the dependency
+// tracker longer than that are overdue. Overdue entries are logged once,
+// counted by ldapsync_pending_entries_overdue and marked in GET
+// /dependencies, and the action decides what else happens: flag (default)
+// keeps them waiting, drop discards them like DELETE /dependencies/:dn, and
+// write writes them without their unsynced dependencies, leaving out the
+// values that reference unresolved bindings. An entry whose DN itself is
+// unresolved cannot be written and stays pending.
+
+// PendingTimeoutConfig configures overdue pending entries.
+type PendingTimeoutConfig struct {
+	MaxAgeSec   int    `yaml:"max_age_s"`  // Wait before an entry is overdue (default: 0, never)
+	Action      string `yaml:"action"`     // flag (default), write or drop
+	IntervalSec int    `yaml:"interval_s"` // How often pending entries are checked (default: 60)
+}
+
+const (
+	pendingTimeoutFlag  = "flag"
+	pendingTimeoutWrite = "write"
+	pendingTimeoutDrop  = "drop"
+)
+
+var (
+	mPendingOverdue = describeMetric("ldapsync_pending_entries_overdue", "gauge",
+		"Pending entries waiting longer than pending_timeout.max_age_s.")
+	mPendingTimeouts = describeMetric("ldapsync_pending_timeouts_total", "counter",
+		"Overdue pending entries acted on, by action (write or drop).")
+)
+
+// overdueWarned holds the keys of overdue entries already logged, so each
+// is only warned about once. Only the checker goroutine uses it.
+var overdueWarned = make(map[string]struct{})
+
+func init() {
+	registerCollector(func() {
+		setGauge(mPendingOverdue, float64(len(dependencyTracker.overdueKeys())))
+	})
+}
+
+func validatePendingTimeout() error {
+	cfg := config.PendingTimeout
+	if cfg.MaxAgeSec < 0 {
+		return fmt.Errorf("pending_timeout: max_age_s must not be negative")
+	}
+	switch cfg.Action {
+	case "", pendingTimeoutFlag, pendingTimeoutWrite, pendingTimeoutDrop:
+		return nil
+	}
+	return fmt.Errorf("pending_timeout: unknown action %q", cfg.Action)
+}
+
+func pendingMaxAge() time.Duration {
+	return time.Duration(config.PendingTimeout.MaxAgeSec) * time.Second
+}
+
+// pendingOverdueLocked reports whether a pending entry has waited too long;
+// d.mu must be held.
+func (d *dependencyState) pendingOverdueLocked(key string, now time.Time) bool {
+	maxAge := pendingMaxAge()
+	since, ok := d.waitingSince[key]
+	return maxAge > 0 && ok && now.Sub(since) > maxAge
+}
+
+// overdueKeys returns the keys of the overdue pending entries.
+func (d *dependencyState) overdueKeys() []string {
+	if pendingMaxAge() <= 0 {
+		return nil
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var keys []string
+	for key, p := range d.pending {
+		if p != nil && d.pendingOverdueLocked(key, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// startPendingTimeout starts checking for overdue entries when a max age is
+// configured.
+func startPendingTimeout() {
+	if pendingMaxAge() <= 0 {
+		return
+	}
+	interval := time.Duration(config.PendingTimeout.IntervalSec) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			dependencyTracker.handleOverdue()
+		}
+	}()
+}
+
+// handleOverdue warns about and acts on the overdue entries.
+func (d *dependencyState) handleOverdue() {
+	keys := d.overdueKeys()
+	current := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		current[key] = struct{}{}
+		info := d.entryInfo(key)
+		if info.Pending == nil {
+			continue
+		}
+		p := info.Pending
+		switch config.PendingTimeout.Action {
+		case pendingTimeoutDrop:
+			if d.dropPending(key) {
+				incCounter(mPendingTimeouts, "action", pendingTimeoutDrop)
+				depLogger.Warn("Dropped overdue pending entry", "DN", p.DN, "WaitingSince", p.WaitingSince,
+					"UnresolvedDependencies", p.UnresolvedDependencies, "MissingBindings", p.MissingBindings)
+			}
+			continue
+		case pendingTimeoutWrite:
+			if d.forceWritePending(key) {
+				incCounter(mPendingTimeouts, "action", pendingTimeoutWrite)
+				depLogger.Warn("Force-wrote overdue pending entry", "DN", p.DN, "WaitingSince", p.WaitingSince,
+					"UnresolvedDependencies", p.UnresolvedDependencies, "MissingBindings", p.MissingBindings)
+				continue
+			}
+		}
+		if _, warned := overdueWarned[key]; !warned {
+			overdueWarned[key] = struct{}{}
+			depLogger.Warn("Pending entry overdue", "DN", p.DN, "WaitingSince", p.WaitingSince,
+				"UnresolvedDependencies", p.UnresolvedDependencies, "MissingBindings", p.MissingBindings)
+		}
+	}
+	for key := range overdueWarned {
+		if _, ok := current[key]; !ok {
+			delete(overdueWarned, key)
+		}
+	}
+}
+
+// forceWritePending writes a pending entry without waiting for its
+// dependencies, treating unresolved bindings as null. It reports false when
+// the DN cannot be resolved or the entry left the tracker meanwhile.
+func (d *dependencyState) forceWritePending(key string) bool {
+	d.mu.Lock()
+	p := d.pending[key]
+	d.mu.Unlock()
+	if p == nil || p.entry == nil {
+		return false
+	}
+	values, nulls := getBindingsSnapshot()
+	for _, k := range collectMissingBindings(p.entry, nil, values, nulls) {
+		nulls[k] = struct{}{}
+	}
+	resolved, missing := resolveEntryTemplates(p.entry, values, nulls)
+	if missing {
+		return false
+	}
+
+	d.mu.Lock()
+	if d.pending[key] != p {
+		d.mu.Unlock()
+		return false
+	}
+	d.removePendingLocked(key, p)
+	d.mu.Unlock()
+	unpersistPending(key)
+	if p.group != nil {
+		if p.group.markReady(key, resolved) {
+			d.commitGroup(p.group)
+		}
+		return true
+	}
+	d.submitWrite(resolved, false)
+	return true
+}
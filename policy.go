@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// PolicyConfig guards destructive target operations. Deletes and renames are
+// always evaluated; modifies of existing entries are evaluated against the
+// ownership marker and modification budget, and against protected subtrees
+// when ProtectModifies is set.
+type PolicyConfig struct {
+	// MaxDeletionsPerRun and MaxModificationsPerRun cap the operations within
+	// one run window (0 disables the cap).
+	MaxDeletionsPerRun     int `yaml:"max_deletions_per_run"`
+	MaxModificationsPerRun int `yaml:"max_modifications_per_run"`
+	RunWindowSec           int `yaml:"run_window_s"` // Length of a run window (default: 3600)
+	// ProtectedSubtrees are target DNs under which entries are never
+	// deleted or renamed.
+	ProtectedSubtrees []string `yaml:"protected_subtrees"`
+	ProtectModifies   bool     `yaml:"protect_modifies"`
+	// DeniedSubtrees are target DNs at or below which ldap-sync never writes
+	// at all, whatever the hooks return.
+	DeniedSubtrees []string `yaml:"denied_subtrees"`
+	// OwnershipMarker, when set, must be present on an existing target entry
+	// before it is modified, renamed or deleted. It is added to new entries.
+	OwnershipMarker *OwnershipMarker `yaml:"ownership_marker"`
+	AuditSize       int              `yaml:"audit_size"` // Violations kept for GET /policy/violations (default: 1000)
+}
+
+// OwnershipMarker identifies target entries managed by ldap-sync.
+type OwnershipMarker struct {
+	Attribute string `yaml:"attribute"`
+	Value     string `yaml:"value"`
+}
+
+// PolicyViolation is the audit record of a blocked operation.
+type PolicyViolation struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	DN        string    `json:"dn"`
+	Rule      string    `json:"rule"`
+	Reason    string    `json:"reason"`
+}
+
+// errPolicyViolation is wrapped by every error returned for a blocked operation.
+var errPolicyViolation = errors.New("blocked by policy")
+
+const (
+	policyDelete = "delete"
+	policyRename = "rename"
+	policyModify = "modify"
+)
+
+type policyState struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	violations  []PolicyViolation
+	protected   []*ldap.DN
+	denied      []*ldap.DN
+}
+
+var policies = &policyState{counts: make(map[string]int)}
+
+var mPolicyBlocked = describeMetric("ldapsync_policy_blocked_total", "counter",
+	"Target operations blocked by policy, by operation and rule.")
+
+// initPolicy parses the protected subtrees and validates the marker.
+func initPolicy() error {
+	p := config.Policy
+	for _, s := range p.ProtectedSubtrees {
+		dn, err := ldap.ParseDN(s)
+		if err != nil {
+			return fmt.Errorf("policy: invalid protected subtree %q: %w", s, err)
+		}
+		policies.protected = append(policies.protected, dn)
+	}
+	for _, s := range p.DeniedSubtrees {
+		dn, err := ldap.ParseDN(s)
+		if err != nil {
+			return fmt.Errorf("policy: invalid denied subtree %q: %w", s, err)
+		}
+		policies.denied = append(policies.denied, dn)
+	}
+	if m := p.OwnershipMarker; m != nil && (m.Attribute == "" || m.Value == "") {
+		return fmt.Errorf("policy: ownership_marker requires attribute and value")
+	}
+	return nil
+}
+
+func (s *policyState) runWindow() time.Duration {
+	if config.Policy.RunWindowSec > 0 {
+		return time.Duration(config.Policy.RunWindowSec) * time.Second
+	}
+	return time.Hour
+}
+
+// inSubtree reports whether dn is one of roots or below one of them.
+func inSubtree(dn string, roots []*ldap.DN) bool {
+	if len(roots) == 0 {
+		return false
+	}
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return false
+	}
+	for _, p := range roots {
+		if p.EqualFold(parsed) || p.AncestorOfFold(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOwnershipMarker reports whether an existing target entry carries the
+// configured marker. Without a marker every entry is considered owned.
+func hasOwnershipMarker(existing *ldap.Entry) bool {
+	m := config.Policy.OwnershipMarker
+	if m == nil {
+		return true
+	}
+	for _, v := range getEntryAttributeValues(existing, m.Attribute) {
+		if strings.EqualFold(v, m.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// policySearchAttributes lists target attributes needed to evaluate policy.
+func policySearchAttributes() []string {
+	if m := config.Policy.OwnershipMarker; m != nil {
+		return []string{m.Attribute}
+	}
+	return nil
+}
+
+// stampOwnershipMarker adds the marker to attributes being written. For
+// modifies (add false) it only keeps the marker in an attribute that is
+// being replaced, so the write cannot strip it.
+func stampOwnershipMarker(attributes map[string][]string, add bool) {
+	m := config.Policy.OwnershipMarker
+	if m == nil {
+		return
+	}
+	for attr, vals := range attributes {
+		if strings.EqualFold(attr, m.Attribute) {
+			attributes[attr] = mergeUnique(vals, []string{m.Value})
+			return
+		}
+	}
+	if add {
+		attributes[m.Attribute] = []string{m.Value}
+	}
+}
+
+// checkPolicy evaluates a destructive operation against the policy and
+// records an audit entry when it is blocked. existing is the current target
+// entry, if known. Allowed operations count against the run budget.
+func checkPolicy(op, dn string, existing *ldap.Entry) error {
+	p := config.Policy
+	rule, reason := "", ""
+	switch {
+	case inSubtree(dn, policies.denied):
+		rule, reason = "denied_subtree", "entry is in a subtree ldap-sync never writes to"
+	case (op == policyDelete || op == policyRename || (op == policyModify && p.ProtectModifies)) && inSubtree(dn, policies.protected):
+		rule, reason = "protected_subtree", "entry is in a protected subtree"
+	case existing != nil && !hasOwnershipMarker(existing):
+		rule = "ownership_marker"
+		reason = fmt.Sprintf("entry lacks %s=%s", p.OwnershipMarker.Attribute, p.OwnershipMarker.Value)
+	}
+
+	policies.mu.Lock()
+	now := time.Now()
+	if now.Sub(policies.windowStart) >= policies.runWindow() {
+		policies.windowStart = now
+		policies.counts = make(map[string]int)
+	}
+	if rule == "" {
+		limit, limitRule := 0, ""
+		switch op {
+		case policyDelete:
+			limit, limitRule = p.MaxDeletionsPerRun, "max_deletions_per_run"
+		case policyModify:
+			limit, limitRule = p.MaxModificationsPerRun, "max_modifications_per_run"
+		}
+		if limit > 0 && policies.counts[op] >= limit {
+			rule = limitRule
+			reason = fmt.Sprintf("%d %ss already performed this run", policies.counts[op], op)
+		} else {
+			policies.counts[op]++
+		}
+	}
+	policies.mu.Unlock()
+	if rule == "" {
+		return nil
+	}
+	recordViolation(PolicyViolation{Time: now, Operation: op, DN: dn, Rule: rule, Reason: reason})
+	return fmt.Errorf("%s of %s %w: %s", op, dn, errPolicyViolation, reason)
+}
+
+// isDeniedWrite reports whether dn lies in a denied subtree, recording the
+// violation if so. Callers skip the write entirely.
+func isDeniedWrite(op, dn string) bool {
+	if !inSubtree(dn, policies.denied) {
+		return false
+	}
+	recordViolation(PolicyViolation{Time: time.Now(), Operation: op, DN: dn, Rule: "denied_subtree",
+		Reason: "entry is in a subtree ldap-sync never writes to"})
+	return true
+}
+
+// recordViolation logs, counts and audits a blocked operation.
+func recordViolation(v PolicyViolation) {
+	policies.mu.Lock()
+	policies.violations = append(policies.violations, v)
+	size := config.Policy.AuditSize
+	if size <= 0 {
+		size = 1000
+	}
+	if over := len(policies.violations) - size; over > 0 {
+		policies.violations = append([]PolicyViolation{}, policies.violations[over:]...)
+	}
+	policies.mu.Unlock()
+
+	incCounter(mPolicyBlocked, "operation", v.Operation, "rule", v.Rule)
+	logger.Warn("Operation blocked by policy", "Operation", v.Operation, "DN", v.DN, "Rule", v.Rule, "Reason", v.Reason)
+	if db != nil {
+		if _, err := db.Exec(`INSERT INTO policy_violations (time, operation, dn, rule, reason) VALUES ($1, $2, $3, $4, $5)`,
+			v.Time, v.Operation, v.DN, v.Rule, v.Reason); err != nil {
+			logger.Error("Failed to record policy violation", "DN", v.DN, "Err", err)
+		}
+	}
+}
+
+// getPolicyViolationsHandler godoc
+// @Summary List policy violations
+// @Description Lists recent target operations blocked by the destructive-operation policy, oldest first.
+// @Tags policy
+// @Produce json
+// @Success 200 {array} PolicyViolation
+// @Router /policy/violations [get]
+func getPolicyViolationsHandler(c echo.Context) error {
+	policies.mu.Lock()
+	out := append([]PolicyViolation{}, policies.violations...)
+	policies.mu.Unlock()
+	return c.JSON(http.StatusOK, out)
+}
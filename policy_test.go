@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	marker := &OwnershipMarker{Attribute: "description", Value: "managed-by-ldap-sync"}
+	owned := ldap.NewEntry("uid=a,ou=people,dc=org", map[string][]string{"description": {"Managed-By-LDAP-Sync"}})
+	foreign := ldap.NewEntry("uid=a,ou=people,dc=org", map[string][]string{"description": {"hand-made"}})
+	base := PolicyConfig{
+		ProtectedSubtrees: []string{"ou=admins,dc=org"},
+		DeniedSubtrees:    []string{"ou=system,dc=org"},
+	}
+	tests := []struct {
+		name     string
+		policy   func(p *PolicyConfig)
+		prior    int // Operations of the same kind allowed before
+		op, dn   string
+		existing *ldap.Entry
+		wantRule string // "" when allowed
+	}{
+		{name: "delete allowed", op: policyDelete, dn: "uid=a,ou=people,dc=org"},
+		{name: "denied subtree", op: policyModify, dn: "uid=a,ou=system,dc=org", wantRule: "denied_subtree"},
+		{name: "denied subtree root", op: policyDelete, dn: "OU=System, DC=org", wantRule: "denied_subtree"},
+		{name: "protected delete", op: policyDelete, dn: "cn=root,ou=admins,dc=org", wantRule: "protected_subtree"},
+		{name: "protected rename", op: policyRename, dn: "cn=root,ou=admins,dc=org", wantRule: "protected_subtree"},
+		{name: "protected modify allowed", op: policyModify, dn: "cn=root,ou=admins,dc=org"},
+		{
+			name: "protected modify with protect_modifies", op: policyModify, dn: "cn=root,ou=admins,dc=org",
+			policy: func(p *PolicyConfig) { p.ProtectModifies = true }, wantRule: "protected_subtree",
+		},
+		{name: "sibling of protected subtree", op: policyDelete, dn: "ou=admins2,dc=org"},
+		{
+			name: "owned entry", op: policyModify, dn: owned.DN, existing: owned,
+			policy: func(p *PolicyConfig) { p.OwnershipMarker = marker },
+		},
+		{
+			name: "entry without marker", op: policyDelete, dn: foreign.DN, existing: foreign,
+			policy: func(p *PolicyConfig) { p.OwnershipMarker = marker }, wantRule: "ownership_marker",
+		},
+		{name: "no marker configured", op: policyDelete, dn: foreign.DN, existing: foreign},
+		{
+			name: "deletion budget left", op: policyDelete, dn: "uid=a,ou=people,dc=org", prior: 1,
+			policy: func(p *PolicyConfig) { p.MaxDeletionsPerRun = 2 },
+		},
+		{
+			name: "deletion budget spent", op: policyDelete, dn: "uid=a,ou=people,dc=org", prior: 2,
+			policy: func(p *PolicyConfig) { p.MaxDeletionsPerRun = 2 }, wantRule: "max_deletions_per_run",
+		},
+		{
+			name: "modification budget spent", op: policyModify, dn: "uid=a,ou=people,dc=org", prior: 1,
+			policy: func(p *PolicyConfig) { p.MaxModificationsPerRun = 1 }, wantRule: "max_modifications_per_run",
+		},
+		{
+			name: "renames have no budget", op: policyRename, dn: "uid=a,ou=people,dc=org", prior: 5,
+			policy: func(p *PolicyConfig) { p.MaxDeletionsPerRun, p.MaxModificationsPerRun = 1, 1 },
+		},
+	}
+	defer func(p PolicyConfig, s *policyState) { config.Policy, policies = p, s }(config.Policy, policies)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Policy = base
+			if tt.policy != nil {
+				tt.policy(&config.Policy)
+			}
+			policies = &policyState{counts: make(map[string]int)}
+			if err := initPolicy(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.prior; i++ {
+				if err := checkPolicy(tt.op, "uid=other,ou=people,dc=org", nil); err != nil {
+					t.Fatalf("prior operation %d: %v", i+1, err)
+				}
+			}
+			err := checkPolicy(tt.op, tt.dn, tt.existing)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Fatalf("checkPolicy() = %v, want allowed", err)
+				}
+				if len(policies.violations) != 0 {
+					t.Errorf("violations = %v, want none", policies.violations)
+				}
+				return
+			}
+			if !errors.Is(err, errPolicyViolation) {
+				t.Fatalf("checkPolicy() = %v, want a policy violation", err)
+			}
+			if len(policies.violations) != 1 || policies.violations[0].Rule != tt.wantRule {
+				t.Errorf("violations = %v, want one for rule %s", policies.violations, tt.wantRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AttributeProvenance records the last write of one target attribute.
+type AttributeProvenance struct {
+	Search    string    `json:"search,omitempty"`
+	Producer  string    `json:"producer,omitempty"`
+	Operation string    `json:"operation"`
+	WrittenAt time.Time `json:"writtenAt"`
+}
+
+// EntryTrace is the trace of a target entry returned by GET /trace.
+type EntryTrace struct {
+	DN         string                         `json:"dn"`
+	Attributes map[string]AttributeProvenance `json:"attributes"`
+}
+
+// provenanceStore keeps attribute provenance in memory when the database is
+// disabled; with persistence enabled the attribute_provenance table is used.
+type provenanceStore struct {
+	mu      sync.Mutex
+	entries map[string]map[string]AttributeProvenance // normalized DN -> attribute
+}
+
+var provenance = &provenanceStore{entries: make(map[string]map[string]AttributeProvenance)}
+
+// recordProvenance notes that the given attributes of entry were just written.
+func recordProvenance(entry *TransformedEntry, op string, attributes []string) {
+	now := time.Now()
+	key := normalizeDN(entry.DN)
+	if db != nil {
+		const upsertSQL = `
+		INSERT INTO attribute_provenance (dn, attribute, search_id, producer, operation, written_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (dn, attribute) DO UPDATE
+		SET search_id = $3, producer = $4, operation = $5, written_at = $6;`
+		for _, attr := range attributes {
+			if _, err := db.Exec(upsertSQL, key, attr, entry.Search, entry.Producer, op, now); err != nil {
+				logger.Error("Failed to record attribute provenance", "DN", entry.DN, "Attribute", attr, "Err", err)
+				return
+			}
+		}
+		return
+	}
+	provenance.mu.Lock()
+	defer provenance.mu.Unlock()
+	attrs := provenance.entries[key]
+	if attrs == nil {
+		attrs = make(map[string]AttributeProvenance)
+		provenance.entries[key] = attrs
+	}
+	for _, attr := range attributes {
+		attrs[attr] = AttributeProvenance{Search: entry.Search, Producer: entry.Producer, Operation: op, WrittenAt: now}
+	}
+}
+
+// lookupProvenance returns the recorded provenance of a target entry.
+func lookupProvenance(dn string) (map[string]AttributeProvenance, error) {
+	key := normalizeDN(dn)
+	out := make(map[string]AttributeProvenance)
+	if db != nil {
+		rows, err := db.Query(`SELECT attribute, search_id, producer, operation, written_at FROM attribute_provenance WHERE dn = $1`, key)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var attr string
+			var p AttributeProvenance
+			if err := rows.Scan(&attr, &p.Search, &p.Producer, &p.Operation, &p.WrittenAt); err != nil {
+				return nil, err
+			}
+			out[attr] = p
+		}
+		return out, rows.Err()
+	}
+	provenance.mu.Lock()
+	defer provenance.mu.Unlock()
+	for attr, p := range provenance.entries[key] {
+		out[attr] = p
+	}
+	return out, nil
+}
+
+// getTraceHandler godoc
+// @Summary Trace a target entry
+// @Description Shows which search and hook, transform or mapping last wrote each attribute of a target entry, and when.
+// @Tags trace
+// @Produce json
+// @Param dn query string true "Target DN"
+// @Success 200 {object} EntryTrace
+// @Failure 400 {string} string "Missing dn"
+// @Failure 404 {string} string "No writes recorded for this DN"
+// @Router /trace [get]
+func getTraceHandler(c echo.Context) error {
+	dn := c.QueryParam("dn")
+	if dn == "" {
+		return c.String(http.StatusBadRequest, "Missing required parameter: dn")
+	}
+	attrs, err := lookupProvenance(dn)
+	if err != nil {
+		logger.Error("Failed to load attribute provenance", "DN", dn, "Err", err)
+		return c.String(http.StatusInternalServerError, "Failed to load provenance")
+	}
+	if len(attrs) == 0 {
+		return c.String(http.StatusNotFound, "No writes recorded for this DN")
+	}
+	return c.JSON(http.StatusOK, EntryTrace{DN: dn, Attributes: attrs})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// ProvisioningWebhook receives an event whenever ldap-sync adds an entry to
+// the target for the first time, so onboarding automation (home
+// directories, mailboxes) can start from it.
+type ProvisioningWebhook struct {
+	URL string `yaml:"url"`
+	// Searches limits the webhook to entries produced by these searches
+	// (default: all searches).
+	Searches []string `yaml:"searches"`
+	// Attributes are the written attributes included in the event (default:
+	// none; "*" for all).
+	Attributes []string `yaml:"attributes"`
+}
+
+// ProvisionedEvent is posted to provisioning webhooks.
+type ProvisionedEvent struct {
+	Event      string              `json:"event"`
+	Time       time.Time           `json:"time"`
+	Search     string              `json:"search,omitempty"`
+	Source     string              `json:"source,omitempty"`
+	DN         string              `json:"dn"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+const provisionedEventType = "entry.provisioned"
+
+var mProvisioningNotifications = describeMetric("ldapsync_provisioning_notifications_total", "counter",
+	"Provisioning events posted to webhooks, by result.")
+
+func (w ProvisioningWebhook) wants(search string) bool {
+	if len(w.Searches) == 0 {
+		return true
+	}
+	for _, s := range w.Searches {
+		if s == search {
+			return true
+		}
+	}
+	return false
+}
+
+func (w ProvisioningWebhook) eventAttributes(written map[string][]string) map[string][]string {
+	out := make(map[string][]string)
+	for attr, vals := range written {
+		for _, want := range w.Attributes {
+			if want == "*" || strings.EqualFold(want, attr) {
+				out[attr] = previewValues(attr, vals)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// notifyProvisioned posts an entry.provisioned event for a newly added
+// target entry to every interested webhook. Delivery is asynchronous and
+// retried like hook requests; failures are logged and counted, never
+// failing the write.
+func notifyProvisioned(entry *TransformedEntry, written map[string][]string) {
+	for _, w := range config.ProvisioningWebhooks {
+		if !w.wants(entry.Search) {
+			continue
+		}
+		event := ProvisionedEvent{
+			Event:      provisionedEventType,
+			Time:       time.Now().UTC(),
+			Search:     entry.Search,
+			Source:     entry.Source,
+			DN:         entry.DN,
+			Attributes: w.eventAttributes(written),
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			hookLogger.Error("Failed to encode provisioning event", "DN", entry.DN, "Err", err)
+			continue
+		}
+		go func(url string) {
+			resp, err := postToHookWithRetry(url, entry.Search, payload)
+			if err != nil {
+				incCounter(mProvisioningNotifications, "result", "error")
+				hookLogger.Error("Provisioning webhook failed", "URL", url, "DN", entry.DN, "Err", err)
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			incCounter(mProvisioningNotifications, "result", "success")
+			hookLogger.Debug("Provisioning event delivered", "URL", url, "DN", entry.DN)
+		}(w.URL)
+	}
+}
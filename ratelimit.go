@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TargetRateLimitConfig throttles writes to the target across all searches
+// so a large initial sync does not overwhelm the destination directory.
+type TargetRateLimitConfig struct {
+	// WritesPerSecond caps adds, modifies and renames (0 disables the cap).
+	WritesPerSecond float64 `yaml:"writes_per_second"`
+	Burst           int     `yaml:"burst"` // Writes allowed at once (default: WritesPerSecond, at least 1)
+	// MaxConnections caps concurrently open target connections when
+	// pipelining is disabled (0 disables the cap).
+	MaxConnections int `yaml:"max_connections"`
+}
+
+var mTargetWriteThrottled = describeMetric("ldapsync_target_write_throttled_seconds_total", "counter",
+	"Time target writes spent waiting for the rate limiter.")
+
+var (
+	writeLimiterOnce sync.Once
+	writeLimiter     *rate.Limiter
+	connSlotsOnce    sync.Once
+	connSlots        chan struct{}
+)
+
+// waitTargetWrite blocks until the rate limit allows another target write.
+func waitTargetWrite() {
+	writeLimiterOnce.Do(func() {
+		rl := config.TargetRateLimit
+		if rl.WritesPerSecond <= 0 {
+			return
+		}
+		burst := rl.Burst
+		if burst <= 0 {
+			burst = int(rl.WritesPerSecond)
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		writeLimiter = rate.NewLimiter(rate.Limit(rl.WritesPerSecond), burst)
+	})
+	if writeLimiter == nil {
+		return
+	}
+	start := time.Now()
+	writeLimiter.Wait(context.Background())
+	if waited := time.Since(start); waited > time.Millisecond {
+		addCounter(mTargetWriteThrottled, waited.Seconds())
+	}
+}
+
+// targetConnSlots returns the semaphore limiting open target connections,
+// or nil when they are unlimited.
+func targetConnSlots() chan struct{} {
+	connSlotsOnce.Do(func() {
+		if n := config.TargetRateLimit.MaxConnections; n > 0 {
+			connSlots = make(chan struct{}, n)
+		}
+	})
+	return connSlots
+}
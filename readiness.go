@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReadinessConfig makes GET /readyz depend on the service's dependencies.
+// A required component is ready when it was last reached successfully
+// within the window; otherwise the probe checks it on the spot.
+type ReadinessConfig struct {
+	RequireSource   bool `yaml:"require_source"`   // Source LDAP bind
+	RequireTarget   bool `yaml:"require_target"`   // Target LDAP bind
+	RequireDatabase bool `yaml:"require_database"` // Database ping (when enabled)
+	// RequireRestore fails readiness if searches could not be restored from
+	// the database at startup.
+	RequireRestore bool `yaml:"require_restore"`
+	WindowSec      int  `yaml:"window_s"` // How recent a success must be (default: 60)
+}
+
+// ReadinessResponse is returned by GET /readyz. Components maps each
+// required component to "ok" or the reason it is failing.
+type ReadinessResponse struct {
+	Status     string            `json:"status"`
+	Failing    []string          `json:"failing,omitempty"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+const (
+	readySource   = "source"
+	readyTarget   = "target"
+	readyDatabase = "database"
+	readyRestore  = "restore"
+)
+
+// readinessState records successful connections made by the syncer so
+// probes rarely need to connect themselves.
+type readinessState struct {
+	mu         sync.Mutex
+	lastOK     map[string]time.Time
+	probeMu    sync.Mutex // Serializes on-the-spot checks
+	restoreErr string
+}
+
+var readiness = &readinessState{lastOK: make(map[string]time.Time)}
+
+// recordHealth notes the outcome of using a component.
+func recordHealth(component string, err error) {
+	if err != nil {
+		return
+	}
+	readiness.mu.Lock()
+	readiness.lastOK[component] = time.Now()
+	readiness.mu.Unlock()
+}
+
+// recordRestoreFailure marks the startup restore of searches as failed.
+func recordRestoreFailure(err error) {
+	readiness.mu.Lock()
+	readiness.restoreErr = err.Error()
+	readiness.mu.Unlock()
+}
+
+func (r *readinessState) recent(component string, window time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last, ok := r.lastOK[component]
+	return ok && time.Since(last) < window
+}
+
+// check reports whether component is ready, probing it if it has not been
+// reached within the window.
+func (r *readinessState) check(component string, window time.Duration) error {
+	// A database the monitor found down is not ready, however recently
+	// it answered.
+	if component == readyDatabase {
+		if err := databaseDownErr(); err != nil {
+			return err
+		}
+	}
+	if r.recent(component, window) {
+		return nil
+	}
+	r.probeMu.Lock()
+	defer r.probeMu.Unlock()
+	if r.recent(component, window) {
+		return nil
+	}
+	var err error
+	switch component {
+	case readySource:
+		l, dialErr := connectAndBindLDAP()
+		if err = dialErr; err == nil {
+			l.Close()
+		}
+	case readyTarget:
+		l, dialErr := dialTarget()
+		if err = dialErr; err == nil {
+			l.Close()
+		}
+	case readyDatabase:
+		if db == nil {
+			err = fmt.Errorf("database not initialized")
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		recordHealth(readyDatabase, err)
+	}
+	return err
+}
+
+// readyzHandler handles the readiness probe.
+// @Summary Readiness Probe
+// @Description Returns OK if the application is ready to serve traffic. With readiness checks configured, requires a recent successful source bind, target bind and database ping, and a successful restore of searches; the response names the failing components.
+// @Tags probes
+// @Produce json
+// @Success 200 {object} ReadinessResponse "status: ready"
+// @Failure 503 {object} ReadinessResponse "status: not ready, with the failing components"
+// @Router /readyz [get]
+func readyzHandler(c echo.Context) error {
+	rc := config.Readiness
+	window := time.Duration(rc.WindowSec) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+	var required []string
+	if rc.RequireSource {
+		required = append(required, readySource)
+	}
+	if rc.RequireTarget {
+		required = append(required, readyTarget)
+	}
+	if rc.RequireDatabase && config.Database.Enabled {
+		required = append(required, readyDatabase)
+	}
+
+	resp := ReadinessResponse{Status: "ready"}
+	if len(required) > 0 || rc.RequireRestore {
+		resp.Components = make(map[string]string)
+	}
+	for _, component := range required {
+		if err := readiness.check(component, window); err != nil {
+			resp.Failing = append(resp.Failing, component)
+			resp.Components[component] = err.Error()
+			continue
+		}
+		resp.Components[component] = "ok"
+	}
+	if rc.RequireRestore && config.Database.Enabled {
+		readiness.mu.Lock()
+		restoreErr := readiness.restoreErr
+		readiness.mu.Unlock()
+		if restoreErr != "" {
+			resp.Failing = append(resp.Failing, readyRestore)
+			resp.Components[readyRestore] = restoreErr
+		} else {
+			resp.Components[readyRestore] = "ok"
+		}
+	}
+	if len(resp.Failing) > 0 {
+		resp.Status = "not ready"
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
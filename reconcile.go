@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// ReconcileReport describes how the target diverges from what a search
+// would currently write. Nothing is written while producing it.
+type ReconcileReport struct {
+	Search        string `json:"search"`
+	SourceEntries int    `json:"sourceEntries"`
+	// ExpectedEntries is the number of target entries the mapping,
+	// transform or hooks produced from the source entries.
+	ExpectedEntries int             `json:"expectedEntries"`
+	InSync          int             `json:"inSync"`
+	MissingOnTarget []string        `json:"missingOnTarget"`
+	ExtraOnTarget   []string        `json:"extraOnTarget,omitempty"` // Only with targetBase
+	Differing       []ReconcileDiff `json:"differing"`
+	// Unresolved entries reference bindings that are not known yet and
+	// were not compared.
+	Unresolved []string         `json:"unresolved,omitempty"`
+	Errors     []ReconcileError `json:"errors,omitempty"`
+}
+
+// ReconcileDiff is an expected entry whose target attributes differ. Before
+// holds the target's values and After the values the search produces.
+type ReconcileDiff struct {
+	DN         string                     `json:"dn"`
+	Attributes map[string]AttributeChange `json:"attributes"`
+}
+
+// ReconcileError is a source entry that could not be transformed or a
+// target entry that could not be read.
+type ReconcileError struct {
+	DN    string `json:"dn"`
+	Error string `json:"error"`
+}
+
+// reconcileHandler godoc
+// @Summary Reconcile a search against the target
+// @Description Runs the search against the source, transforms the entries with its mapping, transform or hooks, and compares the result with the target without writing anything. Hooks receive "reconcile": true and only the transformed entries of their responses are used. Attributes merged by union are only checked for missing values. With targetBase, target entries below it (matching targetFilter) that the search does not produce are reported as extra.
+// @Tags reconcile
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param id path string true "Search id"
+// @Param targetBase formData string false "Report target entries below this DN that the search does not produce"
+// @Param targetFilter formData string false "Filter selecting the target entries considered for extras (default: the ownership marker, or (objectClass=*))"
+// @Success 200 {object} ReconcileReport
+// @Failure 400 {string} string "Search with suppressHooks"
+// @Failure 404 {string} string "Search not found"
+// @Failure 502 {object} map[string]string "Source or target could not be read"
+// @Router /reconcile/{id} [post]
+func reconcileHandler(c echo.Context) error {
+	id := c.Param("id")
+	searchesMu.RLock()
+	specPtr, ok := searches[id]
+	var spec SearchSpec
+	if ok {
+		spec = *specPtr
+	}
+	searchesMu.RUnlock()
+	if !ok {
+		return c.String(http.StatusNotFound, "Search not found")
+	}
+	if spec.SuppressHooks {
+		return c.String(http.StatusBadRequest, "Searches with suppressHooks do not write to the target")
+	}
+	report, err := reconcileSearch(id, &spec, c.FormValue("targetBase"), c.FormValue("targetFilter"))
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// reconcileSearch builds the drift report of a search.
+func reconcileSearch(id string, spec *SearchSpec, targetBase, targetFilter string) (*ReconcileReport, error) {
+	report := &ReconcileReport{Search: id, MissingOnTarget: []string{}, Differing: []ReconcileDiff{}}
+
+	src, err := connectAndBindLDAP()
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	sr, err := src.SearchWithPaging(sourceSearchRequest(id, spec), 500)
+	if err != nil && sr != nil && searchLimitExceeded(id, err) {
+		err = nil
+	}
+	for _, entry := range sr.Entries {
+		if err != nil {
+			break
+		}
+		err = completeRangedAttributes(src, entry)
+	}
+	src.Close()
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	report.SourceEntries = len(sr.Entries)
+
+	expected := make(map[string]*TransformedEntry)
+	for _, entry := range sr.Entries {
+		result := LDAPResult{DN: entry.DN, Content: resultContent(id, entry, spec), changeType: changeTypeModify}
+		produced, err := reconcileTransform(id, spec, result)
+		if err != nil {
+			report.Errors = append(report.Errors, ReconcileError{DN: entry.DN, Error: err.Error()})
+			continue
+		}
+		for _, t := range produced {
+			expected[normalizeDN(t.DN)] = t
+		}
+	}
+	report.ExpectedEntries = len(expected)
+
+	bindingsMu.RLock()
+	bindingsSnapshot := make(map[string]BindingValue, len(bindings))
+	for k, v := range bindings {
+		bindingsSnapshot[k] = v
+	}
+	nullSnapshot := make(map[string]struct{}, len(nullBindings))
+	for k := range nullBindings {
+		nullSnapshot[k] = struct{}{}
+	}
+	bindingsMu.RUnlock()
+
+	l, err := dialTarget()
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+	defer l.Close()
+
+	keys := make([]string, 0, len(expected))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry, missing := resolveEntryTemplates(expected[key], bindingsSnapshot, nullSnapshot)
+		if missing {
+			report.Unresolved = append(report.Unresolved, expected[key].DN)
+			continue
+		}
+		attributes, aggregate, err := reconcileAttributes(entry)
+		if err != nil {
+			report.Errors = append(report.Errors, ReconcileError{DN: entry.DN, Error: err.Error()})
+			continue
+		}
+		current, err := readTargetAttributes(l, entry.DN, attributes)
+		if err != nil {
+			report.Errors = append(report.Errors, ReconcileError{DN: entry.DN, Error: err.Error()})
+			continue
+		}
+		if current == nil {
+			report.MissingOnTarget = append(report.MissingOnTarget, entry.DN)
+			continue
+		}
+		changes := make(map[string]AttributeChange)
+		for attr, want := range attributes {
+			have := getEntryAttributeValues(current, attr)
+			if !reconcileValuesMatch(attr, aggregate[attr], have, want) {
+				changes[attr] = AttributeChange{Before: previewValues(attr, have), After: previewValues(attr, want)}
+			}
+		}
+		if len(changes) == 0 {
+			report.InSync++
+			continue
+		}
+		report.Differing = append(report.Differing, ReconcileDiff{DN: entry.DN, Attributes: changes})
+	}
+
+	if targetBase != "" {
+		if targetFilter == "" {
+			targetFilter = "(objectClass=*)"
+			if m := config.Policy.OwnershipMarker; m != nil {
+				targetFilter = "(" + ldap.EscapeFilter(m.Attribute) + "=" + ldap.EscapeFilter(m.Value) + ")"
+			}
+		}
+		tr, err := l.SearchWithPaging(ldap.NewSearchRequest(targetBase, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, 0, false, targetFilter, []string{"1.1"}, nil), 500)
+		if err != nil {
+			return nil, fmt.Errorf("target: %w", err)
+		}
+		base := normalizeDN(targetBase)
+		for _, e := range tr.Entries {
+			key := normalizeDN(e.DN)
+			if _, ok := expected[key]; !ok && key != base {
+				report.ExtraOnTarget = append(report.ExtraOnTarget, e.DN)
+			}
+		}
+		sort.Strings(report.ExtraOnTarget)
+	}
+	return report, nil
+}
+
+// reconcileTransform returns the target entries the search's pipeline
+// produces for a source result, without side effects: derived searches,
+// bindings and dependencies in the responses are ignored.
+func reconcileTransform(id string, spec *SearchSpec, result LDAPResult) ([]*TransformedEntry, error) {
+	if spec.Mapping != "" {
+		mapped, direct, err := applyMapping(spec.Mapping, result)
+		if err != nil {
+			return nil, err
+		}
+		if direct {
+			return []*TransformedEntry{mapped}, nil
+		}
+		result = LDAPResult{DN: mapped.DN, Content: mapped.Content, changeType: result.changeType}
+	}
+	req := newHookRequest(id, result)
+	req.Reconcile = true
+	var hidden map[string]interface{}
+	var responses []HookResponse
+	if spec.Transform != "" {
+		engine, ok := transformEngines[spec.Transform]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q", spec.Transform)
+		}
+		out, err := engine.run(req)
+		if err != nil {
+			return nil, err
+		}
+		responses = out
+	} else {
+		for _, hookURL := range config.Hooks {
+			if !hookWants(hookURL, result) {
+				continue
+			}
+			hookReq := req
+			hidden = hidePasswords(&hookReq)
+			payload, err := encodeHookPayload(hookURL, []HookRequest{hookReq}, false)
+			if err != nil {
+				return nil, err
+			}
+			out, err := reconcileHook(hookURL, id, payload)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", hookURL, err)
+			}
+			responses = append(responses, out...)
+		}
+	}
+	var produced []*TransformedEntry
+	for _, resp := range responses {
+		entries := restorePasswords(hookEntries(resp.Transformed), hidden)
+		for i := range entries {
+			produced = append(produced, &entries[i])
+		}
+	}
+	return produced, nil
+}
+
+// reconcileHook posts one request to a hook, without retries.
+func reconcileHook(hookURL, searchID string, payload []byte) ([]HookResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, hookURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setHookProtocol(req, hookURL)
+	if err := setHookIdentity(req, hookURL, searchID); err != nil {
+		return nil, fmt.Errorf("signing hook identity: %w", err)
+	}
+	client := hookHTTPClientWithTimeout(hookURL, 30*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("hook answered %s", resp.Status)
+	}
+	return decodeHookResponses(body)
+}
+
+// reconcileAttributes converts entry content to the value lists written to
+// the target, as storeDestinationLDAP does, and notes which attributes were
+// given as lists.
+func reconcileAttributes(entry *TransformedEntry) (map[string][]string, map[string]bool, error) {
+	attributes := make(map[string][]string, len(entry.Content))
+	aggregate := make(map[string]bool)
+	for attr, value := range entry.Content {
+		vals := toStringSlice(value)
+		aggregate[attr] = isSliceValue(value)
+		if isBinaryAttr(attr) {
+			decoded, err := decodeBinaryValues(attr, vals)
+			if err != nil {
+				return nil, nil, err
+			}
+			vals = decoded
+		}
+		attributes[attr] = vals
+	}
+	return attributes, aggregate, nil
+}
+
+// reconcileValuesMatch compares target values with the produced values
+// under the attribute's merge strategy. Lists without a configured strategy
+// are unioned on write, like storeDestinationLDAP does.
+func reconcileValuesMatch(attr string, aggregate bool, have, want []string) bool {
+	strategy, ok := mergeStrategy(attr)
+	if !ok && aggregate {
+		strategy = mergeUnion
+	}
+	switch strategy {
+	case mergeUnion, mergeRemoveAbsent:
+		// Other writers may add values; only missing ones are drift.
+		present := make(map[string]struct{}, len(have))
+		for _, v := range have {
+			present[v] = struct{}{}
+		}
+		for _, v := range want {
+			if _, ok := present[v]; !ok {
+				return false
+			}
+		}
+		return true
+	case mergeTargetWins:
+		return len(have) > 0 || len(want) == 0
+	case mergeSourceWins:
+		if len(want) == 0 {
+			return true
+		}
+	case mergeExact:
+		kept := make([]string, 0, len(have))
+		for _, v := range have {
+			if !isProtectedMember(v) {
+				kept = append(kept, v)
+			}
+		}
+		have = kept
+	}
+	return sameValues(have, want)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Partitioned directories answer a subtree search with continuation
+// references for the parts held by other servers. Without
+// referrals.chase they are logged and counted, and the entries behind them
+// are not read. With it, the search is repeated on the referred server,
+// with the same filter, attributes and options, and its entries are
+// processed like the source's own.
+
+// ReferralConfig controls how a source's search references are handled.
+type ReferralConfig struct {
+	Chase bool `yaml:"chase"`
+	// Credentials is "anonymous" (default) or "bind", which binds to the
+	// referred servers like to the source itself and requires AllowedHosts.
+	Credentials string `yaml:"credentials"`
+	// AllowedHosts lists the hosts referrals may be chased to (host or
+	// host:port); empty allows any host with anonymous credentials.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+	MaxDepth     int      `yaml:"max_depth"` // Referrals followed from referred servers (default: 1, only the source's own)
+}
+
+const (
+	referralCredentialsAnonymous = "anonymous"
+	referralCredentialsBind      = "bind"
+)
+
+var mReferrals = describeMetric("ldapsync_referrals_total", "counter",
+	"Search references returned by the source, by result (ignored, chased, skipped or failed).")
+
+func validateReferrals() error {
+	r := config.Source.Referrals
+	switch r.Credentials {
+	case "", referralCredentialsAnonymous:
+	case referralCredentialsBind:
+		if r.Chase && len(r.AllowedHosts) == 0 {
+			return fmt.Errorf("referrals: credentials bind requires allowed_hosts")
+		}
+	default:
+		return fmt.Errorf("referrals: invalid credentials %q; expected anonymous or bind", r.Credentials)
+	}
+	if r.MaxDepth < 0 {
+		return fmt.Errorf("referrals: max_depth must not be negative")
+	}
+	return nil
+}
+
+// referralTarget is a parsed search reference: the server to ask and the
+// base DN to search there.
+type referralTarget struct {
+	server string
+	baseDN string
+}
+
+// parseReferral reads an LDAP URL (RFC 4516) from a search reference. The
+// base DN defaults to the one of the referring search.
+func parseReferral(ref, baseDN string) (referralTarget, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return referralTarget{}, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ldap", "ldaps":
+	default:
+		return referralTarget{}, fmt.Errorf("unsupported referral scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return referralTarget{}, fmt.Errorf("referral has no host")
+	}
+	target := referralTarget{server: u.Scheme + "://" + u.Host, baseDN: baseDN}
+	if dn := strings.TrimPrefix(u.Path, "/"); dn != "" {
+		target.baseDN = dn
+	}
+	return target, nil
+}
+
+// referralAllowed reports whether a referral may be chased to server.
+func referralAllowed(server string) bool {
+	hosts := config.Source.Referrals.AllowedHosts
+	if len(hosts) == 0 {
+		return true
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialReferral connects to a referred server with the configured
+// credentials.
+func dialReferral(server string) (*ldap.Conn, error) {
+	cfg := config.Source
+	cfg.URL = server
+	cfg.DCLocator = DCLocatorConfig{}
+	if cfg.Referrals.Credentials == referralCredentialsBind {
+		return dialLDAP(cfg)
+	}
+	opts, err := ldapDialOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ldap.DialURL(server, opts...)
+}
+
+// followReferrals handles the search references a search at the given depth
+// returned, searching each referred server unless chasing is off, the depth
+// is exhausted or the server is not allowed. A referred server that cannot
+// be searched fails the search, so its entries are not taken as deleted.
+func followReferrals(refs []string, req *ldap.SearchRequest, depth int, seen map[string]struct{}, stop <-chan struct{}, process func(*ldap.Entry)) error {
+	cfg := config.Source.Referrals
+	maxDepth := cfg.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = 1
+	}
+	for _, ref := range refs {
+		if !cfg.Chase {
+			incCounter(mReferrals, "result", "ignored")
+			syncLogger.Warn("Ignoring search reference; its entries are not read", "Referral", ref, "BaseDN", req.BaseDN)
+			continue
+		}
+		target, err := parseReferral(ref, req.BaseDN)
+		if err != nil {
+			incCounter(mReferrals, "result", "failed")
+			syncLogger.Warn("Invalid search reference", "Referral", ref, "Err", err)
+			continue
+		}
+		key := strings.ToLower(target.server) + "|" + normalizeDN(target.baseDN)
+		if _, ok := seen[key]; ok {
+			incCounter(mReferrals, "result", "skipped")
+			syncLogger.Debug("Search reference already followed", "Referral", ref)
+			continue
+		}
+		seen[key] = struct{}{}
+		if depth >= maxDepth || !referralAllowed(target.server) {
+			incCounter(mReferrals, "result", "skipped")
+			syncLogger.Warn("Not chasing search reference", "Referral", ref, "Depth", depth+1, "MaxDepth", maxDepth)
+			continue
+		}
+		referred := *req
+		referred.BaseDN, referred.Controls = target.baseDN, nil
+		if referred.Scope == ldap.ScopeSingleLevel {
+			// A one-level reference names the child entry itself (RFC 4511).
+			referred.Scope = ldap.ScopeBaseObject
+		}
+		l, err := dialReferral(target.server)
+		if err == nil {
+			err = streamSearch(l, &referred, depth+1, seen, stop, process)
+			l.Close()
+		}
+		if errors.Is(err, errSearchStopped) {
+			return err
+		}
+		if err != nil {
+			incCounter(mReferrals, "result", "failed")
+			return fmt.Errorf("referral %s: %w", ref, err)
+		}
+		incCounter(mReferrals, "result", "chased")
+	}
+	return nil
+}
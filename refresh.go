@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RefreshConfig spreads and adapts the refresh of interval-based searches
+// (not scheduled ones).
+type RefreshConfig struct {
+	// JitterPercent randomizes every interval by up to this percentage in
+	// either direction, and delays the first run of each search by up to
+	// this percentage of its interval, so searches with the same refresh do
+	// not hit the source at once.
+	JitterPercent int                   `yaml:"jitter_percent"`
+	Adaptive      AdaptiveRefreshConfig `yaml:"adaptive"`
+}
+
+// AdaptiveRefreshConfig lengthens the interval of polling searches that keep
+// finding no changes and restores it as soon as they find one.
+type AdaptiveRefreshConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	QuietCycles int     `yaml:"quiet_cycles"` // Unchanged cycles before each lengthening (default: 5)
+	Factor      float64 `yaml:"factor"`       // Interval multiplier per lengthening (default: 2)
+	MaxFactor   float64 `yaml:"max_factor"`   // Longest interval as a multiple of refresh (default: 8)
+}
+
+var mSearchInterval = describeMetric("ldapsync_search_refresh_seconds", "gauge",
+	"Current refresh interval of each interval-based search, after adaptive lengthening (before jitter).")
+
+// jittered randomizes d by up to refresh.jitter_percent.
+func jittered(d time.Duration) time.Duration {
+	p := config.Refresh.JitterPercent
+	if p <= 0 || d <= 0 {
+		return d
+	}
+	if p > 100 {
+		p = 100
+	}
+	spread := float64(d) * float64(p) / 100
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// initialJitter delays the first run of an interval search by up to
+// refresh.jitter_percent of its interval.
+func initialJitter(d time.Duration) time.Duration {
+	p := config.Refresh.JitterPercent
+	if p <= 0 || d <= 0 {
+		return 0
+	}
+	if p > 100 {
+		p = 100
+	}
+	return time.Duration(rand.Float64() * float64(d) * float64(p) / 100)
+}
+
+// adaptInterval returns the interval after a polling cycle: lengthened by
+// the factor once quiet reaches quiet_cycles, back to base after a change.
+// It also returns the updated count of quiet cycles.
+func adaptInterval(base, current time.Duration, quiet int, changed bool) (time.Duration, int) {
+	a := config.Refresh.Adaptive
+	if !a.Enabled || base <= 0 {
+		return base, 0
+	}
+	if changed {
+		return base, 0
+	}
+	cycles := a.QuietCycles
+	if cycles <= 0 {
+		cycles = 5
+	}
+	factor := a.Factor
+	if factor <= 1 {
+		factor = 2
+	}
+	maxFactor := a.MaxFactor
+	if maxFactor < 1 {
+		maxFactor = 8
+	}
+	quiet++
+	if quiet < cycles {
+		return current, quiet
+	}
+	next := time.Duration(float64(current) * factor)
+	if limit := time.Duration(float64(base) * maxFactor); next > limit {
+		next = limit
+	}
+	return next, 0
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Searches created with rename=true (or tracked by identity_tracking)
+// remember the target DN written for each source entry. When a later result
+// for the same source entry produces a different DN (e.g. after a username
+// change), the old target entry is moved with a ModifyDN instead of being
+// left behind next to a new duplicate.
+//
+// Only entries that were the sole output for their source entry carry a
+// Source, so sources expanded into several target entries are not tracked.
+
+var mTargetRenames = describeMetric("ldapsync_target_renames_total", "counter",
+	"Target entries renamed because the DN produced for their source entry changed.")
+
+// dnMappingStore maps search id and source key (the correlation key, or the
+// normalized source DN) to the target DN last written. With the database
+// enabled mappings are kept in dn_mappings.
+type dnMappingStore struct {
+	mu  sync.Mutex
+	dns map[string]dnMapping
+}
+
+type dnMapping struct {
+	target   string
+	sourceDN string // source DN last seen
+	updated  time.Time
+}
+
+var dnMappings = &dnMappingStore{dns: make(map[string]dnMapping)}
+
+// sourceKey identifies the entry's source entry within its search.
+func sourceKey(entry *TransformedEntry) string {
+	if entry.Correlation != "" {
+		return entry.Correlation
+	}
+	return normalizeDN(entry.Source)
+}
+
+func dnMappingKey(search, source string) string {
+	return search + "\x00" + source
+}
+
+func (s *dnMappingStore) get(search, source string) string {
+	key := dnMappingKey(search, source)
+	s.mu.Lock()
+	m, ok := s.dns[key]
+	s.mu.Unlock()
+	if ok || db == nil {
+		return m.target
+	}
+	err := db.QueryRow(`SELECT target_dn, source_dn, updated_at FROM dn_mappings WHERE search_id = $1 AND source_dn_key = $2`,
+		search, source).Scan(&m.target, &m.sourceDN, &m.updated)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Error("Failed to read DN mapping", "SearchId", search, "Source", source, "Err", err)
+		}
+		return ""
+	}
+	s.mu.Lock()
+	s.dns[key] = m
+	s.mu.Unlock()
+	return m.target
+}
+
+func (s *dnMappingStore) set(search, source, sourceDN, target string) {
+	key := dnMappingKey(search, source)
+	s.mu.Lock()
+	old := s.dns[key]
+	unchanged := old.target == target && old.sourceDN == sourceDN
+	if !unchanged {
+		s.dns[key] = dnMapping{target: target, sourceDN: sourceDN, updated: time.Now()}
+	}
+	s.mu.Unlock()
+	if unchanged || db == nil {
+		return
+	}
+	const upsertSQL = `
+	INSERT INTO dn_mappings (search_id, source_dn_key, target_dn, source_dn, updated_at) VALUES ($1, $2, $3, $4, NOW())
+	ON CONFLICT (search_id, source_dn_key) DO UPDATE SET target_dn = $3, source_dn = $4, updated_at = NOW();`
+	if _, err := db.Exec(upsertSQL, search, source, target, sourceDN); err != nil {
+		logger.Error("Failed to persist DN mapping", "SearchId", search, "Source", source, "Err", err)
+	}
+}
+
+// forget drops a mapping if it still points at target.
+func (s *dnMappingStore) forget(search, source, target string) {
+	if current := s.get(search, source); current == "" || normalizeDN(current) != normalizeDN(target) {
+		return
+	}
+	s.mu.Lock()
+	delete(s.dns, dnMappingKey(search, source))
+	s.mu.Unlock()
+	if db == nil {
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM dn_mappings WHERE search_id = $1 AND source_dn_key = $2`, search, source); err != nil {
+		logger.Error("Failed to delete DN mapping", "SearchId", search, "Source", source, "Err", err)
+	}
+}
+
+// move rekeys a mapping, when a search starts identifying its source
+// entries by a correlation attribute.
+func (s *dnMappingStore) move(search, from, to string) {
+	if target := s.get(search, from); target != "" && s.get(search, to) == "" {
+		s.mu.Lock()
+		sourceDN := s.dns[dnMappingKey(search, from)].sourceDN
+		s.mu.Unlock()
+		s.set(search, to, sourceDN, target)
+	}
+}
+
+// list returns the mappings of a search, or of all searches; the database
+// holds them all, memory only those used since startup.
+func (s *dnMappingStore) list(search string) ([]IdentityMapping, error) {
+	var out []IdentityMapping
+	if db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, m := range s.dns {
+			id, source, _ := strings.Cut(key, "\x00")
+			if search == "" || id == search {
+				out = append(out, IdentityMapping{Search: id, Source: source, SourceDN: m.sourceDN,
+					TargetDN: m.target, UpdatedAt: m.updated})
+			}
+		}
+		return out, nil
+	}
+	rows, err := db.Query(`SELECT search_id, source_dn_key, source_dn, target_dn, updated_at FROM dn_mappings
+	WHERE $1 = '' OR search_id = $1`, search)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m IdentityMapping
+		if err := rows.Scan(&m.Search, &m.Source, &m.SourceDN, &m.TargetDN, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// renameEnabled reports whether the entry's search tracks renames, and the
+// search's correlation attribute. Searches tracked by identity always do.
+func renameEnabled(entry *TransformedEntry) (bool, string) {
+	if entry.Source == "" || entry.Search == "" {
+		return false, ""
+	}
+	searchesMu.RLock()
+	defer searchesMu.RUnlock()
+	spec, ok := searches[entry.Search]
+	if !ok {
+		return false, ""
+	}
+	return spec.Rename || identityAttribute(entry.Search) != "", correlationAttribute(entry.Search, spec)
+}
+
+// recordDNMapping remembers the DN written for the entry's source entry.
+func recordDNMapping(entry *TransformedEntry) {
+	if enabled, _ := renameEnabled(entry); enabled {
+		dnMappings.set(entry.Search, sourceKey(entry), entry.Source, entry.DN)
+	}
+}
+
+// splitDN returns the leading RDN of dn and its parent DN.
+func splitDN(dn string) (rdn, parent string, err error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", "", err
+	}
+	if len(parsed.RDNs) == 0 {
+		return "", "", fmt.Errorf("empty DN")
+	}
+	attrs := make([]RDNAttribute, len(parsed.RDNs[0].Attributes))
+	for i, a := range parsed.RDNs[0].Attributes {
+		attrs[i] = RDNAttribute{Type: a.Type, Value: a.Value}
+	}
+	if rdn, err = formatRDN(attrs...); err != nil {
+		return "", "", err
+	}
+	return rdn, (&ldap.DN{RDNs: parsed.RDNs[1:]}).String(), nil
+}
+
+// renameIfMoved moves the target entry previously written for the entry's
+// source entry to entry.DN. Nothing is done unless the old entry exists and
+// the new DN is free; the caller holds the lock on entry.DN.
+func renameIfMoved(l *ldap.Conn, entry *TransformedEntry) error {
+	enabled, correlationAttr := renameEnabled(entry)
+	if !enabled {
+		return nil
+	}
+	oldDN := dnMappings.get(entry.Search, sourceKey(entry))
+	if oldDN == "" {
+		// Without a recorded mapping, match on the correlation attribute if
+		// the hooks write it to the target.
+		var err error
+		if oldDN, err = findTargetByCorrelation(l, entry, correlationAttr); err != nil {
+			return err
+		}
+	}
+	if oldDN == "" || normalizeDN(oldDN) == normalizeDN(entry.DN) {
+		return nil
+	}
+	if isDeniedWrite(policyRename, oldDN) {
+		return nil
+	}
+	markerAttrs := make(map[string][]string)
+	for _, attr := range policySearchAttributes() {
+		markerAttrs[attr] = nil
+	}
+	if len(markerAttrs) == 0 {
+		markerAttrs["objectClass"] = nil
+	}
+	old, err := readTargetAttributes(l, oldDN, markerAttrs)
+	if err != nil || old == nil {
+		return err
+	}
+	existing, err := readTargetAttributes(l, entry.DN, map[string][]string{"objectClass": nil})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		logger.Warn("Not renaming target entry; new DN already exists", "OldDN", oldDN, "DN", entry.DN, "SearchId", entry.Search)
+		return nil
+	}
+
+	change := map[string][]string{"dn": {entry.DN}}
+	before := ldap.NewEntry(oldDN, map[string][]string{"dn": {oldDN}})
+	if isDryRun(entry) {
+		recordPreview(entry, policyRename, change, before)
+		return nil
+	}
+	if err := checkPolicy(policyRename, oldDN, old); err != nil {
+		return err
+	}
+	newRDN, newParent, err := splitDN(entry.DN)
+	if err != nil {
+		return fmt.Errorf("invalid target DN %q: %w", entry.DN, err)
+	}
+	_, oldParent, err := splitDN(oldDN)
+	if err != nil {
+		return fmt.Errorf("invalid previous target DN %q: %w", oldDN, err)
+	}
+	newSuperior := ""
+	if !strings.EqualFold(normalizeDN(oldParent), normalizeDN(newParent)) {
+		newSuperior = newParent
+	}
+	waitTargetWrite()
+	err = l.ModifyDN(ldap.NewModifyDNRequest(oldDN, newRDN, true, newSuperior))
+	journalWrite(entry, policyRename, change, before, err)
+	if err != nil {
+		return err
+	}
+	targetMirror.invalidate(oldDN)
+	incCounter(mTargetRenames)
+	logger.Info("Renamed entry in destination LDAP", "OldDN", oldDN, "DN", entry.DN, "SearchId", entry.Search)
+	dnMappings.set(entry.Search, sourceKey(entry), entry.Source, entry.DN)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// ReplayResponse reports how many cached results a replay re-sent.
+type ReplayResponse struct {
+	Replayed int `json:"replayed"`
+	// Skipped results were restored without content (persist_results: hash)
+	// and cannot be re-sent until the source entry is read again.
+	Skipped int `json:"skipped"`
+}
+
+var mReplayed = describeMetric("ldapsync_replayed_entries_total", "counter",
+	"Cached results re-sent through the transformation pipeline by replays.")
+
+// replayResultsHandler godoc
+// @Summary Replay cached results
+// @Description Re-sends the search's cached results through its mapping, transform or hooks, e.g. after deploying a fixed hook. Payloads carry "replay": true. Without base or dn every result is replayed. Dampening is bypassed. Results are sent in the background.
+// @Tags search
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param id path string true "Search id"
+// @Param base formData string false "Only replay results at or below this source DN"
+// @Param dn formData []string false "Only replay results with these source DNs (repeatable)" collectionFormat(multi)
+// @Success 202 {object} ReplayResponse
+// @Failure 400 {string} string "Invalid parameters or search with suppressHooks"
+// @Failure 404 {string} string "Search not found"
+// @Router /search/{id}/replay [post]
+func replayResultsHandler(c echo.Context) error {
+	id := c.Param("id")
+	searchesMu.RLock()
+	specPtr, ok := searches[id]
+	var spec SearchSpec
+	if ok {
+		spec = *specPtr
+	}
+	searchesMu.RUnlock()
+	if !ok {
+		return c.String(http.StatusNotFound, "Search not found")
+	}
+	if spec.SuppressHooks {
+		return c.String(http.StatusBadRequest, "Searches with suppressHooks do not send results to hooks")
+	}
+
+	var base *ldap.DN
+	if b := c.FormValue("base"); b != "" {
+		parsed, err := ldap.ParseDN(b)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid base: "+err.Error())
+		}
+		base = parsed
+	}
+	var only map[string]bool
+	if params, err := c.FormParams(); err == nil && len(params["dn"]) > 0 {
+		only = make(map[string]bool)
+		for _, dn := range params["dn"] {
+			only[normalizeDN(dn)] = true
+		}
+	}
+
+	var selected []LDAPResult
+	var resp ReplayResponse
+	searchResultsMu.Lock()
+	for _, r := range searchResults[id] {
+		if only != nil && !only[normalizeDN(r.DN)] {
+			continue
+		}
+		if base != nil {
+			parsed, err := ldap.ParseDN(r.DN)
+			if err != nil || !(base.EqualFold(parsed) || base.AncestorOfFold(parsed)) {
+				continue
+			}
+		}
+		if r.Content == nil {
+			resp.Skipped++
+			continue
+		}
+		r.changeType = changeTypeModify
+		r.previous = nil
+		r.replay = true
+		selected = append(selected, r)
+	}
+	searchResultsMu.Unlock()
+	sort.Slice(selected, func(i, j int) bool { return selected[i].DN < selected[j].DN })
+	resp.Replayed = len(selected)
+
+	logger.Info("Replaying search results", "SearchId", id, "Entries", resp.Replayed, "Skipped", resp.Skipped)
+	addCounter(mReplayed, float64(resp.Replayed))
+	go func() {
+		for _, r := range selected {
+			dispatchResult(id, &spec, r)
+		}
+	}()
+	return c.JSON(http.StatusAccepted, resp)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// With result_cache: hash the results of searches are kept in memory as
+// their DN and a canonical hash of their content instead of the content
+// itself, which shrinks memory and turns change detection into one hash
+// comparison. The hash ignores the case of attribute names and the order
+// of values, which LDAP does not define. Without the content, GET /results
+// returns DNs only, replay skips the results, and hooks get no previous
+// content for modifications or deletions.
+
+// canonicalHashPrefix marks canonical hashes; hashes persisted without it
+// were computed by resultHash.
+const canonicalHashPrefix = "c1:"
+
+func validateResultCache() error {
+	switch config.ResultCache {
+	case "", persistResultsContent, persistResultsHash:
+		return nil
+	}
+	return fmt.Errorf("result_cache: invalid value %q (want content or hash)", config.ResultCache)
+}
+
+// cacheContent reports whether results keep their content in memory.
+func cacheContent() bool {
+	return config.ResultCache != persistResultsHash
+}
+
+// canonicalResultHash hashes content independently of the case of
+// attribute names and the order of values.
+func canonicalResultHash(content map[string]interface{}) string {
+	attrs := make([]string, 0, len(content))
+	values := make(map[string][]string, len(content))
+	for name, v := range content {
+		lower := strings.ToLower(name)
+		var vals []string
+		switch v := v.(type) {
+		case string:
+			vals = []string{v}
+		case []string:
+			vals = append(vals, v...)
+		default:
+			vals = []string{fmt.Sprint(v)}
+		}
+		if _, ok := values[lower]; !ok {
+			attrs = append(attrs, lower)
+		}
+		values[lower] = append(values[lower], vals...)
+	}
+	sort.Strings(attrs)
+	h := sha256.New()
+	for _, a := range attrs {
+		vals := values[a]
+		sort.Strings(vals)
+		// Lengths delimit the names and values unambiguously.
+		fmt.Fprintf(h, "%d:%s%d", len(a), a, len(vals))
+		for _, v := range vals {
+			fmt.Fprintf(h, ":%d:%s", len(v), v)
+		}
+	}
+	return canonicalHashPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedResult returns result as it is kept in a search's results: without
+// its content when only hashes are cached.
+func cachedResult(result LDAPResult) LDAPResult {
+	if cacheContent() || result.Content == nil {
+		return result
+	}
+	result.hash = canonicalResultHash(result.Content)
+	result.Content = nil
+	result.previous = nil
+	return result
+}
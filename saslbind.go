@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/jcmturner/gokrb5/v8/client"
+)
+
+// With sasl.mechanism set, connections bind with SASL instead of bind_dn
+// and a password. GSSAPI authenticates as a Kerberos principal, from a
+// keytab or from a credential cache kept fresh by an external kinit; the
+// service principal defaults to ldap/<server host>. The Kerberos client is
+// shared by the connections of a server and renews its ticket before it
+// expires; it is rebuilt when the keytab or cache file changes or a bind
+// fails, so rotated keytabs are picked up without a restart. EXTERNAL binds
+// as the identity the server derives from the connection: the TLS client
+// certificate (cert_file and key_file, over ldaps) or, over ldapi, the
+// process's user.
+
+// SASLConfig configures SASL binds.
+type SASLConfig struct {
+	Mechanism string `yaml:"mechanism"` // GSSAPI or EXTERNAL (default: simple bind)
+	// Principal is the Kerberos user principal, e.g. svc-ldapsync or
+	// svc-ldapsync@CORP.EXAMPLE.ORG.
+	Principal string `yaml:"principal"`
+	Realm     string `yaml:"realm"`     // Default: the principal's, else default_realm of krb5_conf
+	Keytab    string `yaml:"keytab"`    // Keytab holding the principal's keys
+	CCache    string `yaml:"ccache"`    // Credential cache, instead of a keytab
+	Krb5Conf  string `yaml:"krb5_conf"` // Default: /etc/krb5.conf
+	SPN       string `yaml:"spn"`       // Service principal (default: ldap/<server host>)
+	AuthzID   string `yaml:"authz_id"`  // Identity to act as, if the server allows it
+	// CertFile and KeyFile hold the TLS client certificate for EXTERNAL;
+	// CAFile the CAs trusted for the server certificate.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+}
+
+const (
+	saslGSSAPI   = "GSSAPI"
+	saslExternal = "EXTERNAL"
+)
+
+var mSASLBinds = describeMetric("ldapsync_sasl_binds_total", "counter",
+	"SASL binds to LDAP servers, by mechanism and result (success or failure).")
+
+// kerberosClient is the Kerberos client of one principal and credential
+// file; mu serializes binds, as a client holds one security context at a
+// time.
+type kerberosClient struct {
+	mu       sync.Mutex
+	client   *gssapi.Client
+	modified time.Time // of the keytab or credential cache loaded
+}
+
+var kerberosClients = struct {
+	sync.Mutex
+	byKey map[string]*kerberosClient
+}{byKey: make(map[string]*kerberosClient)}
+
+func validateSASL() error {
+	for name, cfg := range map[string]LDAPConfig{"source": config.Source, "target": config.Target} {
+		s := cfg.SASL
+		switch strings.ToUpper(s.Mechanism) {
+		case "":
+		case saslGSSAPI:
+			if s.Principal == "" && s.CCache == "" {
+				return fmt.Errorf("%s.sasl: principal is required for GSSAPI", name)
+			}
+			if (s.Keytab == "") == (s.CCache == "") {
+				return fmt.Errorf("%s.sasl: GSSAPI needs either keytab or ccache", name)
+			}
+		case saslExternal:
+			if (s.CertFile == "") != (s.KeyFile == "") {
+				return fmt.Errorf("%s.sasl: cert_file and key_file go together", name)
+			}
+		default:
+			return fmt.Errorf("%s.sasl: unknown mechanism %q", name, s.Mechanism)
+		}
+	}
+	return nil
+}
+
+// ldapDialOptions returns the options for dialing a server: the TLS client
+// certificate and CAs of the SASL settings.
+func ldapDialOptions(cfg LDAPConfig) ([]ldap.DialOpt, error) {
+	s := cfg.SASL
+	if s.CertFile == "" && s.CAFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", s.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return []ldap.DialOpt{ldap.DialWithTLSConfig(tlsConfig)}, nil
+}
+
+// bindLDAP binds a connection made to serverURL as configured.
+func bindLDAP(l *ldap.Conn, cfg LDAPConfig, serverURL string) error {
+	mechanism := strings.ToUpper(cfg.SASL.Mechanism)
+	if mechanism == "" {
+		return bindWithRotation(l, cfg)
+	}
+	var err error
+	switch mechanism {
+	case saslExternal:
+		err = l.ExternalBind()
+	case saslGSSAPI:
+		err = gssapiBind(l, cfg.SASL, serverURL)
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	incCounter(mSASLBinds, "mechanism", mechanism, "result", result)
+	return err
+}
+
+// gssapiBind binds with Kerberos, logging in again once if the bind fails
+// with the current client.
+func gssapiBind(l *ldap.Conn, s SASLConfig, serverURL string) error {
+	spn := s.SPN
+	if spn == "" {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return err
+		}
+		spn = "ldap/" + u.Hostname()
+	}
+	kc, err := kerberosClientFor(s)
+	if err != nil {
+		return err
+	}
+	kc.mu.Lock()
+	err = l.GSSAPIBind(kc.client, spn, s.AuthzID)
+	kc.mu.Unlock()
+	if err == nil {
+		return nil
+	}
+	logger.Warn("Kerberos bind failed; logging in again", "Principal", s.Principal, "SPN", spn, "Err", err)
+	forgetKerberosClient(s)
+	if kc, err = kerberosClientFor(s); err != nil {
+		return err
+	}
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	return l.GSSAPIBind(kc.client, spn, s.AuthzID)
+}
+
+func kerberosKey(s SASLConfig) string {
+	return strings.Join([]string{s.Principal, s.Realm, s.Keytab, s.CCache, s.Krb5Conf}, "\x00")
+}
+
+func credentialFile(s SASLConfig) string {
+	if s.Keytab != "" {
+		return s.Keytab
+	}
+	return s.CCache
+}
+
+// kerberosClientFor returns the logged-in Kerberos client for the SASL
+// settings, building a new one when the credential file changed.
+func kerberosClientFor(s SASLConfig) (*kerberosClient, error) {
+	info, err := os.Stat(credentialFile(s))
+	if err != nil {
+		return nil, err
+	}
+	key := kerberosKey(s)
+	kerberosClients.Lock()
+	defer kerberosClients.Unlock()
+	if kc, ok := kerberosClients.byKey[key]; ok {
+		if kc.modified.Equal(info.ModTime()) {
+			return kc, nil
+		}
+		logger.Info("Kerberos credentials changed; logging in again", "Path", credentialFile(s))
+		kc.mu.Lock()
+		kc.client.Close()
+		kc.mu.Unlock()
+		delete(kerberosClients.byKey, key)
+	}
+
+	krb5Conf := s.Krb5Conf
+	if krb5Conf == "" {
+		krb5Conf = "/etc/krb5.conf"
+	}
+	var c *gssapi.Client
+	if s.Keytab != "" {
+		user, realm, _ := strings.Cut(s.Principal, "@")
+		if s.Realm != "" {
+			realm = s.Realm
+		}
+		c, err = gssapi.NewClientWithKeytab(user, realm, s.Keytab, krb5Conf, client.DisablePAFXFAST(true))
+	} else {
+		c, err = gssapi.NewClientFromCCache(s.CCache, krb5Conf, client.DisablePAFXFAST(true))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading Kerberos credentials: %w", err)
+	}
+	// Logging in starts the renewal of the ticket.
+	if err := c.AffirmLogin(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("kerberos login: %w", err)
+	}
+	kc := &kerberosClient{client: c, modified: info.ModTime()}
+	kerberosClients.byKey[key] = kc
+	return kc, nil
+}
+
+func forgetKerberosClient(s SASLConfig) {
+	key := kerberosKey(s)
+	kerberosClients.Lock()
+	kc, ok := kerberosClients.byKey[key]
+	delete(kerberosClients.byKey, key)
+	kerberosClients.Unlock()
+	if ok {
+		kc.mu.Lock()
+		kc.client.Close()
+		kc.mu.Unlock()
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A search with a schedule runs at the times given by a five-field cron
+// expression (minute hour day-of-month month day-of-week) instead of every
+// refresh interval, e.g. "0 2 * * *" for a nightly full sync. Its refresh,
+// if set, is only used to retry after errors. The time of the last
+// scheduled run is persisted, so a run missed while the service was down is
+// made up at startup.
+
+// cronSchedule is a parsed cron expression; each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// With both day fields restricted a day matches either, as in cron.
+	domAny, dowAny bool
+	loc            *time.Location
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+
+var cronDayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// parseCronSchedule parses a cron expression. Fields accept *, values,
+// ranges (1-5), lists (1,15) and steps (*/15, 0-30/10); months and days of
+// week also accept three-letter names. A leading "CRON_TZ=<zone>" or
+// "TZ=<zone>" evaluates the schedule in that time zone instead of the local
+// one. The @hourly, @daily, @weekly, @monthly and @yearly macros are
+// supported.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	s := &cronSchedule{loc: time.Local}
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		zone, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(zone, "=")
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %w", err)
+		}
+		s.loc = loc
+		expr = strings.TrimSpace(rest)
+	}
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields (minute hour day-of-month month day-of-week)", expr)
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("schedule minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("schedule hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("schedule day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("schedule month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("schedule day of week: %w", err)
+	}
+	// 7 is another name for Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*" || fields[2] == "?"
+	s.dowAny = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rangePart != "*" && rangePart != "?" {
+			loStr, hiStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseCronValue(loStr, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseCronValue(hiStr, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowMatch
+	case s.dowAny:
+		return domMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time after t the schedule fires, or the zero time
+// if it never does (e.g. February 30).
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	// Five years covers every satisfiable combination, leap days included.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// searchTimer decides when a search runs next.
+type searchTimer struct {
+	id      string
+	sched   *cronSchedule
+	refresh time.Duration
+	lastRun time.Time
+	// interval is the adaptive refresh interval and quiet the number of
+	// polling cycles since it last changed without finding changes.
+	interval time.Duration
+	quiet    int
+}
+
+func newSearchTimer(id string, spec *SearchSpec) *searchTimer {
+	refresh := time.Duration(spec.Refresh) * time.Second
+	t := &searchTimer{id: id, refresh: refresh, lastRun: spec.LastRun, interval: refresh}
+	if spec.Schedule != "" {
+		sched, err := parseCronSchedule(spec.Schedule)
+		if err != nil {
+			// Schedules are validated when searches are created; fall back
+			// to the refresh interval for anything that slipped through.
+			syncLogger.Error("Invalid search schedule; using the refresh interval", "SearchId", id, "Err", err)
+		} else {
+			t.sched = sched
+		}
+	}
+	return t
+}
+
+// untilFirst is how long to wait before the first run: the initial jitter
+// for interval searches, none for scheduled searches that missed a run,
+// otherwise until the next scheduled time. A scheduled search that never
+// ran waits for its first scheduled time.
+func (t *searchTimer) untilFirst() time.Duration {
+	if t.sched == nil {
+		return initialJitter(t.refresh)
+	}
+	last := t.lastRun
+	if last.IsZero() {
+		last = time.Now()
+	}
+	next := t.sched.next(last)
+	if next.IsZero() {
+		return t.never()
+	}
+	if wait := time.Until(next); wait > 0 {
+		return wait
+	}
+	syncLogger.Info("Running scheduled search missed while stopped", "SearchId", t.id, "LastRun", t.lastRun)
+	return 0
+}
+
+// untilNext is how long to wait after a completed run.
+func (t *searchTimer) untilNext() time.Duration {
+	if t.sched == nil {
+		return jittered(t.interval)
+	}
+	next := t.sched.next(time.Now())
+	if next.IsZero() {
+		return t.never()
+	}
+	return time.Until(next)
+}
+
+// untilRetry is how long to wait after a failed run: the refresh interval,
+// or for scheduled searches without one, the next scheduled time.
+func (t *searchTimer) untilRetry() time.Duration {
+	if t.sched != nil && t.refresh <= 0 {
+		return t.untilNext()
+	}
+	return jittered(t.refresh)
+}
+
+// polled adapts the interval of an interval search after a full polling
+// cycle that did or did not find changes.
+func (t *searchTimer) polled(changed bool) {
+	if t.sched != nil {
+		return
+	}
+	interval, quiet := adaptInterval(t.refresh, t.interval, t.quiet, changed)
+	if interval != t.interval {
+		syncLogger.Debug("Adapted search refresh interval", "SearchId", t.id, "Interval", interval, "Changed", changed)
+	}
+	t.interval, t.quiet = interval, quiet
+	setGauge(mSearchInterval, t.interval.Seconds(), "search", t.id)
+}
+
+func (t *searchTimer) never() time.Duration {
+	syncLogger.Warn("Search schedule never fires", "SearchId", t.id)
+	return 100 * 365 * 24 * time.Hour
+}
+
+// completed records a run of a scheduled search in memory and the database.
+func (t *searchTimer) completed() {
+	if t.sched == nil {
+		return
+	}
+	t.lastRun = time.Now()
+	searchesMu.Lock()
+	if spec, ok := searches[t.id]; ok {
+		spec.LastRun = t.lastRun
+	}
+	searchesMu.Unlock()
+	if db == nil {
+		return
+	}
+	if _, err := db.Exec(`UPDATE searches SET last_run_at = $2 WHERE id = $1`, t.id, t.lastRun); err != nil {
+		syncLogger.Error("Failed to record scheduled search run", "SearchId", t.id, "Err", err)
+	}
+}
+
+// lastScheduledRun returns when a scheduled search last ran, or nil.
+func lastScheduledRun(spec *SearchSpec) *time.Time {
+	if spec.Schedule == "" || spec.LastRun.IsZero() {
+		return nil
+	}
+	last := spec.LastRun
+	return &last
+}
+
+// nextScheduledRun returns when a scheduled search runs next, or nil.
+func nextScheduledRun(spec *SearchSpec) *time.Time {
+	if spec.Schedule == "" {
+		return nil
+	}
+	sched, err := parseCronSchedule(spec.Schedule)
+	if err != nil {
+		return nil
+	}
+	next := sched.next(time.Now())
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
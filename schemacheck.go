@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// SchemaValidationConfig checks entries against the target's subschema
+// before they are written, so schema errors are reported with the offending
+// attribute instead of an opaque objectClassViolation (65) from the server.
+type SchemaValidationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ReportOnly records violations but still sends the write.
+	ReportOnly bool `yaml:"report_only"`
+	RefreshMin int  `yaml:"refresh_m"`  // How often the subschema is re-read (default: 60)
+	AuditSize  int  `yaml:"audit_size"` // Violations kept for GET /schema/violations (default: 1000)
+}
+
+// SchemaViolation describes one way an entry breaks the target schema.
+type SchemaViolation struct {
+	Time      time.Time `json:"time"`
+	DN        string    `json:"dn"`
+	Search    string    `json:"search,omitempty"`
+	Operation string    `json:"operation"`
+	// Kind is missing_required, unknown_attribute, not_allowed,
+	// single_value or unknown_object_class.
+	Kind      string `json:"kind"`
+	Attribute string `json:"attribute"`
+	Reason    string `json:"reason"`
+}
+
+// errSchemaViolation is wrapped by every error returned for an entry
+// rejected by schema validation.
+var errSchemaViolation = errors.New("violates target schema")
+
+var mSchemaViolations = describeMetric("ldapsync_schema_violations_total", "counter",
+	"Target schema violations found before writes, by kind.")
+
+type schemaAttribute struct {
+	name        string
+	singleValue bool
+	operational bool
+}
+
+type schemaClass struct {
+	name string
+	sup  []string
+	must []string
+	may  []string
+}
+
+// targetSchema is the parsed subschema; lookups are by lowercase name or OID.
+type targetSchema struct {
+	attributes map[string]*schemaAttribute
+	classes    map[string]*schemaClass
+}
+
+type schemaState struct {
+	mu      sync.Mutex // Guards schema and fetched
+	schema  *targetSchema
+	fetched time.Time
+
+	auditMu    sync.Mutex
+	violations []SchemaViolation
+}
+
+var schemaCheck = &schemaState{}
+
+// schemaSearchAttributes returns the attributes to read from an existing
+// target entry so modifies can be validated against its object classes.
+func schemaSearchAttributes() []string {
+	if !config.SchemaValidation.Enabled {
+		return nil
+	}
+	return []string{"objectClass"}
+}
+
+// get returns the cached subschema, re-reading it over l when it is older
+// than the refresh interval. A failed read keeps the previous schema.
+func (s *schemaState) get(l *ldap.Conn) *targetSchema {
+	refresh := time.Duration(config.SchemaValidation.RefreshMin) * time.Minute
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.schema != nil && time.Since(s.fetched) < refresh {
+		return s.schema
+	}
+	schema, err := fetchTargetSchema(l)
+	if err != nil {
+		logger.Error("Failed to read target schema; entries are not validated", "Err", err)
+		// Retry on the next write rather than on every write.
+		s.fetched = time.Now().Add(time.Minute - refresh)
+		return s.schema
+	}
+	s.schema, s.fetched = schema, time.Now()
+	logger.Debug("Read target schema", "Attributes", len(schema.attributes), "ObjectClasses", len(schema.classes))
+	return s.schema
+}
+
+func fetchTargetSchema(l *ldap.Conn) (*targetSchema, error) {
+	root, err := l.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", []string{"subschemaSubentry"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("reading root DSE: %w", err)
+	}
+	subentry := ""
+	if len(root.Entries) > 0 {
+		subentry = root.Entries[0].GetAttributeValue("subschemaSubentry")
+	}
+	if subentry == "" {
+		return nil, fmt.Errorf("target does not publish a subschemaSubentry")
+	}
+	sr, err := l.Search(ldap.NewSearchRequest(subentry, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=subschema)", []string{"attributeTypes", "objectClasses"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("reading subschema %q: %w", subentry, err)
+	}
+	if len(sr.Entries) == 0 {
+		return nil, fmt.Errorf("subschema %q not found", subentry)
+	}
+	return parseTargetSchema(sr.Entries[0].GetEqualFoldAttributeValues("attributeTypes"),
+		sr.Entries[0].GetEqualFoldAttributeValues("objectClasses")), nil
+}
+
+// parseTargetSchema parses RFC 4512 attribute type and object class
+// descriptions. Descriptions that cannot be parsed are skipped.
+func parseTargetSchema(attributeTypes, objectClasses []string) *targetSchema {
+	schema := &targetSchema{
+		attributes: make(map[string]*schemaAttribute),
+		classes:    make(map[string]*schemaClass),
+	}
+	for _, desc := range attributeTypes {
+		oid, fields := parseSchemaDescription(desc)
+		if oid == "" {
+			continue
+		}
+		names := fields["NAME"]
+		attr := &schemaAttribute{name: oid, operational: len(fields["USAGE"]) > 0 && fields["USAGE"][0] != "userApplications"}
+		if len(names) > 0 {
+			attr.name = names[0]
+		}
+		_, attr.singleValue = fields["SINGLE-VALUE"]
+		for _, n := range append(names, oid) {
+			schema.attributes[strings.ToLower(n)] = attr
+		}
+	}
+	for _, desc := range objectClasses {
+		oid, fields := parseSchemaDescription(desc)
+		if oid == "" {
+			continue
+		}
+		names := fields["NAME"]
+		class := &schemaClass{name: oid, sup: fields["SUP"], must: fields["MUST"], may: fields["MAY"]}
+		if len(names) > 0 {
+			class.name = names[0]
+		}
+		for _, n := range append(names, oid) {
+			schema.classes[strings.ToLower(n)] = class
+		}
+	}
+	return schema
+}
+
+// schemaFlags are description keywords without a value.
+var schemaFlags = map[string]bool{
+	"SINGLE-VALUE": true, "OBSOLETE": true, "COLLECTIVE": true, "NO-USER-MODIFICATION": true,
+	"ABSTRACT": true, "STRUCTURAL": true, "AUXILIARY": true,
+}
+
+// parseSchemaDescription splits "( oid KEYWORD value KEYWORD ( a $ b ) ... )"
+// into the OID and the values of each keyword.
+func parseSchemaDescription(desc string) (string, map[string][]string) {
+	tokens := tokenizeSchemaDescription(desc)
+	if len(tokens) < 2 || tokens[0] != "(" {
+		return "", nil
+	}
+	oid := tokens[1]
+	fields := make(map[string][]string)
+	for i := 2; i < len(tokens); i++ {
+		keyword := tokens[i]
+		if keyword == ")" {
+			break
+		}
+		if schemaFlags[keyword] {
+			fields[keyword] = nil
+			continue
+		}
+		if i+1 >= len(tokens) {
+			break
+		}
+		i++
+		if tokens[i] != "(" {
+			fields[keyword] = []string{tokens[i]}
+			continue
+		}
+		var values []string
+		for i++; i < len(tokens) && tokens[i] != ")"; i++ {
+			if tokens[i] != "$" {
+				values = append(values, tokens[i])
+			}
+		}
+		fields[keyword] = values
+	}
+	return oid, fields
+}
+
+func tokenizeSchemaDescription(desc string) []string {
+	var tokens []string
+	for i := 0; i < len(desc); {
+		switch c := desc[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '$':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '\'':
+			end := strings.IndexByte(desc[i+1:], '\'')
+			if end < 0 {
+				tokens = append(tokens, desc[i+1:])
+				return tokens
+			}
+			tokens = append(tokens, desc[i+1:i+1+end])
+			i += end + 2
+		default:
+			j := i
+			for j < len(desc) && !strings.ContainsRune(" \t\n\r()$'", rune(desc[j])) {
+				j++
+			}
+			tokens = append(tokens, desc[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// attribute looks up an attribute description, ignoring options such as
+// ;binary.
+func (s *targetSchema) attribute(name string) *schemaAttribute {
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return s.attributes[strings.ToLower(name)]
+}
+
+// classClosure returns the given object classes and all their superclasses;
+// unknown names are returned separately.
+func (s *targetSchema) classClosure(names []string) (classes []*schemaClass, unknown []string) {
+	seen := make(map[*schemaClass]bool)
+	var walk func(string, bool)
+	walk = func(name string, top bool) {
+		class, ok := s.classes[strings.ToLower(name)]
+		if !ok {
+			if top {
+				unknown = append(unknown, name)
+			}
+			return
+		}
+		if seen[class] {
+			return
+		}
+		seen[class] = true
+		classes = append(classes, class)
+		for _, sup := range class.sup {
+			walk(sup, false)
+		}
+	}
+	for _, n := range names {
+		walk(n, true)
+	}
+	return classes, unknown
+}
+
+// validate checks the attributes written to dn. For adds every required
+// attribute must be present; modifies only replace the given attributes, so
+// just those are checked, against the entry's current object classes unless
+// objectClass itself is written.
+func (s *targetSchema) validate(op, dn string, attributes map[string][]string, existing *ldap.Entry) []SchemaViolation {
+	var out []SchemaViolation
+	add := func(kind, attr, reason string) {
+		out = append(out, SchemaViolation{DN: dn, Operation: op, Kind: kind, Attribute: attr, Reason: reason})
+	}
+
+	objectClasses := getAttributeValues(attributes, "objectClass")
+	if objectClasses == nil && existing != nil {
+		objectClasses = getEntryAttributeValues(existing, "objectClass")
+	}
+	classes, unknown := s.classClosure(objectClasses)
+	for _, oc := range unknown {
+		add("unknown_object_class", "objectClass", fmt.Sprintf("object class %q is not defined by the target schema", oc))
+	}
+
+	allowed := make(map[*schemaAttribute]bool)
+	anyAllowed := len(unknown) > 0 // Cannot tell what unknown classes allow.
+	for _, class := range classes {
+		if strings.EqualFold(class.name, "extensibleObject") {
+			anyAllowed = true
+		}
+		for _, a := range append(append([]string{}, class.must...), class.may...) {
+			if attr := s.attribute(a); attr != nil {
+				allowed[attr] = true
+			}
+		}
+		if op != "add" {
+			continue
+		}
+		for _, a := range class.must {
+			if len(getAttributeValues(attributes, a)) == 0 && !rdnHasAttribute(dn, a) {
+				add("missing_required", a, fmt.Sprintf("required by object class %s", class.name))
+			}
+		}
+	}
+
+	for name, vals := range attributes {
+		if len(vals) == 0 {
+			continue // Removes the attribute
+		}
+		attr := s.attribute(name)
+		if attr == nil {
+			add("unknown_attribute", name, "attribute type is not defined by the target schema")
+			continue
+		}
+		if attr.singleValue && len(vals) > 1 {
+			add("single_value", name, fmt.Sprintf("attribute is single-valued but %d values were given", len(vals)))
+		}
+		if !attr.operational && !anyAllowed && len(classes) > 0 && !allowed[attr] {
+			add("not_allowed", name, "attribute is not allowed by the entry's object classes")
+		}
+	}
+	return out
+}
+
+func getAttributeValues(attributes map[string][]string, name string) []string {
+	for attr, vals := range attributes {
+		if strings.EqualFold(attr, name) {
+			return vals
+		}
+	}
+	return nil
+}
+
+// rdnHasAttribute reports whether the RDN of dn carries attr; servers add
+// RDN values to the entry implicitly.
+func rdnHasAttribute(dn, attr string) bool {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return false
+	}
+	for _, a := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(a.Type, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSchema validates an add or modify against the target schema and
+// records any violations. Unless validation is report-only or the entry is
+// a dry run, the write is rejected with an error naming the violations.
+func checkSchema(l *ldap.Conn, entry *TransformedEntry, op string, attributes map[string][]string, existing *ldap.Entry) error {
+	if !config.SchemaValidation.Enabled {
+		return nil
+	}
+	schema := schemaCheck.get(l)
+	if schema == nil {
+		return nil
+	}
+	violations := schema.validate(op, entry.DN, attributes, existing)
+	if len(violations) == 0 {
+		return nil
+	}
+	now := time.Now()
+	reasons := make([]string, len(violations))
+	for i := range violations {
+		violations[i].Time = now
+		violations[i].Search = entry.Search
+		v := violations[i]
+		reasons[i] = v.Attribute + ": " + v.Reason
+		incCounter(mSchemaViolations, "kind", v.Kind)
+		logger.Warn("Entry violates target schema", "DN", v.DN, "SearchId", v.Search, "Operation", op,
+			"Kind", v.Kind, "Attribute", v.Attribute, "Reason", v.Reason)
+	}
+	schemaCheck.record(violations)
+	if config.SchemaValidation.ReportOnly || isDryRun(entry) {
+		return nil
+	}
+	return fmt.Errorf("%s of %s %w: %s", op, entry.DN, errSchemaViolation, strings.Join(reasons, "; "))
+}
+
+func (s *schemaState) record(violations []SchemaViolation) {
+	size := config.SchemaValidation.AuditSize
+	if size <= 0 {
+		size = 1000
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	s.violations = append(s.violations, violations...)
+	if over := len(s.violations) - size; over > 0 {
+		s.violations = append([]SchemaViolation{}, s.violations[over:]...)
+	}
+}
+
+// getSchemaViolationsHandler godoc
+// @Summary List schema violations
+// @Description Lists recent entries found to violate the target schema before being written, oldest first.
+// @Tags schema
+// @Produce json
+// @Success 200 {array} SchemaViolation
+// @Router /schema/violations [get]
+func getSchemaViolationsHandler(c echo.Context) error {
+	schemaCheck.auditMu.Lock()
+	out := append([]SchemaViolation{}, schemaCheck.violations...)
+	schemaCheck.auditMu.Unlock()
+	return c.JSON(http.StatusOK, out)
+}
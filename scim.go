@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A SCIM target provisions users and groups into a SCIM 2.0 service
+// provider. Entries are classified by objectClass; the name attribute
+// becomes userName (users) or displayName (groups), by which existing
+// resources are found, and the DN becomes externalId. Existing resources are
+// updated with a PATCH replacing the mapped attributes, or with a PUT when
+// use_put is set. Group members are given as DNs and resolved to the ids
+// of resources this target wrote or can find by the member's RDN value;
+// members that cannot be resolved are left out.
+
+// SCIMConfig configures a SCIM 2.0 target.
+type SCIMConfig struct {
+	// URL is the base URL of the service provider, e.g.
+	// https://idp.example.org/scim/v2.
+	URL string `yaml:"url"`
+	// Token is the bearer token; TokenFile is read for it on every request
+	// instead, so it can be rotated.
+	Token      string `yaml:"token"`
+	TokenFile  string `yaml:"token_file"`
+	TimeoutSec int    `yaml:"timeout_s"` // default: 30
+	// UsePut replaces existing resources with PUT instead of PATCH.
+	UsePut bool               `yaml:"use_put"`
+	Users  SCIMResourceConfig `yaml:"users"`
+	Groups SCIMResourceConfig `yaml:"groups"`
+}
+
+// SCIMResourceConfig maps entries to one SCIM resource type.
+type SCIMResourceConfig struct {
+	// ObjectClass selects the entries of this type (users: inetOrgPerson,
+	// groups: groupOfNames).
+	ObjectClass string `yaml:"object_class"`
+	// NameAttribute holds userName or displayName (users: uid, groups: cn).
+	NameAttribute string `yaml:"name_attribute"`
+	// Attributes maps LDAP attributes to SCIM attribute paths, such as
+	// givenName: name.givenName. emails and phoneNumbers take lists.
+	Attributes map[string]string `yaml:"attributes"`
+	// MemberAttribute holds the member DNs of groups (default: member).
+	MemberAttribute string `yaml:"member_attribute"`
+}
+
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+)
+
+var errSCIMNotFound = errors.New("SCIM resource not found")
+
+// scimResourceType is a SCIM endpoint and how entries map to it.
+type scimResourceType struct {
+	endpoint  string // Users or Groups
+	schema    string
+	nameField string // userName or displayName
+	SCIMResourceConfig
+}
+
+type scimTarget struct {
+	name   string
+	cfg    SCIMConfig
+	client *http.Client
+	users  scimResourceType
+	groups scimResourceType
+
+	mu  sync.Mutex
+	ids map[string]string // Normalized DN to SCIM id
+}
+
+func newSCIMTarget(t TargetConfig) (TargetBackend, error) {
+	cfg := t.SCIM
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("scim.url is required")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("scim.url: %w", err)
+	}
+	if cfg.Token != "" && cfg.TokenFile != "" {
+		return nil, fmt.Errorf("scim: token and token_file are mutually exclusive")
+	}
+	if cfg.TokenFile != "" {
+		if _, err := os.ReadFile(cfg.TokenFile); err != nil {
+			return nil, fmt.Errorf("scim.token_file: %w", err)
+		}
+	}
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	users := scimResourceType{endpoint: "Users", schema: scimUserSchema, nameField: "userName", SCIMResourceConfig: cfg.Users}
+	if users.ObjectClass == "" {
+		users.ObjectClass = "inetOrgPerson"
+	}
+	if users.NameAttribute == "" {
+		users.NameAttribute = "uid"
+	}
+	if users.Attributes == nil {
+		users.Attributes = map[string]string{
+			"cn":        "displayName",
+			"givenName": "name.givenName",
+			"sn":        "name.familyName",
+			"mail":      "emails",
+		}
+	}
+	groups := scimResourceType{endpoint: "Groups", schema: scimGroupSchema, nameField: "displayName", SCIMResourceConfig: cfg.Groups}
+	if groups.ObjectClass == "" {
+		groups.ObjectClass = "groupOfNames"
+	}
+	if groups.NameAttribute == "" {
+		groups.NameAttribute = "cn"
+	}
+	if groups.MemberAttribute == "" {
+		groups.MemberAttribute = "member"
+	}
+	return &scimTarget{
+		name:   t.Name,
+		cfg:    cfg,
+		client: &http.Client{Timeout: timeout},
+		users:  users,
+		groups: groups,
+		ids:    make(map[string]string),
+	}, nil
+}
+
+func (s *scimTarget) Name() string { return s.name }
+
+func (s *scimTarget) Store(entry *TransformedEntry) error {
+	rt := s.resourceType(entry.Content)
+	if rt == nil {
+		return nil
+	}
+	name := firstValue(entry.Content, rt.NameAttribute)
+	if name == "" {
+		return fmt.Errorf("%w: %s has no %s for %s", errTargetRejected, entry.DN, rt.NameAttribute, rt.nameField)
+	}
+	resource := s.resource(rt, entry, name)
+
+	key := normalizeDN(entry.DN)
+	s.mu.Lock()
+	id := s.ids[key]
+	s.mu.Unlock()
+	var err error
+	if id == "" {
+		if id, err = s.find(rt, name); err != nil {
+			return err
+		}
+	}
+	if id != "" {
+		err = s.update(rt, id, resource)
+		if errors.Is(err, errSCIMNotFound) {
+			// Deleted at the provider since it was looked up.
+			id = ""
+		} else if err != nil {
+			return err
+		}
+	}
+	if id == "" {
+		if id, err = s.create(rt, name, resource); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.ids[key] = id
+	s.mu.Unlock()
+	return nil
+}
+
+// resourceType returns the resource type of an entry, nil for neither.
+func (s *scimTarget) resourceType(content map[string]interface{}) *scimResourceType {
+	switch {
+	case contentHasValue(content, "objectClass", s.users.ObjectClass):
+		return &s.users
+	case contentHasValue(content, "objectClass", s.groups.ObjectClass):
+		return &s.groups
+	}
+	return nil
+}
+
+// resource builds the SCIM representation of an entry.
+func (s *scimTarget) resource(rt *scimResourceType, entry *TransformedEntry, name string) map[string]interface{} {
+	res := map[string]interface{}{
+		"schemas":    []string{rt.schema},
+		rt.nameField: name,
+		"externalId": entry.DN,
+	}
+	for attr, path := range rt.Attributes {
+		_, v, ok := lookupAttr(entry.Content, attr)
+		if !ok {
+			continue
+		}
+		setSCIMValue(res, path, toStringSlice(v))
+	}
+	if rt == &s.groups {
+		members := []map[string]string{}
+		if _, v, ok := lookupAttr(entry.Content, rt.MemberAttribute); ok {
+			for _, dn := range toStringSlice(v) {
+				id, err := s.memberID(dn)
+				if err != nil || id == "" {
+					logger.Warn("Leaving out unresolved SCIM group member", "Target", s.name,
+						"Group", entry.DN, "Member", dn, "Err", err)
+					continue
+				}
+				members = append(members, map[string]string{"value": id})
+			}
+		}
+		res["members"] = members
+	}
+	return res
+}
+
+// setSCIMValue sets a dotted attribute path. Multi-valued SCIM attributes
+// (emails, phoneNumbers, ...) take a list of {"value": ...}; others take the
+// first value.
+func setSCIMValue(res map[string]interface{}, path string, vals []string) {
+	if len(vals) == 0 {
+		return
+	}
+	parts := strings.Split(path, ".")
+	obj := res
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := obj[p].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			obj[p] = next
+		}
+		obj = next
+	}
+	last := parts[len(parts)-1]
+	switch last {
+	case "emails", "phoneNumbers", "ims", "photos", "addresses", "entitlements", "roles", "x509Certificates":
+		list := make([]map[string]interface{}, len(vals))
+		for i, v := range vals {
+			list[i] = map[string]interface{}{"value": v}
+		}
+		if len(list) > 0 && last == "emails" {
+			list[0]["primary"] = true
+		}
+		obj[last] = list
+	default:
+		obj[last] = vals[0]
+	}
+}
+
+// memberID resolves a member DN to a SCIM id, from the DNs this target
+// wrote or by looking up the RDN value as a userName, then a displayName.
+func (s *scimTarget) memberID(dn string) (string, error) {
+	key := normalizeDN(dn)
+	s.mu.Lock()
+	id := s.ids[key]
+	s.mu.Unlock()
+	if id != "" {
+		return id, nil
+	}
+	rdn, _, _ := strings.Cut(dn, ",")
+	_, value, ok := strings.Cut(rdn, "=")
+	if !ok || value == "" {
+		return "", fmt.Errorf("invalid DN")
+	}
+	for _, rt := range []*scimResourceType{&s.users, &s.groups} {
+		if id, err := s.find(rt, value); err != nil || id != "" {
+			if id != "" {
+				s.mu.Lock()
+				s.ids[key] = id
+				s.mu.Unlock()
+			}
+			return id, err
+		}
+	}
+	return "", nil
+}
+
+// find looks a resource up by name; "" if there is none.
+func (s *scimTarget) find(rt *scimResourceType, name string) (string, error) {
+	filter := fmt.Sprintf(`%s eq "%s"`, rt.nameField, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name))
+	var list struct {
+		Resources []struct {
+			ID string `json:"id"`
+		} `json:"Resources"`
+	}
+	if err := s.do(http.MethodGet, rt.endpoint+"?filter="+url.QueryEscape(filter), nil, &list); err != nil {
+		return "", err
+	}
+	if len(list.Resources) == 0 {
+		return "", nil
+	}
+	return list.Resources[0].ID, nil
+}
+
+// create creates a resource. A resource created meanwhile by someone else
+// (409) is looked up and updated instead.
+func (s *scimTarget) create(rt *scimResourceType, name string, resource map[string]interface{}) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	err := s.do(http.MethodPost, rt.endpoint, resource, &created)
+	var statusErr *scimStatusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusConflict {
+		id, ferr := s.find(rt, name)
+		if ferr != nil || id == "" {
+			return "", err
+		}
+		return id, s.update(rt, id, resource)
+	}
+	if err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", fmt.Errorf("SCIM %s created without an id", rt.endpoint)
+	}
+	return created.ID, nil
+}
+
+// update replaces the mapped attributes of an existing resource.
+func (s *scimTarget) update(rt *scimResourceType, id string, resource map[string]interface{}) error {
+	path := rt.endpoint + "/" + url.PathEscape(id)
+	if s.cfg.UsePut {
+		return s.do(http.MethodPut, path, resource, nil)
+	}
+	value := make(map[string]interface{}, len(resource))
+	for k, v := range resource {
+		if k != "schemas" {
+			value[k] = v
+		}
+	}
+	patch := map[string]interface{}{
+		"schemas":    []string{scimPatchSchema},
+		"Operations": []map[string]interface{}{{"op": "replace", "value": value}},
+	}
+	return s.do(http.MethodPatch, path, patch, nil)
+}
+
+// scimStatusError is an error response of the service provider.
+type scimStatusError struct {
+	method, path string
+	code         int
+	detail       string
+}
+
+func (e *scimStatusError) Error() string {
+	return fmt.Sprintf("SCIM %s %s: %d %s", e.method, e.path, e.code, e.detail)
+}
+
+// Unwrap classifies client errors other than timeouts, conflicts and rate
+// limits as permanent, and 404 as a missing resource.
+func (e *scimStatusError) Unwrap() error {
+	switch {
+	case e.code == http.StatusNotFound:
+		return errSCIMNotFound
+	case e.code == http.StatusUnauthorized, e.code == http.StatusRequestTimeout,
+		e.code == http.StatusConflict, e.code == http.StatusTooManyRequests:
+		return nil
+	case e.code >= 400 && e.code < 500:
+		return errTargetRejected
+	}
+	return nil
+}
+
+func (s *scimTarget) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(s.cfg.URL, "/")+"/"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/scim+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	token := s.cfg.Token
+	if s.cfg.TokenFile != "" {
+		data, err := os.ReadFile(s.cfg.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read SCIM token file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var scimErr struct {
+			Detail string `json:"detail"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &scimErr) != nil || scimErr.Detail == "" {
+			scimErr.Detail = strings.TrimSpace(string(data))
+		}
+		return &scimStatusError{method: method, path: path, code: resp.StatusCode, detail: scimErr.Detail}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// firstValue returns the first value of an attribute, "" if it has none.
+func firstValue(content map[string]interface{}, attr string) string {
+	_, v, ok := lookupAttr(content, attr)
+	if !ok {
+		return ""
+	}
+	if vals := toStringSlice(v); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
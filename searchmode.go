@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// runOnce stops a search after its first complete run, and suppressHooks
+// keeps its results from the hooks, transform and mapping, so they are only
+// cached and nothing is written to the target. Both default to false. They
+// replace oneshot, which meant both and defaulted to true: the API and
+// derived searches still accept oneShot/oneshot and set both flags from
+// it, and searches saved before the split take theirs from the oneshot
+// column.
+
+// runFlagsFromForm reads the runOnce and suppressHooks parameters of a
+// search request, starting from the legacy oneShot parameter if given.
+func runFlagsFromForm(c echo.Context) (runOnce, suppressHooks bool, err error) {
+	if s := c.FormValue("oneShot"); s != "" {
+		legacy, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, false, fmt.Errorf("Invalid oneShot parameter")
+		}
+		runOnce, suppressHooks = legacy, legacy
+	}
+	if s := c.FormValue("runOnce"); s != "" {
+		if runOnce, err = strconv.ParseBool(s); err != nil {
+			return false, false, fmt.Errorf("Invalid runOnce parameter")
+		}
+	}
+	if s := c.FormValue("suppressHooks"); s != "" {
+		if suppressHooks, err = strconv.ParseBool(s); err != nil {
+			return false, false, fmt.Errorf("Invalid suppressHooks parameter")
+		}
+	}
+	return runOnce, suppressHooks, nil
+}
+
+// derivedRunFlags returns the run flags of a derived search; the legacy
+// oneshot field sets both.
+func derivedRunFlags(ds DerivedSearchSpec) (runOnce, suppressHooks bool) {
+	return ds.RunOnce || ds.Oneshot, ds.SuppressHooks || ds.Oneshot
+}
+
+// storedRunFlags returns the run flags of a saved search. Rows written
+// before the flags were split have them NULL and take both from oneshot.
+func storedRunFlags(oneshot bool, runOnce, suppressHooks sql.NullBool) (bool, bool) {
+	if !runOnce.Valid {
+		runOnce.Bool = oneshot
+	}
+	if !suppressHooks.Valid {
+		suppressHooks.Bool = oneshot
+	}
+	return runOnce.Bool, suppressHooks.Bool
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// maxPreviewSize bounds the entries a search preview reads.
+const maxPreviewSize = 1000
+
+// SearchPreview is what a search would match on the source right now.
+type SearchPreview struct {
+	Filter string `json:"filter"`
+	BaseDN string `json:"baseDN"`
+	// Count is the number of matching entries read, at most the limit;
+	// Truncated reports that more entries match.
+	Count     int              `json:"count"`
+	Truncated bool             `json:"truncated"`
+	DNs       []string         `json:"dns"`
+	Sample    *ResultEntryFull `json:"sample,omitempty"` // The first entry, as its results would store it
+}
+
+// previewSearchHandler godoc
+// @Summary Preview a search
+// @Description Runs a filter once against the source and returns the matching DNs and the first entry as it would be stored, without creating a search. Nothing is sent to hooks or written to the target.
+// @Tags search
+// @Accept application/x-www-form-urlencoded
+// @Produce json
+// @Param filter formData string true "LDAP search filter"
+// @Param baseDN formData string false "Optional base DN for the search; defaults to global config if omitted"
+// @Param scope formData string false "Search scope: base, one or sub (default)"
+// @Param deref formData string false "Alias dereferencing: never (default), search, find or always"
+// @Param timeLimit formData int false "Maximum seconds the source spends on the search (default: no limit)"
+// @Param attributes formData string false "Optional comma-separated attributes to request from the source; defaults to all user attributes"
+// @Param exclude_attributes formData string false "Optional comma-separated attributes stripped from the sample"
+// @Param limit formData int false "Return at most this many DNs (default: 100, maximum: 1000)"
+// @Success 200 {object} SearchPreview
+// @Failure 400 {string} string "Invalid parameters"
+// @Failure 502 {string} string "Source could not be read"
+// @Router /search/preview [post]
+func previewSearchHandler(c echo.Context) error {
+	filter := strings.TrimSpace(c.FormValue("filter"))
+	if filter == "" {
+		return c.String(http.StatusBadRequest, "Missing required parameter (filter)")
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid filter parameter: "+err.Error())
+	}
+	baseDN := c.FormValue("baseDN")
+	if baseDN == "" {
+		baseDN = config.Source.BaseDN
+	}
+	if _, err := ldap.ParseDN(baseDN); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid baseDN parameter: "+err.Error())
+	}
+	scope, deref, _, timeLimit, err := searchOptionsFromForm(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	limit := 100
+	if s := c.FormValue("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxPreviewSize {
+			return c.String(http.StatusBadRequest, "Invalid limit parameter")
+		}
+	}
+
+	// One entry more than the limit tells whether more match.
+	spec := &SearchSpec{
+		Filter:            filter,
+		BaseDN:            baseDN,
+		Attributes:        parseAttributeList(c.FormValue("attributes")),
+		ExcludeAttributes: parseAttributeList(c.FormValue("exclude_attributes")),
+		Scope:             scope,
+		Deref:             deref,
+		SizeLimit:         limit + 1,
+		TimeLimit:         timeLimit,
+	}
+	l, err := connectAndBindLDAP()
+	if err != nil {
+		return c.String(http.StatusBadGateway, "Failed to connect to the source: "+err.Error())
+	}
+	defer l.Close()
+	sr, err := l.Search(sourceSearchRequest("", spec))
+	if err != nil && (sr == nil || limitExceeded(err) == "") {
+		return c.String(http.StatusBadGateway, "Search failed: "+err.Error())
+	}
+
+	out := SearchPreview{Filter: filter, BaseDN: baseDN, DNs: []string{}}
+	entries := sr.Entries
+	if len(entries) > limit {
+		entries, out.Truncated = entries[:limit], true
+	} else if err != nil {
+		// The server stopped at its own size or time limit.
+		out.Truncated = true
+	}
+	for _, entry := range entries {
+		out.DNs = append(out.DNs, entry.DN)
+	}
+	out.Count = len(out.DNs)
+	if len(entries) > 0 {
+		sample := entries[0]
+		if err := completeRangedAttributes(l, sample); err != nil {
+			return c.String(http.StatusBadGateway, "Search failed: "+err.Error())
+		}
+		out.Sample = &ResultEntryFull{DN: sample.DN, Content: resultContent("", sample, spec)}
+	}
+	return c.JSON(http.StatusOK, out)
+}
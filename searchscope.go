@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// A search reads the whole subtree under its base DN without dereferencing
+// aliases or limits unless it sets scope (base, one or sub), deref (never,
+// search, find or always), sizeLimit (entries) or timeLimit (seconds). The
+// limits are enforced by the source server; a run that reaches one keeps the
+// entries returned up to then.
+
+var (
+	searchScopes = map[string]int{
+		"base": ldap.ScopeBaseObject,
+		"one":  ldap.ScopeSingleLevel,
+		"sub":  ldap.ScopeWholeSubtree,
+	}
+	derefPolicies = map[string]int{
+		"never":  ldap.NeverDerefAliases,
+		"search": ldap.DerefInSearching,
+		"find":   ldap.DerefFindingBaseObj,
+		"always": ldap.DerefAlways,
+	}
+)
+
+var mSearchLimits = describeMetric("ldapsync_search_limit_exceeded_total", "counter",
+	"Source search runs cut short by their size or time limit, by search and limit (size or time).")
+
+// validateSearchOptions checks the scope, deref and limits of a search.
+func validateSearchOptions(scope, deref string, sizeLimit, timeLimit int) error {
+	if _, ok := searchScopes[scope]; scope != "" && !ok {
+		return fmt.Errorf("invalid scope %q; expected base, one or sub", scope)
+	}
+	if _, ok := derefPolicies[deref]; deref != "" && !ok {
+		return fmt.Errorf("invalid deref %q; expected never, search, find or always", deref)
+	}
+	if sizeLimit < 0 || timeLimit < 0 {
+		return fmt.Errorf("sizeLimit and timeLimit must not be negative")
+	}
+	return nil
+}
+
+// searchOptionsFromForm reads the scope, deref, sizeLimit and timeLimit
+// parameters of a search request.
+func searchOptionsFromForm(c echo.Context) (scope, deref string, sizeLimit, timeLimit int, err error) {
+	scope = strings.ToLower(strings.TrimSpace(c.FormValue("scope")))
+	deref = strings.ToLower(strings.TrimSpace(c.FormValue("deref")))
+	if s := c.FormValue("sizeLimit"); s != "" {
+		if sizeLimit, err = strconv.Atoi(s); err != nil {
+			return "", "", 0, 0, fmt.Errorf("Invalid sizeLimit parameter")
+		}
+	}
+	if s := c.FormValue("timeLimit"); s != "" {
+		if timeLimit, err = strconv.Atoi(s); err != nil {
+			return "", "", 0, 0, fmt.Errorf("Invalid timeLimit parameter")
+		}
+	}
+	if err = validateSearchOptions(scope, deref, sizeLimit, timeLimit); err != nil {
+		return "", "", 0, 0, fmt.Errorf("Invalid search options: %w", err)
+	}
+	return scope, deref, sizeLimit, timeLimit, nil
+}
+
+// sourceSearchRequest builds the request a search sends to the source.
+func sourceSearchRequest(id string, spec *SearchSpec) *ldap.SearchRequest {
+	scope, ok := searchScopes[spec.Scope]
+	if !ok {
+		scope = ldap.ScopeWholeSubtree
+	}
+	deref, ok := derefPolicies[spec.Deref]
+	if !ok {
+		deref = ldap.NeverDerefAliases
+	}
+	return ldap.NewSearchRequest(spec.BaseDN, scope, deref, spec.SizeLimit, spec.TimeLimit, false,
+		spec.Filter, requestedAttributes(id, spec), nil)
+}
+
+// inSearchScope reports whether dn falls within the scope of a search
+// rooted at base.
+func inSearchScope(base, dn *ldap.DN, scope string) bool {
+	switch scope {
+	case "base":
+		return base.EqualFold(dn)
+	case "one":
+		if len(dn.RDNs) != len(base.RDNs)+1 {
+			return false
+		}
+		return base.AncestorOfFold(dn)
+	default:
+		return base.EqualFold(dn) || base.AncestorOfFold(dn)
+	}
+}
+
+// limitExceeded returns the limit ("size" or "time") a search stopped at
+// when err reports one, or "".
+func limitExceeded(err error) string {
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) {
+		return ""
+	}
+	switch ldapErr.ResultCode {
+	case ldap.LDAPResultSizeLimitExceeded:
+		return "size"
+	case ldap.LDAPResultTimeLimitExceeded:
+		return "time"
+	}
+	return ""
+}
+
+// searchLimitExceeded reports whether err is a search stopping at its size
+// or time limit, and counts it.
+func searchLimitExceeded(id string, err error) bool {
+	limit := limitExceeded(err)
+	if limit == "" {
+		return false
+	}
+	incCounter(mSearchLimits, "search", id, "limit", limit)
+	syncLogger.Warn("Search stopped at its limit; later entries were not read", "SearchId", id, "Err", err)
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Full searches stream their entries: each entry is processed as it
+// arrives instead of after the whole result set has been read, so a large
+// search does not hold every raw entry in memory at once, and a search
+// stopped while it runs abandons the rest of its result. The channel
+// between the connection and the processing is bounded, so a slow hook
+// pipeline slows reading instead of buffering the directory.
+
+// SearchStreamConfig tunes how full searches read the source.
+type SearchStreamConfig struct {
+	// BufferSize is the number of entries read ahead of processing
+	// (default: 64).
+	BufferSize int `yaml:"buffer_size"`
+	// PageSize requests the result in pages of this many entries with the
+	// paged results control, for servers that cap unpaged results (such as
+	// Active Directory's MaxPageSize). 0 does not page.
+	PageSize uint32 `yaml:"page_size"`
+}
+
+// errSearchStopped is returned when a search is stopped while it streams.
+var errSearchStopped = errors.New("search stopped")
+
+// streamLDAPSearch runs a search and calls process for each entry as it
+// arrives. It returns errSearchStopped if stop is closed first, and
+// the search's error if it fails part way; entries processed before then
+// stay processed. Search references are handled by followReferrals.
+func streamLDAPSearch(l *ldap.Conn, req *ldap.SearchRequest, stop <-chan struct{}, process func(*ldap.Entry)) error {
+	return streamSearch(l, req, 0, make(map[string]struct{}), stop, process)
+}
+
+// streamSearch is streamLDAPSearch on a server reached through depth
+// referrals; seen holds the referrals followed so far.
+func streamSearch(l *ldap.Conn, req *ldap.SearchRequest, depth int, seen map[string]struct{}, stop <-chan struct{}, process func(*ldap.Entry)) error {
+	bufferSize := config.SearchStream.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 64
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var paging *ldap.ControlPaging
+	if size := config.SearchStream.PageSize; size > 0 {
+		paging = ldap.NewControlPaging(size)
+	}
+	var refs []string
+	for {
+		if paging != nil {
+			req.Controls = []ldap.Control{paging}
+		}
+		resp := l.SearchAsync(ctx, req, bufferSize)
+		var cookie []byte
+		for resp.Next() {
+			if ctx.Err() != nil {
+				// Unblock the reader so it sees the cancellation.
+				for resp.Next() {
+				}
+				return errSearchStopped
+			}
+			if entry := resp.Entry(); entry != nil {
+				process(entry)
+				continue
+			}
+			if ref := resp.Referral(); ref != "" {
+				refs = append(refs, ref)
+				continue
+			}
+			if c, ok := ldap.FindControl(resp.Controls(), ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+				cookie = c.Cookie
+			}
+		}
+		if ctx.Err() != nil {
+			return errSearchStopped
+		}
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if paging == nil || len(cookie) == 0 {
+			break
+		}
+		paging.SetCookie(cookie)
+	}
+	// The connection is free again once the stream is done.
+	return followReferrals(refs, req, depth, seen, stop, process)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Bind passwords may come from a file (bind_password_file), typically a
+// mounted Kubernetes secret. The file is re-read when a bind fails with
+// invalid credentials and, with bind_password_refresh_s, periodically, so a
+// rotated secret is picked up without a restart.
+
+var mBindPasswordReloads = describeMetric("ldapsync_bind_password_reloads_total", "counter",
+	"Bind password files re-read with a changed password, by server (source, target).")
+
+type cachedSecret struct {
+	value  string
+	readAt time.Time
+}
+
+var bindSecrets = struct {
+	sync.Mutex
+	byPath map[string]cachedSecret
+}{byPath: make(map[string]cachedSecret)}
+
+// initBindPasswords reads the configured password files so a missing one
+// fails at startup.
+func initBindPasswords() error {
+	for name, cfg := range map[string]LDAPConfig{"source": config.Source, "target": config.Target} {
+		if cfg.BindPasswordFile == "" {
+			continue
+		}
+		if cfg.BindPassword != "" {
+			return fmt.Errorf("%s: bind_password and bind_password_file are mutually exclusive", name)
+		}
+		if _, err := readBindPassword(cfg.BindPasswordFile); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func readBindPassword(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bind password file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	bindSecrets.Lock()
+	old, known := bindSecrets.byPath[path]
+	bindSecrets.byPath[path] = cachedSecret{value: value, readAt: time.Now()}
+	bindSecrets.Unlock()
+	if known && old.value != value {
+		logger.Info("Bind password file changed", "Path", path)
+		incCounter(mBindPasswordReloads, "server", bindServerName(path))
+	}
+	return value, nil
+}
+
+// bindServerName names the configured server using the password file.
+func bindServerName(path string) string {
+	switch path {
+	case config.Source.BindPasswordFile:
+		return "source"
+	case config.Target.BindPasswordFile:
+		return "target"
+	}
+	return "other"
+}
+
+// bindPassword returns the password to bind with, from the file when one
+// is configured, re-reading it once the refresh interval has passed.
+func bindPassword(cfg LDAPConfig) (string, error) {
+	if cfg.BindPasswordFile == "" {
+		return cfg.BindPassword, nil
+	}
+	bindSecrets.Lock()
+	cached, ok := bindSecrets.byPath[cfg.BindPasswordFile]
+	bindSecrets.Unlock()
+	refresh := time.Duration(cfg.BindPasswordRefreshSec) * time.Second
+	if ok && (refresh <= 0 || time.Since(cached.readAt) < refresh) {
+		return cached.value, nil
+	}
+	value, err := readBindPassword(cfg.BindPasswordFile)
+	if err != nil && ok {
+		// Keep binding with the last password while the file is unreadable,
+		// e.g. during a secret update.
+		logger.Warn("Could not re-read bind password file; using the previous password", "Path", cfg.BindPasswordFile, "Err", err)
+		return cached.value, nil
+	}
+	return value, err
+}
+
+// bindWithRotation binds l, re-reading the password file and retrying once
+// if the server rejects the credentials.
+func bindWithRotation(l *ldap.Conn, cfg LDAPConfig) error {
+	password, err := bindPassword(cfg)
+	if err != nil {
+		return err
+	}
+	err = l.Bind(cfg.BindDN, password)
+	if err == nil || cfg.BindPasswordFile == "" || !isInvalidCredentials(err) {
+		return err
+	}
+	fresh, readErr := readBindPassword(cfg.BindPasswordFile)
+	if readErr != nil || fresh == password {
+		return err
+	}
+	logger.Info("Retrying bind with the rotated password", "URL", cfg.URL)
+	return l.Bind(cfg.BindDN, fresh)
+}
+
+func isInvalidCredentials(err error) bool {
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// With database.driver "sqlite" the state is kept in a local file instead
+// of Postgres, for single-node deployments. The statements are written for
+// Postgres; the sqliteDialect driver wraps the SQLite driver and rewrites
+// them: $n placeholders become ?n, NOW() the current UTC time, and time
+// arguments UTC text in the same format, so stored times compare in order.
+// The schema is created on startup from db/schema.sqlite.sql.
+//
+// The SQLite driver (modernc.org/sqlite, pure Go) is only linked into
+// binaries built with the sqlite build tag; see sqlite_driver.go.
+
+const (
+	dbDriverPostgres = "postgres"
+	dbDriverSQLite   = "sqlite"
+
+	sqliteDialectDriver = "ldapsync-sqlite"
+	sqliteTimeFormat    = "2006-01-02 15:04:05.000000"
+)
+
+//go:embed db/schema.sqlite.sql
+var sqliteSchema string
+
+// sqliteMigrations add columns to tables created by an older schema. SQLite
+// has no ADD COLUMN IF NOT EXISTS, so duplicate column errors are ignored.
+var sqliteMigrations = []string{
+	`ALTER TABLE bindings ADD COLUMN list_value TEXT`,
+	`ALTER TABLE bindings ADD COLUMN expires_at TIMESTAMP`,
+	`ALTER TABLE bindings ADD COLUMN search_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE bindings ADD COLUMN source_dn TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE bindings ADD COLUMN hook TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE dn_mappings ADD COLUMN source_dn TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE searches ADD COLUMN scope TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE searches ADD COLUMN deref TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE searches ADD COLUMN size_limit INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE searches ADD COLUMN time_limit INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE searches ADD COLUMN run_once BOOLEAN`,
+	`ALTER TABLE searches ADD COLUMN suppress_hooks BOOLEAN`,
+	`ALTER TABLE searches ADD COLUMN completed_at TIMESTAMP`,
+	`ALTER TABLE searches ADD COLUMN result_count INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE searches ADD COLUMN parent TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE searches ADD COLUMN parent_dn TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE searches ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE searches ADD COLUMN expires_at TIMESTAMP`,
+	`ALTER TABLE searches ADD COLUMN idle_expiry_s INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE searches ADD COLUMN last_change_at TIMESTAMP`,
+}
+
+var (
+	sqlitePlaceholder = regexp.MustCompile(`\$(\d+)`)
+	sqliteNow         = strings.NewReplacer("NOW()", `strftime('%Y-%m-%d %H:%M:%f000', 'now')`)
+)
+
+// usingSQLite reports whether the database is a SQLite file.
+func usingSQLite() bool {
+	return config.Database.Driver == dbDriverSQLite
+}
+
+func validateDatabaseDriver() error {
+	switch config.Database.Driver {
+	case "", dbDriverPostgres:
+		return nil
+	case dbDriverSQLite:
+		if config.Database.Enabled && config.Database.Path == "" {
+			return fmt.Errorf("database: driver sqlite requires path")
+		}
+		return nil
+	}
+	return fmt.Errorf("database: unknown driver %q (want postgres or sqlite)", config.Database.Driver)
+}
+
+// openSQLite opens the database file and creates the schema.
+func openSQLite(path string) (*sql.DB, error) {
+	if !slices.Contains(sql.Drivers(), dbDriverSQLite) {
+		return nil, fmt.Errorf("this binary was built without SQLite support; rebuild it with -tags sqlite")
+	}
+	if !slices.Contains(sql.Drivers(), sqliteDialectDriver) {
+		raw, err := sql.Open(dbDriverSQLite, ":memory:")
+		if err != nil {
+			return nil, err
+		}
+		sql.Register(sqliteDialectDriver, sqliteDialect{raw.Driver()})
+		raw.Close()
+	}
+	// WAL lets the API read while searches write, and the busy timeout
+	// makes concurrent writers wait for each other.
+	sdb, err := sql.Open(sqliteDialectDriver, "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range strings.Split(sqliteSchema, ";\n") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := sdb.Exec(stmt); err != nil {
+			sdb.Close()
+			return nil, fmt.Errorf("failed to create SQLite schema: %w", err)
+		}
+	}
+	for _, stmt := range sqliteMigrations {
+		if _, err := sdb.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			sdb.Close()
+			return nil, fmt.Errorf("failed to migrate SQLite schema: %w", err)
+		}
+	}
+	return sdb, nil
+}
+
+// sqliteDialect wraps the SQLite driver. Like faultInjectingPQ its
+// connections expose only the basic driver.Conn methods, so every
+// statement goes through Prepare, where it is rewritten.
+type sqliteDialect struct{ driver.Driver }
+
+type sqliteConn struct{ driver.Conn }
+
+type sqliteStmt struct{ driver.Stmt }
+
+func (d sqliteDialect) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteConn{c}, nil
+}
+
+func (c sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	if injectFault(faultDB) {
+		return nil, fmt.Errorf("%w: database statement failed", errInjectedFault)
+	}
+	query = sqlitePlaceholder.ReplaceAllString(sqliteNow.Replace(query), "?$1")
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteStmt{s}, nil
+}
+
+func (s sqliteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.Stmt.Exec(sqliteArgs(args))
+}
+
+func (s sqliteStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.Stmt.Query(sqliteArgs(args))
+}
+
+func sqliteArgs(args []driver.Value) []driver.Value {
+	for i, a := range args {
+		if t, ok := a.(time.Time); ok {
+			args[i] = t.UTC().Format(sqliteTimeFormat)
+		}
+	}
+	return args
+}
+
+// sqlNotInList returns a condition that column is not one of the values
+// passed as argument n, and the argument: a text array on Postgres, a
+// JSON array on SQLite.
+func sqlNotInList(column string, n int, values []string) (string, interface{}) {
+	if usingSQLite() {
+		data, _ := json.Marshal(values)
+		return fmt.Sprintf("%s NOT IN (SELECT value FROM json_each($%d))", column, n), string(data)
+	}
+	return fmt.Sprintf("%s <> ALL($%d::text[])", column, n), "{" + quoteArrayElements(values) + "}"
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Links the pure-Go SQLite driver for database.driver: sqlite. Build with
+// -tags sqlite.
+import _ "modernc.org/sqlite"
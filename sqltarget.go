@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A SQL target upserts transformed entries into tables, so applications can
+// read the synchronized identities relationally. Each table holds the
+// entries of one objectClass (or all entries), keyed by the normalized DN,
+// with the DN, the content as JSON and mapped attributes in columns of their
+// own. The tables are not created by ldap-sync; the key column must be the
+// primary key or have a unique constraint. Without a dsn the service's own
+// database is used.
+
+// SQLTargetConfig configures a SQL target.
+type SQLTargetConfig struct {
+	// DSN is a Postgres connection URL; DSNFile is read for it instead.
+	DSN     string           `yaml:"dsn"`
+	DSNFile string           `yaml:"dsn_file"`
+	Tables  []SQLTableConfig `yaml:"tables"`
+}
+
+// SQLTableConfig maps entries to one table.
+type SQLTableConfig struct {
+	Table string `yaml:"table"`
+	// ObjectClass selects the entries written to the table (default: all).
+	ObjectClass string `yaml:"object_class"`
+	KeyColumn   string `yaml:"key_column"` // Normalized DN (default: dn_key)
+	DNColumn    string `yaml:"dn_column"`  // default: dn
+	// ContentColumn receives the content as JSON (JSONB on Postgres).
+	// Defaults to content when no columns are mapped.
+	ContentColumn string `yaml:"content_column"`
+	// Columns maps LDAP attributes to columns, which receive the first
+	// value.
+	Columns map[string]string `yaml:"columns"`
+}
+
+// Table names may be schema-qualified; column names may not.
+var (
+	sqlTableName  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	sqlColumnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type sqlTable struct {
+	objectClass string
+	attrs       []string // Attribute of each mapped column, in column order
+	hasContent  bool
+	upsert      string
+}
+
+type sqlTarget struct {
+	name   string
+	conn   *sql.DB // nil: the service database
+	tables []sqlTable
+}
+
+func newSQLTarget(t TargetConfig) (TargetBackend, error) {
+	cfg := t.SQL
+	if len(cfg.Tables) == 0 {
+		return nil, fmt.Errorf("sql.tables is required")
+	}
+	target := &sqlTarget{name: t.Name}
+	for i, tc := range cfg.Tables {
+		table, err := buildSQLTable(tc)
+		if err != nil {
+			return nil, fmt.Errorf("sql.tables[%d]: %w", i, err)
+		}
+		target.tables = append(target.tables, table)
+	}
+	if cfg.DSN != "" && cfg.DSNFile != "" {
+		return nil, fmt.Errorf("sql: dsn and dsn_file are mutually exclusive")
+	}
+	dsn := cfg.DSN
+	if cfg.DSNFile != "" {
+		data, err := os.ReadFile(cfg.DSNFile)
+		if err != nil {
+			return nil, fmt.Errorf("sql.dsn_file: %w", err)
+		}
+		dsn = strings.TrimSpace(string(data))
+	}
+	if dsn == "" {
+		if !config.Database.Enabled {
+			return nil, fmt.Errorf("sql: dsn is required unless the database is enabled")
+		}
+		return target, nil
+	}
+	conn, err := sql.Open(dbDriverName(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql: %w", err)
+	}
+	target.conn = conn
+	return target, nil
+}
+
+// buildSQLTable validates a table mapping and prepares its upsert.
+func buildSQLTable(tc SQLTableConfig) (sqlTable, error) {
+	key, dn, content := tc.KeyColumn, tc.DNColumn, tc.ContentColumn
+	if key == "" {
+		key = "dn_key"
+	}
+	if dn == "" {
+		dn = "dn"
+	}
+	if content == "" && len(tc.Columns) == 0 {
+		content = "content"
+	}
+	columns := []string{key, dn}
+	if content != "" {
+		columns = append(columns, content)
+	}
+	table := sqlTable{objectClass: tc.ObjectClass, hasContent: content != ""}
+	mapped := make([]string, 0, len(tc.Columns))
+	for attr := range tc.Columns {
+		mapped = append(mapped, attr)
+	}
+	sort.Strings(mapped)
+	for _, attr := range mapped {
+		columns = append(columns, tc.Columns[attr])
+		table.attrs = append(table.attrs, attr)
+	}
+	if !sqlTableName.MatchString(tc.Table) {
+		return sqlTable{}, fmt.Errorf("invalid table name %q", tc.Table)
+	}
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		if !sqlColumnName.MatchString(c) {
+			return sqlTable{}, fmt.Errorf("invalid column name %q", c)
+		}
+	}
+	placeholders := make([]string, len(columns))
+	updates := make([]string, 0, len(columns)-1)
+	for i, c := range columns {
+		if seen[strings.ToLower(c)] {
+			return sqlTable{}, fmt.Errorf("column %s is used twice", c)
+		}
+		seen[strings.ToLower(c)] = true
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		if i > 0 {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", c, c))
+		}
+	}
+	table.upsert = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		tc.Table, strings.Join(columns, ", "), strings.Join(placeholders, ", "), key, strings.Join(updates, ", "))
+	return table, nil
+}
+
+func (s *sqlTarget) Name() string { return s.name }
+
+func (s *sqlTarget) Store(entry *TransformedEntry) error {
+	conn := s.conn
+	if conn == nil {
+		conn = db
+	}
+	if conn == nil {
+		return fmt.Errorf("database is not connected")
+	}
+	for _, t := range s.tables {
+		if t.objectClass != "" && !contentHasValue(entry.Content, "objectClass", t.objectClass) {
+			continue
+		}
+		args := []interface{}{normalizeDN(entry.DN), entry.DN}
+		if t.hasContent {
+			data, err := json.Marshal(entry.Content)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errTargetRejected, err)
+			}
+			args = append(args, string(data))
+		}
+		for _, attr := range t.attrs {
+			if v := firstValue(entry.Content, attr); v != "" {
+				args = append(args, v)
+			} else {
+				args = append(args, nil)
+			}
+		}
+		if _, err := conn.Exec(t.upsert, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestBuildSQLTable(t *testing.T) {
+	tests := []struct {
+		name       string
+		table      SQLTableConfig
+		wantUpsert string
+		wantErr    bool
+	}{
+		{
+			name:       "defaults",
+			table:      SQLTableConfig{Table: "identities"},
+			wantUpsert: "INSERT INTO identities (dn_key, dn, content) VALUES ($1, $2, $3) ON CONFLICT (dn_key) DO UPDATE SET dn = EXCLUDED.dn, content = EXCLUDED.content",
+		},
+		{
+			name:       "schema-qualified table and mapped columns",
+			table:      SQLTableConfig{Table: "iam.users", KeyColumn: "id", Columns: map[string]string{"uid": "login", "mail": "email"}},
+			wantUpsert: "INSERT INTO iam.users (id, dn, email, login) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO UPDATE SET dn = EXCLUDED.dn, email = EXCLUDED.email, login = EXCLUDED.login",
+		},
+		{name: "missing table", table: SQLTableConfig{}, wantErr: true},
+		{name: "injected table", table: SQLTableConfig{Table: "users; DROP TABLE users"}, wantErr: true},
+		{name: "quoted table", table: SQLTableConfig{Table: `"users"`}, wantErr: true},
+		{name: "three-part table", table: SQLTableConfig{Table: "db.iam.users"}, wantErr: true},
+		{name: "table starting with a digit", table: SQLTableConfig{Table: "1users"}, wantErr: true},
+		{name: "invalid key column", table: SQLTableConfig{Table: "users", KeyColumn: "dn key"}, wantErr: true},
+		{name: "qualified column", table: SQLTableConfig{Table: "users", DNColumn: "users.dn"}, wantErr: true},
+		{name: "injected mapped column", table: SQLTableConfig{Table: "users", Columns: map[string]string{"uid": "login) VALUES (1"}}, wantErr: true},
+		{name: "column used twice", table: SQLTableConfig{Table: "users", Columns: map[string]string{"uid": "DN"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildSQLTable(tt.table)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildSQLTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.upsert != tt.wantUpsert {
+				t.Errorf("upsert = %q, want %q", got.upsert, tt.wantUpsert)
+			}
+		})
+	}
+}
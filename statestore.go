@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/helxplatform/ldap-sync/hooksdk"
+)
+
+// Bindings and pending dependency entries are persisted when the database is
+// enabled, so entries deferred before a restart are still written after it.
+// Search results are persisted when database.persist_results is set, so a
+// restart does not re-send every entry to the hooks.
+
+// persistBindings stores a batch of binding updates, with the expiry and
+// owner in metas, in one transaction. A nil value is stored as SQL NULL (a
+// null binding); lists are stored as JSON in list_value instead of value.
+func persistBindings(updates map[string]*BindingValue, metas map[string]bindingMeta) error {
+	if db == nil || len(updates) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+	INSERT INTO bindings (key, value, list_value, expires_at, search_id, source_dn, hook, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+	ON CONFLICT (key) DO UPDATE SET value = $2, list_value = $3, expires_at = $4,
+		search_id = $5, source_dn = $6, hook = $7, updated_at = NOW();`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for k, v := range updates {
+		var value, list sql.NullString
+		switch {
+		case v == nil:
+		case v.IsList():
+			list = sql.NullString{String: v.String(), Valid: true}
+		default:
+			value = sql.NullString{String: v.String(), Valid: true}
+		}
+		m := metas[k]
+		var expires interface{}
+		if !m.expires.IsZero() {
+			expires = m.expires
+		}
+		if _, err := stmt.Exec(k, value, list, expires, m.search, m.source, m.hook); err != nil {
+			return fmt.Errorf("binding %q: %w", k, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func deletePersistedBinding(keys ...string) error {
+	if db == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if _, err := db.Exec(`DELETE FROM bindings WHERE key = $1`, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadBindingsFromDB restores bindings and null bindings with their expiry
+// and owner.
+func loadBindingsFromDB() error {
+	rows, err := db.Query(`SELECT key, value, list_value, expires_at, search_id, source_dn, hook FROM bindings`)
+	if err != nil {
+		return fmt.Errorf("failed to query bindings: %w", err)
+	}
+	defer rows.Close()
+	bindingsMu.Lock()
+	defer bindingsMu.Unlock()
+	for rows.Next() {
+		var key string
+		var value, list, search, source, hook sql.NullString
+		var expires sql.NullTime
+		if err := rows.Scan(&key, &value, &list, &expires, &search, &source, &hook); err != nil {
+			return fmt.Errorf("failed to scan binding: %w", err)
+		}
+		m := bindingMeta{expires: expires.Time, search: search.String, source: source.String, hook: hook.String}
+		if m != (bindingMeta{}) {
+			bindingMetas[key] = m
+		}
+		switch {
+		case list.Valid:
+			var values []string
+			if err := json.Unmarshal([]byte(list.String), &values); err != nil {
+				return fmt.Errorf("binding %q: %w", key, err)
+			}
+			bindings[key] = hooksdk.ListValue(values...)
+			delete(nullBindings, key)
+		case value.Valid:
+			bindings[key] = hooksdk.StringValue(value.String)
+			delete(nullBindings, key)
+		default:
+			nullBindings[key] = struct{}{}
+			delete(bindings, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	logger.Info("Loaded bindings from database", "Count", len(bindings), "NullCount", len(nullBindings))
+	return nil
+}
+
+// persistPending stores an entry parked by the dependency tracker.
+func persistPending(key string, entry *TransformedEntry, rawDeps []string, since time.Time) {
+	if db == nil {
+		return
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("Failed to encode pending entry", "DN", entry.DN, "Err", err)
+		return
+	}
+	depsJSON, err := json.Marshal(rawDeps)
+	if err != nil {
+		logger.Error("Failed to encode pending dependencies", "DN", entry.DN, "Err", err)
+		return
+	}
+	const upsertSQL = `
+	INSERT INTO pending_entries (dn_key, entry, raw_deps, waiting_since) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (dn_key) DO UPDATE SET entry = $2, raw_deps = $3, waiting_since = $4;`
+	if _, err := db.Exec(upsertSQL, key, string(entryJSON), string(depsJSON), since); err != nil {
+		logger.Error("Failed to persist pending entry", "DN", entry.DN, "Err", err)
+	}
+}
+
+// unpersistPending removes an entry that has left the dependency tracker.
+func unpersistPending(key string) {
+	if db == nil {
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM pending_entries WHERE dn_key = $1`, key); err != nil {
+		logger.Error("Failed to delete persisted pending entry", "Key", key, "Err", err)
+	}
+}
+
+// restorePendingFromDB feeds persisted pending entries back through the
+// dependency tracker, keeping their original waiting time. Write groups are
+// not persisted; restored members are handled individually.
+func restorePendingFromDB() error {
+	rows, err := db.Query(`SELECT dn_key, entry, raw_deps, waiting_since FROM pending_entries`)
+	if err != nil {
+		return fmt.Errorf("failed to query pending entries: %w", err)
+	}
+	type restored struct {
+		entry   TransformedEntry
+		rawDeps []string
+	}
+	var entries []restored
+	for rows.Next() {
+		var key string
+		var entryJSON, depsJSON []byte
+		var since time.Time
+		if err := rows.Scan(&key, &entryJSON, &depsJSON, &since); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pending entry: %w", err)
+		}
+		var r restored
+		if err := json.Unmarshal(entryJSON, &r.entry); err != nil {
+			logger.Error("Skipping undecodable pending entry", "Key", key, "Err", err)
+			continue
+		}
+		if err := json.Unmarshal(depsJSON, &r.rawDeps); err != nil {
+			logger.Error("Skipping undecodable pending entry", "Key", key, "Err", err)
+			continue
+		}
+		dependencyTracker.mu.Lock()
+		dependencyTracker.waitingSince[key] = since
+		dependencyTracker.mu.Unlock()
+		entries = append(entries, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	logger.Info("Restoring pending entries from database", "Count", len(entries))
+	for i := range entries {
+		dependencyTracker.handleEntry(&entries[i].entry, entries[i].rawDeps, nil)
+	}
+	return nil
+}
+
+// Search result persistence modes (database.persist_results).
+const (
+	persistResultsHash    = "hash"
+	persistResultsContent = "content"
+)
+
+func validateResultPersistence() error {
+	switch config.Database.PersistResults {
+	case "", persistResultsHash, persistResultsContent:
+		return nil
+	}
+	return fmt.Errorf("database: invalid persist_results %q (want hash or content)", config.Database.PersistResults)
+}
+
+func persistResultsEnabled() bool {
+	return db != nil && config.Database.PersistResults != ""
+}
+
+// resultHash hashes the content of a search result. JSON encoding sorts the
+// attribute names, so the hash only changes when the content does.
+func resultHash(content map[string]interface{}) string {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sameResultContent reports whether content matches a stored result. Results
+// restored in hash mode, and all results with result_cache: hash, carry only
+// the hash of their content.
+func sameResultContent(existing LDAPResult, content map[string]interface{}) bool {
+	if existing.Content == nil && existing.hash != "" {
+		if strings.HasPrefix(existing.hash, canonicalHashPrefix) {
+			return existing.hash == canonicalResultHash(content)
+		}
+		return existing.hash == resultHash(content)
+	}
+	return reflect.DeepEqual(existing.Content, content)
+}
+
+// persistResult stores the last-seen content (or its hash) of a result.
+func persistResult(id, key string, result LDAPResult) {
+	if !persistResultsEnabled() {
+		return
+	}
+	var content sql.NullString
+	if config.Database.PersistResults == persistResultsContent {
+		data, err := json.Marshal(result.Content)
+		if err != nil {
+			logger.Error("Failed to encode search result", "SearchId", id, "DN", result.DN, "Err", err)
+			return
+		}
+		content = sql.NullString{String: string(data), Valid: true}
+	}
+	const upsertSQL = `
+	INSERT INTO search_results (search_id, dn_key, dn, content_hash, content, updated_at)
+	VALUES ($1, $2, $3, $4, $5, NOW())
+	ON CONFLICT (search_id, dn_key) DO UPDATE
+	SET dn = $3, content_hash = $4, content = $5, updated_at = NOW();`
+	hash := resultHash(result.Content)
+	if result.Content == nil && result.hash != "" {
+		hash = result.hash
+	}
+	if _, err := db.Exec(upsertSQL, id, key, result.DN, hash, content); err != nil {
+		logger.Error("Failed to persist search result", "SearchId", id, "DN", result.DN, "Err", err)
+	}
+}
+
+// unpersistResults removes persisted results of a search: one entry when
+// key is set, otherwise all of them. An empty id removes every search's
+// results.
+func unpersistResults(id, key string) {
+	if !persistResultsEnabled() {
+		return
+	}
+	var err error
+	switch {
+	case id == "":
+		_, err = db.Exec(`DELETE FROM search_results`)
+	case key == "":
+		_, err = db.Exec(`DELETE FROM search_results WHERE search_id = $1`, id)
+	default:
+		_, err = db.Exec(`DELETE FROM search_results WHERE search_id = $1 AND dn_key = $2`, id, key)
+	}
+	if err != nil {
+		logger.Error("Failed to delete persisted search results", "SearchId", id, "Err", err)
+	}
+}
+
+// loadResultsFromDB restores the persisted results of every search, keyed by
+// search id. Content is restored when it was stored; otherwise only the hash
+// is, which is enough for change detection.
+func loadResultsFromDB() (map[string]map[string]LDAPResult, error) {
+	loaded := make(map[string]map[string]LDAPResult)
+	if !persistResultsEnabled() {
+		return loaded, nil
+	}
+	rows, err := db.Query(`SELECT search_id, dn_key, dn, content_hash, content FROM search_results`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query search results: %w", err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var id, key, dn, hash string
+		var content sql.NullString
+		if err := rows.Scan(&id, &key, &dn, &hash, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		result := LDAPResult{DN: dn, hash: hash}
+		if key != normalizeDN(dn) {
+			result.correlation = key
+		}
+		if content.Valid {
+			if err := json.Unmarshal([]byte(content.String), &result.Content); err != nil {
+				logger.Error("Restoring search result hash only", "SearchId", id, "DN", dn, "Err", err)
+				result.Content = nil
+			} else {
+				restoreResultValues(result.Content)
+			}
+		}
+		if loaded[id] == nil {
+			loaded[id] = make(map[string]LDAPResult)
+		}
+		loaded[id][key] = cachedResult(result)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	logger.Info("Loaded search results from database", "Count", count)
+	return loaded, nil
+}
+
+// restoreResultValues turns decoded multi-valued attributes back into
+// []string, the type processLDAPEntry produces.
+func restoreResultValues(content map[string]interface{}) {
+	for attr, v := range content {
+		list, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		vals := make([]string, len(list))
+		for i, x := range list {
+			vals[i] = fmt.Sprintf("%v", x)
+		}
+		content[attr] = vals
+	}
+}
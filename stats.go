@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Every search run (a full search or a changelog pass) is summarized: the
+// entries it saw and how many were new, updated, unchanged or deleted, the
+// hook calls made for the search while it ran and how many failed, its
+// duration and error. The last runs of each search are kept in a ring
+// buffer, and optionally in the search_runs table, for GET /search/:id/stats.
+
+// SearchStatsConfig configures the run history of searches.
+type SearchStatsConfig struct {
+	// History is the number of runs kept per search (default: 50).
+	History int `yaml:"history"`
+	// Persist also stores every run in the search_runs table, so the
+	// history survives restarts and is served by standbys.
+	Persist bool `yaml:"persist"`
+}
+
+// SearchRunStats summarizes one run of a search. HookCalls and HookErrors
+// count the hook deliveries for the search that completed during the run;
+// deliveries of batched or slow hooks may fall into the next run.
+type SearchRunStats struct {
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"duration_ms"`
+	Mode       string    `json:"mode"` // full or changelog
+	Seen       int64     `json:"seen"`
+	New        int64     `json:"new"`
+	Updated    int64     `json:"updated"`
+	Unchanged  int64     `json:"unchanged"`
+	Deleted    int64     `json:"deleted"`
+	HookCalls  int64     `json:"hook_calls"`
+	HookErrors int64     `json:"hook_errors"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// SearchStats is the response of GET /search/:id/stats. The totals count
+// the runs since this replica started; History is newest first.
+type SearchStats struct {
+	ID                  string           `json:"id"`
+	Runs                int64            `json:"runs"`
+	FailedRuns          int64            `json:"failed_runs"`
+	ConsecutiveFailures int64            `json:"consecutive_failures"`
+	LastSuccess         *time.Time       `json:"last_success,omitempty"`
+	History             []SearchRunStats `json:"history"`
+}
+
+const (
+	runModeFull      = "full"
+	runModeChangelog = "changelog"
+)
+
+// searchCounters are incremented as a search's entries and hook calls are
+// processed; a run's stats are the difference over the run.
+type searchCounters struct {
+	seen, added, updated, unchanged, deleted, hookCalls, hookErrors atomic.Int64
+}
+
+// searchHistory holds a search's totals and a ring buffer of its runs.
+type searchHistory struct {
+	runs                []SearchRunStats
+	next                int
+	total, failed, fail int64
+	lastSuccess         time.Time
+}
+
+var (
+	searchCountersByID sync.Map // search id -> *searchCounters
+	searchHistories    = struct {
+		sync.Mutex
+		byID map[string]*searchHistory
+	}{byID: make(map[string]*searchHistory)}
+)
+
+func countersFor(id string) *searchCounters {
+	if c, ok := searchCountersByID.Load(id); ok {
+		return c.(*searchCounters)
+	}
+	c, _ := searchCountersByID.LoadOrStore(id, &searchCounters{})
+	return c.(*searchCounters)
+}
+
+func (c *searchCounters) snapshot() SearchRunStats {
+	return SearchRunStats{
+		Seen:       c.seen.Load(),
+		New:        c.added.Load(),
+		Updated:    c.updated.Load(),
+		Unchanged:  c.unchanged.Load(),
+		Deleted:    c.deleted.Load(),
+		HookCalls:  c.hookCalls.Load(),
+		HookErrors: c.hookErrors.Load(),
+	}
+}
+
+// countHookCall counts a hook delivery for a search.
+func countHookCall(id string, err error) {
+	c := countersFor(id)
+	c.hookCalls.Add(1)
+	if err != nil {
+		c.hookErrors.Add(1)
+	}
+}
+
+func searchHistorySize() int {
+	if n := config.SearchStats.History; n > 0 {
+		return n
+	}
+	return 50
+}
+
+// searchRun measures one run of a search.
+type searchRun struct {
+	id    string
+	mode  string
+	start SearchRunStats
+}
+
+func startSearchRun(id, mode string) *searchRun {
+	start := countersFor(id).snapshot()
+	start.Started = time.Now()
+	return &searchRun{id: id, mode: mode, start: start}
+}
+
+// finish records the run; err is why it failed, if it did.
+func (r *searchRun) finish(err error) {
+	end := countersFor(r.id).snapshot()
+	stats := SearchRunStats{
+		Started:    r.start.Started,
+		DurationMs: time.Since(r.start.Started).Milliseconds(),
+		Mode:       r.mode,
+		Seen:       end.Seen - r.start.Seen,
+		New:        end.New - r.start.New,
+		Updated:    end.Updated - r.start.Updated,
+		Unchanged:  end.Unchanged - r.start.Unchanged,
+		Deleted:    end.Deleted - r.start.Deleted,
+		HookCalls:  end.HookCalls - r.start.HookCalls,
+		HookErrors: end.HookErrors - r.start.HookErrors,
+	}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+
+	searchHistories.Lock()
+	h := searchHistories.byID[r.id]
+	if h == nil {
+		h = &searchHistory{}
+		searchHistories.byID[r.id] = h
+	}
+	if size := searchHistorySize(); len(h.runs) < size {
+		h.runs = append(h.runs, stats)
+		h.next = len(h.runs) % size
+	} else {
+		h.runs[h.next] = stats
+		h.next = (h.next + 1) % len(h.runs)
+	}
+	h.total++
+	if err != nil {
+		h.failed++
+		h.fail++
+	} else {
+		h.fail = 0
+		h.lastSuccess = time.Now()
+	}
+	searchHistories.Unlock()
+
+	if db != nil && config.SearchStats.Persist {
+		go persistSearchRun(r.id, stats)
+	}
+}
+
+// forgetSearchStats drops the counters and history of a removed search.
+func forgetSearchStats(id string) {
+	searchCountersByID.Delete(id)
+	searchHistories.Lock()
+	delete(searchHistories.byID, id)
+	searchHistories.Unlock()
+	if db != nil && config.SearchStats.Persist {
+		if _, err := db.Exec(`DELETE FROM search_runs WHERE search_id = $1`, id); err != nil {
+			logger.Error("Failed to delete search run history", "SearchId", id, "Err", err)
+		}
+	}
+}
+
+func persistSearchRun(id string, s SearchRunStats) {
+	const insertSQL = `
+	INSERT INTO search_runs (search_id, started_at, duration_ms, mode, seen, new, updated,
+		unchanged, deleted, hook_calls, hook_errors, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	if _, err := db.Exec(insertSQL, id, s.Started, s.DurationMs, s.Mode, s.Seen, s.New, s.Updated,
+		s.Unchanged, s.Deleted, s.HookCalls, s.HookErrors, s.Error); err != nil {
+		logger.Error("Failed to persist search run", "SearchId", id, "Err", err)
+	}
+}
+
+// recentSearchRuns returns up to limit runs of a search, newest first.
+func recentSearchRuns(id string, limit int) ([]SearchRunStats, error) {
+	if db != nil && config.SearchStats.Persist {
+		return loadSearchRuns(id, limit)
+	}
+	searchHistories.Lock()
+	defer searchHistories.Unlock()
+	runs := []SearchRunStats{}
+	h := searchHistories.byID[id]
+	if h == nil {
+		return runs, nil
+	}
+	for i := 1; i <= len(h.runs) && len(runs) < limit; i++ {
+		runs = append(runs, h.runs[(h.next-i+len(h.runs))%len(h.runs)])
+	}
+	return runs, nil
+}
+
+func loadSearchRuns(id string, limit int) ([]SearchRunStats, error) {
+	rows, err := db.Query(`
+	SELECT started_at, duration_ms, mode, seen, new, updated, unchanged, deleted,
+		hook_calls, hook_errors, error
+	FROM search_runs WHERE search_id = $1 ORDER BY started_at DESC LIMIT $2`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []SearchRunStats{}
+	for rows.Next() {
+		var s SearchRunStats
+		var errText sql.NullString
+		if err := rows.Scan(&s.Started, &s.DurationMs, &s.Mode, &s.Seen, &s.New, &s.Updated,
+			&s.Unchanged, &s.Deleted, &s.HookCalls, &s.HookErrors, &errText); err != nil {
+			return nil, err
+		}
+		s.Error = errText.String
+		runs = append(runs, s)
+	}
+	return runs, rows.Err()
+}
+
+// searchStatsHandler godoc
+// @Summary Get search run statistics
+// @Description Returns the run totals of a search since this replica started and its recent runs, newest first: entries seen, new, updated, unchanged and deleted, hook calls and errors, duration and error of each.
+// @Tags search
+// @Produce json
+// @Param id path string true "Search ID"
+// @Param limit query int false "Number of runs to return (default: search_stats.history)"
+// @Success 200 {object} SearchStats
+// @Failure 404 {string} string "Search not found"
+// @Router /search/{id}/stats [get]
+func searchStatsHandler(c echo.Context) error {
+	id := c.Param("id")
+	searchesMu.RLock()
+	_, exists := searches[id]
+	searchesMu.RUnlock()
+	if !exists {
+		return c.String(http.StatusNotFound, "Search not found")
+	}
+	limit := searchHistorySize()
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return c.String(http.StatusBadRequest, "Invalid limit parameter")
+		}
+		limit = n
+	}
+	history, err := recentSearchRuns(id, limit)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to load search runs: "+err.Error())
+	}
+	out := SearchStats{ID: id, History: history}
+	searchHistories.Lock()
+	if h := searchHistories.byID[id]; h != nil {
+		out.Runs, out.FailedRuns, out.ConsecutiveFailures = h.total, h.failed, h.fail
+		if !h.lastSuccess.IsZero() {
+			t := h.lastSuccess
+			out.LastSuccess = &t
+		}
+	}
+	searchHistories.Unlock()
+	return c.JSON(http.StatusOK, out)
+}
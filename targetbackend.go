@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Transformed entries are written to the target LDAP server and to any
+// further targets configured under targets, such as a SCIM identity
+// provider or database tables. A write succeeds once every target accepted it; a failure at
+// any target fails the entry, which is then retried or dead-lettered as a
+// whole, so targets must tolerate the same entry being written again.
+
+// TargetBackend is a destination for transformed entries.
+type TargetBackend interface {
+	// Name identifies the target in logs and metrics.
+	Name() string
+	// Store creates or updates the entry. Entries the target does not
+	// hold (for example neither users nor groups) are ignored.
+	Store(entry *TransformedEntry) error
+}
+
+// TargetConfig is an additional target.
+type TargetConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // scim or sql
+	// Searches limits the target to entries produced by these searches
+	// (default: all).
+	Searches []string        `yaml:"searches"`
+	SCIM     SCIMConfig      `yaml:"scim"`
+	SQL      SQLTargetConfig `yaml:"sql"`
+}
+
+// errTargetRejected marks writes a target refused for reasons a retry
+// cannot fix.
+var errTargetRejected = errors.New("rejected by target")
+
+var mTargetBackendWrites = describeMetric("ldapsync_target_backend_writes_total", "counter",
+	"Writes to additional targets, by target and result (success, failure).")
+
+// ldapTarget is the target LDAP server of the target config section,
+// always written first.
+type ldapTarget struct{}
+
+var primaryTarget TargetBackend = ldapTarget{}
+
+func (ldapTarget) Name() string                        { return "ldap" }
+func (ldapTarget) Store(entry *TransformedEntry) error { return storeDestinationLDAP(entry) }
+
+// targetBackendTypes builds the additional targets by type.
+var targetBackendTypes = map[string]func(TargetConfig) (TargetBackend, error){
+	"scim": newSCIMTarget,
+	"sql":  newSQLTarget,
+}
+
+type configuredTarget struct {
+	backend  TargetBackend
+	searches []string
+}
+
+var extraTargets []configuredTarget
+
+func initTargets() error {
+	extraTargets = nil
+	seen := map[string]bool{"ldap": true}
+	for i, t := range config.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("targets[%d]: name is required", i)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("targets[%d]: duplicate name %q", i, t.Name)
+		}
+		seen[t.Name] = true
+		build, ok := targetBackendTypes[t.Type]
+		if !ok {
+			return fmt.Errorf("targets %s: unknown type %q (want scim or sql)", t.Name, t.Type)
+		}
+		backend, err := build(t)
+		if err != nil {
+			return fmt.Errorf("targets %s: %w", t.Name, err)
+		}
+		extraTargets = append(extraTargets, configuredTarget{backend: backend, searches: t.Searches})
+	}
+	return nil
+}
+
+// storeEntry writes an entry to the target LDAP server, then to the
+// additional targets it is meant for.
+func storeEntry(entry *TransformedEntry) error {
+	if err := primaryTarget.Store(entry); err != nil {
+		return err
+	}
+	if len(extraTargets) == 0 || isDryRun(entry) || isDeniedWrite("write", entry.DN) {
+		return nil
+	}
+	var errs []error
+	for _, t := range extraTargets {
+		if len(t.searches) > 0 && !slices.Contains(t.searches, entry.Search) {
+			continue
+		}
+		if err := t.backend.Store(entry); err != nil {
+			incCounter(mTargetBackendWrites, "target", t.backend.Name(), "result", "failure")
+			errs = append(errs, fmt.Errorf("target %s: %w", t.backend.Name(), err))
+			continue
+		}
+		incCounter(mTargetBackendWrites, "target", t.backend.Name(), "result", "success")
+	}
+	return errors.Join(errs...)
+}
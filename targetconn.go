@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// PipelineConfig enables pipelining of target writes: independent add/modify
+// requests share one bound connection and are issued without waiting for
+// earlier responses. Only enable it for servers that process concurrent
+// operations on a connection (RFC 4511 permits it; most servers do).
+type PipelineConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxInFlight int  `yaml:"max_in_flight"` // Outstanding requests on the connection (default: 16)
+}
+
+// targetPipeline owns the shared connection used when pipelining is enabled.
+type targetPipeline struct {
+	mu   sync.Mutex
+	conn *ldap.Conn
+	slot chan struct{}
+	once sync.Once
+}
+
+var pipeline = &targetPipeline{}
+
+func (p *targetPipeline) slots() chan struct{} {
+	p.once.Do(func() {
+		n := config.TargetPipeline.MaxInFlight
+		if n <= 0 {
+			n = 16
+		}
+		p.slot = make(chan struct{}, n)
+	})
+	return p.slot
+}
+
+// shared returns the pipelined connection, dialling and binding a new one if
+// the previous connection was closed.
+func (p *targetPipeline) shared() (*ldap.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil && !p.conn.IsClosing() {
+		return p.conn, nil
+	}
+	l, err := dialTarget()
+	if err != nil {
+		return nil, err
+	}
+	p.conn = l
+	logger.Debug("Opened pipelined target connection")
+	return l, nil
+}
+
+// invalidate drops the shared connection after a network error so the next
+// request reconnects. Requests still in flight on it fail and are retried by
+// their callers.
+func (p *targetPipeline) invalidate(l *ldap.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == l {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func dialTarget() (*ldap.Conn, error) {
+	l, err := dialLDAP(config.Target)
+	recordHealth(readyTarget, err)
+	return l, err
+}
+
+func isNetworkError(err error) bool {
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.ErrorNetwork
+}
+
+// acquireTargetConn returns a bound target connection and a release function
+// that must be called with the outcome of the work done on it. Without
+// pipelining every caller gets its own connection.
+func acquireTargetConn() (*ldap.Conn, func(error), error) {
+	if err := injectedLDAPError(config.Target.URL); err != nil {
+		return nil, nil, err
+	}
+	if !config.TargetPipeline.Enabled {
+		slots := targetConnSlots()
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		l, err := dialTarget()
+		if err != nil {
+			if slots != nil {
+				<-slots
+			}
+			return nil, nil, err
+		}
+		return l, func(error) {
+			l.Close()
+			if slots != nil {
+				<-slots
+			}
+		}, nil
+	}
+	slots := pipeline.slots()
+	slots <- struct{}{}
+	l, err := pipeline.shared()
+	if err != nil {
+		<-slots
+		return nil, nil, err
+	}
+	return l, func(opErr error) {
+		if isNetworkError(opErr) {
+			pipeline.invalidate(l)
+		}
+		<-slots
+	}, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// TargetMirrorConfig tunes the read-only target lookup offered to hooks by
+// GET /target/entry.
+type TargetMirrorConfig struct {
+	CacheTTLSec int `yaml:"cache_ttl_s"` // How long lookups are cached (default: 30; negative disables caching)
+	CacheSize   int `yaml:"cache_size"`  // Cached lookups kept (default: 10000)
+}
+
+// TargetEntry is a target entry as returned by GET /target/entry. Binary
+// values are base64-encoded and password values read [redacted] unless
+// password_sync.show_in_results is set.
+type TargetEntry struct {
+	DN         string              `json:"dn"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+var (
+	mTargetMirrorLookups = describeMetric("ldapsync_target_mirror_lookups_total", "counter",
+		"Target entry lookups served to hooks, by cache result.")
+)
+
+type mirrorItem struct {
+	entry   *TargetEntry // nil when the entry does not exist
+	expires time.Time
+}
+
+// targetMirrorCache caches lookups by normalized DN and requested attribute
+// set. Writes by ldap-sync invalidate every lookup of the written DN.
+type targetMirrorCache struct {
+	mu    sync.Mutex
+	items map[string]map[string]mirrorItem
+	count int
+}
+
+var targetMirror = &targetMirrorCache{items: make(map[string]map[string]mirrorItem)}
+
+func targetMirrorTTL() time.Duration {
+	switch ttl := config.TargetMirror.CacheTTLSec; {
+	case ttl < 0:
+		return 0
+	case ttl == 0:
+		return 30 * time.Second
+	default:
+		return time.Duration(ttl) * time.Second
+	}
+}
+
+func (c *targetMirrorCache) get(dnKey, attrsKey string) (mirrorItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[dnKey][attrsKey]
+	if !ok || time.Now().After(item.expires) {
+		return mirrorItem{}, false
+	}
+	return item, true
+}
+
+func (c *targetMirrorCache) put(dnKey, attrsKey string, entry *TargetEntry) {
+	ttl := targetMirrorTTL()
+	if ttl == 0 {
+		return
+	}
+	limit := config.TargetMirror.CacheSize
+	if limit <= 0 {
+		limit = 10000
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.count >= limit {
+		// Drop expired lookups; if none were, start over.
+		now := time.Now()
+		for dn, byAttrs := range c.items {
+			for k, item := range byAttrs {
+				if now.After(item.expires) {
+					delete(byAttrs, k)
+					c.count--
+				}
+			}
+			if len(byAttrs) == 0 {
+				delete(c.items, dn)
+			}
+		}
+		if c.count >= limit {
+			c.items = make(map[string]map[string]mirrorItem)
+			c.count = 0
+		}
+	}
+	if c.items[dnKey] == nil {
+		c.items[dnKey] = make(map[string]mirrorItem)
+	}
+	if _, exists := c.items[dnKey][attrsKey]; !exists {
+		c.count++
+	}
+	c.items[dnKey][attrsKey] = mirrorItem{entry: entry, expires: time.Now().Add(ttl)}
+}
+
+// invalidate forgets every cached lookup of dn.
+func (c *targetMirrorCache) invalidate(dn string) {
+	key := normalizeDN(dn)
+	c.mu.Lock()
+	c.count -= len(c.items[key])
+	delete(c.items, key)
+	c.mu.Unlock()
+}
+
+// lookupTargetEntry reads a target entry over the syncer's target
+// connections; it returns nil if the entry does not exist.
+func lookupTargetEntry(dn string, attrs []string) (entry *TargetEntry, err error) {
+	l, release, err := acquireTargetConn()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { release(err) }()
+	req := make(map[string][]string, len(attrs))
+	for _, a := range attrs {
+		req[a] = nil
+	}
+	found, err := readTargetAttributes(l, dn, req)
+	if err != nil || found == nil {
+		return nil, err
+	}
+	return targetEntryFrom(found), nil
+}
+
+func targetEntryFrom(e *ldap.Entry) *TargetEntry {
+	out := &TargetEntry{DN: e.DN, Attributes: make(map[string][]string, len(e.Attributes))}
+	for _, a := range e.Attributes {
+		if isBinaryAttr(a.Name) {
+			out.Attributes[a.Name] = encodeBinaryValues(a.ByteValues)
+		} else if config.PasswordSync.ShowInResults {
+			out.Attributes[a.Name] = a.Values
+		} else {
+			out.Attributes[a.Name] = redactedValues(a.Name, a.Values)
+		}
+	}
+	return out
+}
+
+// getTargetEntryHandler godoc
+// @Summary Look up a target entry
+// @Description Read-only, cached lookup of an entry in the target directory over the syncer's connections, so hooks need no target credentials. Lookups are cached for target_mirror.cache_ttl_s and invalidated when ldap-sync writes the entry.
+// @Tags target
+// @Produce json
+// @Param dn query string true "Target DN"
+// @Param attributes query string false "Comma-separated attributes to return; defaults to all user attributes"
+// @Success 200 {object} TargetEntry
+// @Failure 400 {string} string "Missing or invalid dn"
+// @Failure 404 {string} string "Entry not found"
+// @Failure 502 {string} string "Target lookup failed"
+// @Router /target/entry [get]
+func getTargetEntryHandler(c echo.Context) error {
+	dn := strings.TrimSpace(c.QueryParam("dn"))
+	if dn == "" {
+		return c.String(http.StatusBadRequest, "Missing required parameter: dn")
+	}
+	if _, err := ldap.ParseDN(dn); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid dn: "+err.Error())
+	}
+	attrs := parseAttributeList(c.QueryParam("attributes"))
+	sorted := make([]string, len(attrs))
+	for i, a := range attrs {
+		sorted[i] = strings.ToLower(a)
+	}
+	sort.Strings(sorted)
+	dnKey, attrsKey := normalizeDN(dn), strings.Join(sorted, ",")
+
+	item, cached := targetMirror.get(dnKey, attrsKey)
+	if cached {
+		incCounter(mTargetMirrorLookups, "cache", "hit")
+	} else {
+		incCounter(mTargetMirrorLookups, "cache", "miss")
+		entry, err := lookupTargetEntry(dn, attrs)
+		if err != nil {
+			logger.Error("Target lookup failed", "DN", dn, "Err", err)
+			return c.String(http.StatusBadGateway, "Target lookup failed")
+		}
+		targetMirror.put(dnKey, attrsKey, entry)
+		item.entry = entry
+	}
+	if item.entry == nil {
+		return c.String(http.StatusNotFound, "Entry not found")
+	}
+	return c.JSON(http.StatusOK, item.entry)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// PendingWrite is an entry whose target write failed and is waiting for a
+// quick retry before it would be dead-lettered.
+type PendingWrite struct {
+	ID          uint64    `json:"id"`
+	DN          string    `json:"dn"`
+	Search      string    `json:"search,omitempty"`
+	Attempts    int       `json:"attempts"` // Failed writes so far
+	Error       string    `json:"error"`
+	FirstFailed time.Time `json:"firstFailed"`
+	NextRetry   time.Time `json:"nextRetry"`
+}
+
+type pendingWrite struct {
+	PendingWrite
+	entry TransformedEntry
+	timer *time.Timer
+}
+
+// targetRetryQueue holds at most one pending write per target DN: a newer
+// failed write of the same DN replaces the queued content but keeps its
+// attempt count, and any successful write of the DN cancels the retry.
+type targetRetryQueue struct {
+	mu   sync.Mutex
+	seq  uint64
+	byDN map[string]*pendingWrite
+}
+
+var targetRetries = &targetRetryQueue{byDN: make(map[string]*pendingWrite)}
+
+var (
+	mTargetWriteRetries = describeMetric("ldapsync_target_write_retries_total", "counter",
+		"Retried target writes, by result (success, failure, exhausted).")
+	mTargetWritesRetrying = describeMetric("ldapsync_target_writes_retrying", "gauge",
+		"Target writes currently waiting for a retry.")
+)
+
+// targetRetrySettings returns the target_retry settings; unset values fall
+// back to the hook_retry settings.
+func targetRetrySettings() (int, time.Duration, time.Duration) {
+	maxRetries, initialDelay, maxDelay := hookRetrySettings()
+	r := config.TargetRetry
+	if r.MaxRetries != 0 {
+		maxRetries = r.MaxRetries
+	}
+	if r.InitialDelayMs != 0 {
+		initialDelay = time.Duration(r.InitialDelayMs) * time.Millisecond
+	}
+	if r.MaxDelayMs != 0 {
+		maxDelay = time.Duration(r.MaxDelayMs) * time.Millisecond
+	}
+	return maxRetries, initialDelay, maxDelay
+}
+
+// permanentWriteErrors are LDAP result codes a retry cannot fix.
+var permanentWriteErrors = map[uint16]bool{
+	ldap.LDAPResultUndefinedAttributeType:   true,
+	ldap.LDAPResultConstraintViolation:      true,
+	ldap.LDAPResultInvalidAttributeSyntax:   true,
+	ldap.LDAPResultInvalidDNSyntax:          true,
+	ldap.LDAPResultNamingViolation:          true,
+	ldap.LDAPResultObjectClassViolation:     true,
+	ldap.LDAPResultInsufficientAccessRights: true,
+}
+
+// retryableWriteError reports whether a failed write is worth retrying
+// before it is dead-lettered.
+func retryableWriteError(err error) bool {
+	if errors.Is(err, errPolicyViolation) || errors.Is(err, errSchemaViolation) ||
+		errors.Is(err, errTargetRejected) {
+		return false
+	}
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) && permanentWriteErrors[ldapErr.ResultCode] {
+		return false
+	}
+	return true
+}
+
+// schedule queues a failed write for a retry with exponential backoff. Once
+// the retries are exhausted, or for errors a retry cannot fix, the entry is
+// dead-lettered instead.
+func (q *targetRetryQueue) schedule(entry *TransformedEntry, err error) {
+	if !retryableWriteError(err) {
+		deadLetters.add([]TransformedEntry{*entry}, err)
+		return
+	}
+	maxRetries, initialDelay, maxDelay := targetRetrySettings()
+	key := normalizeDN(entry.DN)
+	now := time.Now()
+
+	q.mu.Lock()
+	item := &pendingWrite{entry: *entry}
+	item.DN, item.Search, item.Error, item.FirstFailed = entry.DN, entry.Search, err.Error(), now
+	if prev, ok := q.byDN[key]; ok {
+		prev.timer.Stop()
+		item.ID, item.Attempts, item.FirstFailed = prev.ID, prev.Attempts, prev.FirstFailed
+	} else {
+		q.seq++
+		item.ID = q.seq
+	}
+	item.Attempts++
+	if item.Attempts > maxRetries {
+		delete(q.byDN, key)
+		setGauge(mTargetWritesRetrying, float64(len(q.byDN)))
+		q.mu.Unlock()
+		incCounter(mTargetWriteRetries, "result", "exhausted")
+		logger.Error("Target write failed after retries; dead-lettering", "DN", entry.DN, "Attempts", item.Attempts, "Err", err)
+		deadLetters.add([]TransformedEntry{*entry}, err)
+		return
+	}
+	delay := initialDelay << (item.Attempts - 1)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	item.NextRetry = now.Add(delay)
+	item.timer = time.AfterFunc(delay, func() { q.fire(key, item) })
+	q.byDN[key] = item
+	setGauge(mTargetWritesRetrying, float64(len(q.byDN)))
+	q.mu.Unlock()
+	logger.Warn("Target write failed; will retry", "DN", entry.DN, "Attempt", item.Attempts, "NextRetry", item.NextRetry, "Err", err)
+}
+
+// fire retries a pending write unless it was replaced or cancelled.
+func (q *targetRetryQueue) fire(key string, item *pendingWrite) {
+	q.mu.Lock()
+	current := q.byDN[key] == item
+	q.mu.Unlock()
+	if !current {
+		return
+	}
+	entry := item.entry
+	if err := storeEntry(&entry); err != nil {
+		incCounter(mTargetWriteRetries, "result", "failure")
+		q.schedule(&entry, err)
+		return
+	}
+	incCounter(mTargetWriteRetries, "result", "success")
+	logger.Info("Target write retry succeeded", "DN", entry.DN, "Attempts", item.Attempts)
+	dependencyTracker.markSyncedAndRelease(entry.DN)
+}
+
+// supersede cancels the pending retry of dn after a successful write.
+func (q *targetRetryQueue) supersede(dn string) {
+	key := normalizeDN(dn)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.byDN[key]
+	if !ok {
+		return
+	}
+	item.timer.Stop()
+	delete(q.byDN, key)
+	setGauge(mTargetWritesRetrying, float64(len(q.byDN)))
+}
+
+func (q *targetRetryQueue) list() []PendingWrite {
+	q.mu.Lock()
+	out := make([]PendingWrite, 0, len(q.byDN))
+	for _, item := range q.byDN {
+		out = append(out, item.PendingWrite)
+	}
+	q.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].NextRetry.Before(out[j].NextRetry) })
+	return out
+}
+
+// getTargetRetriesHandler godoc
+// @Summary List target writes waiting for retry
+// @Description Lists entries whose target write failed and is scheduled for a retry with exponential backoff (target_retry), soonest first. Entries whose retries are exhausted move to the dead-letter queue.
+// @Tags deadletters
+// @Produce json
+// @Success 200 {array} PendingWrite
+// @Router /retries [get]
+func getTargetRetriesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, targetRetries.list())
+}
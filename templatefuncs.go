@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Besides $key, templates can apply functions to bindings with ${...}:
+//
+//	${lower(key)} ${upper(key)} ${trim(key)}
+//	${default(key, "fallback")}    the first argument that has a value
+//	${concat(a, "-", b)}           the arguments joined together
+//	${join(key, ",")}              the values of a list in one string
+//	${regexReplace(key, "pat", "repl")}
+//
+// Arguments are binding keys (with or without the leading $), double-quoted
+// strings with \" and \\ escapes, or nested calls; ${key} alone is the same
+// as $key. Functions apply to each value of a list binding. An argument that
+// is not yet bound leaves the template waiting, except in default, which
+// moves on to the next argument instead. A malformed expression is kept as
+// literal text.
+
+// templateExpr is a parsed template expression: a binding reference, a
+// string literal or a function call.
+type templateExpr struct {
+	key     string
+	literal string
+	isLit   bool
+	fn      string
+	args    []*templateExpr
+	re      *regexp.Regexp // Compiled pattern of regexReplace
+}
+
+// templateRef is a reference found in a template, input[start:end].
+type templateRef struct {
+	start, end int
+	expr       *templateExpr
+}
+
+// templateFuncArity is the number of arguments of each function; -1 means
+// one or more.
+var templateFuncArity = map[string]int{
+	"lower":        1,
+	"upper":        1,
+	"trim":         1,
+	"default":      -1,
+	"concat":       -1,
+	"join":         2,
+	"regexReplace": 3,
+}
+
+func isBindingKeyChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+// templateRefs returns the $key and ${...} references in input, in order.
+func templateRefs(input string) []templateRef {
+	if !strings.Contains(input, "$") {
+		return nil
+	}
+	var refs []templateRef
+	for i := 0; i < len(input); i++ {
+		if input[i] != '$' || i+1 == len(input) {
+			continue
+		}
+		if input[i+1] == '{' {
+			p := &templateParser{s: input, pos: i + 2}
+			expr, err := p.expr()
+			if err == nil {
+				p.skipSpace()
+				if p.pos < len(input) && input[p.pos] == '}' {
+					refs = append(refs, templateRef{start: i, end: p.pos + 1, expr: expr})
+					i = p.pos
+				}
+			}
+			continue
+		}
+		end := i + 1
+		for end < len(input) && isBindingKeyChar(input[end]) {
+			end++
+		}
+		if end > i+1 {
+			refs = append(refs, templateRef{start: i, end: end, expr: &templateExpr{key: input[i+1 : end]}})
+			i = end - 1
+		}
+	}
+	return refs
+}
+
+// replaceTemplateRefs replaces every reference in input with repl.
+func replaceTemplateRefs(input, repl string) string {
+	refs := templateRefs(input)
+	if len(refs) == 0 {
+		return input
+	}
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		b.WriteString(input[last:ref.start])
+		b.WriteString(repl)
+		last = ref.end
+	}
+	b.WriteString(input[last:])
+	return b.String()
+}
+
+type templateParser struct {
+	s   string
+	pos int
+}
+
+func (p *templateParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *templateParser) expr() (*templateExpr, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return nil, fmt.Errorf("unexpected end of template")
+	}
+	if p.s[p.pos] == '"' {
+		return p.stringLiteral()
+	}
+	if p.s[p.pos] == '$' {
+		p.pos++
+	}
+	start := p.pos
+	for p.pos < len(p.s) && isBindingKeyChar(p.s[p.pos]) {
+		p.pos++
+	}
+	name := p.s[start:p.pos]
+	if name == "" {
+		return nil, fmt.Errorf("expected a binding key, string or function at %d", p.pos)
+	}
+	p.skipSpace()
+	if p.pos == len(p.s) || p.s[p.pos] != '(' {
+		return &templateExpr{key: name}, nil
+	}
+	arity, ok := templateFuncArity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.pos++
+	call := &templateExpr{fn: name}
+	for {
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == ')' && len(call.args) == 0 {
+			break
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos == len(p.s) || p.s[p.pos] != ')' {
+		return nil, fmt.Errorf("unterminated call of %s", name)
+	}
+	p.pos++
+	if (arity < 0 && len(call.args) == 0) || (arity >= 0 && len(call.args) != arity) {
+		return nil, fmt.Errorf("wrong number of arguments to %s", name)
+	}
+	if name == "regexReplace" {
+		pattern := call.args[1]
+		if !pattern.isLit {
+			return nil, fmt.Errorf("the pattern of regexReplace must be a string")
+		}
+		re, err := regexp.Compile(pattern.literal)
+		if err != nil {
+			return nil, err
+		}
+		call.re = re
+	}
+	return call, nil
+}
+
+func (p *templateParser) stringLiteral() (*templateExpr, error) {
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; c {
+		case '"':
+			p.pos++
+			return &templateExpr{literal: b.String(), isLit: true}, nil
+		case '\\':
+			if p.pos+1 < len(p.s) {
+				p.pos++
+				c = p.s[p.pos]
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// keys returns the binding keys an expression references.
+func (e *templateExpr) keys() []string {
+	if e.key != "" {
+		return []string{e.key}
+	}
+	var keys []string
+	for _, arg := range e.args {
+		keys = append(keys, arg.keys()...)
+	}
+	return keys
+}
+
+// eval evaluates an expression against the bindings. list reports whether
+// the values come from a list binding, null whether a null binding was
+// referenced, and missing lists the unbound keys it waits on.
+func (e *templateExpr) eval(bindings map[string]BindingValue, nullBindings map[string]struct{}) (values []string, list, null bool, missing []string) {
+	switch {
+	case e.isLit:
+		return []string{e.literal}, false, false, nil
+	case e.key != "":
+		if val, ok := bindings[e.key]; ok {
+			return val.Values(), val.IsList(), false, nil
+		}
+		if _, ok := nullBindings[e.key]; ok {
+			return nil, false, true, nil
+		}
+		return nil, false, false, []string{e.key}
+	case e.fn == "default":
+		for i, arg := range e.args {
+			values, list, null, missing = arg.eval(bindings, nullBindings)
+			if i == len(e.args)-1 || (len(missing) == 0 && !null && len(values) > 0) {
+				return values, list, null, missing
+			}
+		}
+	}
+
+	args := make([][]string, len(e.args))
+	for i, arg := range e.args {
+		vals, argList, argNull, argMissing := arg.eval(bindings, nullBindings)
+		args[i] = vals
+		list = list || argList
+		null = null || argNull
+		missing = append(missing, argMissing...)
+	}
+	if null || len(missing) > 0 {
+		return nil, list, null, missing
+	}
+	single := func(vals []string) string { return strings.Join(vals, "") }
+	switch e.fn {
+	case "lower":
+		values = mapValues(args[0], strings.ToLower)
+	case "upper":
+		values = mapValues(args[0], strings.ToUpper)
+	case "trim":
+		values = mapValues(args[0], strings.TrimSpace)
+	case "concat":
+		values = []string{""}
+		for _, vals := range args {
+			next := make([]string, 0, len(values)*len(vals))
+			for _, prefix := range values {
+				for _, v := range vals {
+					next = append(next, prefix+v)
+				}
+			}
+			values = next
+		}
+	case "join":
+		return []string{strings.Join(args[0], single(args[1]))}, false, false, nil
+	case "regexReplace":
+		repl := single(args[2])
+		values = mapValues(args[0], func(s string) string { return e.re.ReplaceAllString(s, repl) })
+	}
+	return values, list, false, nil
+}
+
+func mapValues(vals []string, f func(string) string) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = f(v)
+	}
+	return out
+}
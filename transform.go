@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+// TransformConfig describes an embedded transform that a search can use in
+// place of the external hooks.
+type TransformConfig struct {
+	Type   string `yaml:"type"`   // Engine type; only "starlark" is supported.
+	Script string `yaml:"script"` // Inline script source.
+	File   string `yaml:"file"`   // Path to a script file; used when Script is empty.
+}
+
+// starlarkTransform holds a compiled starlark script and its transform entrypoint.
+type starlarkTransform struct {
+	name string
+	fn   starlark.Callable
+}
+
+var transformEngines = make(map[string]*starlarkTransform)
+
+func transformExists(name string) bool {
+	_, ok := transformEngines[name]
+	return ok
+}
+
+// initTransforms compiles every transform declared in the config. Globals are
+// frozen after execution so a single compiled script can be shared by all searches.
+func initTransforms() error {
+	for name, tc := range config.Transforms {
+		engine := strings.ToLower(tc.Type)
+		if engine == "" {
+			engine = "starlark"
+		}
+		if engine != "starlark" {
+			return fmt.Errorf("transform %q: unsupported type %q", name, tc.Type)
+		}
+		src := tc.Script
+		filename := name + ".star"
+		if src == "" {
+			if tc.File == "" {
+				return fmt.Errorf("transform %q: either script or file is required", name)
+			}
+			data, err := os.ReadFile(tc.File)
+			if err != nil {
+				return fmt.Errorf("transform %q: failed to read script: %w", name, err)
+			}
+			src = string(data)
+			filename = tc.File
+		}
+		thread := newTransformThread(name)
+		predeclared := starlark.StringDict{"json": starlarkjson.Module, "dn": starlarkDNModule}
+		globals, err := starlark.ExecFile(thread, filename, src, predeclared)
+		if err != nil {
+			return fmt.Errorf("transform %q: %w", name, err)
+		}
+		globals.Freeze()
+		fn, ok := globals["transform"].(starlark.Callable)
+		if !ok {
+			return fmt.Errorf("transform %q: script must define a transform(entry) function", name)
+		}
+		transformEngines[name] = &starlarkTransform{name: name, fn: fn}
+		hookLogger.Info("Transform loaded", "Transform", name, "Type", engine)
+	}
+	return nil
+}
+
+func newTransformThread(name string) *starlark.Thread {
+	return &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			hookLogger.Debug("Transform output", "Transform", name, "Message", msg)
+		},
+	}
+}
+
+// run calls the script's transform function with the entry and returns the
+// hook-style responses it produced. A None result yields no responses.
+func (t *starlarkTransform) run(req HookRequest) ([]HookResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	thread := newTransformThread(t.name)
+	decode := starlarkjson.Module.Members["decode"]
+	entry, err := starlark.Call(thread, decode, starlark.Tuple{starlark.String(payload)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	out, err := starlark.Call(thread, t.fn, starlark.Tuple{entry}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if out == starlark.None {
+		return nil, nil
+	}
+	encode := starlarkjson.Module.Members["encode"]
+	encoded, err := starlark.Call(thread, encode, starlark.Tuple{out}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("transform result is not JSON-encodable: %w", err)
+	}
+	return decodeHookResponses([]byte(encoded.(starlark.String)))
+}
+
+// applyTransform runs an embedded transform in-process and feeds its output
+// through the same pipeline as an external hook response. Like a hook, the
+// transform sees password values as the placeholder unless hook_access is
+// set.
+func applyTransform(name, searchID string, source sourceRef, result LDAPResult) {
+	engine, ok := transformEngines[name]
+	if !ok {
+		hookLogger.Error("Unknown transform", "Transform", name, "DN", result.DN)
+		return
+	}
+	req := newHookRequest(searchID, result)
+	source.passwords = hidePasswords(&req)
+	responses, err := engine.run(req)
+	if err != nil {
+		hookLogger.Error("Transform failed", "Transform", name, "DN", result.DN, "Err", err)
+		return
+	}
+	for _, resp := range responses {
+		processHookResponse(resp, searchID, "transform:"+name, source)
+	}
+}
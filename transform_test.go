@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// echoPasswordScript passes userPassword through and records the value it
+// saw in description.
+const echoPasswordScript = `
+def transform(entry):
+    pw = entry["content"]["userPassword"]
+    print(pw)
+    return {"transformed": [{"dn": entry["dn"], "content": {"userPassword": pw, "description": "saw " + pw[0]}}]}
+`
+
+func TestApplyTransformHidesPasswords(t *testing.T) {
+	tests := []struct {
+		name       string
+		hookAccess bool
+		wantSeen   string
+	}{
+		{"placeholder", false, "saw " + passwordPlaceholder},
+		{"hook access", true, "saw {SSHA}hash"},
+	}
+	defer func(c Config) { config = c }(config)
+	defer func(d *dependencyState) { dependencyTracker = d }(dependencyTracker)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.PasswordSync = PasswordSyncConfig{Enabled: true, HookAccess: tt.hookAccess}
+			config.Transforms = map[string]TransformConfig{"echo": {Script: echoPasswordScript}}
+			if err := initTransforms(); err != nil {
+				t.Fatal(err)
+			}
+			target := useRecordingTarget(t)
+			dependencyTracker = newDependencyState()
+			result := LDAPResult{DN: "uid=a,dc=org", Content: map[string]interface{}{"userPassword": []string{"{SSHA}hash"}}}
+			applyTransform("echo", "people", sourceRef{DN: result.DN}, result)
+
+			if len(target.entries) != 1 {
+				t.Fatalf("written %q, want one entry", target.written())
+			}
+			content := target.entries[0].Content
+			if got := content["description"]; got != tt.wantSeen {
+				t.Errorf("transform saw %q, want %q", got, tt.wantSeen)
+			}
+			// The placeholder the transform returned is replaced by the
+			// source hashes.
+			if got := toStringSlice(content["userPassword"]); !reflect.DeepEqual(got, []string{"{SSHA}hash"}) {
+				t.Errorf("userPassword written as %q, want the source hash", got)
+			}
+		})
+	}
+}
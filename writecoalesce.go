@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Several hook responses often produce the same target entry, such as a
+// shared group emitted for every one of its members. With
+// write_coalescing.window_ms set, the first write to a DN is held for the
+// window; entries for the same DN arriving meanwhile are merged into it and
+// one write is issued when the window ends. A later entry from the same
+// source entry replaces the earlier one, so removals are not lost; entries
+// from different source entries are unioned, keeping the content of each
+// for remove-absent merging. The dependents of the entry are released after
+// that write.
+
+// WriteCoalescingConfig configures the coalescing of writes to one DN.
+type WriteCoalescingConfig struct {
+	// WindowMs is how long the first write to a DN waits for more (0: off).
+	WindowMs int `yaml:"window_ms"`
+}
+
+var mWritesCoalesced = describeMetric("ldapsync_target_writes_coalesced_total", "counter",
+	"Target writes merged into another write to the same DN.")
+
+// heldWrite is a write waiting for its coalescing window to end.
+type heldWrite struct {
+	entry    *TransformedEntry
+	deferred bool
+}
+
+var coalescedWrites = struct {
+	sync.Mutex
+	byDN map[string]*heldWrite
+}{byDN: make(map[string]*heldWrite)}
+
+// submitWrite writes a resolved entry and releases its dependents, after
+// merging it with the other writes to its DN in the coalescing window.
+func (d *dependencyState) submitWrite(entry *TransformedEntry, deferred bool) {
+	window := time.Duration(config.WriteCoalescing.WindowMs) * time.Millisecond
+	if window <= 0 {
+		d.enqueueWrite(entry, deferred)
+		return
+	}
+	key := normalizeDN(entry.DN)
+	coalescedWrites.Lock()
+	if held, ok := coalescedWrites.byDN[key]; ok {
+		held.entry = mergeHeldEntry(held.entry, entry)
+		held.deferred = held.deferred || deferred
+		coalescedWrites.Unlock()
+		incCounter(mWritesCoalesced)
+		depLogger.Debug("Coalesced target write", "DN", entry.DN)
+		return
+	}
+	coalescedWrites.byDN[key] = &heldWrite{entry: entry, deferred: deferred}
+	coalescedWrites.Unlock()
+	time.AfterFunc(window, func() {
+		coalescedWrites.Lock()
+		held := coalescedWrites.byDN[key]
+		delete(coalescedWrites.byDN, key)
+		coalescedWrites.Unlock()
+		if held != nil {
+			d.enqueueWrite(held.entry, held.deferred)
+		}
+	})
+}
+
+// mergeHeldEntry merges a later entry for the same DN into a held one. The
+// later content of a contributor replaces its earlier content, and the
+// entry is the union of its contributors' content. An entry merged from
+// several source entries is not attributed to any one of them.
+func mergeHeldEntry(held, incoming *TransformedEntry) *TransformedEntry {
+	contributions := make(map[string]map[string]interface{}, len(held.Contributions)+1)
+	for c, content := range held.Contributions {
+		contributions[c] = content
+	}
+	if len(contributions) == 0 {
+		contributions[managedContributor(held)] = held.Content
+	}
+	contributions[managedContributor(incoming)] = incoming.Content
+	if len(contributions) == 1 {
+		return incoming
+	}
+	merged := *incoming
+	merged.Content = nil
+	for _, c := range sortedContributors(contributions) {
+		merged.Content = mergeEntryContent(merged.Content, contributions[c])
+	}
+	merged.Contributions = contributions
+	merged.Origin = ""
+	if held.Source != incoming.Source || held.Correlation != incoming.Correlation {
+		merged.Source, merged.Correlation = "", ""
+	}
+	return &merged
+}
+
+func sortedContributors(contributions map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(contributions))
+	for c := range contributions {
+		keys = append(keys, c)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeHeldEntry(t *testing.T) {
+	const dn = "cn=staff,ou=groups,dc=org"
+	entry := func(origin string, members ...string) *TransformedEntry {
+		values := make([]interface{}, len(members))
+		for i, m := range members {
+			values[i] = m
+		}
+		return &TransformedEntry{DN: dn, Search: "groups", Origin: origin, Source: origin,
+			Content: map[string]interface{}{"member": values}}
+	}
+	tests := []struct {
+		name              string
+		writes            []*TransformedEntry
+		wantMembers       []string
+		wantContributions map[string][]string // Members by origin; nil when not coalesced
+	}{
+		{
+			name:        "same source entry replaces",
+			writes:      []*TransformedEntry{entry("uid=a,dc=org", "x", "y"), entry("uid=a,dc=org", "x")},
+			wantMembers: []string{"x"},
+		},
+		{
+			name:        "different source entries are unioned",
+			writes:      []*TransformedEntry{entry("uid=a,dc=org", "x"), entry("uid=b,dc=org", "y")},
+			wantMembers: []string{"x", "y"},
+			wantContributions: map[string][]string{
+				"uid=a,dc=org": {"x"},
+				"uid=b,dc=org": {"y"},
+			},
+		},
+		{
+			name: "removal by one of several source entries",
+			writes: []*TransformedEntry{
+				entry("uid=a,dc=org", "x", "z"), entry("uid=b,dc=org", "y"), entry("UID=A,DC=org", "x"),
+			},
+			wantMembers: []string{"x", "y"},
+			wantContributions: map[string][]string{
+				"uid=a,dc=org": {"x"},
+				"uid=b,dc=org": {"y"},
+			},
+		},
+		{
+			name: "value kept while another source entry produces it",
+			writes: []*TransformedEntry{
+				entry("uid=a,dc=org", "x", "z"), entry("uid=b,dc=org", "z"), entry("uid=a,dc=org", "x"),
+			},
+			wantMembers: []string{"x", "z"},
+			wantContributions: map[string][]string{
+				"uid=a,dc=org": {"x"},
+				"uid=b,dc=org": {"z"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			held := tt.writes[0]
+			for _, w := range tt.writes[1:] {
+				held = mergeHeldEntry(held, w)
+			}
+			if got := toStringSlice(held.Content["member"]); !reflect.DeepEqual(got, tt.wantMembers) {
+				t.Errorf("members = %q, want %q", got, tt.wantMembers)
+			}
+			var got map[string][]string
+			if held.Contributions != nil {
+				got = make(map[string][]string)
+				for c, content := range held.Contributions {
+					got[c[len("groups\x00"):]] = toStringSlice(content["member"])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantContributions) {
+				t.Errorf("contributions = %q, want %q", got, tt.wantContributions)
+			}
+			if held.Contributions != nil && (held.Origin != "" || held.Source != "") {
+				t.Errorf("coalesced entry attributed to %q (source %q)", held.Origin, held.Source)
+			}
+		})
+	}
+}
+
+func TestCoalescedRemoveAbsent(t *testing.T) {
+	const dn = "cn=staff,ou=groups,dc=org"
+	defer func(s map[string]string) { mergeStrategies = s }(mergeStrategies)
+	defer func(s *managedValueStore) { managedValues = s }(managedValues)
+	mergeStrategies = map[string]string{"member": mergeRemoveAbsent}
+	managedValues = &managedValueStore{values: make(map[string]map[string][]string)}
+
+	a := &TransformedEntry{DN: dn, Search: "groups", Origin: "uid=a,dc=org", Content: map[string]interface{}{"member": []interface{}{"x", "old-a"}}}
+	b := &TransformedEntry{DN: dn, Search: "groups", Origin: "uid=b,dc=org", Content: map[string]interface{}{"member": []interface{}{"y"}}}
+	rememberManagedValues(a, map[string][]string{"member": {"x", "old-a"}})
+	rememberManagedValues(b, map[string][]string{"member": {"y"}})
+
+	// a dropped old-a and b added z; both writes were coalesced.
+	a2 := &TransformedEntry{DN: dn, Search: "groups", Origin: "uid=a,dc=org", Content: map[string]interface{}{"member": []interface{}{"x"}}}
+	b2 := &TransformedEntry{DN: dn, Search: "groups", Origin: "uid=b,dc=org", Content: map[string]interface{}{"member": []interface{}{"y", "z"}}}
+	merged := mergeHeldEntry(a2, b2)
+	incoming := toStringSlice(merged.Content["member"])
+	got, _ := mergeValues(mergeRemoveAbsent, dn, "member", managedContributors(merged), []string{"x", "old-a", "y", "manual"}, incoming)
+	if want := []string{"x", "y", "manual", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged members = %q, want %q", got, want)
+	}
+	rememberManagedValues(merged, map[string][]string{"member": incoming})
+	managed := managedValues.get(dn, "member")
+	if got, want := managed[managedContributor(a)], []string{"x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values of a = %q, want %q", got, want)
+	}
+	if got, want := managed[managedContributor(b)], []string{"y", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values of b = %q, want %q", got, want)
+	}
+}
+
+func TestSubmitWriteCoalesces(t *testing.T) {
+	target := useRecordingTarget(t)
+	defer func(w int) { config.WriteCoalescing.WindowMs = w }(config.WriteCoalescing.WindowMs)
+	config.WriteCoalescing.WindowMs = 20
+
+	d := newDependencyState()
+	d.submitWrite(&TransformedEntry{DN: "cn=staff,dc=org", Origin: "uid=a,dc=org", Content: map[string]interface{}{"member": []interface{}{"x", "y"}}}, false)
+	d.submitWrite(&TransformedEntry{DN: "CN=Staff,DC=org", Origin: "uid=a,dc=org", Content: map[string]interface{}{"member": []interface{}{"x"}}}, false)
+	if got := target.written(); len(got) != 0 {
+		t.Fatalf("written %q before the window ended", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(target.written()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(40 * time.Millisecond)
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if len(target.entries) != 1 {
+		t.Fatalf("%d writes, want 1", len(target.entries))
+	}
+	if got := toStringSlice(target.entries[0].Content["member"]); !reflect.DeepEqual(got, []string{"x"}) {
+		t.Errorf("members = %q, want [x]", got)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.synced[normalizeDN("cn=staff,dc=org")]; !ok {
+		t.Error("coalesced write not marked synced")
+	}
+}
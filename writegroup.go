@@ -0,0 +1,238 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var writeGroupSeq atomic.Uint64
+
+// writeGroup collects the transformed entries of a single hook response so
+// they are written together: nothing is written until every member is ready,
+// and a failure of any member sends the whole group back for retry.
+type writeGroup struct {
+	id      uint64
+	origin  uint64 // id of the first attempt, under which the status is kept
+	attempt int
+	atomic  bool               // roll back the members added by a failed attempt
+	raw     []TransformedEntry // members as received, replayed on retry
+	deps    []string           // dependencies shared by all members
+
+	mu        sync.Mutex
+	order     []string                       // member keys in response order
+	keys      map[string]struct{}            // raw and resolved DN keys of all members
+	intraDeps map[string]map[string]struct{} // member key -> member keys it depends on
+	ready     map[string]*TransformedEntry   // member key -> resolved entry
+	committed bool
+}
+
+func newWriteGroup(entries []TransformedEntry, deps []string, attempt int, atomic bool) *writeGroup {
+	g := &writeGroup{
+		id:        writeGroupSeq.Add(1),
+		attempt:   attempt,
+		atomic:    atomic,
+		deps:      append([]string{}, deps...),
+		keys:      make(map[string]struct{}),
+		intraDeps: make(map[string]map[string]struct{}),
+		ready:     make(map[string]*TransformedEntry),
+	}
+	bindingsSnapshot, nullSnapshot := getBindingsSnapshot()
+	for _, e := range entries {
+		key := normalizeDN(e.DN)
+		if key == "" {
+			continue
+		}
+		g.raw = append(g.raw, e)
+		g.order = append(g.order, key)
+		g.keys[key] = struct{}{}
+		if resolved, _, _ := resolveString(e.DN, bindingsSnapshot, nullSnapshot); resolved != e.DN {
+			g.keys[normalizeDN(resolved)] = struct{}{}
+		}
+	}
+	g.origin = g.id
+	if attempt == 0 {
+		registerWriteGroup(g)
+	}
+	return g
+}
+
+// contains reports whether a (resolved) DN key belongs to a member of the group.
+func (g *writeGroup) contains(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.keys[key]
+	return ok
+}
+
+func (g *writeGroup) setDeps(key string, deps map[string]struct{}) {
+	g.mu.Lock()
+	g.intraDeps[key] = deps
+	g.mu.Unlock()
+}
+
+// markReady records a resolved member and reports whether the group just
+// became complete. Only one caller ever observes completion.
+func (g *writeGroup) markReady(key string, entry *TransformedEntry) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ready[key] = entry
+	g.keys[normalizeDN(entry.DN)] = struct{}{}
+	return g.completeLocked()
+}
+
+// drop removes a member that was superseded by a newer write for the same DN
+// and reports whether the remaining members are now complete.
+func (g *writeGroup) drop(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, k := range g.order {
+		if k == key {
+			g.order = append(g.order[:i:i], g.order[i+1:]...)
+			break
+		}
+	}
+	delete(g.ready, key)
+	for i, m := range g.raw {
+		if normalizeDN(m.DN) == key {
+			g.raw = append(g.raw[:i:i], g.raw[i+1:]...)
+			break
+		}
+	}
+	return g.completeLocked()
+}
+
+func (g *writeGroup) completeLocked() bool {
+	if g.committed || len(g.order) == 0 {
+		return false
+	}
+	for _, key := range g.order {
+		if _, ok := g.ready[key]; !ok {
+			return false
+		}
+	}
+	g.committed = true
+	return true
+}
+
+// ordered returns the resolved members so that members other members depend
+// on are written first; otherwise the hook's response order is kept.
+func (g *writeGroup) ordered() []*TransformedEntry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	resolvedKey := make(map[string]string, len(g.ready))
+	for key, e := range g.ready {
+		resolvedKey[normalizeDN(e.DN)] = key
+		resolvedKey[key] = key
+	}
+	visited := make(map[string]bool, len(g.order))
+	out := make([]*TransformedEntry, 0, len(g.order))
+	var visit func(key string)
+	visit = func(key string) {
+		if visited[key] {
+			return
+		}
+		visited[key] = true
+		for dep := range g.intraDeps[key] {
+			if member, ok := resolvedKey[dep]; ok {
+				visit(member)
+			}
+		}
+		if e, ok := g.ready[key]; ok {
+			out = append(out, e)
+		}
+	}
+	for _, key := range g.order {
+		visit(key)
+	}
+	return out
+}
+
+// commitGroup writes every member of a complete group. If any write fails the
+// remaining members are not written, an atomic group deletes the members it
+// added, and the whole group is scheduled for retry; dependents are released
+// only once the full group has landed.
+func (d *dependencyState) commitGroup(g *writeGroup) {
+	entries := g.ordered()
+	rollback := g.atomic && writeGroupRollback()
+	var added []*TransformedEntry
+	for i, e := range entries {
+		// Only entries that did not exist before are deleted on rollback.
+		isNew := false
+		if rollback && !isDryRun(e) && !isDeniedWrite("write", e.DN) {
+			exists, err := targetEntryExists(e.DN)
+			isNew = err == nil && !exists
+		}
+		err := storeEntry(e)
+		if isNew && (err == nil || targetEntryAdded(e.DN)) {
+			added = append(added, e)
+		}
+		if err != nil {
+			depLogger.Error(
+				"Error storing grouped entry in destination LDAP; group will be retried",
+				"GroupId", g.id,
+				"DN", e.DN,
+				"Written", i,
+				"GroupSize", len(entries),
+				"Attempt", g.attempt+1,
+				"Err", err,
+			)
+			var rolledBack []string
+			if len(added) > 0 {
+				rolledBack = rollbackGroup(g, added)
+			}
+			state := groupStateRetrying
+			if !retryableWriteError(err) || !scheduleGroupRetry(g) {
+				state = groupStateDeadLettered
+				resolved := make([]TransformedEntry, 0, len(entries))
+				for _, entry := range entries {
+					resolved = append(resolved, *entry)
+				}
+				deadLetters.add(resolved, err)
+			}
+			updateWriteGroup(g, func(s *WriteGroupStatus) {
+				s.State = state
+				s.Attempts = g.attempt + 1
+				s.RolledBack = rolledBack
+				s.LastError = err.Error()
+			})
+			return
+		}
+	}
+	updateWriteGroup(g, func(s *WriteGroupStatus) {
+		s.State = groupStateCommitted
+		s.Attempts = g.attempt + 1
+	})
+	depLogger.Debug("Write group committed", "GroupId", g.id, "GroupSize", len(entries))
+	for _, e := range entries {
+		d.markSyncedAndRelease(e.DN)
+	}
+}
+
+// scheduleGroupRetry resubmits all members of a failed group after an
+// exponential backoff derived from the target retry settings. It returns false
+// once the retries are exhausted.
+func scheduleGroupRetry(g *writeGroup) bool {
+	maxRetries, initialDelay, maxDelay := targetRetrySettings()
+	if g.attempt >= maxRetries {
+		depLogger.Error("Write group failed after retries; dead-lettering", "GroupId", g.id, "Attempts", g.attempt+1)
+		return false
+	}
+	delay := initialDelay << g.attempt
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	time.AfterFunc(delay, func() {
+		g.mu.Lock()
+		raw := append([]TransformedEntry{}, g.raw...)
+		g.mu.Unlock()
+		retry := newWriteGroup(raw, g.deps, g.attempt+1, g.atomic)
+		retry.origin = g.origin
+		depLogger.Info("Retrying write group", "GroupId", g.id, "RetryGroupId", retry.id, "Attempt", retry.attempt+1)
+		for i := range raw {
+			entry := raw[i]
+			dependencyTracker.handleEntry(&entry, retry.deps, retry)
+		}
+	})
+	return true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// With target_write_queue.workers set, entries ready to be written are
+// queued to a pool of writer goroutines instead of being written by the
+// goroutine that processed the hook response or released the dependency,
+// so hook handling does not wait for slow target writes. Each DN always
+// goes to the same worker, whose queue is FIFO, so the writes to one DN keep
+// their order. Dependents of an entry are released once its write succeeds,
+// as without the queue. A full queue blocks the producer: that is the
+// back-pressure on hooks when the target cannot keep up.
+
+// TargetWriteQueueConfig configures the target write worker pool.
+type TargetWriteQueueConfig struct {
+	// Workers is the number of concurrent writers (0: write inline).
+	Workers int `yaml:"workers"`
+	// QueueSize is the number of entries each worker queues (default: 1000).
+	QueueSize int `yaml:"queue_size"`
+}
+
+var (
+	mWriteQueueDepth = describeMetric("ldapsync_target_write_queue_depth", "gauge",
+		"Entries queued for a target write worker.")
+	mWriteQueueWait = describeMetric("ldapsync_target_write_queue_wait_seconds_total", "counter",
+		"Time entries spent queued before a worker wrote them.")
+)
+
+type queuedWrite struct {
+	entry    *TransformedEntry
+	deferred bool // Released from the pending set rather than written right away
+	queued   time.Time
+}
+
+// writeQueues holds one queue per worker; nil writes inline.
+var writeQueues []chan queuedWrite
+
+func init() {
+	registerCollector(func() {
+		depth := 0
+		for _, q := range writeQueues {
+			depth += len(q)
+		}
+		setGauge(mWriteQueueDepth, float64(depth))
+	})
+}
+
+// startWriteQueue starts the configured write workers.
+func startWriteQueue() {
+	workers := config.TargetWriteQueue.Workers
+	if workers <= 0 {
+		return
+	}
+	size := config.TargetWriteQueue.QueueSize
+	if size <= 0 {
+		size = 1000
+	}
+	writeQueues = make([]chan queuedWrite, workers)
+	for i := range writeQueues {
+		writeQueues[i] = make(chan queuedWrite, size)
+		go runWriteWorker(writeQueues[i])
+	}
+	logger.Info("Started target write workers", "Workers", workers, "QueueSize", size)
+}
+
+func runWriteWorker(queue chan queuedWrite) {
+	for w := range queue {
+		addCounter(mWriteQueueWait, time.Since(w.queued).Seconds())
+		if dependencyTracker.writeEntry(w.entry, w.deferred) {
+			// Releasing may queue dependents to this worker; a full
+			// queue must not block the worker that drains it.
+			go dependencyTracker.markSyncedAndRelease(w.entry.DN)
+		}
+	}
+}
+
+// enqueueWrite writes a resolved entry and releases its dependents, through
+// the worker owning the entry's DN if the queue is enabled.
+func (d *dependencyState) enqueueWrite(entry *TransformedEntry, deferred bool) {
+	if len(writeQueues) == 0 {
+		if d.writeEntry(entry, deferred) {
+			d.markSyncedAndRelease(entry.DN)
+		}
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(normalizeDN(entry.DN)))
+	writeQueues[h.Sum32()%uint32(len(writeQueues))] <- queuedWrite{entry: entry, deferred: deferred, queued: time.Now()}
+}
+
+// writeEntry writes an entry to the target and reports whether it
+// succeeded; a failed write is scheduled for retry.
+func (d *dependencyState) writeEntry(entry *TransformedEntry, deferred bool) bool {
+	if err := storeEntry(entry); err != nil {
+		msg := "Error storing entry in destination LDAP"
+		if deferred {
+			msg = "Error storing deferred entry in destination LDAP"
+		}
+		depLogger.Error(msg, "DN", entry.DN, "Err", err)
+		targetRetries.schedule(entry, err)
+		return false
+	}
+	if deferred {
+		depLogger.Info("Storing deferred entry in destination LDAP", "DN", entry.DN)
+	}
+	return true
+}
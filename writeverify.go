@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/labstack/echo/v4"
+)
+
+// WriteVerificationConfig re-reads every entry after a successful add or
+// modify and checks that the written values are on the target.
+type WriteVerificationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Ignore lists attributes the target rewrites beyond recognition, such
+	// as ones maintained by overlays. Password attributes are never checked.
+	Ignore    []string `yaml:"ignore"`
+	AuditSize int      `yaml:"audit_size"` // Failures kept for GET /verification/failures (default: 1000)
+}
+
+// VerificationFailure is a written attribute whose values were not found
+// on the target when the entry was read back.
+type VerificationFailure struct {
+	Time      time.Time `json:"time"`
+	DN        string    `json:"dn"`
+	Search    string    `json:"search,omitempty"`
+	Operation string    `json:"operation"`
+	Attribute string    `json:"attribute,omitempty"` // Empty when the entry itself could not be read
+	Expected  []string  `json:"expected,omitempty"`
+	Actual    []string  `json:"actual,omitempty"`
+	Reason    string    `json:"reason"`
+}
+
+var mWriteVerifications = describeMetric("ldapsync_write_verifications_total", "counter",
+	"Target entries read back after a write, by result (ok, mismatch or error).")
+
+var verificationFailures struct {
+	mu       sync.Mutex
+	failures []VerificationFailure
+}
+
+// verifyTargetWrite reads an entry back after a successful write and
+// records the attributes that did not land as written. It never fails the
+// write.
+func verifyTargetWrite(l *ldap.Conn, entry *TransformedEntry, op string, attributes map[string][]string) {
+	if !config.WriteVerification.Enabled {
+		return
+	}
+	checked := make(map[string][]string, len(attributes))
+	for attr, values := range attributes {
+		if !isPasswordAttr(attr) && !verificationIgnored(attr) {
+			checked[attr] = values
+		}
+	}
+	if len(checked) == 0 {
+		return
+	}
+	now := time.Now()
+	current, err := readTargetAttributes(l, entry.DN, checked)
+	if err != nil || current == nil {
+		reason := "entry not found"
+		if err != nil {
+			reason = err.Error()
+		}
+		incCounter(mWriteVerifications, "result", "error")
+		logger.Warn("Could not read back written entry", "DN", entry.DN, "SearchId", entry.Search, "Reason", reason)
+		recordVerificationFailures([]VerificationFailure{{Time: now, DN: entry.DN, Search: entry.Search, Operation: op, Reason: reason}})
+		return
+	}
+	var failures []VerificationFailure
+	for _, attr := range attributeNames(checked) {
+		want := checked[attr]
+		have := getEntryAttributeValues(current, attr)
+		if reason := verifyValues(attr, have, want); reason != "" {
+			failures = append(failures, VerificationFailure{
+				Time: now, DN: entry.DN, Search: entry.Search, Operation: op, Attribute: attr,
+				Expected: previewValues(attr, want), Actual: previewValues(attr, have), Reason: reason,
+			})
+		}
+	}
+	if len(failures) == 0 {
+		incCounter(mWriteVerifications, "result", "ok")
+		return
+	}
+	incCounter(mWriteVerifications, "result", "mismatch")
+	for _, f := range failures {
+		logger.Warn("Written values not found on target", "DN", f.DN, "SearchId", f.Search, "Attribute", f.Attribute, "Reason", f.Reason)
+	}
+	recordVerificationFailures(failures)
+}
+
+func verificationIgnored(attr string) bool {
+	base, _, _ := strings.Cut(attr, ";")
+	for _, name := range config.WriteVerification.Ignore {
+		if strings.EqualFold(base, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyValues compares the values read back with the values written and
+// returns why they differ, or "". Values are compared as the server would
+// match them: binary values exactly, DNs by their normalized form and
+// anything else case-insensitively with whitespace collapsed. The target may
+// add object classes (superclasses) of its own.
+func verifyValues(attr string, have, want []string) string {
+	present := make(map[string]struct{}, len(have))
+	for _, v := range have {
+		present[verificationValue(attr, v)] = struct{}{}
+	}
+	expected := make(map[string]struct{}, len(want))
+	for _, v := range want {
+		key := verificationValue(attr, v)
+		expected[key] = struct{}{}
+		if _, ok := present[key]; !ok {
+			return "missing values"
+		}
+	}
+	if strings.EqualFold(attr, "objectClass") {
+		return ""
+	}
+	for key := range present {
+		if _, ok := expected[key]; !ok {
+			return "unexpected values"
+		}
+	}
+	return ""
+}
+
+func verificationValue(attr, value string) string {
+	if isBinaryAttr(attr) {
+		return value
+	}
+	if strings.Contains(value, "=") {
+		if dn, err := ldap.ParseDN(value); err == nil && len(dn.RDNs) > 0 {
+			return normalizeDN(value)
+		}
+	}
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+func recordVerificationFailures(failures []VerificationFailure) {
+	size := config.WriteVerification.AuditSize
+	if size <= 0 {
+		size = 1000
+	}
+	verificationFailures.mu.Lock()
+	defer verificationFailures.mu.Unlock()
+	verificationFailures.failures = append(verificationFailures.failures, failures...)
+	if over := len(verificationFailures.failures) - size; over > 0 {
+		verificationFailures.failures = append([]VerificationFailure{}, verificationFailures.failures[over:]...)
+	}
+}
+
+// getVerificationFailuresHandler godoc
+// @Summary List write verification failures
+// @Description Lists recent writes whose values were not found on the target when the entry was read back, oldest first.
+// @Tags verification
+// @Produce json
+// @Param search query string false "Only failures of writes by this search"
+// @Param limit query int false "Return only the most recent failures"
+// @Success 200 {array} VerificationFailure
+// @Failure 400 {string} string "Invalid limit"
+// @Router /verification/failures [get]
+func getVerificationFailuresHandler(c echo.Context) error {
+	limit := 0
+	if s := c.QueryParam("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return c.String(http.StatusBadRequest, "Invalid limit parameter")
+		}
+	}
+	search := c.QueryParam("search")
+	verificationFailures.mu.Lock()
+	out := make([]VerificationFailure, 0, len(verificationFailures.failures))
+	for _, f := range verificationFailures.failures {
+		if search == "" || f.Search == search {
+			out = append(out, f)
+		}
+	}
+	verificationFailures.mu.Unlock()
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return c.JSON(http.StatusOK, out)
+}